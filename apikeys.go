@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	apikeys "google.golang.org/api/apikeys/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// APIKey models an API key declared in googlecloudproject.yaml. Keys are restricted to the
+// given APIs, and to either the given referrers or the given IP addresses.
+type APIKey struct {
+	Name        string   // ID of the key, e.g. "web-frontend"
+	DisplayName string   `yaml:"displayName"` // shown in the console (default: the name)
+	APIs        []string // APIs that the key may call, e.g. maps-backend.googleapis.com (default: any)
+	Referrers   []string // websites that may use the key, e.g. https://example.com/*
+	IPs         []string `yaml:"ips"` // server addresses that may use the key, e.g. 203.0.113.0/24
+}
+
+// get the resource name of an API key
+func apiKeyName(projectID, key string) string {
+	return fmt.Sprintf("projects/%s/locations/global/keys/%s", projectID, key)
+}
+
+// convert the restrictions on a key in the spec to the form expected by the API keys API
+func apiKeyRestrictions(key APIKey) (*apikeys.V2Restrictions, error) {
+	if len(key.Referrers) > 0 && len(key.IPs) > 0 {
+		return nil, fmt.Errorf("API key %s can be restricted to referrers or IPs but not both", key.Name)
+	}
+
+	var r apikeys.V2Restrictions
+	var apis []string
+	for _, api := range key.APIs {
+		apis = append(apis, expandAPIName(api))
+	}
+	sort.Strings(apis)
+	for _, api := range apis {
+		r.ApiTargets = append(r.ApiTargets, &apikeys.V2ApiTarget{Service: api})
+	}
+	if len(key.Referrers) > 0 {
+		r.BrowserKeyRestrictions = &apikeys.V2BrowserKeyRestrictions{AllowedReferrers: key.Referrers}
+	}
+	if len(key.IPs) > 0 {
+		r.ServerKeyRestrictions = &apikeys.V2ServerKeyRestrictions{AllowedIps: key.IPs}
+	}
+	return &r, nil
+}
+
+// summarize restrictions for the report, e.g. "apis=a,b referrers=c"
+func formatRestrictions(r *apikeys.V2Restrictions) string {
+	if r == nil {
+		return "unrestricted"
+	}
+	var parts []string
+	var apis []string
+	for _, t := range r.ApiTargets {
+		apis = append(apis, t.Service)
+	}
+	if len(apis) > 0 {
+		parts = append(parts, "apis="+strings.Join(apis, ","))
+	}
+	if r.BrowserKeyRestrictions != nil {
+		parts = append(parts, "referrers="+strings.Join(r.BrowserKeyRestrictions.AllowedReferrers, ","))
+	}
+	if r.ServerKeyRestrictions != nil {
+		parts = append(parts, "ips="+strings.Join(r.ServerKeyRestrictions.AllowedIps, ","))
+	}
+	if len(parts) == 0 {
+		return "unrestricted"
+	}
+	return strings.Join(parts, " ")
+}
+
+// wait for an API keys operation to complete
+func waitForAPIKeys(ctx context.Context, svc *apikeys.Service, op *apikeys.Operation) error {
+	check := func() (bool, error) {
+		if op.Error != nil {
+			return false, newOperationError(op.Error.Code, op.Error.Message, op.Error.Details)
+		}
+		return op.Done, nil
+	}
+	if done, err := check(); done || err != nil {
+		return err
+	}
+	return poll(ctx, "operation "+op.Name, func() (bool, error) {
+		var err error
+		op, err = svc.Operations.Get(op.Name).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error getting operation info: %w", err)
+		}
+		return check()
+	})
+}
+
+// create an API key if it does not exist, or update its restrictions if they differ from
+// the spec, and report whether anything changed. The key string itself is never printed.
+func applyAPIKey(ctx context.Context, conn *connection, projectID string, key APIKey, state *State) (bool, error) {
+	restrictions, err := apiKeyRestrictions(key)
+	if err != nil {
+		return false, err
+	}
+	displayName := key.DisplayName
+	if displayName == "" {
+		displayName = key.Name
+	}
+
+	svc, err := apikeys.NewService(ctx, conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the API keys API: %w", err)
+	}
+
+	name := apiKeyName(projectID, key.Name)
+	existing, err := svc.Projects.Locations.Keys.Get(name).Context(ctx).Do()
+	if e, ok := err.(*googleapi.Error); ok && e.Code == 404 {
+		fmt.Printf("creating API key %s (%s)\n", key.Name, formatRestrictions(restrictions))
+		parent := fmt.Sprintf("projects/%s/locations/global", projectID)
+		op, err := svc.Projects.Locations.Keys.Create(parent, &apikeys.V2Key{
+			DisplayName:  displayName,
+			Restrictions: restrictions,
+		}).KeyId(key.Name).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error creating API key %s: %w", key.Name, err)
+		}
+		noteOperation(ctx, op.Name)
+		err = waitForAPIKeys(ctx, svc, op)
+		if err != nil {
+			return false, fmt.Errorf("error creating API key %s: %w", key.Name, err)
+		}
+		state.Record(kindAPIKey, name, "")
+		noteChange(ctx, "", formatRestrictions(restrictions))
+		fmt.Printf("to get the key string, run\n  $ gcloud services api-keys get-key-string %s\n", name)
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error getting API key %s: %w", key.Name, err)
+	}
+
+	before := formatRestrictions(existing.Restrictions)
+	after := formatRestrictions(restrictions)
+	if before == after && existing.DisplayName == displayName {
+		return false, nil
+	}
+
+	// the key carries the etag from the get, so this fails rather than overwriting a concurrent change
+	fmt.Printf("updating API key %s (%s)\n", key.Name, after)
+	existing.DisplayName = displayName
+	existing.Restrictions = restrictions
+	op, err := svc.Projects.Locations.Keys.Patch(name, existing).UpdateMask("displayName,restrictions").Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error updating API key %s: %w", key.Name, err)
+	}
+	noteOperation(ctx, op.Name)
+	err = waitForAPIKeys(ctx, svc, op)
+	if err != nil {
+		return false, fmt.Errorf("error updating API key %s: %w", key.Name, err)
+	}
+	noteChange(ctx, before, after)
+	return true, nil
+}
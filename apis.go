@@ -9,16 +9,39 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/serviceusage/v1"
 )
 
 // next make a map from API to enabled/disabled
 type api struct {
-	Name    string // machine readable name, e.g. "billingbudgets.googleapis.com"
-	Title   string // human readable name, e.g. "Cloud Billing API"
-	Summary string // one sentence description of the API
-	Enabled bool
+	Name     string // machine readable name, e.g. "billingbudgets.googleapis.com"
+	Title    string // human readable name, e.g. "Cloud Billing API"
+	Summary  string // one sentence description of the API
+	Enabled  bool
+	Category string // grouping such as "Databases" or "Maps"
+	FreeTier bool   // whether the API is free or has a monthly free usage tier
+}
+
+// kinds of service, which differ in how they become available to a project
+const (
+	serviceGoogle    = "google"    // google's own APIs, e.g. "run.googleapis.com"
+	serviceEndpoints = "endpoints" // APIs deployed with cloud endpoints, e.g. "api.endpoints.my-project.cloud.goog"
+	servicePartner   = "partner"   // third-party services from the marketplace, e.g. "elastic.example.com"
+)
+
+// get the kind of a service from its full name
+func serviceKind(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".googleapis.com"):
+		return serviceGoogle
+	case strings.HasSuffix(name, ".cloud.goog"):
+		return serviceEndpoints
+	}
+	return servicePartner
 }
 
 func formatProjectNumber(n int64) string {
@@ -26,13 +49,13 @@ func formatProjectNumber(n int64) string {
 }
 
 func cacheDir(projectNumber int64) (string, error) {
-	cacheDir, err := os.UserCacheDir() // return ~/.cache on linux
+	cacheDir, err := fsys.UserCacheDir() // ~/.cache on linux, %LocalAppData% on windows
 	if err != nil {
 		return "", fmt.Errorf("error getting user cache dir: %w", err)
 	}
 
 	path := filepath.Join(cacheDir, "gproj", strconv.FormatInt(projectNumber, 10))
-	err = os.MkdirAll(path, os.ModePerm)
+	err = fsys.MkdirAll(path, dirPerm)
 	if err != nil {
 		return "", fmt.Errorf("error creating cache dir: %w", err)
 	}
@@ -49,21 +72,211 @@ func firstLine(s string) string {
 	return s[:pos]
 }
 
+// determines whether a string is in a list of strings
+func contains(xs []string, x string) bool {
+	for _, y := range xs {
+		if y == x {
+			return true
+		}
+	}
+	return false
+}
+
+// get the names of the APIs that are currently enabled in a project
+func enabledAPIs(ctx context.Context, svc *serviceusage.Service, projectNumber int64) ([]string, error) {
+	var enabled []string
+	err := svc.Services.List(formatProjectNumber(projectNumber)).
+		Filter("state:ENABLED").
+		Pages(ctx, func(r *serviceusage.ListServicesResponse) error {
+			for _, s := range r.Services {
+				enabled = append(enabled, s.Config.Name)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("error listing enabled APIs: %w", err)
+	}
+	sort.Strings(enabled)
+	return enabled, nil
+}
+
+// find the services among the given ones that are not in the project's service catalog, so
+// that a typo or an unavailable partner service is reported before anything is enabled
+func missingServices(ctx context.Context, svc *serviceusage.Service, projectNumber int64, names []string) ([]string, error) {
+	var missing []string
+	for _, name := range names {
+		_, err := svc.Services.Get(fmt.Sprintf("%s/services/%s", formatProjectNumber(projectNumber), name)).Context(ctx).Do()
+		// the service usage API does not distinguish a service that does not exist from one
+		// that is not visible to the project, and reports both as 403
+		if e, ok := err.(*googleapi.Error); ok && (e.Code == 403 || e.Code == 404) {
+			missing = append(missing, name)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error looking up %s: %w", name, err)
+		}
+	}
+	return missing, nil
+}
+
+// explain why a service might be missing from the catalog
+func missingServiceHint(name string) string {
+	switch serviceKind(name) {
+	case serviceEndpoints:
+		return "endpoints services exist only once their service config has been deployed"
+	case servicePartner:
+		return "partner services must be purchased in the cloud marketplace before they can be enabled"
+	}
+	return `see "gproj apis" for the names of the APIs that are available`
+}
+
+// number of times verifyEnabled checks the enabled APIs before giving up
+const verifyAttempts = 6
+
+// verifyEnabled checks that every one of the given APIs is reported as enabled, asking
+// again for any stragglers. This is needed because enable operations sometimes report done
+// while some services are still propagating, and then calls to those services fail.
+func verifyEnabled(ctx context.Context, svc *serviceusage.Service, projectNumber int64, apis []string) error {
+	delay := 2 * time.Second
+	for attempt := 1; ; attempt++ {
+		enabled, err := enabledAPIs(ctx, svc, projectNumber)
+		if err != nil {
+			return err
+		}
+
+		var missing []string
+		for _, api := range apis {
+			if !contains(enabled, api) {
+				missing = append(missing, api)
+			}
+		}
+		if len(missing) == 0 {
+			return nil
+		}
+		if attempt == verifyAttempts {
+			return fmt.Errorf("%s still not enabled after the enable operation completed", strings.Join(missing, ", "))
+		}
+
+		// give propagation a chance before asking again
+		if attempt > 1 {
+			_, err := svc.Services.BatchEnable(formatProjectNumber(projectNumber), &serviceusage.BatchEnableServicesRequest{
+				ServiceIds: missing,
+			}).Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("error in API call to enable %s again: %w", strings.Join(missing, ", "), err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// get the APIs that must be enabled for the resources declared in the spec
+func impliedAPIs(spec *ProjectSpec) []string {
+	var apis []string
+	if spec.Compute != nil && (len(spec.Compute.Metadata) > 0 || spec.Compute.SharedVPC != nil) {
+		apis = append(apis, "compute.googleapis.com")
+	}
+	if spec.ServiceNetworking != nil {
+		apis = append(apis, "compute.googleapis.com", "servicenetworking.googleapis.com")
+	}
+	if spec.IdentityPlatform != nil {
+		apis = append(apis, "identitytoolkit.googleapis.com")
+	}
+	if spec.OAuth != nil {
+		apis = append(apis, "iap.googleapis.com")
+	}
+	if len(spec.ActAs) > 0 {
+		apis = append(apis, "iam.googleapis.com")
+	}
+	if len(spec.APIKeys) > 0 {
+		apis = append(apis, "apikeys.googleapis.com")
+	}
+	if len(spec.CloudSQL) > 0 {
+		apis = append(apis, "sqladmin.googleapis.com")
+	}
+	for _, r := range spec.Resources {
+		if r.API != "" {
+			apis = append(apis, r.API)
+		}
+	}
+	if len(spec.Datasets) > 0 {
+		apis = append(apis, "bigquery.googleapis.com")
+	}
+	if spec.BillingExport != nil {
+		apis = append(apis, "bigquery.googleapis.com", "bigquerydatatransfer.googleapis.com")
+	}
+	if len(spec.CloudRun) > 0 {
+		apis = append(apis, "run.googleapis.com")
+	}
+	if len(spec.Scheduler) > 0 {
+		apis = append(apis, "cloudscheduler.googleapis.com", "appengine.googleapis.com")
+		for _, job := range spec.Scheduler {
+			if job.PubSub != nil {
+				apis = append(apis, "pubsub.googleapis.com")
+				break
+			}
+		}
+	}
+	return apis
+}
+
+// the API catalog is fetched again from scratch when the cache is older than this
+const catalogMaxAge = 7 * 24 * time.Hour
+
+// the largest page size that the service usage API accepts when listing services
+const catalogPageSize = 200
+
+// get the full names of all the APIs that the spec needs, whether listed explicitly or
+// implied by the resources it declares
+func desiredAPIs(spec *ProjectSpec) []string {
+	var apis []string
+	for _, api := range append(spec.APIs, impliedAPIs(spec)...) {
+		api = expandAPIName(api)
+		if !contains(apis, api) {
+			apis = append(apis, api)
+		}
+	}
+	return apis
+}
+
+// get when the cached API catalog for a project was last fetched, if there is one
+func catalogFetched(projectNumber int64) (time.Time, bool) {
+	cacheDir, err := cacheDir(projectNumber)
+	if err != nil {
+		return time.Time{}, false
+	}
+	info, err := fsys.Stat(filepath.Join(cacheDir, "available-apis.json"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
+
 // get the available APIs from local cache or request from Google Cloud if missing
-func availableAPIs(ctx context.Context, projectNumber int64) ([]*api, error) {
+func availableAPIs(ctx context.Context, conn *connection, projectNumber int64, refresh bool) ([]*api, error) {
 	cacheDir, err := cacheDir(projectNumber)
 	if err != nil {
 		// failed to create the cache dir so just do a pull and do not try to store the results
 		fmt.Printf("warning: unable to cache results, error was: %v", err)
-		return pullAvailableAPIs(ctx, projectNumber)
+		return pullAvailableAPIs(ctx, conn, projectNumber)
 	}
 
 	// try to look up the results from cache
 	cachePath := filepath.Join(cacheDir, "available-apis.json")
-	cached, err := os.ReadFile(cachePath)
+	info, err := fsys.Stat(cachePath)
+	if err != nil || refresh || time.Since(info.ModTime()) > catalogMaxAge {
+		return pullAndStoreAvailableAPIs(ctx, conn, projectNumber, cachePath)
+	}
+
+	cached, err := fsys.ReadFile(cachePath)
 	if err != nil {
-		fmt.Println("fetching available APIs, this may take a minute or two...")
-		return pullAndStoreAvailableAPIs(ctx, projectNumber, cachePath)
+		return pullAndStoreAvailableAPIs(ctx, conn, projectNumber, cachePath)
 	}
 
 	var apis []*api
@@ -72,12 +285,49 @@ func availableAPIs(ctx context.Context, projectNumber int64) ([]*api, error) {
 		return nil, fmt.Errorf("error decoding cached API listing; try deleting %s: %v", cachePath, err)
 	}
 
+	// the full catalog rarely changes but the enabled APIs often do, so check the (much
+	// shorter) list of enabled APIs against the cache before trusting it
+	fresh, err := refreshEnabled(ctx, conn, projectNumber, apis)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return pullAndStoreAvailableAPIs(ctx, conn, projectNumber, cachePath)
+	}
+
+	enrichAPIs(apis)
 	return apis, nil
 }
 
+// update the enabled flags in a cached catalog, and report whether the cache is still
+// usable, which it is not if an API has been enabled that the cache does not know about
+func refreshEnabled(ctx context.Context, conn *connection, projectNumber int64, apis []*api) (bool, error) {
+	apiService, err := serviceusage.NewService(ctx, conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the service usage API: %w", err)
+	}
+
+	enabled, err := enabledAPIs(ctx, apiService, projectNumber)
+	if err != nil {
+		return false, err
+	}
+
+	known := make(map[string]bool)
+	for _, api := range apis {
+		known[api.Name] = true
+		api.Enabled = contains(enabled, api.Name)
+	}
+	for _, name := range enabled {
+		if !known[name] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 // pull the available APIs from Google Cloud and store to a file if successful
-func pullAndStoreAvailableAPIs(ctx context.Context, projectNumber int64, path string) ([]*api, error) {
-	apis, err := pullAvailableAPIs(ctx, projectNumber)
+func pullAndStoreAvailableAPIs(ctx context.Context, conn *connection, projectNumber int64, path string) ([]*api, error) {
+	apis, err := pullAvailableAPIs(ctx, conn, projectNumber)
 	if err != nil {
 		return nil, err
 	}
@@ -87,7 +337,7 @@ func pullAndStoreAvailableAPIs(ctx context.Context, projectNumber int64, path st
 		return nil, fmt.Errorf("error marshalling apis to json: %v", err)
 	}
 
-	err = os.WriteFile(path, buf, os.ModePerm)
+	err = fsys.WriteFile(path, buf, filePerm)
 	if err != nil {
 		return nil, fmt.Errorf("error writing apis to cache: %v", err)
 	}
@@ -98,33 +348,66 @@ func pullAndStoreAvailableAPIs(ctx context.Context, projectNumber int64, path st
 }
 
 // pull the available APIs from Google Cloud
-func pullAvailableAPIs(ctx context.Context, projectNumber int64) ([]*api, error) {
+func pullAvailableAPIs(ctx context.Context, conn *connection, projectNumber int64) ([]*api, error) {
 	// create an API service to access the list of available APIs
-	apiService, err := serviceusage.NewService(ctx)
+	apiService, err := serviceusage.NewService(ctx, conn.options()...)
 	if err != nil {
 		return nil, fmt.Errorf("error initializing the service usage API: %w", err)
 	}
 
 	projNum := formatProjectNumber(projectNumber)
 
-	// Fetch each page in turn
-	var apis []*api
-	err = apiService.Services.List(projNum).Pages(ctx, func(r *serviceusage.ListServicesResponse) error {
-		for _, s := range r.Services {
-			// note that s.Name is of the form "projects/123/services/foo.googleapis.com"
-			// while s.Config.Name is of the form "foo.googleapis.com"
-			api := api{
-				Name:    s.Config.Name,
-				Title:   s.Config.Title,
-				Enabled: s.State == "ENABLED",
-			}
-			if s.Config.Documentation != nil {
-				api.Summary = firstLine(s.Config.Documentation.Summary)
-			}
-			apis = append(apis, &api)
+	// pages are chained by page token so a single listing cannot be parallelized, but the
+	// enabled and disabled APIs can be listed concurrently as two separate listings
+	// only show a running count on a terminal since it relies on carriage returns
+	progress := isTerminal(os.Stdout)
+
+	var (
+		mu      sync.Mutex
+		apis    []*api
+		fetched int
+	)
+	fetch := func(filter string) error {
+		return apiService.Services.List(projNum).
+			Filter(filter).
+			PageSize(catalogPageSize).
+			Pages(ctx, func(r *serviceusage.ListServicesResponse) error {
+				mu.Lock()
+				defer mu.Unlock()
+				for _, s := range r.Services {
+					// note that s.Name is of the form "projects/123/services/foo.googleapis.com"
+					// while s.Config.Name is of the form "foo.googleapis.com"
+					api := api{
+						Name:    s.Config.Name,
+						Title:   s.Config.Title,
+						Enabled: s.State == "ENABLED",
+					}
+					if s.Config.Documentation != nil {
+						api.Summary = firstLine(s.Config.Documentation.Summary)
+					}
+					apis = append(apis, &api)
+				}
+				fetched += len(r.Services)
+				if progress {
+					fmt.Printf("\rfetching available APIs... %d", fetched)
+				}
+				return nil
+			})
+	}
+
+	errs := make(chan error, 2)
+	for _, filter := range []string{"state:ENABLED", "state:DISABLED"} {
+		filter := filter
+		go func() { errs <- fetch(filter) }()
+	}
+	for i := 0; i < 2; i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
 		}
-		return nil
-	})
+	}
+	if progress {
+		fmt.Println()
+	}
 	if err != nil {
 		return nil, fmt.Errorf("error getting list of APIs: %w", err)
 	}
@@ -133,5 +416,6 @@ func pullAvailableAPIs(ctx context.Context, projectNumber int64) ([]*api, error)
 		return apis[i].Name < apis[j].Name
 	})
 
+	enrichAPIs(apis)
 	return apis, nil
 }
@@ -0,0 +1,872 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kr/pretty"
+	"google.golang.org/api/cloudbilling/v1"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/serviceusage/v1"
+)
+
+// applier holds everything needed to apply a spec, shared between the nodes of the apply graph
+type applier struct {
+	args      *args
+	spec      *ProjectSpec
+	conn      *connection
+	state     *State
+	resources *cloudresourcemanager.Service
+	apis      *serviceusage.Service
+	billing   *cloudbilling.APIService
+	specRev   string    // git commit of the spec, or empty if it is not in a git repository
+	owner     string    // email of the current user if --owner-label was given
+	created   time.Time // when the project was created, or zero if it already existed
+
+	billingEnabled bool   // whether the project has billing, filled in by the "billing" node
+	billingAccount string // billing account linked to the project, filled in by the "billing" node
+
+	project *cloudresourcemanager.Project // filled in by the "project" node
+	enabled map[string]bool               // APIs that were already enabled, filled in by the "enabled-apis" node
+	brand   string                        // resource name of the OAuth brand, filled in by the "oauth-brand" node
+
+	oauthClients [][2]string // CI outputs giving the ID of each OAuth client, filled in by the "oauth-clients" node
+
+	outputsMu     sync.Mutex
+	pluginOutputs [][2]string // CI outputs published by plugins, filled in by the "plugin:" nodes
+}
+
+// name of the graph node that enables an API
+func apiNode(api string) string {
+	return "api:" + api
+}
+
+func apply(ctx context.Context, args *args) error {
+	// check this up front rather than after a long apply
+	if args.Apply.Notify != "" && args.Apply.Notify != "desktop" {
+		return fmt.Errorf("unknown notification type %q, expected \"desktop\"", args.Apply.Notify)
+	}
+
+	if args.Apply.PlanFile != "" && args.Apply.FromRequest != "" {
+		return errors.New("a plan file and --from-request cannot be used together")
+	}
+	if args.Apply.VerifyKey != "" && args.Apply.FromRequest != "" {
+		return errors.New("requests are signed with the request key, so --verify-key cannot be used with --from-request")
+	}
+
+	// find the project spec, or take it from the plan file or request if one was given
+	var spec *ProjectSpec
+	switch {
+	case args.Apply.FromRequest != "":
+		r, err := readRequest(args.Apply.FromRequest, args.Apply.RequestKey, args.Apply.AllowedPlugins)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("applying request made %s, which says it is from %s\n", humanAgo(r.Created), r.Requester)
+		spec = r.Spec
+	case args.Apply.PlanFile != "":
+		// the spec is inside the plan, so it is the plan that must be signed
+		if args.Apply.VerifyKey != "" {
+			err := verifySignature(args.Apply.PlanFile, args.Apply.VerifyKey, args.Apply.Signature)
+			if err != nil {
+				return err
+			}
+		}
+
+		reviewed, err := readPlan(args.Apply.PlanFile)
+		if err != nil {
+			return err
+		}
+
+		// refuse to apply a plan if the project has drifted since the plan was reviewed
+		err = checkPlan(ctx, args, reviewed)
+		if err != nil {
+			return err
+		}
+		spec = reviewed.Spec
+	case args.Apply.VerifyKey != "":
+		// verify before reading, so that an untrusted spec is never decoded or decrypted
+		if args.Project != "" {
+			return errors.New("--verify-key cannot be used with --project since there is no spec to verify")
+		}
+		path, err := projectSpecPath(args)
+		if err != nil {
+			return err
+		}
+		err = verifySpecFiles(path, args.Apply.VerifyKey, args.Apply.Signature)
+		if err != nil {
+			return err
+		}
+		spec, err = loadProjectSpec(fsys, path)
+		if err != nil {
+			return err
+		}
+	default:
+		var err error
+		spec, err = readProjectSpec(args)
+		if err != nil {
+			return err
+		}
+	}
+	return applySpec(ctx, args, spec)
+}
+
+// applySpec creates or updates the project and the resources in a spec
+func applySpec(ctx context.Context, args *args, spec *ProjectSpec) (err error) {
+	// record a trace of the apply if an OTLP endpoint was given
+	ctx, tracer := withTracer(ctx, args.OTLPEndpoint)
+	ctx, span := startSpan(ctx, "apply", 1)
+	defer func() {
+		span.finish(err)
+		if flushErr := tracer.flush(context.Background()); flushErr != nil {
+			fmt.Println("warning:", flushErr)
+		}
+	}()
+
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
+
+	// now enable the appropriate APIs
+	apis, err := serviceusage.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the service usage API: %w", err)
+	}
+
+	// initialize the billing service
+	billing, err := cloudbilling.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the billing API: %w", err)
+	}
+
+	// load the record of resources created by gproj, if state tracking is enabled
+	backend, err := newStateBackend(ctx, conn, spec)
+	if err != nil {
+		return err
+	}
+
+	var state *State
+	if backend != nil {
+		// prevent others from applying concurrently
+		unlock, err := lockState(ctx, backend)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		state, err = backend.Load(ctx)
+		if err != nil {
+			return err
+		}
+		state.ProjectID = spec.ID
+
+		// save even if apply fails part-way through so that partially created resources are tracked
+		defer func() {
+			ctx, cancel := cleanupContext(ctx)
+			defer cancel()
+			if err := backend.Save(ctx, state); err != nil {
+				fmt.Println("warning:", err)
+			}
+		}()
+	}
+
+	// record which commit of the spec was applied, and warn if that is not the whole story
+	rev, dirty := specRevision(spec.path)
+	if dirty {
+		fmt.Printf("warning: %s has uncommitted changes\n", spec.path)
+		rev += "-dirty"
+	}
+
+	a := applier{
+		args:      args,
+		spec:      spec,
+		conn:      conn,
+		state:     state,
+		resources: resources,
+		apis:      apis,
+		billing:   billing,
+		specRev:   rev,
+	}
+
+	// look up the owner up front so that a failure does not leave a half-created project
+	if args.Apply.OwnerLabel {
+		a.owner, err = principalEmail(ctx, conn)
+		if err != nil {
+			return err
+		}
+	}
+
+	g := a.graph()
+	if isInteractive(args) {
+		g.recover = a.recoverInteractively
+	}
+	started := time.Now()
+
+	// record each completed step so that an interrupted apply can be resumed, carrying on
+	// from an earlier record if this is a resume
+	prog := &progress{Spec: spec, SpecPath: spec.path, Args: args.Apply, Started: started}
+	if args.Resume != nil {
+		prog, err = loadProgress(spec.ID)
+		if err != nil {
+			return err
+		}
+		g.skip = prog.skippable()
+	}
+	if !spec.encrypted {
+		// the record contains the spec, which must not be written to disk if it was encrypted
+		g.onDone = func(name string) {
+			prog.Completed = append(prog.Completed, name)
+			if err := prog.save(); err != nil {
+				fmt.Println("warning:", err)
+			}
+		}
+	}
+	endGroup := ciGroup(args, "gproj apply "+spec.ID)
+	err = g.run(ctx, args.Apply.Parallelism)
+	endGroup()
+
+	// in CI mode, publish a summary of the apply and the outputs for later steps
+	if ciErr := writeCISummary(args, actionsMarkdown(spec.ID, g.actions, err)); ciErr != nil {
+		fmt.Println("warning: unable to write CI summary:", ciErr)
+	}
+	outputs := [][2]string{{"changed", strconv.FormatBool(len(g.changed) > 0)}}
+	if a.project != nil {
+		outputs = append(outputs, [2]string{"project-number", strconv.FormatInt(a.project.ProjectNumber, 10)})
+	}
+	outputs = append(outputs, a.oauthClients...)
+	outputs = append(outputs, a.pluginOutputs...)
+	if ciErr := writeCIOutputs(args, outputs); ciErr != nil {
+		fmt.Println("warning: unable to write CI outputs:", ciErr)
+	}
+
+	// write the report even if the apply failed, since that is when it is most useful
+	if args.Apply.Report != "" {
+		reportErr := writeReport(args.Apply.Report, &Report{
+			ProjectID: spec.ID,
+			Started:   started,
+			Finished:  time.Now(),
+			Success:   err == nil,
+			Actions:   g.actions,
+		})
+		if reportErr != nil {
+			fmt.Println("warning:", reportErr)
+		}
+	}
+
+	notifyApplyFinished(ctx, args, spec.ID, g.changed, err)
+	if err != nil {
+		if len(prog.Completed) > 0 {
+			fmt.Printf("to carry on from the last completed step, run\n  $ gproj resume\n")
+		}
+		return err
+	}
+	if err := prog.remove(); err != nil {
+		fmt.Println("warning:", err)
+	}
+
+	// TODO: disable API that have been removed from the config
+
+	took := humanDuration(time.Since(started))
+	if len(g.changed) == 0 {
+		fmt.Printf("checked %d resources in %s, project %s is up to date\n", g.checked, took, spec.ID)
+		return nil
+	}
+	fmt.Printf("checked %d resources in %s, changed %d: %s\n", g.checked, took, len(g.changed), strings.Join(g.changed, ", "))
+	return nil
+}
+
+// get the full names of the APIs to enable, including those implied by other parts of the spec
+func (a *applier) apisToEnable() []string {
+	if a.args.Verbose {
+		for _, api := range a.spec.APIs {
+			if full := expandAPIName(api); full != api {
+				fmt.Printf("assuming that %q means %q\n", api, full)
+			}
+		}
+	}
+	return desiredAPIs(a.spec)
+}
+
+// build the graph of steps needed to apply the spec
+func (a *applier) graph() *graph {
+	var g graph
+	var resourceNodes []string // the steps that record resources in the state, which must finish before it is pruned
+	g.add("project", nil, a.ensureProject)
+	g.add("labels", []string{"project"}, a.ensureLabels)
+	if len(a.spec.Tags) > 0 {
+		g.add("tags", []string{"project"}, a.ensureTags)
+	}
+	g.add("billing", []string{"project"}, a.ensureBilling)
+	if len(a.spec.AllowedCustomerIDs) > 0 {
+		g.add("domain-restriction", []string{"project"}, a.ensureDomainRestriction)
+	}
+	if len(a.spec.IAM) > 0 {
+		g.add("iam", []string{"project"}, a.ensureIAM)
+	}
+	if a.spec.Budget != nil {
+		g.add("budget", []string{"billing"}, a.ensureBudget)
+	}
+
+	// most APIs cannot be enabled until billing is set up
+	toEnable := a.apisToEnable()
+	g.add("enabled-apis", []string{"billing"}, func(ctx context.Context) (bool, error) {
+		return false, a.findEnabledAPIs(ctx, toEnable)
+	})
+	for _, api := range toEnable {
+		api := api
+		g.add(apiNode(api), []string{"enabled-apis"}, func(ctx context.Context) (bool, error) {
+			return a.enableAPI(ctx, api)
+		})
+	}
+
+	if a.spec.IdentityPlatform != nil {
+		g.add("identity-platform", []string{apiNode("identitytoolkit.googleapis.com")}, a.ensureIdentityPlatform)
+	}
+	if a.spec.OAuth != nil {
+		g.add("oauth-brand", []string{apiNode("iap.googleapis.com")}, a.ensureOAuthBrand)
+		if len(a.spec.OAuth.Clients) > 0 {
+			g.add("oauth-clients", []string{"oauth-brand"}, a.ensureOAuthClients)
+			resourceNodes = append(resourceNodes, "oauth-clients")
+		}
+	}
+	if len(a.spec.ActAs) > 0 {
+		g.add("act-as", []string{apiNode("iam.googleapis.com")}, a.ensureActAs)
+	}
+	if a.spec.Compute != nil && len(a.spec.Compute.Metadata) > 0 {
+		g.add("compute-metadata", []string{apiNode("compute.googleapis.com")}, a.ensureComputeMetadata)
+	}
+	if a.spec.Compute != nil && a.spec.Compute.SharedVPC != nil {
+		g.add("shared-vpc", []string{apiNode("compute.googleapis.com")}, a.ensureSharedVPC)
+	}
+	if a.spec.ServiceNetworking != nil {
+		g.add("service-networking", []string{apiNode("compute.googleapis.com"), apiNode("servicenetworking.googleapis.com")}, a.ensureServiceNetworking)
+	}
+	if a.spec.Compute != nil && a.spec.Compute.RequireShieldedVM {
+		g.add("shielded-vm", []string{"project"}, a.ensureShieldedVM)
+	}
+
+	for _, svc := range a.spec.CloudRun {
+		svc := svc
+		name := "cloudrun:" + svc.Name
+		g.add(name, []string{apiNode("run.googleapis.com")}, func(ctx context.Context) (bool, error) {
+			return applyCloudRunService(ctx, a.conn, a.spec.ID, svc, a.state)
+		})
+		resourceNodes = append(resourceNodes, name)
+	}
+
+	for _, ds := range a.spec.Datasets {
+		ds := ds
+		name := "dataset:" + ds.Name
+		g.add(name, []string{apiNode("bigquery.googleapis.com")}, func(ctx context.Context) (bool, error) {
+			return applyDataset(ctx, a.conn, a.spec.ID, ds, a.state)
+		})
+		resourceNodes = append(resourceNodes, name)
+	}
+
+	if a.spec.BillingExport != nil {
+		g.add("billing-export", []string{"billing", apiNode("bigquery.googleapis.com")}, a.ensureBillingExport)
+		resourceNodes = append(resourceNodes, "billing-export")
+	}
+
+	for _, inst := range a.spec.CloudSQL {
+		inst := inst
+		name := "cloudsql:" + inst.Name
+		deps := []string{apiNode("sqladmin.googleapis.com")}
+		if a.spec.ServiceNetworking != nil {
+			// a private IP needs the peering to be in place first
+			deps = append(deps, "service-networking")
+		}
+		g.add(name, deps, func(ctx context.Context) (bool, error) {
+			return applyCloudSQL(ctx, a.conn, a.spec, inst, a.state)
+		})
+		resourceNodes = append(resourceNodes, name)
+	}
+
+	for _, r := range a.spec.Resources {
+		r := r
+		name := "resource:" + r.Name
+		deps := []string{"project"}
+		if r.API != "" {
+			deps = append(deps, apiNode(expandAPIName(r.API)))
+		}
+		if a.spec.ServiceNetworking != nil {
+			// many regional resources, such as memorystore instances, use private service access
+			deps = append(deps, "service-networking")
+		}
+		g.add(name, deps, func(ctx context.Context) (bool, error) {
+			return applyGenericResource(ctx, a.conn, a.spec.ID, r, a.state)
+		})
+		resourceNodes = append(resourceNodes, name)
+	}
+
+	// plugins may call any API, so they run once all the APIs in the spec are enabled
+	var allAPIs []string
+	for _, api := range toEnable {
+		allAPIs = append(allAPIs, apiNode(api))
+	}
+	for _, p := range a.spec.Plugins {
+		p := p
+		g.add("plugin:"+p.Name, append([]string{"enabled-apis"}, allAPIs...), func(ctx context.Context) (bool, error) {
+			return a.applyPlugin(ctx, p)
+		})
+	}
+
+	for _, key := range a.spec.APIKeys {
+		key := key
+		name := "apikey:" + key.Name
+		g.add(name, []string{apiNode("apikeys.googleapis.com")}, func(ctx context.Context) (bool, error) {
+			return applyAPIKey(ctx, a.conn, a.spec.ID, key, a.state)
+		})
+		resourceNodes = append(resourceNodes, name)
+	}
+
+	if len(a.spec.Scheduler) > 0 {
+		// all jobs share the one app engine application, which is created in the region of the first job
+		g.add("appengine", []string{apiNode("appengine.googleapis.com")}, func(ctx context.Context) (bool, error) {
+			return ensureAppEngineApp(ctx, a.conn, a.spec.ID, a.spec.Scheduler[0].Region)
+		})
+
+		for _, job := range a.spec.Scheduler {
+			job := job
+			name := "scheduler:" + job.Name
+			deps := []string{"appengine", apiNode("cloudscheduler.googleapis.com")}
+			if job.PubSub != nil {
+				deps = append(deps, apiNode("pubsub.googleapis.com"))
+			}
+			g.add(name, deps, func(ctx context.Context) (bool, error) {
+				return applySchedulerJob(ctx, a.conn, a.spec.ID, job, a.state)
+			})
+			resourceNodes = append(resourceNodes, name)
+		}
+	}
+
+	// deal with resources that gproj created but which have since been removed from the spec
+	if a.state != nil {
+		g.add("prune", append([]string{"project"}, resourceNodes...), func(ctx context.Context) (bool, error) {
+			return pruneOrphans(ctx, a.conn, a.spec, a.state, a.args.Apply.Prune)
+		})
+	}
+
+	return &g
+}
+
+// get the labels that gproj sets on the project, which are those in the spec plus a
+// label that marks the project as managed by gproj and one that records the spec's commit
+func (a *applier) labels() map[string]string {
+	// deep copy the labels so that we can safely modify the map
+	labels := make(map[string]string)
+	for k, v := range a.spec.Labels {
+		labels[k] = v
+	}
+	labels[managedByLabel] = managedByValue
+	if a.specRev != "" {
+		labels[specRevLabel] = a.specRev
+	}
+	return labels
+}
+
+// update the labels on an existing project, and report whether any were changed. Labels
+// that are not in the spec are left alone, as are all labels if the spec ignores changes
+// to them.
+func (a *applier) ensureLabels(ctx context.Context) (bool, error) {
+	if a.spec.ignoresChanges("labels") {
+		return false, nil
+	}
+
+	var changed []string
+	for k, v := range a.labels() {
+		if a.project.Labels[k] != v {
+			changed = append(changed, k)
+		}
+	}
+	if len(changed) == 0 {
+		return false, nil
+	}
+	sort.Strings(changed)
+
+	before := formatLabels(a.project.Labels)
+	updated := *a.project
+	updated.Labels = make(map[string]string)
+	for k, v := range a.project.Labels {
+		updated.Labels[k] = v
+	}
+	for k, v := range a.labels() {
+		updated.Labels[k] = v
+	}
+
+	project, err := a.resources.Projects.Update(a.spec.ID, &updated).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error updating labels: %w", err)
+	}
+
+	// a.project is not replaced since other nodes that depend only on the project read it
+	// concurrently, and nothing else changes when the labels do
+	fmt.Printf("updated labels: %s\n", strings.Join(changed, ", "))
+	noteChange(ctx, before, formatLabels(project.Labels))
+	return true, nil
+}
+
+// the label that marks projects (and other resources) as managed by gproj
+const (
+	managedByLabel = "managed-by"
+	managedByValue = "gproj"
+)
+
+// the labels with which apply --owner-label records who created a project and with which
+// version of gproj
+const (
+	ownerLabel        = "owner"
+	gprojVersionLabel = "created-by-gproj-version"
+)
+
+// the labels that gproj sets on projects itself, which are not part of the spec and so are
+// left out of diffs and snapshots
+var gprojLabels = map[string]bool{
+	managedByLabel:    true,
+	specRevLabel:      true,
+	deleteAfterLabel:  true,
+	pausedLabel:       true,
+	ownerLabel:        true,
+	gprojVersionLabel: true,
+}
+
+// checkManaged returns an error if a project that already exists is not labelled as
+// managed by gproj, to avoid taking over projects that are managed by hand or by other tools
+func checkManaged(project *cloudresourcemanager.Project) error {
+	if project.Labels[managedByLabel] == managedByValue {
+		return nil
+	}
+	return fmt.Errorf("project %s exists but is not labelled %s=%s, so it may be managed by something else; "+
+		"use --adopt to manage it with gproj anyway", project.ProjectId, managedByLabel, managedByValue)
+}
+
+// for a while after a project is created, calls concerning it may fail with 403 or 404
+// while it propagates within google cloud
+const consistencyWindow = 2 * time.Minute
+
+// determine whether an error may be due to a newly created project not yet being visible
+func isNotYetVisible(err error) bool {
+	var e *googleapi.Error
+	return errors.As(err, &e) && (e.Code == 403 || e.Code == 404)
+}
+
+// retryAfterCreate calls f, retrying with back-off if it fails in a way that may be due to
+// the project having only just been created. It does not retry if the project already
+// existed or was created more than consistencyWindow ago.
+func (a *applier) retryAfterCreate(ctx context.Context, f func() error) error {
+	delay := time.Second
+	for {
+		err := f()
+		if err == nil || a.created.IsZero() || time.Since(a.created) > consistencyWindow || !isNotYetVisible(err) {
+			return err
+		}
+		if a.args.Verbose {
+			fmt.Printf("project was just created, retrying in %v: %v\n", delay, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay < 10*time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// label an existing project as managed by gproj
+func (a *applier) adoptProject(ctx context.Context) (bool, error) {
+	before := formatLabels(a.project.Labels)
+	updated := *a.project
+	updated.Labels = map[string]string{managedByLabel: managedByValue}
+	for k, v := range a.project.Labels {
+		updated.Labels[k] = v
+	}
+	updated.Labels[managedByLabel] = managedByValue
+
+	project, err := a.resources.Projects.Update(a.spec.ID, &updated).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error labelling %s as managed by gproj: %w", a.spec.ID, err)
+	}
+
+	fmt.Printf("adopted project %s\n", a.spec.ID)
+	noteChange(ctx, before, formatLabels(project.Labels))
+	a.project = project
+	return true, nil
+}
+
+// fetch the project, creating it if necessary, and report whether it was created
+func (a *applier) ensureProject(ctx context.Context) (bool, error) {
+	spec := a.spec
+	project, err := a.resources.Projects.Get(spec.ID).Context(ctx).Do()
+	if err == nil {
+		a.project = project
+		// applying would relink billing and re-enable APIs behind the back of the record
+		if date := project.Labels[pausedLabel]; date != "" {
+			return false, fmt.Errorf("project %s was paused on %s; run \"gproj resume-project\" before applying", spec.ID, date)
+		}
+		if checkManaged(project) == nil {
+			return false, nil
+		}
+		if !a.args.Apply.Adopt {
+			return false, checkManaged(project)
+		}
+		return a.adoptProject(ctx)
+	}
+
+	// we get "403 Forbidden" if the project does not exist since projects IDs
+	// are global and Google doesn't want to reveal whether the project exists
+	// in someone else's account or not
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != 403 {
+		return false, err
+	}
+
+	fmt.Printf("project %s does not exist, attempting to create it...\n", spec.ID)
+
+	if len(spec.Name) < 4 {
+		return false, fmt.Errorf("project name %q invalid: must be at least 4 characters long (required by Google Cloud)", spec.Name)
+	}
+
+	parent, err := spec.parentResource()
+	if err != nil {
+		return false, err
+	}
+
+	project = &cloudresourcemanager.Project{
+		Name:      spec.Name,
+		ProjectId: spec.ID,
+		Labels:    a.labels(),
+		Parent:    parent,
+	}
+
+	// for cost attribution, record who created the project and with which version of gproj
+	if a.owner != "" {
+		if _, present := project.Labels[ownerLabel]; !present {
+			project.Labels[ownerLabel] = sanitizeLabel(a.owner)
+		}
+		project.Labels[gprojVersionLabel] = sanitizeLabel(version)
+	}
+
+	// creating projects is a long-running operation so we have to poll
+	createOp, err := a.resources.Projects.Create(project).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error creating project: %w", err)
+	}
+	noteOperation(ctx, createOp.Name)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	err = timeOperation("create-project", "project "+spec.ID+" to be created", func() error {
+		return waitForCreate(waitCtx, a.resources.Operations, createOp)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error creating project: %w", err)
+	}
+
+	a.created = time.Now()
+
+	// now fetch the final project info containing the data filled in by the server
+	err = a.retryAfterCreate(ctx, func() error {
+		project, err = a.resources.Projects.Get(spec.ID).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error getting project info right after creating it: %w", err)
+	}
+	a.state.Record(kindProject, "projects/"+spec.ID, "")
+
+	// enable the billing API, which we need in order to enable further APIs
+	projNum := formatProjectNumber(project.ProjectNumber)
+	var enableOp *serviceusage.Operation
+	err = a.retryAfterCreate(ctx, func() error {
+		enableOp, err = a.apis.Services.BatchEnable(projNum, &serviceusage.BatchEnableServicesRequest{
+			ServiceIds: []string{"cloudbilling.googleapis.com"},
+		}).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error in API call to enable APIs: %w", err)
+	}
+
+	err = waitForEnable(ctx, a.apis.Operations, enableOp)
+	if err != nil {
+		return false, fmt.Errorf("error enabling billing API: %v", err)
+	}
+	err = verifyEnabled(ctx, a.apis, project.ProjectNumber, []string{"cloudbilling.googleapis.com"})
+	if err != nil {
+		return false, fmt.Errorf("error enabling billing API: %w", err)
+	}
+
+	fmt.Printf("created project %s\n", spec.ID)
+	noteChange(ctx, "", fmt.Sprintf("created with number %d", project.ProjectNumber))
+	a.project = project
+	return true, nil
+}
+
+// link the project to the billing account in the spec, and report whether it was changed
+func (a *applier) ensureBilling(ctx context.Context) (bool, error) {
+	spec := a.spec
+
+	// get billing info for this account so that we know whether we need to change it
+	projNum := formatProjectNumber(a.project.ProjectNumber)
+	var billingInfo *cloudbilling.ProjectBillingInfo
+	err := a.retryAfterCreate(ctx, func() (err error) {
+		billingInfo, err = a.billing.Projects.GetBillingInfo(projNum).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error getting billing info for %s: %w", spec.ID, err)
+	}
+
+	a.billingEnabled = billingInfo.BillingEnabled
+	a.billingAccount = billingInfo.BillingAccountName
+	if a.spec.ignoresChanges("billing") {
+		return false, nil
+	}
+
+	// find the requested billing account
+	account, err := a.resolveBillingAccount(ctx)
+	if err != nil {
+		return false, err
+	}
+	if account == "" {
+		return false, nil // billing is not managed by gproj
+	}
+
+	// nothing to do if the project is already linked to the right account
+	if billingInfo.BillingAccountName == account {
+		return false, nil
+	}
+
+	err = checkBillingAccount(ctx, a.billing, account)
+	if err != nil {
+		return false, err
+	}
+
+	// update the billing account
+	fmt.Printf("updating billing account to %s\n", account)
+	var updatedBilling *cloudbilling.ProjectBillingInfo
+	err = a.retryAfterCreate(ctx, func() (err error) {
+		updatedBilling, err = a.billing.Projects.UpdateBillingInfo(projNum, &cloudbilling.ProjectBillingInfo{
+			BillingAccountName: account,
+		}).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		err = explainBillingError(ctx, a.billing, spec.ID, account, err)
+		return false, fmt.Errorf("error updating billing info: %w", err)
+	}
+
+	// check that billing is now enabled
+	if !updatedBilling.BillingEnabled {
+		return false, fmt.Errorf(
+			"billing account was updated but API response shows billing still not enabled:\n%s",
+			pretty.Sprint(updatedBilling))
+	}
+
+	a.billingEnabled = true
+	a.billingAccount = account
+	fmt.Println("updated billing info")
+	noteChange(ctx, billingInfo.BillingAccountName, account)
+	return true, nil
+}
+
+// look up which APIs are already enabled so that only the remainder are submitted
+func (a *applier) findEnabledAPIs(ctx context.Context, toEnable []string) error {
+	var enabled []string
+	err := a.retryAfterCreate(ctx, func() (err error) {
+		enabled, err = enabledAPIs(ctx, a.apis, a.project.ProjectNumber)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	a.enabled = make(map[string]bool)
+	for _, api := range enabled {
+		a.enabled[api] = true
+	}
+
+	var already, remaining []string
+	for _, api := range toEnable {
+		if a.enabled[api] {
+			already = append(already, api)
+		} else {
+			remaining = append(remaining, api)
+		}
+	}
+
+	if len(already) > 0 && (a.args.Verbose || len(remaining) > 0) {
+		fmt.Printf("already enabled: %s\n", strings.Join(already, ", "))
+	}
+	if len(remaining) > 0 {
+		fmt.Printf("enabling: %s\n", strings.Join(remaining, ", "))
+	}
+
+	// fail now rather than part-way through enabling APIs
+	missing, err := missingServices(ctx, a.apis, a.project.ProjectNumber, remaining)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		var lines []string
+		for _, name := range missing {
+			lines = append(lines, fmt.Sprintf("  %s: %s", name, missingServiceHint(name)))
+		}
+		return fmt.Errorf("not found in the service catalog of %s:\n%s", a.spec.ID, strings.Join(lines, "\n"))
+	}
+	if blocked := needBilling(remaining); len(blocked) > 0 && !a.billingEnabled {
+		return fmt.Errorf("billing is not enabled for %s, which is needed to enable %s (see \"gproj explain billing\")",
+			a.spec.ID, strings.Join(blocked, ", "))
+	}
+	return nil
+}
+
+// enable a single API if it is not already enabled, and report whether it was enabled
+func (a *applier) enableAPI(ctx context.Context, api string) (bool, error) {
+	if a.enabled[api] {
+		return false, nil
+	}
+
+	name := fmt.Sprintf("%s/services/%s", formatProjectNumber(a.project.ProjectNumber), api)
+	var enableOp *serviceusage.Operation
+	err := a.retryAfterCreate(ctx, func() (err error) {
+		enableOp, err = a.apis.Services.Enable(name, &serviceusage.EnableServiceRequest{}).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error in API call to enable %s: %w", api, err)
+	}
+	noteOperation(ctx, enableOp.Name)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Minute) // this can really take a while
+	defer cancel()
+
+	err = timeOperation("enable-api", api+" to be enabled", func() error {
+		return waitForEnable(waitCtx, a.apis.Operations, enableOp)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error enabling %s: %w", api, err)
+	}
+	err = verifyEnabled(waitCtx, a.apis, a.project.ProjectNumber, []string{api})
+	if err != nil {
+		return false, fmt.Errorf("error enabling %s: %w", api, err)
+	}
+	noteChange(ctx, "DISABLED", "ENABLED")
+	return true, nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/cloudbilling/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// ways in which the billing field of the spec can select a billing account
+const (
+	billingNone    = "none"    // do not manage billing
+	billingAuto    = "auto"    // use the only open billing account, failing if there are several
+	billingPrompt  = "prompt"  // ask which open billing account to use
+	billingID      = "id"      // the billing account with the given ID
+	billingDisplay = "display" // the open billing account with the given display name
+)
+
+// billing account IDs look like "012345-6789AB-CDEFG0"
+var billingIDPattern = regexp.MustCompile(`^[0-9A-Fa-f]{6}-[0-9A-Fa-f]{6}-[0-9A-Fa-f]{6}$`)
+
+// work out which strategy the billing field of the spec asks for. "enable" is the old
+// name for "auto" and an empty field means "none".
+func billingStrategy(billing string) string {
+	switch billing {
+	case "", billingNone:
+		return billingNone
+	case billingAuto, "enable":
+		return billingAuto
+	case billingPrompt:
+		return billingPrompt
+	}
+	if strings.HasPrefix(billing, "billingAccounts/") || billingIDPattern.MatchString(billing) {
+		return billingID
+	}
+	return billingDisplay
+}
+
+// list the open billing accounts visible to the current user
+func openBillingAccounts(ctx context.Context, billing *cloudbilling.APIService) ([]*cloudbilling.BillingAccount, error) {
+	var open []*cloudbilling.BillingAccount
+	err := billing.BillingAccounts.List().Pages(ctx, func(r *cloudbilling.ListBillingAccountsResponse) error {
+		for _, a := range r.BillingAccounts {
+			if a.Open {
+				open = append(open, a)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing billing accounts: %w", err)
+	}
+	return open, nil
+}
+
+// billingChoice is an open billing account offered to the user, with what is needed to
+// choose between accounts
+type billingChoice struct {
+	account  *cloudbilling.BillingAccount
+	currency string // e.g. "USD", or empty if unknown
+	linked   int    // number of projects linked to the account, or -1 if unknown
+	canLink  bool   // whether the current user may link projects to the account
+}
+
+func (c *billingChoice) String() string {
+	s := fmt.Sprintf("%s (%s", c.account.DisplayName, strings.TrimPrefix(c.account.Name, "billingAccounts/"))
+	if c.currency != "" {
+		s += ", " + c.currency
+	}
+	if c.linked >= 0 {
+		s += fmt.Sprintf(", %d projects", c.linked)
+	}
+	if !c.canLink {
+		s += ", cannot link projects"
+	}
+	return s + ")"
+}
+
+// count the projects linked to a billing account
+func linkedProjects(ctx context.Context, billing *cloudbilling.APIService, account string) (int, error) {
+	var n int
+	err := billing.BillingAccounts.Projects.List(account).Pages(ctx, func(r *cloudbilling.ListProjectBillingInfoResponse) error {
+		for _, info := range r.ProjectBillingInfo {
+			if info.BillingEnabled {
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// look up what is needed to choose between billing accounts, and rank them: first those to
+// which the user may link projects, then those with the fewest projects already linked,
+// since they are furthest from the quota on linked projects
+func rankBillingAccounts(ctx context.Context, conn *connection, billing *cloudbilling.APIService, accounts []*cloudbilling.BillingAccount) []*billingChoice {
+	var choices []*billingChoice
+	for _, account := range accounts {
+		c := billingChoice{account: account, linked: -1}
+
+		// the currency is not in the generated client
+		var details struct {
+			CurrencyCode string `json:"currencyCode"`
+		}
+		if err := callJSON(ctx, conn, "GET", "https://cloudbilling.googleapis.com/v1/"+account.Name, nil, &details); err == nil {
+			c.currency = details.CurrencyCode
+		}
+		if n, err := linkedProjects(ctx, billing, account.Name); err == nil {
+			c.linked = n
+		}
+		perms, err := billing.BillingAccounts.TestIamPermissions(account.Name, &cloudbilling.TestIamPermissionsRequest{
+			Permissions: []string{billingLinkPermission},
+		}).Context(ctx).Do()
+		c.canLink = err == nil && contains(perms.Permissions, billingLinkPermission)
+		choices = append(choices, &c)
+	}
+
+	sort.SliceStable(choices, func(i, j int) bool {
+		a, b := choices[i], choices[j]
+		if a.canLink != b.canLink {
+			return a.canLink
+		}
+		if a.linked != b.linked {
+			return a.linked < b.linked
+		}
+		return strings.ToLower(a.account.DisplayName) < strings.ToLower(b.account.DisplayName)
+	})
+	return choices
+}
+
+// ask the user to pick one of several billing accounts
+func pickBillingAccount(args *args, choices []*billingChoice) (string, error) {
+	var options []string
+	for _, c := range choices {
+		options = append(options, c.String())
+	}
+	i, err := promptSelect(args, "billing account to use", options, "the billing account ID in the spec")
+	if err != nil {
+		return "", err
+	}
+	return choices[i].account.Name, nil
+}
+
+// list billing accounts for an error message, one per line
+func formatBillingChoices(choices []*billingChoice) string {
+	var lines []string
+	for _, c := range choices {
+		lines = append(lines, "  "+c.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkBillingAccount verifies that projects can be linked to a billing account before
+// trying to, since the error from linking to a closed account does not say why. Whether the
+// account has room for another project cannot be checked here because no API exposes the
+// quota; explainBillingError recognises that failure instead.
+func checkBillingAccount(ctx context.Context, billing *cloudbilling.APIService, account string) error {
+	info, err := billing.BillingAccounts.Get(account).Context(ctx).Do()
+	if err != nil {
+		// explainBillingError makes sense of a lack of access if linking fails
+		return nil
+	}
+	if !info.Open {
+		return fmt.Errorf("billing account %s (%s) is closed; reopen it at %s or choose another account in the spec",
+			account, info.DisplayName, billingAccountConsoleURL(account))
+	}
+	return nil
+}
+
+// resolveBillingAccount works out the resource name of the billing account that the spec
+// asks for, or returns the empty string if billing is not to be managed
+func (a *applier) resolveBillingAccount(ctx context.Context) (string, error) {
+	billing := a.spec.Billing
+	strategy := billingStrategy(billing)
+	switch strategy {
+	case billingNone:
+		return "", nil
+	case billingID:
+		return billingAccountName(billing), nil
+	}
+
+	fmt.Println("looking up available billing accounts...")
+	open, err := openBillingAccounts(ctx, a.billing)
+	if err != nil {
+		return "", err
+	}
+
+	switch strategy {
+	case billingAuto:
+		if len(open) == 1 {
+			fmt.Printf("using the only open billing account: %s (%s)\n", open[0].Name, open[0].DisplayName)
+			return open[0].Name, nil
+		}
+		if len(open) == 0 {
+			return "", fmt.Errorf("billing is %q but there are no open billing accounts", billing)
+		}
+		choices := rankBillingAccounts(ctx, a.conn, a.billing, open)
+		if !isInteractive(a.args) {
+			return "", fmt.Errorf("billing is %q but found %d open billing accounts; give the ID of one of these "+
+				"in the spec instead (see \"gproj explain billing\"):\n%s", billing, len(open), formatBillingChoices(choices))
+		}
+		fmt.Printf("found %d open billing accounts:\n", len(open))
+		return pickBillingAccount(a.args, choices)
+
+	case billingPrompt:
+		if len(open) == 0 {
+			return "", fmt.Errorf("there are no open billing accounts to choose from")
+		}
+		return pickBillingAccount(a.args, rankBillingAccounts(ctx, a.conn, a.billing, open))
+	}
+
+	// otherwise look up the account by display name
+	var matches []*cloudbilling.BillingAccount
+	for _, account := range open {
+		if strings.EqualFold(account.DisplayName, billing) {
+			matches = append(matches, account)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no open billing account named %q", billing)
+	case 1:
+		return matches[0].Name, nil
+	}
+	return "", fmt.Errorf("%d open billing accounts are named %q; give the billing account ID instead", len(matches), billing)
+}
+
+// the permission needed on a billing account in order to link projects to it
+const billingLinkPermission = "billing.resourceAssociations.create"
+
+// the form for asking google to allow more projects to be linked to a billing account
+const billingQuotaIncreaseURL = "https://support.google.com/code/contact/billing_quota_increase"
+
+// get the resource name of a billing account, e.g. "billingAccounts/012345-6789AB-CDEFG0",
+// given either its resource name or its bare ID
+func billingAccountName(account string) string {
+	if strings.HasPrefix(account, "billingAccounts/") {
+		return account
+	}
+	return "billingAccounts/" + account
+}
+
+// get the console page on which the permissions for a billing account are managed
+func billingAccountConsoleURL(account string) string {
+	return fmt.Sprintf("%s/billing/%s/manage", consoleBase, strings.TrimPrefix(billingAccountName(account), "billingAccounts/"))
+}
+
+// isBillingQuotaError reports whether linking failed because the billing account has as
+// many projects linked to it as it may. The API reports this as a failed precondition whose
+// message says nothing about quotas, so the details have to be searched too.
+func isBillingQuotaError(apiErr *googleapi.Error) bool {
+	if apiErr.Code != 400 && apiErr.Code != 429 {
+		return false
+	}
+	text := apiErr.Message + " " + apiErr.Body
+	for _, detail := range apiErr.Details {
+		text += " " + fmt.Sprint(detail)
+	}
+	return strings.Contains(strings.ToLower(text), "quota")
+}
+
+// explainBillingError looks into why linking a project to a billing account was forbidden
+// and returns an error with next steps for the most common causes. Errors other than 403
+// are returned unchanged.
+func explainBillingError(ctx context.Context, billing *cloudbilling.APIService, projectID, account string, err error) error {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+
+	name := billingAccountName(account)
+	url := billingAccountConsoleURL(account)
+
+	// each billing account may only have so many projects linked to it, and the quota is
+	// not exposed by any API, so it only shows up when linking fails
+	if isBillingQuotaError(apiErr) {
+		linked := "its"
+		if n, countErr := linkedProjects(ctx, billing, name); countErr == nil {
+			linked = fmt.Sprintf("its %d", n)
+		}
+		return fmt.Errorf("%w\n\nbilling account %s has reached its quota of linked projects. Unlink one of "+
+			"%s projects, choose another account in the spec, or request more at\n  %s",
+			err, name, linked, billingQuotaIncreaseURL)
+	}
+	if apiErr.Code != 403 {
+		return err
+	}
+
+	// a closed account cannot have projects linked to it
+	info, getErr := billing.BillingAccounts.Get(name).Context(ctx).Do()
+	if getErr == nil && !info.Open {
+		return fmt.Errorf("billing account %s (%s) is closed; reopen it at %s or choose another account in the spec",
+			name, info.DisplayName, url)
+	}
+
+	// if the account cannot even be read then the caller has no role on it at all
+	if getErr != nil {
+		return fmt.Errorf("%w\n\nyou do not appear to have access to billing account %s. Ask a billing "+
+			"administrator to grant you the Billing Account User role at\n  %s", err, name, url)
+	}
+
+	perms, permErr := billing.BillingAccounts.TestIamPermissions(name, &cloudbilling.TestIamPermissionsRequest{
+		Permissions: []string{billingLinkPermission},
+	}).Context(ctx).Do()
+	if permErr == nil && !contains(perms.Permissions, billingLinkPermission) {
+		return fmt.Errorf("%w\n\nyou lack %s on billing account %s. Ask a billing administrator to grant "+
+			"you the Billing Account User role at\n  %s", err, billingLinkPermission, name, url)
+	}
+
+	// the account looks fine so the missing permission must be on the project side
+	return fmt.Errorf("%w\n\nyou have access to billing account %s, so the missing permission is probably "+
+		"resourcemanager.projects.createBillingAssignment on project %s. Ask a project owner to grant you the "+
+		"Project Billing Manager role at\n  %s", err, name, projectID, consoleURL("iam-admin/iam", projectID))
+}
+
+// unlinkBilling detaches a project from its billing account, which stops charges from
+// accruing immediately rather than at the end of the 30 day window after deletion
+func unlinkBilling(ctx context.Context, conn *connection, projectID string) error {
+	billing, err := cloudbilling.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the billing API: %w", err)
+	}
+
+	name := "projects/" + projectID
+	info, err := billing.Projects.GetBillingInfo(name).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error getting billing info for %s: %w", projectID, err)
+	}
+	if info.BillingAccountName == "" {
+		return nil
+	}
+
+	_, err = billing.Projects.UpdateBillingInfo(name, &cloudbilling.ProjectBillingInfo{
+		BillingAccountName: "",
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error unlinking billing account from %s: %w", projectID, err)
+	}
+	fmt.Printf("unlinked %s from billing account %s\n", projectID, info.BillingAccountName)
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsBillingQuotaError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *googleapi.Error
+		want bool
+	}{
+		{
+			name: "quota in the message",
+			err:  &googleapi.Error{Code: 400, Message: "Cloud billing quota exceeded"},
+			want: true,
+		},
+		{
+			name: "failed precondition with a quota failure in the details",
+			err: &googleapi.Error{
+				Code:    400,
+				Message: "Precondition check failed.",
+				Body: `{"error": {"code": 400, "message": "Precondition check failed.", "status": "FAILED_PRECONDITION",
+					"details": [{"@type": "type.googleapis.com/google.rpc.QuotaFailure",
+					"violations": [{"subject": "billingAccounts/000000-000000-000000",
+					"description": "Cloud billing quota exceeded: https://support.google.com/code/contact/billing_quota_increase"}]}]}}`,
+			},
+			want: true,
+		},
+		{
+			name: "quota failure only in the parsed details",
+			err: &googleapi.Error{
+				Code:    429,
+				Message: "Precondition check failed.",
+				Details: []interface{}{map[string]interface{}{
+					"@type":      "type.googleapis.com/google.rpc.QuotaFailure",
+					"violations": []interface{}{map[string]interface{}{"description": "Cloud billing quota exceeded"}},
+				}},
+			},
+			want: true,
+		},
+		{
+			name: "other precondition failure",
+			err:  &googleapi.Error{Code: 400, Message: "Precondition check failed.", Body: `{"error": {"code": 400}}`},
+		},
+		{
+			name: "permission denied that mentions a quota project",
+			err:  &googleapi.Error{Code: 403, Message: "The caller does not have permission on the quota project"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isBillingQuotaError(test.err); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
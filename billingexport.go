@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/bigquery/v2"
+)
+
+// BillingExport models the "billingExport" section of googlecloudproject.yaml, which
+// prepares a dataset in the project to receive the billing account's cost data
+type BillingExport struct {
+	Dataset  string // ID of the dataset to export to (default billing_export)
+	Location string // e.g. "US" or "europe-west1" (default US)
+}
+
+// the service account through which cloud billing writes to the export dataset
+const billingExportAccount = "billing-export-bigquery@system.gserviceaccount.com"
+
+// the prefix of the tables that cloud billing creates once export is turned on
+const billingExportTablePrefix = "gcp_billing_export_"
+
+// fill in the defaults for fields that are not set
+func (e BillingExport) withDefaults() BillingExport {
+	if e.Dataset == "" {
+		e.Dataset = "billing_export"
+	}
+	if e.Location == "" {
+		e.Location = "US"
+	}
+	return e
+}
+
+// the dataset to which billing data is exported
+func (e BillingExport) dataset() BigQueryDataset {
+	e = e.withDefaults()
+	return BigQueryDataset{Name: e.Dataset, Location: e.Location}
+}
+
+// create the export dataset and allow cloud billing to write to it. Cloud billing has no
+// API for turning export on, so that last step is left to the console, and apply keeps
+// pointing it out until billing data shows up in the dataset.
+func (a *applier) ensureBillingExport(ctx context.Context) (bool, error) {
+	if a.billingAccount == "" {
+		return false, fmt.Errorf("project %s has a billing export but is not linked to a billing account", a.spec.ID)
+	}
+	ds := a.spec.BillingExport.dataset()
+
+	changed, err := applyDataset(ctx, a.conn, a.spec.ID, ds, a.state)
+	if err != nil {
+		return false, err
+	}
+
+	svc, err := bigquery.NewService(ctx, a.conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the bigquery API: %w", err)
+	}
+	d, err := svc.Datasets.Get(a.spec.ID, ds.Name).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error getting dataset %s: %w", ds.Name, err)
+	}
+
+	var granted bool
+	for _, entry := range d.Access {
+		if entry.UserByEmail == billingExportAccount && (entry.Role == "WRITER" || entry.Role == "OWNER") {
+			granted = true
+		}
+	}
+	if !granted {
+		fmt.Printf("allowing cloud billing to write to dataset %s\n", ds.Name)
+		access := append(d.Access, &bigquery.DatasetAccess{Role: "WRITER", UserByEmail: billingExportAccount})
+		_, err = svc.Datasets.Patch(a.spec.ID, ds.Name, &bigquery.Dataset{Access: access}).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error granting access to dataset %s: %w", ds.Name, err)
+		}
+		noteChange(ctx, "", "WRITER: "+billingExportAccount)
+		changed = true
+	}
+
+	// cloud billing creates its tables within a few hours of export being turned on
+	tables, err := svc.Tables.List(a.spec.ID, ds.Name).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error listing tables in dataset %s: %w", ds.Name, err)
+	}
+	for _, t := range tables.Tables {
+		if strings.HasPrefix(t.TableReference.TableId, billingExportTablePrefix) {
+			return changed, nil
+		}
+	}
+	fmt.Printf("warning: turn on billing export to %s.%s in the console, since there is no API for it:\n  %s\n",
+		a.spec.ID, ds.Name, consoleURL("billing/"+strings.TrimPrefix(a.billingAccount, "billingAccounts/")+"/export", a.spec.ID))
+	return changed, nil
+}
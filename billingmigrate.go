@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/api/cloudbilling/v1"
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// list the IDs of the projects linked to a billing account
+func linkedProjectIDs(ctx context.Context, billing *cloudbilling.APIService, account string) ([]string, error) {
+	var ids []string
+	err := billing.BillingAccounts.Projects.List(account).Pages(ctx, func(r *cloudbilling.ListProjectBillingInfoResponse) error {
+		for _, info := range r.ProjectBillingInfo {
+			if info.BillingEnabled {
+				ids = append(ids, info.ProjectId)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing projects linked to %s: %w", account, err)
+	}
+	return ids, nil
+}
+
+// billingMigrate relinks the gproj-managed projects that match a filter from one billing
+// account to another, after showing which projects will move and asking to continue
+func billingMigrate(ctx context.Context, args *args) error {
+	from := billingAccountName(args.Billing.Migrate.From)
+	to := billingAccountName(args.Billing.Migrate.To)
+	if from == to {
+		return fmt.Errorf("--from and --to are the same billing account")
+	}
+
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	billing, err := cloudbilling.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the billing API: %w", err)
+	}
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
+
+	// fail before touching any project if none of them could be linked
+	err = checkBillingAccount(ctx, billing, to)
+	if err != nil {
+		return err
+	}
+
+	linked, err := linkedProjectIDs(ctx, billing, from)
+	if err != nil {
+		return err
+	}
+
+	// only move projects that gproj manages, so that projects managed by hand or by other
+	// tools are left for their owners to move
+	terms := append([]string{fmt.Sprintf("label=%s:%s", managedByLabel, managedByValue)}, args.Billing.Migrate.Filter...)
+	filter := projectFilter(terms)
+	if args.Verbose {
+		fmt.Printf("filter: %s\n", filter)
+	}
+	matched, err := searchProjects(ctx, resources, filter)
+	if err != nil {
+		return err
+	}
+
+	var move []*cloudresourcemanager.Project
+	for _, p := range matched {
+		if contains(linked, p.ProjectId) {
+			move = append(move, p)
+		}
+	}
+	if len(move) == 0 {
+		fmt.Printf("none of the %d projects linked to %s are managed by gproj and match the filter\n", len(linked), from)
+		return nil
+	}
+
+	// show the plan
+	fmt.Printf("will move %d projects from %s to %s:\n", len(move), from, to)
+	for _, p := range move {
+		fmt.Printf("  ~ %s (%s)\n", p.ProjectId, p.Name)
+	}
+	if skipped := len(linked) - len(move); skipped > 0 {
+		fmt.Printf("%d other projects linked to %s are not managed by gproj or do not match the filter, and will stay\n", skipped, from)
+	}
+	if args.Billing.Migrate.DryRun {
+		return nil
+	}
+	if !args.Billing.Migrate.Yes {
+		ok, err := promptConfirm(args, "continue?", "--yes")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("not confirmed, not moving anything")
+		}
+	}
+
+	// move the projects one at a time, carrying on past failures so that one project with
+	// missing permissions does not hold up the rest
+	failures := make(map[string]error)
+	for _, p := range move {
+		_, err := billing.Projects.UpdateBillingInfo("projects/"+p.ProjectId, &cloudbilling.ProjectBillingInfo{
+			BillingAccountName: to,
+		}).Context(ctx).Do()
+		if err != nil {
+			failures[p.ProjectId] = explainBillingError(ctx, billing, p.ProjectId, to, err)
+			fmt.Printf("failed to move %s\n", p.ProjectId)
+			continue
+		}
+		fmt.Printf("moved %s\n", p.ProjectId)
+	}
+
+	for _, p := range move {
+		if err, failed := failures[p.ProjectId]; failed {
+			fmt.Printf("\n%s: %v\n", p.ProjectId, err)
+		}
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tRESULT")
+	for _, p := range move {
+		result := "moved"
+		if _, failed := failures[p.ProjectId]; failed {
+			result = "failed"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", p.ProjectId, result)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	// specs that name the old account would move their projects straight back
+	fmt.Printf("\nupdate the billing field of any spec that names %s, or the next apply will move its project back:\n  billing: %s\n",
+		strings.TrimPrefix(from, "billingAccounts/"), strings.TrimPrefix(to, "billingAccounts/"))
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to move %d of %d projects", len(failures), len(move))
+	}
+	return nil
+}
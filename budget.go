@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	budgets "google.golang.org/api/billingbudgets/v1"
+	"google.golang.org/api/cloudbilling/v1"
+)
+
+// alert thresholds used when the spec does not give any
+var defaultBudgetThresholds = []float64{0.5, 0.9, 1.0}
+
+// display name of the budget that gproj manages for a project
+func budgetName(projectID string) string {
+	return "gproj: " + projectID
+}
+
+// the budget described by the spec, for the project with the given number
+func (b *Budget) toAPI(projectID string, projectNumber int64) *budgets.GoogleCloudBillingBudgetsV1Budget {
+	thresholds := b.Thresholds
+	if len(thresholds) == 0 {
+		thresholds = defaultBudgetThresholds
+	}
+
+	budget := &budgets.GoogleCloudBillingBudgetsV1Budget{
+		DisplayName: budgetName(projectID),
+		BudgetFilter: &budgets.GoogleCloudBillingBudgetsV1Filter{
+			Projects: []string{formatProjectNumber(projectNumber)},
+		},
+		Amount: &budgets.GoogleCloudBillingBudgetsV1BudgetAmount{
+			SpecifiedAmount: &budgets.GoogleTypeMoney{
+				CurrencyCode: b.Currency,
+				Units:        b.Amount,
+			},
+		},
+	}
+	for _, t := range thresholds {
+		budget.ThresholdRules = append(budget.ThresholdRules, &budgets.GoogleCloudBillingBudgetsV1ThresholdRule{
+			ThresholdPercent: t,
+		})
+	}
+	return budget
+}
+
+// find the budget that gproj manages for a project on a billing account, or nil if there is none
+func findBudget(ctx context.Context, svc *budgets.Service, billingAccount, projectID string) (*budgets.GoogleCloudBillingBudgetsV1Budget, error) {
+	var found *budgets.GoogleCloudBillingBudgetsV1Budget
+	err := svc.BillingAccounts.Budgets.List(billingAccount).Pages(ctx, func(resp *budgets.GoogleCloudBillingBudgetsV1ListBudgetsResponse) error {
+		for _, b := range resp.Budgets {
+			if b.DisplayName == budgetName(projectID) {
+				found = b
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing budgets for %s: %w", billingAccount, err)
+	}
+	return found, nil
+}
+
+// find the budget that gproj manages for a project on the billing account that the
+// project is linked to, or nil if there is none. Budgets live on the billing account, so
+// they outlive the project unless they are deleted along with it.
+func projectBudget(ctx context.Context, conn *connection, projectID string) (*budgets.GoogleCloudBillingBudgetsV1Budget, error) {
+	billing, err := cloudbilling.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing the billing API: %w", err)
+	}
+	info, err := billing.Projects.GetBillingInfo("projects/" + projectID).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error getting billing info for %s: %w", projectID, err)
+	}
+	if info.BillingAccountName == "" {
+		return nil, nil
+	}
+
+	svc, err := budgets.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing the budgets API: %w", err)
+	}
+	return findBudget(ctx, svc, info.BillingAccountName, projectID)
+}
+
+// delete a budget found by projectBudget
+func deleteBudget(ctx context.Context, conn *connection, budget *budgets.GoogleCloudBillingBudgetsV1Budget) error {
+	svc, err := budgets.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the budgets API: %w", err)
+	}
+	_, err = svc.BillingAccounts.Budgets.Delete(budget.Name).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error deleting budget %s: %w", budget.Name, err)
+	}
+	return nil
+}
+
+// create or update the budget in the spec on the billing account that the project is
+// linked to, and report whether it was changed
+func (a *applier) ensureBudget(ctx context.Context) (bool, error) {
+	if a.billingAccount == "" {
+		return false, fmt.Errorf("project %s has a budget but is not linked to a billing account", a.spec.ID)
+	}
+
+	svc, err := budgets.NewService(ctx, a.conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the budgets API: %w", err)
+	}
+
+	// find the budget created by a previous apply, if any
+	existing, err := findBudget(ctx, svc, a.billingAccount, a.spec.ID)
+	if err != nil {
+		return false, err
+	}
+
+	want := a.spec.Budget.toAPI(a.spec.ID, a.project.ProjectNumber)
+	if existing == nil {
+		_, err = svc.BillingAccounts.Budgets.Create(a.billingAccount, want).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error creating budget: %w", err)
+		}
+		fmt.Printf("created budget of %d %s\n", a.spec.Budget.Amount, a.spec.Budget.Currency)
+		noteChange(ctx, "", fmt.Sprintf("%d %s", a.spec.Budget.Amount, a.spec.Budget.Currency))
+		return true, nil
+	}
+
+	if budgetMatches(existing, want) {
+		return false, nil
+	}
+
+	// keep the currency of the existing budget if the spec does not give one
+	if want.Amount.SpecifiedAmount.CurrencyCode == "" && existing.Amount != nil && existing.Amount.SpecifiedAmount != nil {
+		want.Amount.SpecifiedAmount.CurrencyCode = existing.Amount.SpecifiedAmount.CurrencyCode
+	}
+	want.Etag = existing.Etag
+	_, err = svc.BillingAccounts.Budgets.Patch(existing.Name, want).
+		UpdateMask("amount,thresholdRules").Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error updating budget: %w", err)
+	}
+	fmt.Println("updated budget")
+	noteChange(ctx, formatBudget(existing), formatBudget(want))
+	return true, nil
+}
+
+// determine whether an existing budget has the amount and thresholds that are wanted
+func budgetMatches(existing, want *budgets.GoogleCloudBillingBudgetsV1Budget) bool {
+	if existing.Amount == nil || existing.Amount.SpecifiedAmount == nil {
+		return false
+	}
+	have, w := existing.Amount.SpecifiedAmount, want.Amount.SpecifiedAmount
+	if have.Units != w.Units || (w.CurrencyCode != "" && have.CurrencyCode != w.CurrencyCode) {
+		return false
+	}
+	return formatThresholds(existing) == formatThresholds(want)
+}
+
+// format the thresholds of a budget as a comma separated list
+func formatThresholds(b *budgets.GoogleCloudBillingBudgetsV1Budget) string {
+	var parts []string
+	for _, r := range b.ThresholdRules {
+		parts = append(parts, fmt.Sprint(r.ThresholdPercent))
+	}
+	return strings.Join(parts, ",")
+}
+
+// format a budget for the report
+func formatBudget(b *budgets.GoogleCloudBillingBudgetsV1Budget) string {
+	if b.Amount == nil || b.Amount.SpecifiedAmount == nil {
+		return "(not a fixed amount)"
+	}
+	return fmt.Sprintf("%d %s at %s", b.Amount.SpecifiedAmount.Units, b.Amount.SpecifiedAmount.CurrencyCode, formatThresholds(b))
+}
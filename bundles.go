@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// entries in the apis list of the spec that start with this name a bundle of APIs rather
+// than a single API, e.g. "bundle:web-app"
+const bundlePrefix = "bundle:"
+
+// the bundles of APIs that are built in to gproj, for kinds of project that come up often.
+// Each is a starting point rather than everything such a project could need.
+var builtinBundles = map[string][]string{
+	"web-app": {
+		"run.googleapis.com",
+		"artifactregistry.googleapis.com",
+		"cloudbuild.googleapis.com",
+		"secretmanager.googleapis.com",
+		"sqladmin.googleapis.com",
+		"logging.googleapis.com",
+		"monitoring.googleapis.com",
+	},
+	"data-pipeline": {
+		"bigquery.googleapis.com",
+		"bigquerystorage.googleapis.com",
+		"dataflow.googleapis.com",
+		"pubsub.googleapis.com",
+		"storage.googleapis.com",
+		"cloudscheduler.googleapis.com",
+	},
+	"functions": {
+		"cloudfunctions.googleapis.com",
+		"run.googleapis.com",
+		"cloudbuild.googleapis.com",
+		"artifactregistry.googleapis.com",
+		"eventarc.googleapis.com",
+		"pubsub.googleapis.com",
+	},
+	"kubernetes": {
+		"container.googleapis.com",
+		"compute.googleapis.com",
+		"artifactregistry.googleapis.com",
+		"logging.googleapis.com",
+		"monitoring.googleapis.com",
+	},
+	"ml": {
+		"aiplatform.googleapis.com",
+		"notebooks.googleapis.com",
+		"bigquery.googleapis.com",
+		"storage.googleapis.com",
+		"artifactregistry.googleapis.com",
+	},
+	"observability": {
+		"logging.googleapis.com",
+		"monitoring.googleapis.com",
+		"cloudtrace.googleapis.com",
+		"clouderrorreporting.googleapis.com",
+		"cloudprofiler.googleapis.com",
+	},
+}
+
+// get the name of the bundle that an entry in the apis list refers to, if it refers to one
+func bundleRef(api string) (string, bool) {
+	if !strings.HasPrefix(api, bundlePrefix) {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(api, bundlePrefix)), true
+}
+
+// look up a bundle by name, preferring those defined in the spec to the built in ones
+func (spec *ProjectSpec) bundle(name string) ([]string, bool) {
+	if apis, ok := spec.Bundles[name]; ok {
+		return apis, true
+	}
+	apis, ok := builtinBundles[name]
+	return apis, ok
+}
+
+// list the names of the bundles that the spec can refer to
+func (spec *ProjectSpec) bundleNames() []string {
+	var names []string
+	for name := range builtinBundles {
+		names = append(names, name)
+	}
+	for name := range spec.Bundles {
+		if _, builtin := builtinBundles[name]; !builtin {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expandBundles replaces the bundles in the apis list of the spec with the APIs they
+// contain, so that the rest of gproj only ever sees the names of APIs
+func (spec *ProjectSpec) expandBundles() error {
+	// an API that is both in a bundle and listed on its own is kept once, but APIs listed
+	// twice on their own are kept twice so that lint can point them out
+	var fromBundles []string
+	for _, api := range spec.APIs {
+		name, ok := bundleRef(api)
+		if !ok {
+			continue
+		}
+		bundle, ok := spec.bundle(name)
+		if !ok {
+			return fmt.Errorf("no API bundle named %q; bundles are: %s", name, strings.Join(spec.bundleNames(), ", "))
+		}
+		for _, api := range bundle {
+			if _, nested := bundleRef(api); nested {
+				return fmt.Errorf("bundle %q refers to another bundle, which is not supported", name)
+			}
+			if !contains(fromBundles, expandAPIName(api)) {
+				fromBundles = append(fromBundles, expandAPIName(api))
+			}
+		}
+	}
+
+	var apis []string
+	added := make(map[string]bool)
+	for _, api := range spec.APIs {
+		name, ok := bundleRef(api)
+		if !ok {
+			if !contains(fromBundles, expandAPIName(api)) {
+				apis = append(apis, api)
+			} else if !added[expandAPIName(api)] {
+				apis = append(apis, expandAPIName(api))
+				added[expandAPIName(api)] = true
+			}
+			continue
+		}
+		bundle, _ := spec.bundle(name)
+		for _, api := range bundle {
+			if full := expandAPIName(api); !added[full] {
+				apis = append(apis, full)
+				added[full] = true
+			}
+		}
+	}
+	spec.APIs = apis
+	return nil
+}
+
+// rewriteBundleRefs turns entries in the apis list written as "- bundle: web-app" into the
+// "bundle:web-app" form, which fits in a list of strings. The spec is returned unchanged if
+// it has no entries of the first form.
+func rewriteBundleRefs(buf []byte) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return buf, nil // leave it to the decoder to report
+	}
+
+	var changed bool
+	for i, item := range doc {
+		if item.Key != "apis" {
+			continue
+		}
+		apis, ok := item.Value.([]interface{})
+		if !ok {
+			continue
+		}
+		for j, api := range apis {
+			m, ok := api.(yaml.MapSlice)
+			if ok && len(m) == 1 && m[0].Key == "bundle" {
+				apis[j] = bundlePrefix + fmt.Sprint(m[0].Value)
+				changed = true
+			}
+		}
+		doc[i].Value = apis
+	}
+	if !changed {
+		return buf, nil
+	}
+	return yaml.Marshal(doc)
+}
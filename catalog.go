@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// categoryRule assigns a category to any API whose name or title contains one of the given words
+type categoryRule struct {
+	category string
+	words    []string
+}
+
+// rules for grouping APIs into categories, checked in order. Google does not publish a
+// category in the service config, so these are matched against the name and title from it.
+var categoryRules = []categoryRule{
+	{"Firebase", []string{"firebase", "identitytoolkit", "fcm"}},
+	{"Maps", []string{"maps", "places", "geocoding", "directions", "distancematrix", "elevation", "roads", "streetview", "timezone", "geolocation"}},
+	{"AI and Machine Learning", []string{"aiplatform", "ml.", "vision", "speech", "language", "translate", "dialogflow", "automl", "documentai", "videointelligence", "texttospeech", "retail", "recommendationengine"}},
+	{"Data Analytics", []string{"bigquery", "dataflow", "dataproc", "datafusion", "composer", "pubsub", "datacatalog", "dataplex", "datastream", "analyticshub"}},
+	{"Databases", []string{"sqladmin", "sql-component", "spanner", "bigtable", "datastore", "firestore", "redis", "memcache", "alloydb"}},
+	{"Storage", []string{"storage", "file.", "filestore", "backupdr"}},
+	{"Compute", []string{"compute", "container", "run.", "cloudfunctions", "appengine", "gkehub", "batch", "vmmigration", "osconfig", "oslogin"}},
+	{"Networking", []string{"dns", "networkmanagement", "networkservices", "networkconnectivity", "servicenetworking", "vpcaccess", "trafficdirector", "certificatemanager", "ids."}},
+	{"Security and Identity", []string{"iam", "cloudkms", "secretmanager", "securitycenter", "iap", "binaryauthorization", "privateca", "accesscontextmanager", "cloudidentity", "sts.", "recaptcha", "websecurityscanner", "webrisk", "dlp"}},
+	{"Developer Tools", []string{"cloudbuild", "artifactregistry", "containerregistry", "sourcerepo", "clouddeploy", "cloudscheduler", "cloudtasks", "workflows", "eventarc", "apigateway", "endpoints", "apigee"}},
+	{"Operations", []string{"logging", "monitoring", "cloudtrace", "clouderrorreporting", "cloudprofiler", "clouddebugger", "stackdriver"}},
+	{"Management", []string{"cloudresourcemanager", "serviceusage", "servicemanagement", "cloudbilling", "billingbudgets", "cloudasset", "orgpolicy", "recommender", "deploymentmanager", "runtimeconfig", "essentialcontacts"}},
+	{"Google Workspace", []string{"gmail", "drive", "calendar", "sheets", "docs.", "slides", "admin.", "chat.", "people", "forms", "keep", "tasks.", "script"}},
+}
+
+// APIs that are free to use or that include a monthly free usage tier
+var freeTierAPIs = []string{
+	"appengine.googleapis.com",
+	"artifactregistry.googleapis.com",
+	"bigquery.googleapis.com",
+	"cloudbuild.googleapis.com",
+	"cloudfunctions.googleapis.com",
+	"cloudresourcemanager.googleapis.com",
+	"cloudscheduler.googleapis.com",
+	"compute.googleapis.com",
+	"firestore.googleapis.com",
+	"iam.googleapis.com",
+	"logging.googleapis.com",
+	"monitoring.googleapis.com",
+	"pubsub.googleapis.com",
+	"run.googleapis.com",
+	"secretmanager.googleapis.com",
+	"serviceusage.googleapis.com",
+	"speech.googleapis.com",
+	"storage.googleapis.com",
+	"translate.googleapis.com",
+	"vision.googleapis.com",
+}
+
+// APIs that cannot be enabled unless the project is linked to a billing account
+var billingRequiredAPIs = []string{
+	"aiplatform.googleapis.com",
+	"alloydb.googleapis.com",
+	"artifactregistry.googleapis.com",
+	"bigtableadmin.googleapis.com",
+	"cloudbuild.googleapis.com",
+	"cloudfunctions.googleapis.com",
+	"cloudscheduler.googleapis.com",
+	"composer.googleapis.com",
+	"compute.googleapis.com",
+	"container.googleapis.com",
+	"dataflow.googleapis.com",
+	"dataproc.googleapis.com",
+	"file.googleapis.com",
+	"memcache.googleapis.com",
+	"redis.googleapis.com",
+	"run.googleapis.com",
+	"spanner.googleapis.com",
+	"sqladmin.googleapis.com",
+	"vpcaccess.googleapis.com",
+	"workflows.googleapis.com",
+}
+
+// get the APIs in a list that need billing in order to be enabled
+func needBilling(apis []string) []string {
+	var out []string
+	for _, api := range apis {
+		if contains(billingRequiredAPIs, api) {
+			out = append(out, api)
+		}
+	}
+	return out
+}
+
+// categorize an API by its name and title, returning "Other" if no rule matches
+func categorize(name, title string) string {
+	haystack := strings.ToLower(name + " " + title)
+	for _, rule := range categoryRules {
+		for _, word := range rule.words {
+			if strings.Contains(haystack, word) {
+				return rule.category
+			}
+		}
+	}
+	return "Other"
+}
+
+// fill in the category and free tier fields, which older caches do not contain
+func enrichAPIs(apis []*api) {
+	for _, a := range apis {
+		if a.Category == "" {
+			a.Category = categorize(a.Name, a.Title)
+		}
+		if !a.FreeTier {
+			a.FreeTier = contains(freeTierAPIs, a.Name)
+		}
+	}
+}
+
+// apiDeprecation describes an API that google has deprecated or shut down
+type apiDeprecation struct {
+	Replacement string // API to use instead, if there is one
+	Note        string // what happened to the API, and when
+}
+
+// APIs that google has deprecated or shut down. The catalog only sometimes says so, in the
+// title or summary, so this list is kept by hand.
+var deprecatedAPIs = map[string]apiDeprecation{
+	"automl.googleapis.com":               {"aiplatform.googleapis.com", "AutoML is superseded by Vertex AI"},
+	"cloudiot.googleapis.com":             {"", "IoT Core was shut down in August 2023"},
+	"clouddebugger.googleapis.com":        {"", "Cloud Debugger was shut down in May 2023"},
+	"containerregistry.googleapis.com":    {"artifactregistry.googleapis.com", "Container Registry was shut down in March 2025"},
+	"deploymentmanager.googleapis.com":    {"config.googleapis.com", "Deployment Manager reaches end of support in March 2026"},
+	"gameservices.googleapis.com":         {"", "Game Servers was shut down in June 2023"},
+	"ml.googleapis.com":                   {"aiplatform.googleapis.com", "AI Platform is superseded by Vertex AI"},
+	"recommendationengine.googleapis.com": {"retail.googleapis.com", "Recommendations AI moved to the Retail API"},
+	"runtimeconfig.googleapis.com":        {"secretmanager.googleapis.com", "Runtime Configurator is deprecated"},
+	"sourcerepo.googleapis.com":           {"securesourcemanager.googleapis.com", "Cloud Source Repositories is no longer available to new customers"},
+}
+
+// words in the title or summary of an API in the catalog that mean it is on its way out
+var deprecationWords = []string{"deprecated", "deprecation", "shut down", "shutdown", "turned down", "retired"}
+
+// deprecationWarnings lists the APIs in the spec that are deprecated, according to the list
+// kept by hand or to the catalog, which may be nil if it is not cached
+func deprecationWarnings(spec *ProjectSpec, catalog []*api) []string {
+	byName := make(map[string]*api)
+	for _, a := range catalog {
+		byName[a.Name] = a
+	}
+
+	var warnings []string
+	for _, name := range spec.APIs {
+		name = expandAPIName(name)
+		if d, ok := deprecatedAPIs[name]; ok {
+			msg := fmt.Sprintf("%s is deprecated: %s", name, d.Note)
+			if d.Replacement != "" {
+				msg += fmt.Sprintf("; use %s instead", d.Replacement)
+			}
+			warnings = append(warnings, msg)
+			continue
+		}
+		if a, ok := byName[name]; ok {
+			text := strings.ToLower(a.Title + " " + a.Summary)
+			for _, word := range deprecationWords {
+				if strings.Contains(text, word) {
+					warnings = append(warnings, fmt.Sprintf("%s may be deprecated, according to the API catalog: %s", name, firstLine(strings.TrimSpace(a.Title+". "+a.Summary))))
+					break
+				}
+			}
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// determine whether gproj is running as a github actions step, in which case output is
+// grouped and the summary and outputs are written to the files that github provides
+func githubActions() bool {
+	return os.Getenv("GITHUB_ACTIONS") == "true"
+}
+
+// begin a collapsible group of log output, returning a function that ends it. Groups are
+// only emitted in CI mode on github actions.
+func ciGroup(args *args, title string) func() {
+	if !args.CI || !githubActions() {
+		return func() {}
+	}
+	fmt.Printf("::group::%s\n", title)
+	return func() { fmt.Println("::endgroup::") }
+}
+
+// add text to the end of a file, creating it if necessary
+func appendFile(path, text string) error {
+	buf, err := fsys.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return fsys.WriteFile(path, append(buf, text...), filePerm)
+}
+
+// write markdown to the job summary on github actions, or print it in other CI systems
+func writeCISummary(args *args, markdown string) error {
+	if !args.CI {
+		return nil
+	}
+	if path := os.Getenv("GITHUB_STEP_SUMMARY"); path != "" {
+		return appendFile(path, markdown)
+	}
+	fmt.Print(markdown)
+	return nil
+}
+
+// set step outputs on github actions, or print them as "key=value" in other CI systems
+func writeCIOutputs(args *args, outputs [][2]string) error {
+	if !args.CI {
+		return nil
+	}
+	var lines []string
+	for _, kv := range outputs {
+		lines = append(lines, kv[0]+"="+kv[1]+"\n")
+	}
+	if path := os.Getenv("GITHUB_OUTPUT"); path != "" {
+		return appendFile(path, strings.Join(lines, ""))
+	}
+	fmt.Print(strings.Join(lines, ""))
+	return nil
+}
+
+// format the outcome of an apply as a markdown table
+func actionsMarkdown(projectID string, actions []*Action, err error) string {
+	var b strings.Builder
+	if err != nil {
+		fmt.Fprintf(&b, "### gproj apply failed for `%s`\n\n", projectID)
+	} else {
+		fmt.Fprintf(&b, "### gproj apply for `%s`\n\n", projectID)
+	}
+	b.WriteString("| Resource | Result | Seconds |\n|---|---|---|\n")
+	for _, a := range actions {
+		fmt.Fprintf(&b, "| `%s` | %s | %.1f |\n", a.Resource, a.Result, a.Seconds)
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "\n```\n%v\n```\n", err)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
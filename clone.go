@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// clone creates a new project with the same parent, labels, billing account, and enabled
+// APIs as an existing one
+func clone(ctx context.Context, args *args) error {
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	source, err := takeSnapshot(ctx, conn, &ProjectSpec{ID: args.Clone.From})
+	if err != nil {
+		return err
+	}
+
+	spec := ProjectSpec{
+		Name:    source.Name,
+		ID:      args.Clone.ID,
+		Labels:  source.Labels,
+		APIs:    source.APIs,
+		Billing: source.Billing,
+		Parent:  source.Parent,
+	}
+	if args.Clone.Name != "" {
+		spec.Name = args.Clone.Name
+	}
+
+	if args.Clone.Out != "" {
+		buf, err := marshalSpec(&spec)
+		if err != nil {
+			return fmt.Errorf("error marshalling spec to yaml: %w", err)
+		}
+		err = fsys.WriteFile(args.Clone.Out, buf, filePerm)
+		if err != nil {
+			return fmt.Errorf("error writing spec to %s: %w", args.Clone.Out, err)
+		}
+		fmt.Printf("wrote spec for %s to %s\n", spec.ID, args.Clone.Out)
+		spec.path = args.Clone.Out
+	}
+
+	args.Apply = &applyArgs{Parallelism: args.Clone.Parallelism}
+	return applySpec(ctx, args, &spec)
+}
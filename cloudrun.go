@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	run "google.golang.org/api/run/v1"
+)
+
+// image that is deployed when a cloud run service in the spec does not specify one
+const placeholderImage = "us-docker.pkg.dev/cloudrun/container/hello"
+
+// CloudRunService models a cloud run service declared in googlecloudproject.yaml
+type CloudRunService struct {
+	Name    string   // name of the service, e.g. "frontend"
+	Region  string   // region to deploy to, e.g. "us-central1"
+	Image   string   // initial container image - leave empty to deploy a placeholder
+	Public  bool     // whether to allow unauthenticated invocations
+	Domains []string // custom domains to map onto the service, e.g. "www.example.com"
+}
+
+// the namespaced parts of the cloud run admin API are only served from regional endpoints
+func cloudRunRegionalService(ctx context.Context, conn *connection, region string) (*run.APIService, error) {
+	return run.NewService(ctx, conn.options(
+		option.WithEndpoint(fmt.Sprintf("https://%s-run.googleapis.com/", region)))...)
+}
+
+// create a cloud run service and its domain mappings if they do not already exist,
+// and report whether anything was changed
+func applyCloudRunService(ctx context.Context, conn *connection, projectID string, svc CloudRunService, state *State) (bool, error) {
+	if svc.Name == "" || svc.Region == "" {
+		return false, fmt.Errorf("cloud run services must have both a name and a region")
+	}
+
+	regional, err := cloudRunRegionalService(ctx, conn, svc.Region)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the cloud run API for %s: %w", svc.Region, err)
+	}
+
+	url, changed, err := ensureCloudRunService(ctx, regional, projectID, svc, state)
+	if err != nil {
+		return false, err
+	}
+	if changed {
+		fmt.Printf("cloud run service %s is at %s\n", svc.Name, url)
+		noteChange(ctx, "", url)
+	}
+
+	if svc.Public {
+		madePublic, err := allowPublicInvocation(ctx, conn, projectID, svc)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || madePublic
+	}
+
+	for _, domain := range svc.Domains {
+		mapped, err := ensureDomainMapping(ctx, regional, projectID, svc, domain, state)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || mapped
+	}
+	return changed, nil
+}
+
+// create the cloud run service if it does not exist and return its URL and whether it was created
+func ensureCloudRunService(ctx context.Context, regional *run.APIService, projectID string, svc CloudRunService, state *State) (string, bool, error) {
+	name := fmt.Sprintf("namespaces/%s/services/%s", projectID, svc.Name)
+
+	existing, err := regional.Namespaces.Services.Get(name).Context(ctx).Do()
+	if err == nil {
+		if existing.Status != nil {
+			return existing.Status.Url, false, nil
+		}
+		return "", false, nil
+	}
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != 404 {
+		return "", false, fmt.Errorf("error getting cloud run service %s: %w", svc.Name, err)
+	}
+
+	image := svc.Image
+	if image == "" {
+		image = placeholderImage
+	}
+
+	fmt.Printf("creating cloud run service %s in %s with image %s...\n", svc.Name, svc.Region, image)
+	_, err = regional.Namespaces.Services.Create("namespaces/"+projectID, &run.Service{
+		ApiVersion: "serving.knative.dev/v1",
+		Kind:       "Service",
+		Metadata: &run.ObjectMeta{
+			Name:      svc.Name,
+			Namespace: projectID,
+			Labels:    map[string]string{managedByLabel: managedByValue},
+		},
+		Spec: &run.ServiceSpec{
+			Template: &run.RevisionTemplate{
+				Spec: &run.RevisionSpec{
+					Containers: []*run.Container{{Image: image}},
+				},
+			},
+		},
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", false, fmt.Errorf("error creating cloud run service %s: %w", svc.Name, err)
+	}
+	state.Record(kindCloudRunService, name, svc.Region)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	var url string
+	err = timeOperation("create-cloudrun-service", "cloud run service "+svc.Name+" to become ready", func() error {
+		url, err = waitForCloudRunReady(waitCtx, regional, name)
+		return err
+	})
+	return url, true, err
+}
+
+// poll a cloud run service until its "Ready" condition is true, then return its URL
+func waitForCloudRunReady(ctx context.Context, regional *run.APIService, name string) (string, error) {
+	var url string
+	err := poll(ctx, "cloud run service "+name+" to become ready", func() (bool, error) {
+		svc, err := regional.Namespaces.Services.Get(name).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error getting cloud run service info: %w", err)
+		}
+		if svc.Status == nil {
+			return false, nil
+		}
+		for _, cond := range svc.Status.Conditions {
+			if cond.Type != "Ready" {
+				continue
+			}
+			switch cond.Status {
+			case "True":
+				url = svc.Status.Url
+				return true, nil
+			case "False":
+				return false, fmt.Errorf("cloud run service %s failed to become ready: %s", name, cond.Message)
+			}
+		}
+		return false, nil
+	})
+	return url, err
+}
+
+// grant roles/run.invoker to allUsers on a cloud run service
+func allowPublicInvocation(ctx context.Context, conn *connection, projectID string, svc CloudRunService) (bool, error) {
+	global, err := run.NewService(ctx, conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the cloud run API: %w", err)
+	}
+
+	resource := fmt.Sprintf("projects/%s/locations/%s/services/%s", projectID, svc.Region, svc.Name)
+	policy, err := global.Projects.Locations.Services.GetIamPolicy(resource).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error getting IAM policy for cloud run service %s: %w", svc.Name, err)
+	}
+
+	for _, b := range policy.Bindings {
+		if b.Role != "roles/run.invoker" {
+			continue
+		}
+		for _, m := range b.Members {
+			if m == "allUsers" {
+				return false, nil
+			}
+		}
+	}
+
+	policy.Bindings = append(policy.Bindings, &run.Binding{
+		Role:    "roles/run.invoker",
+		Members: []string{"allUsers"},
+	})
+
+	_, err = global.Projects.Locations.Services.SetIamPolicy(resource, &run.SetIamPolicyRequest{
+		Policy: policy,
+	}).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error allowing public access to cloud run service %s: %w", svc.Name, err)
+	}
+
+	fmt.Printf("allowed unauthenticated invocations of %s\n", svc.Name)
+	return true, nil
+}
+
+// create a domain mapping from a custom domain to a cloud run service if it does not exist
+func ensureDomainMapping(ctx context.Context, regional *run.APIService, projectID string, svc CloudRunService, domain string, state *State) (bool, error) {
+	name := fmt.Sprintf("namespaces/%s/domainmappings/%s", projectID, domain)
+
+	_, err := regional.Namespaces.Domainmappings.Get(name).Context(ctx).Do()
+	if err == nil {
+		return false, nil
+	}
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != 404 {
+		return false, fmt.Errorf("error getting domain mapping for %s: %w", domain, err)
+	}
+
+	fmt.Printf("mapping %s to cloud run service %s...\n", domain, svc.Name)
+	_, err = regional.Namespaces.Domainmappings.Create("namespaces/"+projectID, &run.DomainMapping{
+		ApiVersion: "domains.cloudrun.com/v1",
+		Kind:       "DomainMapping",
+		Metadata: &run.ObjectMeta{
+			Name:      domain,
+			Namespace: projectID,
+		},
+		Spec: &run.DomainMappingSpec{
+			RouteName: svc.Name,
+		},
+	}).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error mapping %s to %s (is the domain verified for this account?): %w", domain, svc.Name, err)
+	}
+	state.Record(kindDomainMapping, name, svc.Region)
+
+	return true, nil
+}
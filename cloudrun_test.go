@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/api/option"
+	run "google.golang.org/api/run/v1"
+)
+
+// fakeCloudRun is an in-memory stand-in for the namespaced parts of the cloud run admin API.
+// Services that it creates are ready at once.
+type fakeCloudRun struct {
+	mu      sync.Mutex
+	objects map[string]json.RawMessage // keyed by path, e.g. "serving.knative.dev/v1/namespaces/p/services/web"
+	creates int
+}
+
+func (f *fakeCloudRun) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(req.URL.Path, "/apis/")
+	switch req.Method {
+	case http.MethodGet:
+		obj, ok := f.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error": {"code": 404, "message": "not found"}}`)
+			return
+		}
+		w.Write(obj)
+	case http.MethodPost:
+		var obj struct {
+			Metadata *run.ObjectMeta `json:"metadata"`
+		}
+		var raw json.RawMessage
+		if err := json.NewDecoder(req.Body).Decode(&raw); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.Unmarshal(raw, &obj); err != nil || obj.Metadata == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if strings.HasSuffix(path, "/services") {
+			var svc run.Service
+			json.Unmarshal(raw, &svc)
+			svc.Status = &run.ServiceStatus{
+				Url:        "https://" + obj.Metadata.Name + ".a.run.app",
+				Conditions: []*run.GoogleCloudRunV1Condition{{Type: "Ready", Status: "True"}},
+			}
+			raw, _ = json.Marshal(&svc)
+		}
+		f.objects[path+"/"+obj.Metadata.Name] = raw
+		f.creates++
+		w.Write(raw)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func newFakeCloudRun(t *testing.T) (*fakeCloudRun, *run.APIService) {
+	// creating a service records how long it took in the user cache dir
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+
+	f := &fakeCloudRun{objects: make(map[string]json.RawMessage)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	regional, err := run.NewService(context.Background(),
+		option.WithEndpoint(srv.URL+"/"),
+		option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, regional
+}
+
+func TestEnsureCloudRunService(t *testing.T) {
+	ctx := context.Background()
+	f, regional := newFakeCloudRun(t)
+	svc := CloudRunService{Name: "web", Region: "us-central1"}
+	var state State
+
+	url, created, err := ensureCloudRunService(ctx, regional, "p", svc, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || url != "https://web.a.run.app" {
+		t.Errorf("got url %q and created=%v, want the new service's url and created=true", url, created)
+	}
+
+	var got run.Service
+	if err := json.Unmarshal(f.objects["serving.knative.dev/v1/namespaces/p/services/web"], &got); err != nil {
+		t.Fatal(err)
+	}
+	if image := got.Spec.Template.Spec.Containers[0].Image; image != placeholderImage {
+		t.Errorf("service without an image was created with %q, want the placeholder", image)
+	}
+	if got.Metadata.Labels[managedByLabel] != managedByValue {
+		t.Errorf("service was created without the %s label", managedByLabel)
+	}
+
+	// what was recorded must match what the spec declares, or prune would delete it
+	if orphans := orphanedResources(&ProjectSpec{ID: "p", CloudRun: []CloudRunService{svc}}, &state); len(orphans) != 0 {
+		t.Errorf("new service is orphaned: %s", orphans[0].key())
+	}
+
+	url, created, err = ensureCloudRunService(ctx, regional, "p", svc, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || url != "https://web.a.run.app" || f.creates != 1 {
+		t.Errorf("existing service was created again (url %q, created=%v, %d creates)", url, created, f.creates)
+	}
+}
+
+func TestEnsureDomainMapping(t *testing.T) {
+	ctx := context.Background()
+	f, regional := newFakeCloudRun(t)
+	svc := CloudRunService{Name: "web", Region: "us-central1", Domains: []string{"www.example.com"}}
+	var state State
+
+	created, err := ensureDomainMapping(ctx, regional, "p", svc, "www.example.com", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("domain mapping was not created")
+	}
+
+	var got run.DomainMapping
+	if err := json.Unmarshal(f.objects["domains.cloudrun.com/v1/namespaces/p/domainmappings/www.example.com"], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.RouteName != "web" {
+		t.Errorf("domain was mapped to %q, want web", got.Spec.RouteName)
+	}
+	if r := state.Find(kindDomainMapping, "namespaces/p/domainmappings/www.example.com"); r == nil || r.Region != "us-central1" {
+		t.Errorf("domain mapping was not recorded with its region: %+v", r)
+	}
+
+	created, err = ensureDomainMapping(ctx, regional, "p", svc, "www.example.com", &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || f.creates != 1 {
+		t.Errorf("existing domain mapping was created again (created=%v, %d creates)", created, f.creates)
+	}
+}
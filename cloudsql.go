@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sqladmin/v1"
+)
+
+// CloudSQLInstance models a cloud SQL instance declared in googlecloudproject.yaml. The
+// defaults give the smallest instance available, which suits development projects.
+type CloudSQLInstance struct {
+	Name    string            // name of the instance, e.g. "main"
+	Engine  string            // postgres, mysql, or sqlserver (default postgres)
+	Version string            // major version of the engine, e.g. "15" or "8_0" (default the latest that gproj knows of)
+	Tier    string            // machine type, e.g. "db-custom-2-7680" (default db-f1-micro)
+	Region  string            // e.g. "us-central1" (default us-central1)
+	Flags   map[string]string // database flags, e.g. max_connections: "100"
+}
+
+// the latest major version of each engine that gproj knows of, used when no version is given
+var defaultSQLVersions = map[string]string{
+	"postgres":  "15",
+	"mysql":     "8_0",
+	"sqlserver": "2019_STANDARD",
+}
+
+// fill in the defaults for the fields that are not given in the spec
+func (inst CloudSQLInstance) withDefaults() CloudSQLInstance {
+	if inst.Engine == "" {
+		inst.Engine = "postgres"
+	}
+	inst.Engine = strings.ToLower(inst.Engine)
+	if inst.Version == "" {
+		inst.Version = defaultSQLVersions[inst.Engine]
+	}
+	if inst.Tier == "" {
+		inst.Tier = "db-f1-micro"
+	}
+	if inst.Region == "" {
+		inst.Region = "us-central1"
+	}
+	return inst
+}
+
+// get the database version in the form expected by the cloud SQL API, e.g. "POSTGRES_15"
+func (inst CloudSQLInstance) databaseVersion() (string, error) {
+	if _, ok := defaultSQLVersions[inst.Engine]; !ok {
+		return "", fmt.Errorf("cloud SQL instance %s: unknown engine %q (expected postgres, mysql, or sqlserver)", inst.Name, inst.Engine)
+	}
+	version := strings.ReplaceAll(inst.Version, ".", "_")
+	return strings.ToUpper(inst.Engine + "_" + version), nil
+}
+
+// convert database flags from the spec to the form expected by the cloud SQL API, in order of name
+func sqlFlags(flags map[string]string) []*sqladmin.DatabaseFlags {
+	var out []*sqladmin.DatabaseFlags
+	for name, value := range flags {
+		out = append(out, &sqladmin.DatabaseFlags{Name: name, Value: value})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// wait for a cloud SQL operation to complete
+func waitForSQL(ctx context.Context, svc *sqladmin.Service, projectID string, op *sqladmin.Operation) error {
+	check := func() (bool, error) {
+		if op.Error != nil && len(op.Error.Errors) > 0 {
+			var msgs []string
+			for _, e := range op.Error.Errors {
+				msgs = append(msgs, e.Code+": "+e.Message)
+			}
+			return false, fmt.Errorf("error performing operation: %s", strings.Join(msgs, "; "))
+		}
+		return op.Status == "DONE", nil
+	}
+	if done, err := check(); done || err != nil {
+		return err
+	}
+	return poll(ctx, "operation "+op.Name, func() (bool, error) {
+		var err error
+		op, err = svc.Operations.Get(projectID, op.Name).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error getting operation info: %w", err)
+		}
+		return check()
+	})
+}
+
+// create a cloud SQL instance if it does not already exist, and report whether it was
+// created. An existing instance whose tier or flags differ from the spec is left alone with
+// a warning, since changing either restarts the database. If the spec sets up private
+// service access then the instance gets a private IP on that network and no public IP.
+func applyCloudSQL(ctx context.Context, conn *connection, spec *ProjectSpec, inst CloudSQLInstance, state *State) (bool, error) {
+	if inst.Name == "" {
+		return false, fmt.Errorf("cloud SQL instances must have a name")
+	}
+	inst = inst.withDefaults()
+	version, err := inst.databaseVersion()
+	if err != nil {
+		return false, err
+	}
+
+	svc, err := sqladmin.NewService(ctx, conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the cloud SQL API: %w", err)
+	}
+
+	existing, err := svc.Instances.Get(spec.ID, inst.Name).Context(ctx).Do()
+	if err == nil {
+		if existing.Settings != nil && existing.Settings.Tier != inst.Tier {
+			fmt.Printf("warning: cloud SQL instance %s has tier %s but the spec says %s; change it in the console to avoid an unplanned restart\n",
+				inst.Name, existing.Settings.Tier, inst.Tier)
+		}
+		return false, nil
+	}
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != 404 {
+		return false, fmt.Errorf("error getting cloud SQL instance %s: %w", inst.Name, err)
+	}
+
+	settings := &sqladmin.Settings{
+		Tier:          inst.Tier,
+		DatabaseFlags: sqlFlags(inst.Flags),
+	}
+	if sn := spec.ServiceNetworking; sn != nil {
+		settings.IpConfiguration = &sqladmin.IpConfiguration{
+			PrivateNetwork:  fmt.Sprintf("projects/%s/global/networks/%s", spec.ID, sn.withDefaults().Network),
+			Ipv4Enabled:     false,
+			ForceSendFields: []string{"Ipv4Enabled"},
+		}
+	}
+
+	fmt.Printf("creating cloud SQL instance %s (%s, %s)\n", inst.Name, version, inst.Tier)
+	op, err := svc.Instances.Insert(spec.ID, &sqladmin.DatabaseInstance{
+		Name:            inst.Name,
+		DatabaseVersion: version,
+		Region:          inst.Region,
+		Settings:        settings,
+	}).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error creating cloud SQL instance %s: %w", inst.Name, err)
+	}
+	noteOperation(ctx, op.Name)
+	state.Record(kindSQLInstance, sqlInstanceName(spec.ID, inst.Name), inst.Region)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Minute) // instances take several minutes to create
+	defer cancel()
+	err = timeOperation("create-sql-instance", "cloud SQL instance "+inst.Name, func() error {
+		return waitForSQL(waitCtx, svc, spec.ID, op)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error creating cloud SQL instance %s: %w", inst.Name, err)
+	}
+	noteChange(ctx, "", version)
+	return true, nil
+}
+
+// the name under which a cloud SQL instance is recorded in the state
+func sqlInstanceName(projectID, instance string) string {
+	return fmt.Sprintf("projects/%s/instances/%s", projectID, instance)
+}
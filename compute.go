@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/compute/v1"
+)
+
+// the org policy constraint that requires every VM to use shielded VM
+const shieldedVMConstraint = "constraints/compute.requireShieldedVm"
+
+// ComputeSettings models the "compute" section of googlecloudproject.yaml, which holds
+// project-wide settings for compute engine
+type ComputeSettings struct {
+	Metadata          map[string]string // project-wide metadata, e.g. enable-oslogin: "TRUE"
+	RequireShieldedVM bool              `yaml:"requireShieldedVM"` // only allow VMs with secure boot, vTPM, and integrity monitoring
+	SharedVPC         *SharedVPC        `yaml:"sharedVPC"`         // make the project a shared VPC host or attach it to one
+}
+
+// wait for a compute operation to complete
+func waitForCompute(ctx context.Context, svc *compute.Service, projectID string, op *compute.Operation) error {
+	check := func() (bool, error) {
+		if op.Error != nil && len(op.Error.Errors) > 0 {
+			var msgs []string
+			for _, e := range op.Error.Errors {
+				msgs = append(msgs, e.Code+": "+e.Message)
+			}
+			return false, fmt.Errorf("error performing operation: %s", strings.Join(msgs, "; "))
+		}
+		return op.Status == "DONE", nil
+	}
+	if done, err := check(); done || err != nil {
+		return err
+	}
+	return poll(ctx, "operation "+op.Name, func() (bool, error) {
+		var err error
+		op, err = svc.GlobalOperations.Get(projectID, op.Name).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error getting operation info: %w", err)
+		}
+		return check()
+	})
+}
+
+// set the project-wide compute metadata in the spec, and report whether it was changed.
+// Metadata keys that are not in the spec are left alone.
+func (a *applier) ensureComputeMetadata(ctx context.Context) (bool, error) {
+	svc, err := compute.NewService(ctx, a.conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the compute API: %w", err)
+	}
+
+	project, err := svc.Projects.Get(a.spec.ID).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error getting compute settings for %s: %w", a.spec.ID, err)
+	}
+	metadata := project.CommonInstanceMetadata
+	if metadata == nil {
+		metadata = &compute.Metadata{}
+	}
+
+	var keys []string
+	for k := range a.spec.Compute.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var changes []string
+	for _, key := range keys {
+		value := a.spec.Compute.Metadata[key]
+		var item *compute.MetadataItems
+		for _, it := range metadata.Items {
+			if it.Key == key {
+				item = it
+			}
+		}
+		switch {
+		case item == nil:
+			metadata.Items = append(metadata.Items, &compute.MetadataItems{Key: key, Value: &value})
+		case item.Value == nil || *item.Value != value:
+			item.Value = &value
+		default:
+			continue
+		}
+		changes = append(changes, key+"="+value)
+	}
+	if len(changes) == 0 {
+		return false, nil
+	}
+
+	// the metadata carries the fingerprint from the get, so this fails rather than overwriting a concurrent change
+	op, err := svc.Projects.SetCommonInstanceMetadata(a.spec.ID, metadata).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error setting compute metadata for %s: %w", a.spec.ID, err)
+	}
+	noteOperation(ctx, op.Name)
+	err = waitForCompute(ctx, svc, a.spec.ID, op)
+	if err != nil {
+		return false, fmt.Errorf("error setting compute metadata for %s: %w", a.spec.ID, err)
+	}
+
+	fmt.Printf("set compute metadata %s\n", strings.Join(changes, ", "))
+	noteChange(ctx, "", strings.Join(changes, ", "))
+	return true, nil
+}
+
+// enforce the shielded VM constraint on the project, and report whether it was changed
+func (a *applier) ensureShieldedVM(ctx context.Context) (bool, error) {
+	resource := "projects/" + a.spec.ID
+	var policy *cloudresourcemanager.OrgPolicy
+	err := a.retryAfterCreate(ctx, func() (err error) {
+		policy, err = a.resources.Projects.GetOrgPolicy(resource, &cloudresourcemanager.GetOrgPolicyRequest{
+			Constraint: shieldedVMConstraint,
+		}).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error getting org policy for %s: %w", a.spec.ID, err)
+	}
+	if policy.BooleanPolicy != nil && policy.BooleanPolicy.Enforced {
+		return false, nil
+	}
+
+	_, err = a.resources.Projects.SetOrgPolicy(resource, &cloudresourcemanager.SetOrgPolicyRequest{
+		Policy: &cloudresourcemanager.OrgPolicy{
+			Constraint:    shieldedVMConstraint,
+			Etag:          policy.Etag,
+			BooleanPolicy: &cloudresourcemanager.BooleanPolicy{Enforced: true},
+		},
+	}).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error setting org policy for %s: %w", a.spec.ID, err)
+	}
+
+	fmt.Println("required shielded VMs")
+	noteChange(ctx, "not enforced", "enforced")
+	return true, nil
+}
+
+// SharedVPC models the "compute.sharedVPC" section of googlecloudproject.yaml. A project
+// is either a host, whose VPC networks other projects can use, or a service project
+// attached to a host, but not both.
+type SharedVPC struct {
+	Host        bool   // make this project a shared VPC host
+	HostProject string `yaml:"hostProject"` // attach this project to the shared VPC of this host project
+}
+
+// make the project a shared VPC host or attach it to a host, and report whether it was changed.
+// A service project that is attached to a different host is not moved, since detaching it
+// would break any VMs using the old host's networks.
+func (a *applier) ensureSharedVPC(ctx context.Context) (bool, error) {
+	vpc := a.spec.Compute.SharedVPC
+	if vpc.Host && vpc.HostProject != "" {
+		return false, fmt.Errorf("a project cannot be both a shared VPC host and attached to host %s", vpc.HostProject)
+	}
+
+	svc, err := compute.NewService(ctx, a.conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the compute API: %w", err)
+	}
+
+	if vpc.Host {
+		project, err := svc.Projects.Get(a.spec.ID).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error getting compute settings for %s: %w", a.spec.ID, err)
+		}
+		if project.XpnProjectStatus == "HOST" {
+			return false, nil
+		}
+
+		op, err := svc.Projects.EnableXpnHost(a.spec.ID).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error making %s a shared VPC host: %w", a.spec.ID, err)
+		}
+		noteOperation(ctx, op.Name)
+		err = waitForCompute(ctx, svc, a.spec.ID, op)
+		if err != nil {
+			return false, fmt.Errorf("error making %s a shared VPC host: %w", a.spec.ID, err)
+		}
+
+		fmt.Println("made the project a shared VPC host")
+		noteChange(ctx, "", "host")
+		return true, nil
+	}
+
+	// a project that is not attached to any host has an empty host
+	host, err := svc.Projects.GetXpnHost(a.spec.ID).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error getting the shared VPC host of %s: %w", a.spec.ID, err)
+	}
+	switch host.Name {
+	case vpc.HostProject:
+		return false, nil
+	case "":
+	default:
+		return false, fmt.Errorf("%s is attached to shared VPC host %s; detach it in the console before attaching it to %s",
+			a.spec.ID, host.Name, vpc.HostProject)
+	}
+
+	// the attachment is made on the host, so this needs the Shared VPC Admin role there
+	op, err := svc.Projects.EnableXpnResource(vpc.HostProject, &compute.ProjectsEnableXpnResourceRequest{
+		XpnResource: &compute.XpnResourceId{Id: a.spec.ID, Type: "PROJECT"},
+	}).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error attaching %s to shared VPC host %s: %w", a.spec.ID, vpc.HostProject, err)
+	}
+	noteOperation(ctx, op.Name)
+	err = waitForCompute(ctx, svc, vpc.HostProject, op)
+	if err != nil {
+		return false, fmt.Errorf("error attaching %s to shared VPC host %s: %w", a.spec.ID, vpc.HostProject, err)
+	}
+
+	fmt.Printf("attached the project to shared VPC host %s\n", vpc.HostProject)
+	noteChange(ctx, "", vpc.HostProject)
+	return true, nil
+}
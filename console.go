@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// base URL of the Google Cloud console
+const consoleBase = "https://console.cloud.google.com"
+
+// consoleURL gets the URL of a page in the cloud console for a project, where path is
+// relative to the console root, e.g. "billing/linkedaccount"
+func consoleURL(path, projectID string) string {
+	return fmt.Sprintf("%s/%s?project=%s", consoleBase, path, url.QueryEscape(projectID))
+}
+
+// console pages that can be opened with "gproj open", relative to the console root
+var consolePages = map[string]string{
+	"dashboard": "home/dashboard",
+	"billing":   "billing/linkedaccount",
+	"apis":      "apis/dashboard",
+	"iam":       "iam-admin/iam",
+	"logs":      "logs/query",
+}
+
+// nodeConsoleURL gets the console page that is most relevant to a step in the apply graph
+func nodeConsoleURL(name, projectID string) string {
+	kind := strings.SplitN(name, ":", 2)[0]
+	switch kind {
+	case "billing":
+		return consoleURL("billing/linkedaccount", projectID)
+	case "api":
+		return consoleURL("apis/library/"+strings.TrimPrefix(name, "api:"), projectID)
+	case "enabled-apis":
+		return consoleURL("apis/dashboard", projectID)
+	case "cloudrun":
+		return consoleURL("run", projectID)
+	case "appengine":
+		return consoleURL("appengine", projectID)
+	case "scheduler":
+		return consoleURL("cloudscheduler", projectID)
+	}
+	return consoleURL("home/dashboard", projectID)
+}
+
+// openBrowser opens a URL in the user's default browser
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
+
+func open(ctx context.Context, args *args) error {
+	path, ok := consolePages[args.Open.Page]
+	if !ok {
+		return fmt.Errorf("unknown page %q, expected one of dashboard, billing, apis, iam, logs", args.Open.Page)
+	}
+
+	// find the project spec
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	url := consoleURL(path, spec.ID)
+	fmt.Println(url)
+	if args.Open.Print {
+		return nil
+	}
+	return openBrowser(url)
+}
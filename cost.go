@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/api/bigquery/v2"
+)
+
+// billing export tables are named like "my-project.my_dataset.gcp_billing_export_v1_XXXXXX"
+var exportTablePattern = regexp.MustCompile(`^[a-z][a-z0-9-]*\.[A-Za-z0-9_]+\.[A-Za-z0-9_]+$`)
+
+// query that sums the cost of a project by service over the last N days, net of credits
+const costQuery = "SELECT service.description, SUM(cost) + SUM(IFNULL((SELECT SUM(c.amount) FROM UNNEST(credits) c), 0)) AS net, currency " +
+	"FROM `%s` " +
+	"WHERE project.id = @project AND usage_start_time >= TIMESTAMP_SUB(CURRENT_TIMESTAMP(), INTERVAL @days DAY) " +
+	"GROUP BY 1, 3 ORDER BY net DESC"
+
+// parse a lookback period such as "30d" or "2w" into a number of days
+func parseLookback(period string) (int, error) {
+	s, unit := period, 1
+	switch {
+	case strings.HasSuffix(s, "d"):
+		s = strings.TrimSuffix(s, "d")
+	case strings.HasSuffix(s, "w"):
+		s = strings.TrimSuffix(s, "w")
+		unit = 7
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid period %q, expected something like 30d or 2w", period)
+	}
+	return n * unit, nil
+}
+
+// a single row of the cost breakdown
+type serviceCost struct {
+	Service  string
+	Cost     float64
+	Currency string
+}
+
+// queryCosts runs the cost query against a billing export table
+func queryCosts(ctx context.Context, bq *bigquery.Service, table, projectID string, days int) ([]serviceCost, error) {
+	legacy := false
+	req := &bigquery.QueryRequest{
+		Query:        fmt.Sprintf(costQuery, table),
+		UseLegacySql: &legacy,
+		TimeoutMs:    60000,
+		QueryParameters: []*bigquery.QueryParameter{
+			{
+				Name:           "project",
+				ParameterType:  &bigquery.QueryParameterType{Type: "STRING"},
+				ParameterValue: &bigquery.QueryParameterValue{Value: projectID},
+			},
+			{
+				Name:           "days",
+				ParameterType:  &bigquery.QueryParameterType{Type: "INT64"},
+				ParameterValue: &bigquery.QueryParameterValue{Value: strconv.Itoa(days)},
+			},
+		},
+	}
+
+	// the query runs in the project that holds the export table
+	queryProject := strings.SplitN(table, ".", 2)[0]
+	resp, err := bq.Jobs.Query(queryProject, req).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error querying billing export: %w", err)
+	}
+
+	rows := resp.Rows
+	for !resp.JobComplete {
+		time.Sleep(time.Second)
+		results, err := bq.Jobs.GetQueryResults(queryProject, resp.JobReference.JobId).
+			Location(resp.JobReference.Location).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("error getting billing export query results: %w", err)
+		}
+		resp.JobComplete, rows = results.JobComplete, results.Rows
+	}
+
+	var costs []serviceCost
+	for _, row := range rows {
+		if len(row.F) != 3 {
+			continue
+		}
+		cost, _ := strconv.ParseFloat(fmt.Sprint(row.F[1].V), 64)
+		costs = append(costs, serviceCost{
+			Service:  fmt.Sprint(row.F[0].V),
+			Cost:     cost,
+			Currency: fmt.Sprint(row.F[2].V),
+		})
+	}
+	return costs, nil
+}
+
+func cost(ctx context.Context, args *args) error {
+	table := args.Cost.Table
+	if table == "" {
+		return fmt.Errorf("no billing export table given; set up a BigQuery billing export " +
+			"(https://cloud.google.com/billing/docs/how-to/export-data-bigquery) and pass its table " +
+			"with --table or GPROJ_BILLING_EXPORT_TABLE, e.g. my-project.billing.gcp_billing_export_v1_XXXXXX")
+	}
+	if !exportTablePattern.MatchString(table) {
+		return fmt.Errorf("%q is not a table name of the form PROJECT.DATASET.TABLE", table)
+	}
+
+	days, err := parseLookback(args.Cost.Last)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	// find the project spec
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	bq, err := bigquery.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the bigquery API: %w", err)
+	}
+
+	costs, err := queryCosts(ctx, bq, table, spec.ID, days)
+	if err != nil {
+		return err
+	}
+	if len(costs) == 0 {
+		fmt.Printf("no costs recorded for %s in the last %d days (exports can lag by a day)\n", spec.ID, days)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "SERVICE\tCOST\t")
+	totals := make(map[string]float64)
+	for _, c := range costs {
+		fmt.Fprintf(w, "%s\t%.2f %s\t\n", c.Service, c.Cost, c.Currency)
+		totals[c.Currency] += c.Cost
+	}
+	for currency, total := range totals {
+		fmt.Fprintf(w, "total\t%.2f %s\t\n", total, currency)
+	}
+	return w.Flush()
+}
@@ -4,9 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
+	"runtime"
 
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/option"
 )
 
 // regular expressions for removing "quota_project_id": "*" from credentials (hacky!!)
@@ -39,13 +46,47 @@ func PrettyJSON(b []byte) string {
 // ultimately get in there?
 //   https://pkg.go.dev/google.golang.org/api/option#WithoutAuthentication
 
+// get the path at which gcloud keeps the application default credentials: in the
+// directory given by CLOUDSDK_CONFIG if it is set, and otherwise in %APPDATA%\gcloud on
+// windows and ~/.config/gcloud elsewhere. This is where google.FindDefaultCredentials looks.
+func adcPath(goos string, getenv func(string) string, home string) string {
+	const name = "application_default_credentials.json"
+	if dir := getenv("CLOUDSDK_CONFIG"); dir != "" {
+		return filepath.Join(dir, name)
+	}
+	if goos == "windows" {
+		appData := getenv("APPDATA")
+		if appData == "" {
+			appData = filepath.Join(home, "AppData", "Roaming")
+		}
+		return filepath.Join(appData, "gcloud", name)
+	}
+	return filepath.Join(home, ".config", "gcloud", name)
+}
+
+// remove the quotes around a path in an environment variable. On windows,
+// "set GOOGLE_APPLICATION_CREDENTIALS="C:\key.json"" keeps the quotes as part of the
+// value, which then names a file that does not exist.
+func unquotePath(s string) string {
+	if len(s) >= 2 && (s[0] == '"' && s[len(s)-1] == '"' || s[0] == '\'' && s[len(s)-1] == '\'') {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
 // SOLVED! The solution is to remove the key "quota_project_id" from application_default_credentials.json
 // eep what a mess...
 func googleCredentials(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+	if v := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); v != unquotePath(v) {
+		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", unquotePath(v))
+	}
+
 	// first get the credentials so that we use the google logic for where the credentials should come from
 	creds, err := google.FindDefaultCredentials(ctx, scopes...)
 	if err != nil {
-		return nil, err
+		home, _ := os.UserHomeDir()
+		return nil, fmt.Errorf("%w\n\nno credentials were found in GOOGLE_APPLICATION_CREDENTIALS or at %s; to create them, run\n  $ gcloud auth application-default login",
+			err, adcPath(runtime.GOOS, os.Getenv, home))
 	}
 
 	// Annoyingly, cloud resource manager fails if the application default credentials
@@ -58,3 +99,52 @@ func googleCredentials(ctx context.Context, scopes ...string) (*google.Credentia
 	creds.JSON = nil
 	return creds, nil
 }
+
+// connection is how gproj talks to google APIs. Every API client is created from the same
+// credentials and HTTP client so that all calls are made with the same identity and quota
+// project, which is not the case if a client finds its own credentials.
+type connection struct {
+	creds  *google.Credentials
+	client *http.Client
+}
+
+// connect finds the credentials and builds the HTTP client shared by all API clients. This
+// is the one place to change the scopes, the identity, or the way requests are made.
+func connect(ctx context.Context, args *args) (*connection, error) {
+	if args.Offline {
+		return nil, errOffline
+	}
+	// we do some hacky stuff to remove quota_project_id from the credentials json... ouch.
+	// Usage can still be attributed to a central project with --quota-project.
+	creds, err := googleCredentials(ctx, cloudresourcemanager.CloudPlatformScope)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := parseEndpointOverrides(args.EndpointOverrides)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := parseRateLimits(args.RateLimit)
+	if err != nil {
+		return nil, err
+	}
+	return &connection{
+		creds:  creds,
+		client: newHTTPClient(creds, args.Verbose, userAgent(args), args.QuotaProject, overrides, newLimiters(limits)),
+	}, nil
+}
+
+// get the user agent for requests to google APIs. Tools that run gproj on behalf of their
+// users can identify themselves with --user-agent, which goes in front of gproj's own.
+func userAgent(args *args) string {
+	ua := "gproj/" + version
+	if args.UserAgent != "" {
+		ua = args.UserAgent + " " + ua
+	}
+	return ua
+}
+
+// get the options with which to create an API client, followed by any extra options
+func (c *connection) options(extra ...option.ClientOption) []option.ClientOption {
+	return append([]option.ClientOption{option.WithHTTPClient(c.client)}, extra...)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestADCPath(t *testing.T) {
+	tests := []struct {
+		name string
+		goos string
+		env  map[string]string
+		home string
+		want string
+	}{
+		{
+			name: "linux",
+			goos: "linux",
+			home: "/home/alex",
+			want: filepath.Join("/home/alex", ".config", "gcloud", "application_default_credentials.json"),
+		},
+		{
+			name: "darwin",
+			goos: "darwin",
+			home: "/Users/alex",
+			want: filepath.Join("/Users/alex", ".config", "gcloud", "application_default_credentials.json"),
+		},
+		{
+			name: "windows",
+			goos: "windows",
+			env:  map[string]string{"APPDATA": filepath.Join("C:", "Users", "alex", "AppData", "Roaming")},
+			home: filepath.Join("C:", "Users", "alex"),
+			want: filepath.Join("C:", "Users", "alex", "AppData", "Roaming", "gcloud", "application_default_credentials.json"),
+		},
+		{
+			name: "windows without APPDATA",
+			goos: "windows",
+			home: filepath.Join("C:", "Users", "alex"),
+			want: filepath.Join("C:", "Users", "alex", "AppData", "Roaming", "gcloud", "application_default_credentials.json"),
+		},
+		{
+			name: "CLOUDSDK_CONFIG",
+			goos: "windows",
+			env:  map[string]string{"CLOUDSDK_CONFIG": "/sdk-config", "APPDATA": "/appdata"},
+			home: "/home/alex",
+			want: filepath.Join("/sdk-config", "application_default_credentials.json"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			getenv := func(k string) string { return test.env[k] }
+			got := adcPath(test.goos, getenv, test.home)
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestUnquotePath(t *testing.T) {
+	tests := map[string]string{
+		`C:\keys\sa.json`:     `C:\keys\sa.json`,
+		`"C:\keys\sa.json"`:   `C:\keys\sa.json`,
+		`'/home/alex/k.json'`: `/home/alex/k.json`,
+		`"unbalanced`:         `"unbalanced`,
+		`"`:                   `"`,
+		``:                    ``,
+	}
+	for in, want := range tests {
+		if got := unquotePath(in); got != want {
+			t.Errorf("unquotePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// BigQueryDataset models a bigquery dataset declared in googlecloudproject.yaml
+type BigQueryDataset struct {
+	Name                   string          // ID of the dataset, e.g. "billing_export"
+	Location               string          // e.g. "US" or "europe-west1" (default US)
+	DefaultTableExpiration string          `yaml:"defaultTableExpiration"` // delete tables this long after they are created, e.g. 90d (default never)
+	Access                 []DatasetAccess // grants in addition to those that the project's owners, editors, and viewers get by default
+}
+
+// DatasetAccess grants a role on a dataset to a member
+type DatasetAccess struct {
+	Role   string // READER, WRITER, or OWNER
+	Member string // e.g. user:alice@example.com, group:analysts@example.com, or domain:example.com
+}
+
+// the grants that bigquery gives a new dataset when no access list is given, which are
+// replaced rather than added to when one is
+var defaultDatasetAccess = []*bigquery.DatasetAccess{
+	{Role: "OWNER", SpecialGroup: "projectOwners"},
+	{Role: "WRITER", SpecialGroup: "projectWriters"},
+	{Role: "READER", SpecialGroup: "projectReaders"},
+}
+
+// convert an IAM-style member to a dataset access entry
+func datasetAccessFromSpec(a DatasetAccess) *bigquery.DatasetAccess {
+	entry := &bigquery.DatasetAccess{Role: strings.ToUpper(a.Role)}
+	kind := strings.SplitN(a.Member, ":", 2)
+	switch {
+	case len(kind) == 2 && (kind[0] == "user" || kind[0] == "serviceAccount"):
+		entry.UserByEmail = kind[1]
+	case len(kind) == 2 && kind[0] == "group":
+		entry.GroupByEmail = kind[1]
+	case len(kind) == 2 && kind[0] == "domain":
+		entry.Domain = kind[1]
+	default:
+		entry.IamMember = a.Member
+	}
+	return entry
+}
+
+// convert a dataset from the spec to the form expected by the bigquery API
+func datasetFromSpec(projectID string, ds BigQueryDataset) (*bigquery.Dataset, error) {
+	if ds.Name == "" {
+		return nil, fmt.Errorf("datasets must have a name")
+	}
+
+	d := bigquery.Dataset{
+		DatasetReference: &bigquery.DatasetReference{ProjectId: projectID, DatasetId: ds.Name},
+		Location:         ds.Location,
+	}
+
+	if ds.DefaultTableExpiration != "" {
+		days, err := parseLookback(ds.DefaultTableExpiration)
+		if err != nil {
+			return nil, fmt.Errorf("dataset %s: %w", ds.Name, err)
+		}
+		d.DefaultTableExpirationMs = int64(days) * 24 * 60 * 60 * 1000
+	}
+
+	if len(ds.Access) > 0 {
+		d.Access = append(d.Access, defaultDatasetAccess...)
+		for _, a := range ds.Access {
+			d.Access = append(d.Access, datasetAccessFromSpec(a))
+		}
+	}
+	return &d, nil
+}
+
+// create a bigquery dataset if it does not already exist, and report whether it was created
+func applyDataset(ctx context.Context, conn *connection, projectID string, ds BigQueryDataset, state *State) (bool, error) {
+	d, err := datasetFromSpec(projectID, ds)
+	if err != nil {
+		return false, err
+	}
+
+	svc, err := bigquery.NewService(ctx, conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the bigquery API: %w", err)
+	}
+
+	_, err = svc.Datasets.Get(projectID, ds.Name).Context(ctx).Do()
+	if err == nil {
+		return false, nil
+	}
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != 404 {
+		return false, fmt.Errorf("error getting dataset %s: %w", ds.Name, err)
+	}
+
+	fmt.Printf("creating dataset %s\n", ds.Name)
+	_, err = svc.Datasets.Insert(projectID, d).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error creating dataset %s: %w", ds.Name, err)
+	}
+	state.Record(kindDataset, datasetName(projectID, ds.Name), ds.Location)
+	return true, nil
+}
+
+// the name under which a dataset is recorded in the state
+func datasetName(projectID, dataset string) string {
+	return fmt.Sprintf("projects/%s/datasets/%s", projectID, dataset)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/cloudbilling/v1"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// projectDescription is everything that "gproj describe" shows about a project
+type projectDescription struct {
+	Project  *cloudresourcemanager.Project             `json:"project"`
+	Billing  *cloudbilling.ProjectBillingInfo          `json:"billing"`
+	Ancestry *cloudresourcemanager.GetAncestryResponse `json:"ancestry"`
+	Liens    []*cloudresourcemanager.Lien              `json:"liens"`
+}
+
+// convert a value to YAML via its JSON encoding, so that the field names and omitted
+// fields match those in the Google API documentation
+func jsonToYAML(v interface{}) ([]byte, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.MapSlice
+	err = yaml.Unmarshal(buf, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// fetch the project, its billing info, its ancestry, and its liens
+func describeProject(ctx context.Context, conn *connection, projectID string) (*projectDescription, error) {
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil, err
+	}
+
+	billing, err := cloudbilling.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing the billing API: %w", err)
+	}
+
+	var desc projectDescription
+	desc.Project, err = resources.Projects.Get(projectID).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error getting project %s: %w", projectID, err)
+	}
+
+	desc.Billing, err = billing.Projects.GetBillingInfo("projects/" + projectID).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error getting billing info: %w", err)
+	}
+
+	desc.Ancestry, err = resources.Projects.GetAncestry(projectID, &cloudresourcemanager.GetAncestryRequest{}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error getting project ancestry: %w", err)
+	}
+
+	desc.Liens, err = projectLiens(ctx, resources, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &desc, nil
+}
+
+func describe(ctx context.Context, args *args) error {
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	// find the project spec
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	desc, err := describeProject(ctx, conn, spec.ID)
+	if err != nil {
+		return err
+	}
+
+	// print the full API responses
+	if args.Describe.Raw {
+		var buf []byte
+		if args.Describe.JSON {
+			buf, err = json.MarshalIndent(desc, "", "  ")
+		} else {
+			buf, err = jsonToYAML(desc)
+		}
+		if err != nil {
+			return fmt.Errorf("error marshalling project description: %w", err)
+		}
+		fmt.Println(strings.TrimSpace(string(buf)))
+		return nil
+	}
+
+	// print a summary
+	p := desc.Project
+	fmt.Printf("id:       %s\n", p.ProjectId)
+	fmt.Printf("name:     %s\n", p.Name)
+	fmt.Printf("number:   %d\n", p.ProjectNumber)
+	fmt.Printf("state:    %s\n", p.LifecycleState)
+	if created, err := time.Parse(time.RFC3339, p.CreateTime); err == nil {
+		fmt.Printf("created:  %s (%s)\n", p.CreateTime, humanAgo(created))
+	} else {
+		fmt.Printf("created:  %s\n", p.CreateTime)
+	}
+	if desc.Billing.BillingEnabled {
+		fmt.Printf("billing:  %s\n", strings.TrimPrefix(desc.Billing.BillingAccountName, "billingAccounts/"))
+	} else {
+		fmt.Printf("billing:  disabled\n")
+	}
+
+	var parents []string
+	for _, ancestor := range desc.Ancestry.Ancestor {
+		if ancestor.ResourceId != nil {
+			parents = append(parents, ancestor.ResourceId.Type+"/"+ancestor.ResourceId.Id)
+		}
+	}
+	fmt.Printf("ancestry: %s\n", strings.Join(parents, " < "))
+	for _, l := range desc.Liens {
+		fmt.Printf("lien:     %s\n", formatLien(l))
+	}
+	return nil
+}
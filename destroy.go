@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// resources are destroyed in this order so that nothing is deleted while something else still depends on it
+var destroyOrder = []string{
+	kindDomainMapping,
+	kindSchedulerJob,
+	kindCloudRunService,
+	kindGeneric, // after the services that may use them, such as topics, buckets, and service accounts
+	kindDataset,
+	kindSQLInstance,
+	kindAPIKey,
+	kindOAuthClient,
+}
+
+// get the position of a kind in the destroy order
+func destroyRank(kind string) int {
+	for i, k := range destroyOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(destroyOrder)
+}
+
+// ask the user to type the project ID to confirm a destructive operation
+func confirmByTyping(args *args, projectID, instead string) (bool, error) {
+	answer, err := promptInput(args, fmt.Sprintf("type the project ID (%s) to confirm:", projectID), instead)
+	if err != nil {
+		return false, err
+	}
+	return answer == projectID, nil
+}
+
+func destroy(ctx context.Context, args *args) error {
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	// find the project spec
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	err = spec.checkDestroyAllowed()
+	if err != nil {
+		return err
+	}
+
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
+
+	if !args.Destroy.Adopt {
+		project, err := resources.Projects.Get(spec.ID).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("error getting project %s: %w", spec.ID, err)
+		}
+		err = checkManaged(project)
+		if err != nil {
+			return err
+		}
+	}
+
+	// if state tracking is enabled then destroy only what gproj created, otherwise everything in
+	// the spec, which may include resources of the same name that gproj never created
+	toDestroy := desiredResources(spec)
+	backend, err := newStateBackend(ctx, conn, spec)
+	if err != nil {
+		return err
+	}
+	if backend == nil && len(toDestroy) > 0 && !args.Destroy.AssumeOwned {
+		return fmt.Errorf("there is no state recording what gproj created in %s, so destroy cannot tell those "+
+			"resources from others with the same names; run with --assume-owned to delete everything in the spec, "+
+			"or delete only the project with\n  $ gproj delete", spec.ID)
+	}
+
+	var state *State
+	if backend != nil {
+		unlock, err := lockState(ctx, backend)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		state, err = backend.Load(ctx)
+		if err != nil {
+			return err
+		}
+
+		toDestroy = nil
+		for _, r := range state.Resources {
+			if r.Kind != kindProject {
+				toDestroy = append(toDestroy, r)
+			}
+		}
+
+		defer func() {
+			ctx, cancel := cleanupContext(ctx)
+			defer cancel()
+			if err := backend.Save(ctx, state); err != nil {
+				fmt.Println("warning:", err)
+			}
+		}()
+	}
+
+	// within each kind, later resources may depend on earlier ones, so delete them in reverse
+	for i, j := 0, len(toDestroy)-1; i < j; i, j = i+1, j-1 {
+		toDestroy[i], toDestroy[j] = toDestroy[j], toDestroy[i]
+	}
+	sort.SliceStable(toDestroy, func(i, j int) bool {
+		return destroyRank(toDestroy[i].Kind) < destroyRank(toDestroy[j].Kind)
+	})
+
+	// the budget lives on the billing account, so it would outlive the project
+	budget, err := projectBudget(ctx, conn, spec.ID)
+	if err != nil {
+		return err
+	}
+
+	// show a preview of what will happen
+	fmt.Println("the following will be destroyed:")
+	for _, r := range toDestroy {
+		name := r.Name
+		if name == "" {
+			name = r.Label
+		}
+		fmt.Printf("  - %s %s\n", r.Kind, name)
+	}
+	if budget != nil {
+		fmt.Printf("  - budget %q on %s\n", budget.DisplayName, strings.Split(budget.Name, "/budgets/")[0])
+	}
+	fmt.Printf("  - project %s\n", spec.ID)
+
+	// destroy has no --yes because it must always be confirmed by a person
+	ok, err := confirmByTyping(args, spec.ID, "")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("confirmation did not match, not destroying anything")
+	}
+
+	for _, r := range toDestroy {
+		fmt.Printf("deleting %s %s...\n", r.Kind, r.Name)
+		err := deleteResource(ctx, conn, r, args.Destroy.ForceEmpty)
+		if err != nil {
+			return fmt.Errorf("error deleting %s %s: %w", r.Kind, r.Name, err)
+		}
+		state.Forget(r.Kind, r.Name)
+	}
+
+	if budget != nil {
+		fmt.Printf("deleting budget %q...\n", budget.DisplayName)
+		err = deleteBudget(ctx, conn, budget)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.Destroy.UnlinkBilling {
+		err = unlinkBilling(ctx, conn, spec.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = resources.Projects.Delete(spec.ID).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	state.Forget(kindProject, "projects/"+spec.ID)
+
+	fmt.Printf("Project %s has been destroyed. To undelete the project (but not its resources) in the next 30 days, run\n  $ gproj undelete\n", spec.ID)
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/cloudbilling/v1"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/serviceusage/v1"
+)
+
+// the value recorded for an item in a list of strings, such as an API in the apis list,
+// for which membership is all that matters
+const listMember = "(listed)"
+
+// specChange is a difference in a single field between two specs
+type specChange struct {
+	Field  string // path to the field, e.g. "labels.team" or "apis[run.googleapis.com]"
+	Before string // empty if the field was added
+	After  string // empty if the field was removed
+}
+
+// flatten a spec into a map from field path to value. Lists of strings are treated as
+// sets and lists of structs are keyed by their Name field, so that reordering a list
+// does not show up as a change. Empty values are omitted.
+func flattenSpec(spec *ProjectSpec) map[string]string {
+	out := make(map[string]string)
+	flattenValue(reflect.ValueOf(spec), "", out)
+	return out
+}
+
+func flattenValue(v reflect.Value, path string, out map[string]string) {
+	join := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			flattenValue(v.Elem(), path, out)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if name := yamlFieldName(v.Type().Field(i)); name != "" {
+				flattenValue(v.Field(i), join(name), out)
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			flattenValue(v.MapIndex(key), join(fmt.Sprint(key.Interface())), out)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			item := reflect.Indirect(v.Index(i))
+			if item.Kind() == reflect.Struct && item.FieldByName("Name").IsValid() {
+				flattenValue(item, fmt.Sprintf("%s[%v]", path, item.FieldByName("Name").Interface()), out)
+			} else {
+				out[fmt.Sprintf("%s[%v]", path, item.Interface())] = listMember
+			}
+		}
+	default:
+		if !v.IsZero() {
+			out[path] = fmt.Sprint(v.Interface())
+		}
+	}
+}
+
+// diffSpecs compares two specs field by field and returns the differences in order of field.
+// Fields of the before spec that are unknown because gproj is offline are shown as changes
+// to every value given for them in the after spec.
+func diffSpecs(before, after *ProjectSpec) []specChange {
+	b, a := flattenSpec(before), flattenSpec(after)
+
+	var changes []specChange
+	for field, value := range b {
+		if a[field] != value {
+			changes = append(changes, specChange{Field: field, Before: value, After: a[field]})
+		}
+	}
+	for field, value := range a {
+		if before.isUnknown(field) {
+			changes = append(changes, specChange{Field: field, Before: unknownOffline, After: value})
+		} else if _, present := b[field]; !present {
+			changes = append(changes, specChange{Field: field, After: value})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// format a change as a single line in the style of a unified diff
+func (c specChange) String() string {
+	switch {
+	case c.Before == unknownOffline && c.After == listMember:
+		return "? " + c.Field
+	case c.Before == unknownOffline:
+		return fmt.Sprintf("? %s: %s", c.Field, c.After)
+	case c.Before == "" && c.After == listMember:
+		return "+ " + c.Field
+	case c.After == "" && c.Before == listMember:
+		return "- " + c.Field
+	case c.Before == "":
+		return fmt.Sprintf("+ %s: %s", c.Field, c.After)
+	case c.After == "":
+		return fmt.Sprintf("- %s: %s", c.Field, c.Before)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", c.Field, c.Before, c.After)
+}
+
+// returned by liveProjectSpec if the project does not exist or is not visible
+var errProjectNotFound = errors.New("project not found")
+
+// liveProjectSpec builds a spec describing the project as it currently exists, for
+// comparison against the desired spec. Only the project-level fields are fetched: the
+// other resources are copied from the desired spec, and APIs that are enabled but not
+// in the desired spec are left out since google enables a number of APIs by default.
+func liveProjectSpec(ctx context.Context, args *args, desired *ProjectSpec) (*ProjectSpec, error) {
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := serviceusage.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing the service usage API: %w", err)
+	}
+	billing, err := cloudbilling.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing the billing API: %w", err)
+	}
+
+	project, err := resources.Projects.Get(desired.ID).Context(ctx).Do()
+	if e, ok := err.(*googleapi.Error); ok && e.Code == 403 {
+		// see ensureProject for why a missing project gives 403
+		return nil, errProjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting project %s: %w", desired.ID, err)
+	}
+
+	live := ProjectSpec{
+		Name:   project.Name,
+		ID:     project.ProjectId,
+		Labels: liveLabels(project.Labels, desired),
+	}
+	copyUnfetchedFields(&live, desired)
+
+	enabled, err := enabledAPIs(ctx, usage, project.ProjectNumber)
+	if err != nil {
+		return nil, err
+	}
+	for _, api := range desiredAPIs(desired) {
+		if contains(enabled, api) {
+			live.APIs = append(live.APIs, api)
+		}
+	}
+
+	billingInfo, err := billing.Projects.GetBillingInfo(formatProjectNumber(project.ProjectNumber)).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error getting billing info for %s: %w", desired.ID, err)
+	}
+	live.Billing = billingInfo.BillingAccountName
+
+	projectNumbersMu.Lock()
+	if err := cacheProjectNumber(project.ProjectId, project.ProjectNumber); err != nil {
+		fmt.Println("warning:", err)
+	}
+	projectNumbersMu.Unlock()
+	if err := cacheLiveSpec(project.ProjectNumber, &live); err != nil {
+		fmt.Println("warning:", err)
+	}
+	return &live, nil
+}
+
+// liveLabels picks out the labels on the live project that are also in the spec. Apply
+// adds and updates the labels in the spec but leaves others alone, whether they were added
+// by gproj itself or by hand, so only these can differ in a way that apply would change.
+func liveLabels(labels map[string]string, desired *ProjectSpec) map[string]string {
+	live := make(map[string]string)
+	for k := range desired.Labels {
+		if v, ok := labels[k]; ok {
+			live[k] = v
+		}
+	}
+	return live
+}
+
+// copy the fields that liveProjectSpec does not fetch from the desired spec to the live spec
+func copyUnfetchedFields(live, desired *ProjectSpec) {
+	live.Version = desired.Version
+	live.Number = desired.Number
+	live.Tags = desired.Tags
+	live.Parent = desired.Parent
+	live.DependsOn = desired.DependsOn
+	live.IAM = desired.IAM
+	live.ActAs = desired.ActAs
+	live.Budget = desired.Budget
+	live.BillingExport = desired.BillingExport
+	live.Bundles = desired.Bundles
+	live.Groups = desired.Groups
+	live.OAuth = desired.OAuth
+	live.IdentityPlatform = desired.IdentityPlatform
+	live.ServiceNetworking = desired.ServiceNetworking
+	live.Resources = desired.Resources
+	live.Plugins = desired.Plugins
+	live.Compute = desired.Compute
+	live.CloudRun = desired.CloudRun
+	live.Scheduler = desired.Scheduler
+	live.Datasets = desired.Datasets
+	live.CloudSQL = desired.CloudSQL
+	live.APIKeys = desired.APIKeys
+	live.State = desired.State
+	live.Lifecycle = desired.Lifecycle
+	live.Notes = desired.Notes
+	live.AllowedMemberDomains = desired.AllowedMemberDomains
+	live.AllowedCustomerIDs = desired.AllowedCustomerIDs
+}
+
+// comparableSpecs fetches the live project and normalizes the spec so that the two can be
+// compared in terms of what apply would do: billing matches unless an account ID is given,
+// and API shorthands and implied APIs are expanded. The live spec is empty if the project
+// does not exist yet.
+func comparableSpecs(ctx context.Context, args *args, spec *ProjectSpec) (live, desired *ProjectSpec, err error) {
+	if args.Offline {
+		live = offlineProjectSpec(spec)
+	} else {
+		live, err = liveProjectSpec(ctx, args, spec)
+	}
+	if errors.Is(err, errProjectNotFound) {
+		live = &ProjectSpec{}
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	normalized := *spec
+	normalized.APIs = desiredAPIs(spec)
+	if live.ID != "" && spec.ignoresChanges("labels") {
+		normalized.Labels = live.Labels
+	}
+	switch billingStrategy(spec.Billing) {
+	case billingID:
+		if live.ID != "" && spec.ignoresChanges("billing") {
+			normalized.Billing = live.Billing
+			break
+		}
+		normalized.Billing = billingAccountName(spec.Billing)
+	default:
+		// the other strategies cannot be compared without asking the billing API or the user
+		normalized.Billing = live.Billing
+	}
+	return live, &normalized, nil
+}
+
+func diff(ctx context.Context, args *args) error {
+	// find the project spec
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	var before, after *ProjectSpec
+	var beforeName, afterName string
+	if args.Diff.Other != "" {
+		other, err := loadProjectSpec(fsys, args.Diff.Other)
+		if err != nil {
+			return err
+		}
+		before, beforeName = spec, spec.path
+		after, afterName = other, other.path
+	} else {
+		live, desired, err := comparableSpecs(ctx, args, spec)
+		if err != nil {
+			return err
+		}
+		before, beforeName = live, "live project "+spec.ID
+		after, afterName = desired, spec.path
+	}
+
+	changes := diffSpecs(before, after)
+	if len(changes) == 0 {
+		fmt.Printf("no differences between %s and %s\n", beforeName, afterName)
+		return nil
+	}
+
+	fmt.Printf("--- %s\n+++ %s\n", beforeName, afterName)
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, c.String())
+	}
+	fmt.Println(strings.Join(lines, "\n"))
+	return nil
+}
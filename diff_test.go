@@ -0,0 +1,183 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLiveLabelsDiff(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    map[string]string
+		live    map[string]string
+		changes []string
+	}{
+		{
+			name: "same",
+			spec: map[string]string{"env": "prod"},
+			live: map[string]string{"env": "prod"},
+		},
+		{
+			name:    "changed value",
+			spec:    map[string]string{"env": "prod"},
+			live:    map[string]string{"env": "dev"},
+			changes: []string{"~ labels.env: dev -> prod"},
+		},
+		{
+			name:    "missing from the project",
+			spec:    map[string]string{"env": "prod", "team": "web"},
+			live:    map[string]string{"env": "prod"},
+			changes: []string{"+ labels.team: web"},
+		},
+		{
+			name: "labels that apply leaves alone are not differences",
+			spec: map[string]string{"env": "prod"},
+			live: map[string]string{
+				"env":             "prod",
+				"added-by-hand":   "yes",
+				managedByLabel:    managedByValue,
+				specRevLabel:      "0123456789ab",
+				deleteAfterLabel:  "1767225600",
+				ownerLabel:        "alex",
+				gprojVersionLabel: "v1",
+			},
+		},
+		{
+			name: "no labels in the spec",
+			live: map[string]string{"env": "prod"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			desired := &ProjectSpec{ID: "acme-app", Labels: test.spec}
+			live := &ProjectSpec{ID: "acme-app", Labels: liveLabels(test.live, desired)}
+			var got []string
+			for _, c := range diffSpecs(live, desired) {
+				got = append(got, c.String())
+			}
+			if !reflect.DeepEqual(got, test.changes) {
+				t.Errorf("got changes %q, want %q", got, test.changes)
+			}
+		})
+	}
+}
+
+func TestCopyUnfetchedFields(t *testing.T) {
+	desired := &ProjectSpec{
+		ID:        "acme-app",
+		Name:      "Acme",
+		Parent:    "folders/123",
+		IAM:       map[string][]string{"roles/viewer": {"group:eng@example.com"}},
+		CloudRun:  []CloudRunService{{Name: "web"}},
+		Scheduler: []SchedulerJob{{Name: "nightly"}},
+		Budget:    &Budget{},
+		Notes:     map[string]string{"team": "web"},
+	}
+	live := &ProjectSpec{ID: "acme-app", Name: "Acme"}
+	copyUnfetchedFields(live, desired)
+	if changes := diffSpecs(live, desired); len(changes) != 0 {
+		t.Errorf("fields that are not fetched showed up as changes: %v", changes)
+	}
+}
+
+func TestDiffSpecs(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after *ProjectSpec
+		want          []string
+	}{
+		{
+			name:   "identical",
+			before: &ProjectSpec{ID: "p", APIs: []string{"a", "b"}},
+			after:  &ProjectSpec{ID: "p", APIs: []string{"a", "b"}},
+		},
+		{
+			name:   "reordered lists",
+			before: &ProjectSpec{APIs: []string{"a", "b"}, CloudRun: []CloudRunService{{Name: "x"}, {Name: "y", Region: "r"}}},
+			after:  &ProjectSpec{APIs: []string{"b", "a"}, CloudRun: []CloudRunService{{Name: "y", Region: "r"}, {Name: "x"}}},
+		},
+		{
+			name:   "changed, added, and removed",
+			before: &ProjectSpec{Name: "Old", Labels: map[string]string{"team": "web"}, APIs: []string{"a"}},
+			after:  &ProjectSpec{Name: "New", Billing: "123", APIs: []string{"b"}},
+			want: []string{
+				"- apis[a]",
+				"+ apis[b]",
+				"+ billing: 123",
+				"- labels.team: web",
+				"~ name: Old -> New",
+			},
+		},
+		{
+			name:   "struct in a list",
+			before: &ProjectSpec{CloudRun: []CloudRunService{{Name: "web", Region: "us-central1"}}},
+			after:  &ProjectSpec{CloudRun: []CloudRunService{{Name: "web", Region: "europe-west1"}}},
+			want:   []string{"~ cloudrun[web].region: us-central1 -> europe-west1"},
+		},
+		{
+			name:   "unknown offline",
+			before: &ProjectSpec{Name: "P", unknown: []string{"apis"}},
+			after:  &ProjectSpec{Name: "P", APIs: []string{"a"}},
+			want:   []string{"? apis[a]"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, c := range diffSpecs(test.before, test.after) {
+				got = append(got, c.String())
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got changes %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// make a value of the given type that is not the zero value
+func nonZero(t reflect.Type) reflect.Value {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Ptr:
+		v.Set(reflect.New(t.Elem()))
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(t, 1, 1))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(t))
+		v.SetMapIndex(nonZero(t.Key()), nonZero(t.Elem()))
+	case reflect.String:
+		v.SetString("x")
+	case reflect.Int, reflect.Int64:
+		v.SetInt(1)
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).IsExported() {
+				v.Field(i).Set(nonZero(t.Field(i).Type))
+			}
+		}
+	}
+	return v
+}
+
+// every field that liveProjectSpec does not fetch must be copied, or a field added to the
+// spec later would show up as a change in every diff and plan
+func TestCopyUnfetchedFieldsCoversSpec(t *testing.T) {
+	fetched := map[string]bool{"Name": true, "ID": true, "Labels": true, "APIs": true, "Billing": true}
+
+	desired := nonZero(reflect.TypeOf(ProjectSpec{})).Interface().(ProjectSpec)
+	var live ProjectSpec
+	copyUnfetchedFields(&live, &desired)
+
+	v := reflect.ValueOf(live)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() || fetched[field.Name] {
+			continue
+		}
+		if v.Field(i).IsZero() {
+			t.Errorf("copyUnfetchedFields does not copy %s", field.Name)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// doctorCheck is one of the things that "gproj doctor" checks about the environment
+type doctorCheck struct {
+	name   string
+	online bool                                      // whether the check needs the network
+	run    func(ctx context.Context) (string, error) // returns a detail to show on success
+	fix    string                                    // what to do if the check fails
+}
+
+// check that application default credentials exist and can be used to get a token
+func checkCredentials(ctx context.Context) (string, error) {
+	creds, err := googleCredentials(ctx, cloudresourcemanager.CloudPlatformScope)
+	if err != nil {
+		return "", err
+	}
+	conn := &connection{creds: creds}
+	email, err := principalEmail(ctx, conn)
+	if err != nil {
+		return "", err
+	}
+	return email, nil
+}
+
+// check that the gcloud binary can be found for "gproj gcloud"
+func checkGcloud(ctx context.Context) (string, error) {
+	return findGcloud()
+}
+
+// check that google APIs can be reached. Any HTTP response at all means the network is fine.
+func checkNetwork(ctx context.Context) (string, error) {
+	const url = "https://cloudresourcemanager.googleapis.com/"
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	begin := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return fmt.Sprintf("%s in %v", url, time.Since(begin).Round(time.Millisecond)), nil
+}
+
+// check that the cache dir exists and files can be written to it
+func checkCacheDir(ctx context.Context) (string, error) {
+	cacheDir, err := fsys.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting user cache dir: %w", err)
+	}
+	dir := filepath.Join(cacheDir, "gproj")
+	err = fsys.MkdirAll(dir, dirPerm)
+	if err != nil {
+		return "", fmt.Errorf("error creating cache dir: %w", err)
+	}
+	path := filepath.Join(dir, "doctor")
+	err = fsys.WriteFile(path, []byte("ok\n"), filePerm)
+	if err != nil {
+		return "", fmt.Errorf("error writing to cache dir: %w", err)
+	}
+	err = fsys.Remove(path)
+	if err != nil {
+		return "", fmt.Errorf("error removing %s: %w", path, err)
+	}
+	return dir, nil
+}
+
+// doctor checks that the environment is set up for gproj to work, and says how to fix
+// whatever is not
+func doctor(ctx context.Context, args *args) error {
+	checks := []doctorCheck{
+		{
+			name:   "credentials",
+			online: true,
+			run:    checkCredentials,
+			fix:    "run \"gcloud auth application-default login\", or set GOOGLE_APPLICATION_CREDENTIALS to a service account key",
+		},
+		{
+			name: "gcloud",
+			run:  checkGcloud,
+			fix:  "install the Google Cloud SDK from https://cloud.google.com/sdk/docs/install (only needed for \"gproj gcloud\")",
+		},
+		{
+			name:   "network",
+			online: true,
+			run:    checkNetwork,
+			fix:    "check your proxy and firewall settings, or use --endpoint-overrides for private access",
+		},
+		{
+			name: "cache",
+			run:  checkCacheDir,
+			fix:  "make the directory writable, or set XDG_CACHE_HOME to somewhere that is",
+		},
+		{
+			name: "spec",
+			run: func(ctx context.Context) (string, error) {
+				spec, err := readProjectSpec(args)
+				if err != nil {
+					return "", err
+				}
+				if n := len(lintSpec(spec)); n > 0 {
+					return "", fmt.Errorf("%s has %d issues", spec.path, n)
+				}
+				return spec.path, nil
+			},
+			fix: "run \"gproj lint\" to see the issues, or create " + gprojFile + " in this directory or one above it",
+		},
+	}
+
+	var failed int
+	for _, c := range checks {
+		if c.online && args.Offline {
+			fmt.Printf("skip  %s (offline)\n", c.name)
+			continue
+		}
+		detail, err := c.run(ctx)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %s: %v\n      fix: %s\n", c.name, err, c.fix)
+			continue
+		}
+		fmt.Printf("ok    %s: %s\n", c.name, detail)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// number of past durations kept for each kind of operation
+const durationHistory = 50
+
+// how often to print progress while waiting for an operation
+const progressInterval = 10 * time.Second
+
+// guards the durations file, since operations finish concurrently during apply
+var durationsMu sync.Mutex
+
+// path to the file in which past operation durations are recorded. Unlike the API
+// catalog this is shared between projects.
+func durationsPath() (string, error) {
+	cacheDir, err := fsys.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting user cache dir: %w", err)
+	}
+
+	dir := filepath.Join(cacheDir, "gproj")
+	err = fsys.MkdirAll(dir, dirPerm)
+	if err != nil {
+		return "", fmt.Errorf("error creating cache dir: %w", err)
+	}
+	return filepath.Join(dir, "durations.json"), nil
+}
+
+// load past durations in seconds, keyed by kind of operation. Errors are not fatal since
+// the durations are only used for progress estimates.
+func loadDurations() map[string][]float64 {
+	durations := make(map[string][]float64)
+	path, err := durationsPath()
+	if err != nil {
+		return durations
+	}
+	buf, err := fsys.ReadFile(path)
+	if err != nil {
+		return durations
+	}
+	json.Unmarshal(buf, &durations)
+	return durations
+}
+
+// add a duration to the history for a kind of operation
+func recordDuration(kind string, d time.Duration) error {
+	durationsMu.Lock()
+	defer durationsMu.Unlock()
+
+	durations := loadDurations()
+	history := append(durations[kind], d.Seconds())
+	if len(history) > durationHistory {
+		history = history[len(history)-durationHistory:]
+	}
+	durations[kind] = history
+
+	path, err := durationsPath()
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(durations)
+	if err != nil {
+		return fmt.Errorf("error marshalling durations: %w", err)
+	}
+	return fsys.WriteFile(path, buf, filePerm)
+}
+
+// get the pth percentile (0 to 1) of a list of numbers
+func percentile(xs []float64, p float64) float64 {
+	sorted := append([]float64(nil), xs...)
+	sort.Float64s(sorted)
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+// format a number of seconds for people
+func seconds(s float64) string {
+	return humanDuration(time.Duration(s * float64(time.Second)))
+}
+
+// timeOperation calls wait, which should block until a long-running operation completes,
+// printing progress along the way with an estimate based on how long the same kind of
+// operation took in the past. It warns if the operation takes longer than usual, and
+// records how long it took if it succeeds.
+func timeOperation(kind, label string, wait func() error) error {
+	durationsMu.Lock()
+	history := loadDurations()[kind]
+	durationsMu.Unlock()
+
+	begin := time.Now()
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+		var warned bool
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+
+			elapsed := time.Since(begin).Seconds()
+			if len(history) < 5 {
+				fmt.Printf("waiting for %s (%s so far)\n", label, seconds(elapsed))
+				continue
+			}
+
+			typical, p95 := percentile(history, 0.5), percentile(history, 0.95)
+			if elapsed > p95 && !warned {
+				fmt.Printf("warning: %s has taken %s, longer than 95%% of previous runs (%s); it may be stuck\n", label, seconds(elapsed), seconds(p95))
+				warned = true
+			} else if elapsed < typical {
+				fmt.Printf("waiting for %s (%s so far, about %s remaining)\n", label, seconds(elapsed), seconds(typical-elapsed))
+			} else {
+				fmt.Printf("waiting for %s (%s so far, usually takes %s)\n", label, seconds(elapsed), seconds(typical))
+			}
+		}
+	}()
+
+	err := wait()
+	close(done)
+	if err != nil {
+		return err
+	}
+
+	took := time.Since(begin)
+	if took > progressInterval {
+		fmt.Printf("%s finished in %s\n", label, humanDuration(took))
+	}
+	if err := recordDuration(kind, took); err != nil {
+		fmt.Println("warning: unable to record operation duration:", err)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// documentation for "gproj explain TOPIC", keyed by the name of a spec field as it is
+// written in googlecloudproject.yaml
+var explanations = map[string]string{
+	"billing": `The billing field of the spec selects the billing account that the project is
+linked to. It can be any of the following:
+
+  billing: 012345-6789AB-CDEFG0
+      Link the project to the billing account with this ID. The "billingAccounts/"
+      prefix is optional. This is the most predictable choice.
+
+  billing: My Billing Account
+      Link the project to the open billing account with this display name. Fails if
+      no open account, or more than one, has this name.
+
+  billing: auto
+      Link the project to the only open billing account visible to you. Fails if you
+      can see more than one open billing account. This used to be spelled "enable",
+      which "gproj migrate-spec" will rename.
+
+  billing: prompt
+      List the open billing accounts and ask which one to use. Fails when gproj is
+      not running interactively, e.g. with --ci.
+
+  billing: none
+      Do not manage billing: gproj neither links nor unlinks a billing account. This
+      is also what happens if the billing field is left out.
+`,
+	"id": `The id field of the spec is the ID of the project, which is how gcloud and google APIs
+refer to it. It must be 6 to 30 lowercase letters, digits, or hyphens, must start
+with a letter, and must be unique across all of google cloud. It cannot be changed
+once the project exists, and cannot be reused for 30 days after the project is
+deleted.
+
+  id: acme-billing-prod
+`,
+	"name": `The name field of the spec is the human readable name of the project, shown in the
+console. Unlike the ID it need not be unique and can be changed at any time. It may
+be 4 to 30 letters, digits, spaces, hyphens, single quotes, double quotes, and
+exclamation marks.
+
+  name: Acme Billing (production)
+`,
+	"labels": `The labels field of the spec is a set of key/value labels to put on the project, which
+show up in the console and in billing exports, where they are handy for working out
+which team a cost belongs to. Keys must start with a lowercase letter and, like
+values, may contain only lowercase letters, digits, underscores, and hyphens, up to 63
+characters.
+
+Labels in the spec are added to the project or changed to match; labels on the
+project that are not in the spec are left alone.
+
+  labels:
+    team: payments
+    env: prod
+`,
+	"tags": `The tags field of the spec binds tag values to the project. Unlike labels, tags are
+defined by the organization and can be used in IAM conditions and org policies. Each
+key is the namespaced name of a tag key, i.e. the ID of the organization or project
+that owns it followed by the short name of the key.
+
+  tags:
+    123456789/environment: production
+`,
+	"apis": `The apis field of the spec lists the APIs to enable on the project. Each entry is the
+full name of a service, such as "run.googleapis.com", or the short form "run", which
+is taken to mean the google API of that name. Spelling names out in full avoids
+ambiguity, and "gproj lint --fix" will do it for you.
+
+An entry can also name a bundle of APIs that are often used together, written either
+as "bundle:NAME" or as "bundle: NAME" on its own line. See "gproj explain bundles" for
+the bundles that are built in, and for how to define your own.
+
+APIs needed by other parts of the spec, such as the cloud run API for cloudRun, are
+enabled whether or not they are listed. APIs that are removed from the list are not
+disabled. To see which APIs are available, run
+
+  $ gproj apis
+
+  apis:
+    - bundle: web-app
+    - pubsub.googleapis.com
+`,
+	"bundles": `The bundles field of the spec defines bundles of APIs, which entries in the apis list
+can refer to by name alongside those built in to gproj. A bundle defined in the spec
+replaces a built in bundle of the same name. Bundles cannot refer to other bundles.
+
+  bundles:
+    backend: [run, sqladmin, secretmanager]
+
+  apis:
+    - bundle: backend
+    - bundle: observability
+
+The bundles built in to gproj are:
+`,
+	"parent": `The parent field of the spec is the organization or folder in which to create the
+project. Without it the project goes wherever google puts new projects for your
+account. The parent is only used when the project is created: gproj does not move
+existing projects.
+
+  parent: organizations/123456789012
+  parent: folders/987654321098
+`,
+	"groups": `The groups field of the spec includes groups of APIs and labels that are shared by
+the projects in a workspace, so that a workspace of many projects need not repeat the
+same list of APIs in every spec. Groups are defined in gproj-workspace.yaml, which
+gproj looks for in the directory of the spec and then in each directory above it, up
+to the root of the repository:
+
+  # gproj-workspace.yaml
+  groups:
+    backend:
+      apis: [bundle:web-app, pubsub.googleapis.com]
+      labels:
+        cost-center: eng-1234
+
+  # payments/googlecloudproject.yaml
+  groups: [backend]
+  apis: [bigquery.googleapis.com]
+  labels:
+    team: payments
+
+The APIs of each group are enabled along with those in the spec. Labels set in the spec
+win over labels from groups, and two groups may not give the same label different
+values. Unlike YAML anchors, groups work across files, and gproj rejects unknown
+groups and misspelled fields in gproj-workspace.yaml.
+`,
+	"iam": `The iam field of the spec lists the members to grant each role on the project.
+Members are written as gcloud writes them: user:EMAIL, group:EMAIL,
+serviceAccount:EMAIL, or domain:DOMAIN.
+
+Grants in the spec are added to the project's IAM policy; grants in the policy that
+are not in the spec are left alone.
+
+  iam:
+    roles/viewer: [group:eng@example.com]
+    roles/run.admin: [serviceAccount:deployer@ci-project.iam.gserviceaccount.com]
+`,
+	"budget": `The budget field of the spec creates a monthly budget for the project, which sends
+alerts to billing administrators as spend crosses each threshold. A budget needs the
+project to be linked to a billing account (see "gproj explain billing").
+
+  budget:
+    amount: 500          # in whole units of the currency
+    currency: USD        # default: the currency of the billing account
+    thresholds: [0.5, 0.9, 1.0]
+`,
+	"actAs": `The actAs field of the spec lists the members to allow to act as each service account
+in the project, such as a CI service account that deploys cloud run services running
+as another account. Each key is the part of the service account's email before the
+"@", and each member is granted the Service Account User role on that account.
+Keys for these accounts can be rotated with "gproj sa rotate-keys NAME".
+
+  actAs:
+    runtime: [serviceAccount:deployer@ci-project.iam.gserviceaccount.com]
+`,
+	"billingExport": `The billingExport field of the spec prepares a bigquery dataset in the project to
+receive the cost data of the project's billing account. gproj creates the dataset and
+allows cloud billing to write to it. Cloud billing has no API for turning the export
+on, so apply prints a link to the console page for that until data arrives.
+
+  billingExport:
+    dataset: billing_export  # the default
+    location: US             # the default
+`,
+	"dependsOn": `The dependsOn field of the spec lists the IDs of projects that "gproj workspace" must
+apply before this one, such as the host project of a shared VPC. It has no effect
+when the spec is applied on its own.
+
+  dependsOn: [acme-network-host]
+`,
+	"allowedMemberDomains": `The allowedMemberDomains field of the spec lists the domains to which IAM members in
+the spec must belong. Plan and apply refuse to grant roles to members from other
+domains. It is checked by gproj only; use allowedCustomerIDs to have google enforce
+it as well.
+
+  allowedMemberDomains: [example.com]
+`,
+	"allowedCustomerIDs": `The allowedCustomerIDs field of the spec sets the domain restricted sharing org policy
+on the project, so that google refuses to grant roles to members outside the given
+workspace customers. Find your customer ID in the admin console under account
+settings.
+
+  allowedCustomerIDs: [C0abc123]
+`,
+	"lifecycle": `The lifecycle field of the spec guards against unwanted changes to the project.
+
+  lifecycle:
+    preventDestroy: true
+        Refuse to delete or destroy the project.
+
+    ignoreChanges: [labels, billing]
+        Leave these fields alone once the project exists, for when they are managed
+        by something other than gproj.
+`,
+	"state": `The state field of the spec sets where gproj records the resources it creates, so that
+"gproj destroy" can remove them later. Without it nothing is recorded, and destroy refuses to
+delete anything but the project unless given --assume-owned.
+
+  state:
+    local: .gproj.state.json   # relative to the spec (the default)
+
+  state:
+    bucket: acme-gproj-state   # a GCS bucket, for sharing state between people
+    object: acme-billing-prod.gproj.state.json  # the default
+`,
+	"notes": `The notes field of the spec holds free-form notes for the people who own the project,
+such as the owning team or a link to a runbook. Notes are kept in the spec and in
+snapshots but are never sent to google.
+
+  notes:
+    owner: payments team
+    runbook: https://wiki.example.com/payments/runbook
+`,
+	"version": `The version field of the spec records which layout of the spec the file uses. The
+current version is 2. Specs without a version field are version 1.
+
+When a field is renamed or its meaning changes, the version goes up. Older specs
+keep working: gproj upgrades them in memory as it reads them and prints a warning
+for each change it made. To upgrade the file itself, run
+
+  $ gproj migrate-spec
+
+Changes from version 1 to version 2:
+
+  billing: enable  ->  billing: auto
+`,
+}
+
+func explain(ctx context.Context, args *args) error {
+	text, ok := explanations[args.Explain.Topic]
+	if !ok {
+		// spec fields are camel case, which is easy to get wrong on the command line
+		for topic := range explanations {
+			if strings.EqualFold(topic, args.Explain.Topic) {
+				text, ok = explanations[topic], true
+			}
+		}
+	}
+	if !ok {
+		var topics []string
+		for topic := range explanations {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+		return fmt.Errorf("no explanation for %q, topics are: %s", args.Explain.Topic, strings.Join(topics, ", "))
+	}
+	fmt.Print(text)
+	if strings.EqualFold(args.Explain.Topic, "bundles") {
+		for _, name := range (&ProjectSpec{}).bundleNames() {
+			fmt.Printf("\n  %s:\n", name)
+			for _, api := range builtinBundles[name] {
+				fmt.Printf("    %s\n", api)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// factoryRequest is the minimal spec that a team submits to have a project created by the
+// project factory. Everything else comes from the org-level template.
+type factoryRequest struct {
+	Name   string            // human readable name of the project
+	ID     string            // ID of the project
+	Owner  string            // email of the person responsible for the project, who is granted roles/owner
+	Labels map[string]string // labels in addition to those in the template
+	APIs   []string          // APIs in addition to the baseline APIs in the template
+}
+
+// load a factory request from a file
+func loadFactoryRequest(fsys filesystem, path string) (*factoryRequest, error) {
+	buf, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening project request: %w", err)
+	}
+
+	var req factoryRequest
+	err = yaml.UnmarshalStrict(buf, &req)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing project request at %s: %w", path, err)
+	}
+	if req.ID == "" || req.Name == "" || req.Owner == "" {
+		return nil, fmt.Errorf("%s must give the name, id, and owner of the project", path)
+	}
+	return &req, nil
+}
+
+// combine the org-level template with a request to get the spec for the project
+func factorySpec(template *ProjectSpec, req *factoryRequest, reqPath string) (*ProjectSpec, error) {
+	if template.ID != "" || template.Name != "" {
+		return nil, fmt.Errorf("%s is a template so must not give the name or id of a project", template.path)
+	}
+
+	spec := *template
+	spec.Name = req.Name
+	spec.ID = req.ID
+	spec.path = reqPath
+
+	// deep copy the maps and lists so that the template is not modified
+	spec.Labels = make(map[string]string)
+	for k, v := range template.Labels {
+		spec.Labels[k] = v
+	}
+	for k, v := range req.Labels {
+		spec.Labels[k] = v
+	}
+	spec.Labels["owner"] = sanitizeLabel(req.Owner)
+
+	spec.APIs = append(append([]string(nil), template.APIs...), req.APIs...)
+
+	spec.IAM = make(map[string][]string)
+	for role, members := range template.IAM {
+		spec.IAM[role] = append([]string(nil), members...)
+	}
+	spec.IAM["roles/owner"] = append(spec.IAM["roles/owner"], "user:"+req.Owner)
+
+	return &spec, nil
+}
+
+// factory creates a project from an org-level template and a minimal per-project request.
+// It is intended to be run by a central provisioning service that has permission to
+// create projects, so it never adopts an existing project.
+func factory(ctx context.Context, args *args) error {
+	template, err := loadProjectSpec(fsys, args.Factory.Template)
+	if err != nil {
+		return err
+	}
+
+	req, err := loadFactoryRequest(fsys, args.Factory.Request)
+	if err != nil {
+		return err
+	}
+
+	spec, err := factorySpec(template, req, args.Factory.Request)
+	if err != nil {
+		return err
+	}
+
+	args.Apply = &applyArgs{
+		Parallelism:   args.Factory.Parallelism,
+		Report:        args.Factory.Report,
+		NotifyWebhook: args.Factory.NotifyWebhook,
+	}
+	return applySpec(ctx, args, spec)
+}
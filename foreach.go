@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// outcome of running a command against one project in "gproj foreach"
+type foreachResult struct {
+	projectID string
+	output    string
+	err       error
+}
+
+// the last non-empty line of some output, for the summary table
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return lines[len(lines)-1]
+}
+
+// childArgs gets the global flags to pass on to gproj when foreach, workspace, or watch
+// run it for each project. The rate limits are divided between the given number of
+// children running at once.
+func childArgs(args *args, parallelism int) []string {
+	var out []string
+	if args.Verbose {
+		out = append(out, "--verbose")
+	}
+	if args.CI {
+		out = append(out, "--ci")
+	}
+	if args.RateLimit != "" {
+		limit, _ := divideRateLimits(args.RateLimit, parallelism)
+		out = append(out, "--rate-limit", limit)
+	}
+	if args.QuotaProject != "" {
+		out = append(out, "--quota-project", args.QuotaProject)
+	}
+	if args.EndpointOverrides != "" {
+		out = append(out, "--endpoint-overrides", args.EndpointOverrides)
+	}
+	if args.UserAgent != "" {
+		out = append(out, "--user-agent", args.UserAgent)
+	}
+	if args.Offline {
+		out = append(out, "--offline")
+	}
+	if args.Naming != "" {
+		out = append(out, "--naming", args.Naming)
+	}
+	if args.NoInput {
+		out = append(out, "--no-input")
+	}
+	return out
+}
+
+// run gproj with the given arguments against a single project, by running this same
+// executable with --project and the global flags that were given to foreach
+func runForProject(ctx context.Context, exe, projectID string, args *args, command []string) foreachResult {
+	cmdArgs := append([]string{"--project", projectID}, childArgs(args, args.Foreach.Parallelism)...)
+	cmdArgs = append(cmdArgs, command...)
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, exe, cmdArgs...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return foreachResult{projectID: projectID, output: out.String(), err: err}
+}
+
+func foreach(ctx context.Context, args *args) error {
+	if len(args.Foreach.Command) == 0 {
+		return fmt.Errorf("no command given, for example: gproj foreach --filter label=env:sandbox -- apis enable logging")
+	}
+	if args.Foreach.Command[0] == "foreach" {
+		return fmt.Errorf("foreach cannot be nested")
+	}
+
+	// the limits apply to all projects together, so check them before dividing them up
+	if _, err := parseRateLimits(args.RateLimit); err != nil {
+		return err
+	}
+	if args.Foreach.Parallelism < 1 {
+		args.Foreach.Parallelism = 1
+	}
+
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
+
+	projects, err := searchProjects(ctx, resources, projectFilter(args.Foreach.Filter))
+	if err != nil {
+		return err
+	}
+	if len(projects) == 0 {
+		fmt.Println("no matching projects")
+		return nil
+	}
+
+	// show what is about to happen and confirm, since this may touch many projects
+	command := strings.Join(args.Foreach.Command, " ")
+	fmt.Printf("will run \"gproj %s\" in %d projects:\n", command, len(projects))
+	for _, p := range projects {
+		fmt.Printf("  - %s\n", p.ProjectId)
+	}
+	if !args.Foreach.Yes {
+		ok, err := promptConfirm(args, "continue?", "--yes")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("not confirmed, not running anything")
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error finding the gproj executable: %w", err)
+	}
+
+	// run the command in each project, at most Parallelism at a time
+	parallelism := args.Foreach.Parallelism
+	sem := make(chan struct{}, parallelism)
+	results := make([]foreachResult, len(projects))
+	var wg sync.WaitGroup
+	for i, p := range projects {
+		wg.Add(1)
+		go func(i int, projectID string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = runForProject(ctx, exe, projectID, args, args.Foreach.Command)
+			fmt.Printf("finished %s\n", projectID)
+		}(i, p.ProjectId)
+	}
+	wg.Wait()
+
+	// print the full output of failures, then a summary of everything
+	var failed int
+	for _, r := range results {
+		if r.err != nil {
+			failed++
+			fmt.Printf("\n%s failed:\n%s", r.projectID, r.output)
+		}
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tRESULT\tOUTPUT")
+	for _, r := range results {
+		result := "ok"
+		if r.err != nil {
+			result = "failed"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.projectID, result, lastLine(r.output))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%q failed in %d of %d projects", command, failed, len(projects))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChildArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		args        args
+		parallelism int
+		want        []string
+	}{
+		{
+			name:        "none",
+			parallelism: 4,
+		},
+		{
+			name:        "switches",
+			args:        args{Verbose: true, CI: true, Offline: true, NoInput: true},
+			parallelism: 1,
+			want:        []string{"--verbose", "--ci", "--offline", "--no-input"},
+		},
+		{
+			name: "values",
+			args: args{
+				QuotaProject:      "billing-host",
+				EndpointOverrides: "cloudresourcemanager=http://localhost:8080",
+				UserAgent:         "acme-ci/1.0",
+				Naming:            "naming.yaml",
+			},
+			parallelism: 1,
+			want: []string{
+				"--quota-project", "billing-host",
+				"--endpoint-overrides", "cloudresourcemanager=http://localhost:8080",
+				"--user-agent", "acme-ci/1.0",
+				"--naming", "naming.yaml",
+			},
+		},
+		{
+			name:        "rate limits are shared between children",
+			args:        args{RateLimit: "cloudresourcemanager=2"},
+			parallelism: 4,
+			want:        []string{"--rate-limit", "cloudresourcemanager.googleapis.com=0.5"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := childArgs(&test.args, test.parallelism)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// permissions for the files and directories that gproj creates. On windows these are
+// mostly ignored, but unlike os.ModePerm they do not make files world-writable on unix.
+const (
+	dirPerm         os.FileMode = 0755
+	filePerm        os.FileMode = 0644
+	privateFilePerm os.FileMode = 0600 // for files that may contain secrets, such as decrypted specs
+)
+
+// filesystem is the set of filesystem operations used by gproj, abstracted so that the
+// path handling for spec discovery, caching, and state can be exercised without touching
+// the real filesystem
+type filesystem interface {
+	Getwd() (string, error)
+	UserCacheDir() (string, error)
+	Stat(path string) (os.FileInfo, error)
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	Chmod(path string, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	Remove(path string) error
+	ReadDir(path string) ([]os.DirEntry, error)
+	EvalSymlinks(path string) (string, error)
+}
+
+// osFilesystem is the real filesystem
+type osFilesystem struct{}
+
+func (osFilesystem) Getwd() (string, error)                { return os.Getwd() }
+func (osFilesystem) UserCacheDir() (string, error)         { return os.UserCacheDir() }
+func (osFilesystem) Stat(path string) (os.FileInfo, error) { return os.Stat(path) }
+func (osFilesystem) ReadFile(path string) ([]byte, error)  { return os.ReadFile(path) }
+func (osFilesystem) EvalSymlinks(path string) (string, error) {
+	return filepath.EvalSymlinks(path)
+}
+func (osFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+func (osFilesystem) Remove(path string) error { return os.Remove(path) }
+func (osFilesystem) ReadDir(path string) ([]os.DirEntry, error) {
+	return os.ReadDir(path)
+}
+func (osFilesystem) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(path, data, perm)
+}
+func (osFilesystem) Chmod(path string, perm os.FileMode) error {
+	return os.Chmod(path, perm)
+}
+
+// fsys is the filesystem used throughout gproj
+var fsys filesystem = osFilesystem{}
+
+// write a file that only the current user may read. WriteFile sets the permissions only
+// when it creates the file, so those of an existing file are tightened as well.
+func writePrivateFile(fsys filesystem, path string, data []byte) error {
+	if err := fsys.WriteFile(path, data, privateFilePerm); err != nil {
+		return err
+	}
+	return fsys.Chmod(path, privateFilePerm)
+}
+
+// find the gcloud executable. On windows the gcloud SDK installs gcloud.cmd, which is
+// found via PATHEXT in most cases, but we look for it explicitly in case PATHEXT has
+// been customized.
+func findGcloud() (string, error) {
+	return findGcloudWith(runtime.GOOS, exec.LookPath)
+}
+
+// find the gcloud executable for the given operating system using lookPath
+func findGcloudWith(goos string, lookPath func(string) (string, error)) (string, error) {
+	if goos == "windows" {
+		if path, err := lookPath("gcloud.cmd"); err == nil {
+			return path, nil
+		}
+	}
+	return lookPath("gcloud")
+}
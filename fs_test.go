@@ -0,0 +1,255 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEntry is a file, directory, or symlink in a fakeFilesystem
+type fakeEntry struct {
+	dir  bool
+	data []byte
+	link string // target of a symlink, relative to the directory containing it unless absolute
+	perm os.FileMode
+}
+
+// fakeFilesystem is an in-memory filesystem for tests. Paths are cleaned with filepath, so
+// tests may use windows paths when run on windows.
+type fakeFilesystem struct {
+	cwd      string
+	cacheDir string
+	entries  map[string]*fakeEntry
+}
+
+func newFakeFilesystem(cwd string) *fakeFilesystem {
+	f := &fakeFilesystem{
+		cwd:      filepath.Clean(cwd),
+		cacheDir: filepath.Join(filepath.VolumeName(cwd)+string(filepath.Separator), "cache"),
+		entries:  make(map[string]*fakeEntry),
+	}
+	f.MkdirAll(f.cwd, dirPerm)
+	return f
+}
+
+// add a file, creating its parent directories
+func (f *fakeFilesystem) file(path, content string) {
+	f.WriteFile(path, []byte(content), filePerm)
+}
+
+// add a directory and its parents
+func (f *fakeFilesystem) dir(path string) {
+	f.MkdirAll(path, dirPerm)
+}
+
+// add a symlink, creating its parent directories
+func (f *fakeFilesystem) symlink(path, target string) {
+	f.MkdirAll(filepath.Dir(path), dirPerm)
+	f.entries[filepath.Clean(path)] = &fakeEntry{link: target}
+}
+
+// resolve the symlinks in every component of a path
+func (f *fakeFilesystem) resolve(path string) (string, error) {
+	path = filepath.Clean(path)
+	vol := filepath.VolumeName(path)
+	rest := strings.TrimPrefix(path[len(vol):], string(filepath.Separator))
+	out := vol + string(filepath.Separator)
+	if rest == "" {
+		return out, nil
+	}
+	for hops, parts := 0, strings.Split(rest, string(filepath.Separator)); len(parts) > 0; {
+		out = filepath.Join(out, parts[0])
+		parts = parts[1:]
+		e, ok := f.entries[out]
+		if !ok {
+			return "", &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+		}
+		if e.link == "" {
+			continue
+		}
+		if hops++; hops > 40 {
+			return "", &fs.PathError{Op: "stat", Path: path, Err: errors.New("too many levels of symbolic links")}
+		}
+		target := e.link
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(out), target)
+		}
+		resolved, err := f.resolve(target)
+		if err != nil {
+			return "", err
+		}
+		out = resolved
+	}
+	return out, nil
+}
+
+func (f *fakeFilesystem) Getwd() (string, error)        { return f.cwd, nil }
+func (f *fakeFilesystem) UserCacheDir() (string, error) { return f.cacheDir, nil }
+
+func (f *fakeFilesystem) Stat(path string) (os.FileInfo, error) {
+	resolved, err := f.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.info(resolved), nil
+}
+
+func (f *fakeFilesystem) info(path string) *fakeInfo {
+	e, ok := f.entries[path]
+	if !ok {
+		// the root of a volume
+		return &fakeInfo{name: path, entry: &fakeEntry{dir: true, perm: dirPerm}}
+	}
+	return &fakeInfo{name: filepath.Base(path), entry: e}
+}
+
+func (f *fakeFilesystem) ReadFile(path string) ([]byte, error) {
+	resolved, err := f.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	e := f.entries[resolved]
+	if e == nil || e.dir {
+		return nil, &fs.PathError{Op: "read", Path: path, Err: errors.New("is a directory")}
+	}
+	return append([]byte(nil), e.data...), nil
+}
+
+func (f *fakeFilesystem) WriteFile(path string, data []byte, perm os.FileMode) error {
+	path = filepath.Clean(path)
+	f.MkdirAll(filepath.Dir(path), dirPerm)
+	if e, ok := f.entries[path]; ok && !e.dir {
+		// like os.WriteFile, keep the permissions of an existing file
+		e.data = append([]byte(nil), data...)
+		return nil
+	}
+	f.entries[path] = &fakeEntry{data: append([]byte(nil), data...), perm: perm}
+	return nil
+}
+
+func (f *fakeFilesystem) Chmod(path string, perm os.FileMode) error {
+	resolved, err := f.resolve(path)
+	if err != nil {
+		return err
+	}
+	f.entries[resolved].perm = perm
+	return nil
+}
+
+func (f *fakeFilesystem) MkdirAll(path string, perm os.FileMode) error {
+	path = filepath.Clean(path)
+	for {
+		if filepath.Dir(path) == path {
+			return nil
+		}
+		if _, ok := f.entries[path]; !ok {
+			f.entries[path] = &fakeEntry{dir: true, perm: perm}
+		}
+		path = filepath.Dir(path)
+	}
+}
+
+func (f *fakeFilesystem) Remove(path string) error {
+	path = filepath.Clean(path)
+	if _, ok := f.entries[path]; !ok {
+		return &fs.PathError{Op: "remove", Path: path, Err: fs.ErrNotExist}
+	}
+	delete(f.entries, path)
+	return nil
+}
+
+func (f *fakeFilesystem) ReadDir(path string) ([]os.DirEntry, error) {
+	resolved, err := f.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	var out []os.DirEntry
+	for p := range f.entries {
+		if filepath.Dir(p) == resolved && p != resolved {
+			out = append(out, fs.FileInfoToDirEntry(f.info(p)))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
+
+func (f *fakeFilesystem) EvalSymlinks(path string) (string, error) {
+	return f.resolve(path)
+}
+
+// fakeInfo describes an entry in a fakeFilesystem
+type fakeInfo struct {
+	name  string
+	entry *fakeEntry
+}
+
+func (i *fakeInfo) Name() string       { return i.name }
+func (i *fakeInfo) Size() int64        { return int64(len(i.entry.data)) }
+func (i *fakeInfo) ModTime() time.Time { return time.Time{} }
+func (i *fakeInfo) IsDir() bool        { return i.entry.dir }
+func (i *fakeInfo) Sys() interface{}   { return nil }
+func (i *fakeInfo) Mode() os.FileMode {
+	if i.entry.dir {
+		return os.ModeDir | i.entry.perm
+	}
+	return i.entry.perm
+}
+
+func TestFindGcloudWith(t *testing.T) {
+	tests := []struct {
+		name   string
+		goos   string
+		onPath []string
+		want   string
+	}{
+		{"linux", "linux", []string{"gcloud"}, "/sdk/gcloud"},
+		{"windows prefers gcloud.cmd", "windows", []string{"gcloud", "gcloud.cmd"}, "/sdk/gcloud.cmd"},
+		{"windows falls back to gcloud", "windows", []string{"gcloud"}, "/sdk/gcloud"},
+		{"linux ignores gcloud.cmd", "linux", []string{"gcloud.cmd"}, ""},
+		{"missing", "windows", nil, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lookPath := func(name string) (string, error) {
+				if contains(test.onPath, name) {
+					return "/sdk/" + name, nil
+				}
+				return "", errors.New("not found")
+			}
+			got, err := findGcloudWith(test.goos, lookPath)
+			if test.want == "" {
+				if err == nil {
+					t.Errorf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWritePrivateFile(t *testing.T) {
+	f := newFakeFilesystem("/work")
+	f.file("/work/plan.json", "old")
+	err := writePrivateFile(f, "/work/plan.json", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := f.Stat("/work/plan.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != privateFilePerm {
+		t.Errorf("got permissions %v, want %v", st.Mode().Perm(), privateFilePerm)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// ciConfig parameterizes the generated pipelines
+type ciConfig struct {
+	ProjectID      string
+	SpecPath       string // path to the spec relative to the root of the repository
+	Branch         string // branch on which merges are applied
+	Provider       string // workload identity provider, for GitHub Actions
+	ServiceAccount string // service account that plan and apply run as
+	Version        string // version of gproj to install
+	Encrypted      bool   // whether the spec is encrypted with sops
+}
+
+// GitHub Actions workflow that plans on pull requests and applies on merge
+var githubWorkflow = template.Must(template.New("github").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(
+	`# Generated by gproj generate ci. Plans {{.ProjectID}} on pull requests and applies it on merge.
+name: gproj {{.ProjectID}}
+on:
+  pull_request:
+    paths: [{{quote .SpecPath}}]
+  push:
+    branches: [{{quote .Branch}}]
+    paths: [{{quote .SpecPath}}]
+permissions:
+  contents: read
+  id-token: write
+concurrency:
+  group: gproj-{{.ProjectID}}
+  cancel-in-progress: false
+jobs:
+  gproj:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      - uses: google-github-actions/auth@v2
+        with:
+          workload_identity_provider: {{quote .Provider}}
+          service_account: {{quote .ServiceAccount}}
+      - uses: actions/setup-go@v5
+        with:
+          go-version: stable
+      - run: go install github.com/alexflint/gproj@{{.Version}}
+{{- if .Encrypted}}
+      - run: go install github.com/getsops/sops/v3/cmd/sops@latest
+{{- end}}
+      - name: plan
+        if: github.event_name == 'pull_request'
+        run: gproj --ci --spec {{quote .SpecPath}} plan
+      - name: apply
+        if: github.event_name == 'push'
+        run: gproj --ci --spec {{quote .SpecPath}} apply
+`))
+
+// Cloud Build config that plans on pull requests and applies on merge. It is meant for two
+// triggers, one on pull requests and one on pushes to the branch, and tells them apart by
+// the _PR_NUMBER substitution that Cloud Build sets only for pull requests.
+var cloudBuildConfig = template.Must(template.New("cloudbuild").Funcs(template.FuncMap{"quote": strconv.Quote}).Parse(
+	`# Generated by gproj generate ci. Plans {{.ProjectID}} on pull requests and applies it on
+# merge; create a pull request trigger and a trigger on pushes to {{.Branch}} that both use
+# this file, with {{.SpecPath}} as the included files filter.
+steps:
+  - name: golang
+    entrypoint: bash
+    args:
+      - -c
+      - |
+        set -e
+        go install github.com/alexflint/gproj@{{.Version}}
+{{- if .Encrypted}}
+        go install github.com/getsops/sops/v3/cmd/sops@latest
+{{- end}}
+        if [ -n "$_PR_NUMBER" ]; then
+          gproj --ci --spec {{quote .SpecPath}} plan
+        else
+          gproj --ci --spec {{quote .SpecPath}} apply
+        fi
+{{- if .ServiceAccount}}
+serviceAccount: {{quote (printf "projects/-/serviceAccounts/%s" .ServiceAccount)}}
+{{- end}}
+options:
+  logging: CLOUD_LOGGING_ONLY
+  substitutionOption: ALLOW_LOOSE
+`))
+
+// where each kind of pipeline is written by default, relative to the root of the repository
+var ciDefaultPaths = map[string]string{
+	"github":     filepath.Join(".github", "workflows", "gproj.yml"),
+	"cloudbuild": "cloudbuild.yaml",
+}
+
+// generateCI writes a pipeline that runs gproj plan on pull requests and gproj apply on merge
+func generateCI(ctx context.Context, args *args) error {
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+	if spec.path == "" {
+		return errors.New("gproj generate ci needs a spec, not --project")
+	}
+
+	a := args.Generate.CI
+	tmpl := githubWorkflow
+	switch a.Provider {
+	case "github":
+		if a.WorkloadIdentityProvider == "" || a.ServiceAccount == "" {
+			return errors.New("GitHub Actions authenticates with workload identity: give --workload-identity-provider and --service-account")
+		}
+	case "cloudbuild":
+		tmpl = cloudBuildConfig
+	default:
+		return fmt.Errorf("unknown CI provider %q, expected \"github\" or \"cloudbuild\"", a.Provider)
+	}
+
+	// paths in the pipeline are relative to the root of the repository
+	specPath, err := filepath.Abs(spec.path)
+	if err != nil {
+		return err
+	}
+	root, err := gitOutput(filepath.Dir(specPath), "rev-parse", "--show-toplevel")
+	if err != nil {
+		return fmt.Errorf("%s is not in a git repository", spec.path)
+	}
+	rel, err := filepath.Rel(root, specPath)
+	if err != nil {
+		return err
+	}
+
+	// pin the version of gproj that generated the pipeline, unless it is a development build
+	ver := "latest"
+	if strings.HasPrefix(version, "v") {
+		ver = version
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, ciConfig{
+		ProjectID:      spec.ID,
+		SpecPath:       filepath.ToSlash(rel),
+		Branch:         a.Branch,
+		Provider:       a.WorkloadIdentityProvider,
+		ServiceAccount: a.ServiceAccount,
+		Version:        ver,
+		Encrypted:      spec.encrypted,
+	})
+	if err != nil {
+		return fmt.Errorf("error generating pipeline: %w", err)
+	}
+
+	if a.Out == "-" {
+		fmt.Print(buf.String())
+		return nil
+	}
+	out := a.Out
+	if out == "" {
+		out = filepath.Join(root, ciDefaultPaths[a.Provider])
+	}
+	if _, err := os.Stat(out); err == nil && !a.Force {
+		return fmt.Errorf("%s already exists; use --force to overwrite it", out)
+	}
+	err = fsys.MkdirAll(filepath.Dir(out), dirPerm)
+	if err != nil {
+		return fmt.Errorf("error creating directory for %s: %w", out, err)
+	}
+	err = fsys.WriteFile(out, buf.Bytes(), filePerm)
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", out, err)
+	}
+	fmt.Printf("wrote %s\n", out)
+	return nil
+}
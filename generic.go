@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+)
+
+// GenericResource models an entry in the "resources" section of googlecloudproject.yaml,
+// which creates a resource of any type that has a google API, for resources that gproj
+// has no dedicated support for. The resource is created with the given API method if it
+// does not already exist, and is otherwise left alone. Resources that gproj creates are
+// recorded in the state, and are deleted by destroy with the delete method that goes with
+// the create method.
+//
+// The string "{project}" is replaced with the project ID in the name, params, and body.
+type GenericResource struct {
+	Name    string            // full name of the resource, e.g. "projects/{project}/locations/us-central1/instances/cache"
+	API     string            // e.g. "redis.googleapis.com"
+	Version string            // version of the API, e.g. "v1"
+	Method  string            // discovery ID of the method that creates the resource, e.g. "redis.projects.locations.instances.create"
+	Params  map[string]string // parameters of the create method, e.g. parent: projects/{project}/locations/us-central1
+	Body    requestBody       `json:",omitempty"` // request body of the create method
+}
+
+// requestBody is a JSON object given in YAML
+type requestBody map[string]interface{}
+
+// UnmarshalYAML converts the maps that the YAML decoder produces, which have keys of any
+// type, to maps with string keys so that the body can be marshalled to JSON
+func (b *requestBody) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw map[string]interface{}
+	err := unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+	out := make(requestBody)
+	for k, v := range raw {
+		out[k] = jsonCompatible(v)
+	}
+	*b = out
+	return nil
+}
+
+// convert a value decoded from YAML to one that can be marshalled to JSON
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{})
+		for k, item := range v {
+			out[fmt.Sprint(k)] = jsonCompatible(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = jsonCompatible(item)
+		}
+		return out
+	}
+	return v
+}
+
+// discoveryDoc is the part of an API discovery document that gproj needs to call a method
+type discoveryDoc struct {
+	RootURL     string                       `json:"rootUrl"`
+	ServicePath string                       `json:"servicePath"`
+	Resources   map[string]discoveryResource `json:"resources"`
+}
+
+type discoveryResource struct {
+	Methods   map[string]discoveryMethod   `json:"methods"`
+	Resources map[string]discoveryResource `json:"resources"`
+}
+
+type discoveryMethod struct {
+	ID         string `json:"id"`
+	Path       string `json:"path"`
+	HTTPMethod string `json:"httpMethod"`
+	Parameters map[string]struct {
+		Location string `json:"location"` // "path" or "query"
+	} `json:"parameters"`
+}
+
+// find a method by its discovery ID, e.g. "redis.projects.locations.instances.create"
+func (d *discoveryDoc) method(id string) (*discoveryMethod, bool) {
+	var find func(resources map[string]discoveryResource) (*discoveryMethod, bool)
+	find = func(resources map[string]discoveryResource) (*discoveryMethod, bool) {
+		for _, r := range resources {
+			for _, m := range r.Methods {
+				if m.ID == id {
+					m := m
+					return &m, true
+				}
+			}
+			if m, ok := find(r.Resources); ok {
+				return m, true
+			}
+		}
+		return nil, false
+	}
+	return find(d.Resources)
+}
+
+// find the method that gets the operations returned by a method. Operations usually sit
+// alongside the collection that the method acts on, such as
+// redis.projects.locations.operations for redis.projects.locations.instances.create, so
+// that is looked at first, followed by each level further up.
+func (d *discoveryDoc) operationsGetMethod(methodID string) (*discoveryMethod, bool) {
+	parts := strings.Split(methodID, ".")
+	for n := len(parts) - 2; n >= 1; n-- {
+		if m, ok := d.method(strings.Join(parts[:n], ".") + ".operations.get"); ok {
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// build the URL for a call to a method. Path parameters of the form {+name} may contain
+// slashes; those of the form {name} are escaped. Parameters that the method does not take
+// in its path are added to the query string.
+func (d *discoveryDoc) url(m *discoveryMethod, params map[string]string) (string, error) {
+	p := m.Path
+	query := url.Values{}
+	for name, value := range params {
+		switch {
+		case strings.Contains(p, "{+"+name+"}"):
+			p = strings.ReplaceAll(p, "{+"+name+"}", value)
+		case strings.Contains(p, "{"+name+"}"):
+			p = strings.ReplaceAll(p, "{"+name+"}", url.PathEscape(value))
+		default:
+			query.Set(name, value)
+		}
+	}
+	if strings.Contains(p, "{") {
+		return "", fmt.Errorf("missing parameters for %s in %s", m.ID, p)
+	}
+	u := d.RootURL + d.ServicePath + p
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u, nil
+}
+
+// discovery documents fetched so far, keyed by API and version
+var (
+	discoveryDocs   = make(map[string]*discoveryDoc)
+	discoveryDocsMu sync.Mutex
+)
+
+// fetch the discovery document for an API, which describes the methods it provides
+func fetchDiscoveryDoc(ctx context.Context, conn *connection, api, version string) (*discoveryDoc, error) {
+	key := api + "/" + version
+	discoveryDocsMu.Lock()
+	doc, ok := discoveryDocs[key]
+	discoveryDocsMu.Unlock()
+	if ok {
+		return doc, nil
+	}
+
+	// the lock is not held while fetching so that resources of different APIs are not held
+	// up by each other; resources of the same API may each fetch the document, which is harmless
+	doc = new(discoveryDoc)
+	u := fmt.Sprintf("https://%s/$discovery/rest?version=%s", api, url.QueryEscape(version))
+	err := callJSON(ctx, conn, http.MethodGet, u, nil, doc)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the discovery document for %s %s: %w", api, version, err)
+	}
+	discoveryDocsMu.Lock()
+	discoveryDocs[key] = doc
+	discoveryDocsMu.Unlock()
+	return doc, nil
+}
+
+// get the ID of the method that gets a resource from the ID of the method that creates it,
+// e.g. "redis.projects.locations.instances.get" for "redis.projects.locations.instances.create"
+func getMethodID(createID string) string {
+	return siblingMethodID(createID, "get")
+}
+
+// get the ID of another method on the same resource as the given method
+func siblingMethodID(id, verb string) string {
+	return strings.TrimSuffix(id, path.Ext(id)) + "." + verb
+}
+
+// work out the path parameters with which to call a method that acts on an existing
+// resource. REST-style APIs identify the resource by its full name; others, such as
+// compute, by the last part of it alongside parameters such as the project and zone.
+func resourceParams(m *discoveryMethod, params map[string]string, name string) map[string]string {
+	out := make(map[string]string)
+	for param, p := range m.Parameters {
+		switch {
+		case p.Location != "path":
+		case params[param] != "":
+			out[param] = params[param]
+		case param == "name":
+			out[param] = name
+		default:
+			out[param] = path.Base(name)
+		}
+	}
+	return out
+}
+
+// wait for the operation returned by the method with the given ID, if any. Operations come
+// in two styles: google.longrunning operations have a "done" field and are fetched by name,
+// while compute-style operations have a "status" field and are fetched by their self link.
+func waitForGenericOperation(ctx context.Context, conn *connection, doc *discoveryDoc, methodID string, op map[string]interface{}) error {
+	check := func() (bool, error) {
+		if e, ok := op["error"]; ok && e != nil {
+			return false, operationErrorFromJSON(e)
+		}
+		if done, ok := op["done"].(bool); ok {
+			return done, nil
+		}
+		if status, ok := op["status"].(string); ok {
+			return status == "DONE", nil
+		}
+		// a response without either field is the resource itself rather than an operation
+		return true, nil
+	}
+	if done, err := check(); done || err != nil {
+		return err
+	}
+
+	name, _ := op["name"].(string)
+	u, _ := op["selfLink"].(string)
+	if u == "" {
+		get, ok := doc.operationsGetMethod(methodID)
+		if !ok {
+			return fmt.Errorf("cannot wait for operation %s because the API has no method to get operations", name)
+		}
+		var err error
+		u, err = doc.url(get, map[string]string{"name": name})
+		if err != nil {
+			return err
+		}
+	}
+
+	return poll(ctx, "operation "+name, func() (bool, error) {
+		op = make(map[string]interface{})
+		err := callJSON(ctx, conn, http.MethodGet, u, nil, &op)
+		if err != nil {
+			return false, fmt.Errorf("error getting operation info: %w", err)
+		}
+		return check()
+	})
+}
+
+// replace "{project}" with the project ID
+func expandProject(s, projectID string) string {
+	return strings.ReplaceAll(s, "{project}", projectID)
+}
+
+// create a generic resource if it does not already exist, and report whether it was created.
+// Existence is checked with the get method that goes with the create method, whose path
+// parameters are taken from the params in the spec, except for the one that identifies the
+// resource itself, which is taken from the name in the spec.
+func applyGenericResource(ctx context.Context, conn *connection, projectID string, r GenericResource, state *State) (bool, error) {
+	if r.Name == "" || r.API == "" || r.Version == "" || r.Method == "" {
+		return false, fmt.Errorf("resources must have a name, api, version, and method")
+	}
+	name := expandProject(r.Name, projectID)
+	params := make(map[string]string)
+	for k, v := range r.Params {
+		params[k] = expandProject(v, projectID)
+	}
+
+	doc, err := fetchDiscoveryDoc(ctx, conn, r.API, r.Version)
+	if err != nil {
+		return false, err
+	}
+	create, ok := doc.method(r.Method)
+	if !ok {
+		return false, fmt.Errorf("%s %s has no method %s", r.API, r.Version, r.Method)
+	}
+	get, ok := doc.method(getMethodID(r.Method))
+	if !ok {
+		return false, fmt.Errorf("%s %s has no method %s with which to check whether %s exists", r.API, r.Version, getMethodID(r.Method), name)
+	}
+
+	getURL, err := doc.url(get, resourceParams(get, params, name))
+	if err != nil {
+		return false, err
+	}
+	err = callJSON(ctx, conn, http.MethodGet, getURL, nil, nil)
+	if err == nil {
+		return false, nil
+	}
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != 404 {
+		return false, fmt.Errorf("error getting %s: %w", name, err)
+	}
+
+	createURL, err := doc.url(create, params)
+	if err != nil {
+		return false, err
+	}
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return false, fmt.Errorf("error marshalling the body for %s: %w", name, err)
+	}
+
+	fmt.Printf("creating %s\n", name)
+	var op map[string]interface{}
+	err = callJSON(ctx, conn, create.HTTPMethod, createURL, json.RawMessage(expandProject(string(body), projectID)), &op)
+	if err != nil {
+		return false, fmt.Errorf("error creating %s: %w", name, err)
+	}
+	if opName, ok := op["name"].(string); ok {
+		noteOperation(ctx, opName)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Minute) // some resources take many minutes to create
+	defer cancel()
+	err = waitForGenericOperation(waitCtx, conn, doc, r.Method, op)
+	if err != nil {
+		return false, fmt.Errorf("error creating %s: %w", name, err)
+	}
+	state.RecordGeneric(name, &GenericResource{
+		Name:    name,
+		API:     r.API,
+		Version: r.Version,
+		Method:  r.Method,
+		Params:  params,
+	})
+	noteChange(ctx, "", name)
+	return true, nil
+}
+
+// deleteGenericResource deletes a resource that was created by applyGenericResource,
+// using the delete method that goes with the method that created it. The name and params
+// must already have "{project}" replaced. Buckets that still contain objects cannot be
+// deleted unless forceEmpty is true, in which case their objects are deleted first.
+func deleteGenericResource(ctx context.Context, conn *connection, r *GenericResource, forceEmpty bool) error {
+	doc, err := fetchDiscoveryDoc(ctx, conn, r.API, r.Version)
+	if err != nil {
+		return err
+	}
+	del, ok := doc.method(siblingMethodID(r.Method, "delete"))
+	if !ok {
+		return fmt.Errorf("%s %s has no method %s with which to delete %s", r.API, r.Version, siblingMethodID(r.Method, "delete"), r.Name)
+	}
+	params := resourceParams(del, r.Params, r.Name)
+	u, err := doc.url(del, params)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == "storage.buckets.insert" && forceEmpty {
+		err = emptyBucket(ctx, conn, params["bucket"])
+		if err != nil {
+			return err
+		}
+	}
+
+	var op map[string]interface{}
+	err = callJSON(ctx, conn, del.HTTPMethod, u, nil, &op)
+	if e, ok := err.(*googleapi.Error); ok && e.Code == 404 {
+		return nil
+	}
+	if e, ok := err.(*googleapi.Error); ok && e.Code == 409 && r.Method == "storage.buckets.insert" {
+		return fmt.Errorf("bucket %s is not empty; run with --force-empty to delete its objects as well", params["bucket"])
+	}
+	if errors.Is(err, io.EOF) {
+		return nil // many delete methods respond with an empty body
+	}
+	if err != nil {
+		return err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Minute)
+	defer cancel()
+	return waitForGenericOperation(waitCtx, conn, doc, del.ID, op)
+}
+
+// delete every object in a bucket, including noncurrent versions
+func emptyBucket(ctx context.Context, conn *connection, bucket string) error {
+	svc, err := storage.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the storage API: %w", err)
+	}
+	var n int
+	err = svc.Objects.List(bucket).Versions(true).Pages(ctx, func(resp *storage.Objects) error {
+		for _, obj := range resp.Items {
+			err := svc.Objects.Delete(bucket, obj.Name).Generation(obj.Generation).Context(ctx).Do()
+			if e, ok := err.(*googleapi.Error); ok && e.Code == 404 {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("error deleting gs://%s/%s: %w", bucket, obj.Name, err)
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error emptying bucket %s: %w", bucket, err)
+	}
+	fmt.Printf("deleted %d objects from bucket %s\n", n, bucket)
+	return nil
+}
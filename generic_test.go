@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// a discovery document with operations at two levels, as in APIs that have both global
+// and regional operations
+const testDiscoveryDoc = `{
+  "rootUrl": "https://example.googleapis.com/",
+  "servicePath": "",
+  "resources": {
+    "projects": {
+      "resources": {
+        "operations": {"methods": {"get": {"id": "example.projects.operations.get", "path": "v1/{+name}"}}},
+        "locations": {
+          "resources": {
+            "operations": {"methods": {"get": {"id": "example.projects.locations.operations.get", "path": "v1/{+name}"}}},
+            "instances": {
+              "methods": {
+                "create": {"id": "example.projects.locations.instances.create", "path": "v1/{+parent}/instances"},
+                "get": {"id": "example.projects.locations.instances.get", "path": "v1/{+name}"}
+              }
+            }
+          }
+        },
+        "buckets": {
+          "methods": {"create": {"id": "example.projects.buckets.create", "path": "v1/{+parent}/buckets"}}
+        }
+      }
+    }
+  }
+}`
+
+func TestDiscoveryMethods(t *testing.T) {
+	var doc discoveryDoc
+	if err := json.Unmarshal([]byte(testDiscoveryDoc), &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if m, ok := doc.method("example.projects.locations.instances.get"); !ok || m.Path != "v1/{+name}" {
+		t.Errorf("got %v, %v", m, ok)
+	}
+	if _, ok := doc.method("instances.get"); ok {
+		t.Error("a suffix of a method ID should not match")
+	}
+
+	// repeat since map iteration order varies from run to run
+	for i := 0; i < 20; i++ {
+		tests := map[string]string{
+			"example.projects.locations.instances.create": "example.projects.locations.operations.get",
+			"example.projects.buckets.create":             "example.projects.operations.get",
+		}
+		for create, want := range tests {
+			m, ok := doc.operationsGetMethod(create)
+			if !ok {
+				t.Fatalf("no operations method for %s", create)
+			}
+			if m.ID != want {
+				t.Fatalf("got %s for %s, want %s", m.ID, create, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// label in which apply records the git commit of the spec
+const specRevLabel = "gproj-spec-rev"
+
+// run git in a directory and return its trimmed output
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// specRevision gets the last commit that changed the spec, and whether the spec has
+// uncommitted changes. Commits that do not touch the spec are ignored so that, in a
+// repository holding many specs, one project is not relabelled when another changes. The
+// revision is empty if the spec is not in a git repository, has never been committed, or
+// git is not installed.
+func specRevision(path string) (rev string, dirty bool) {
+	if path == "" {
+		return "", false
+	}
+
+	dir, file := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	rev, err := gitOutput(dir, "log", "-1", "--format=%h", "--abbrev=12", "--", file)
+	if err != nil || rev == "" {
+		return "", false
+	}
+
+	status, err := gitOutput(dir, "status", "--porcelain", "--", file)
+	if err != nil {
+		return rev, false
+	}
+	return rev, status != ""
+}
+
+// blameLine is a line of the spec annotated with the commit that last changed it
+type blameLine struct {
+	Commit string
+	Author string
+	Time   time.Time
+	Text   string
+}
+
+// run git blame on a file and parse the output
+func gitBlame(path string) ([]blameLine, error) {
+	dir, file := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	out, err := gitOutput(dir, "blame", "--line-porcelain", "--", file)
+	if err != nil {
+		return nil, fmt.Errorf("error running git blame on %s: %w", path, err)
+	}
+
+	// each line of the file is preceded by a header in which the first line starts with
+	// the commit and later lines are "key value" pairs, and the line itself starts with a tab
+	var lines []blameLine
+	var cur blameLine
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "\t"):
+			cur.Text = line[1:]
+			lines = append(lines, cur)
+			cur = blameLine{}
+		case cur.Commit == "":
+			cur.Commit = strings.Fields(line)[0]
+		case strings.HasPrefix(line, "author "):
+			cur.Author = strings.TrimPrefix(line, "author ")
+		case strings.HasPrefix(line, "author-time "):
+			secs, _ := strconv.ParseInt(strings.TrimPrefix(line, "author-time "), 10, 64)
+			cur.Time = time.Unix(secs, 0)
+		}
+	}
+	return lines, nil
+}
+
+// split a line of YAML of the form "key: value" into key and value
+func splitYAMLKey(s string) (string, string, bool) {
+	pos := strings.Index(s, ":")
+	if pos <= 0 || (pos+1 < len(s) && s[pos+1] != ' ') {
+		return "", "", false
+	}
+	return strings.TrimSpace(s[:pos]), strings.TrimSpace(s[pos+1:]), true
+}
+
+// yamlFieldPaths works out the spec field that each line of a YAML document belongs to,
+// e.g. "labels.team" or "cloudrun[api].image", using indentation. Lists of strings are
+// keyed by value and lists of objects by their name key, as in "gproj diff". The path is
+// empty for blank lines and comments.
+func yamlFieldPaths(lines []string) []string {
+	type frame struct {
+		indent int
+		path   string
+	}
+	var stack []frame
+	parent := func() string {
+		if len(stack) == 0 {
+			return ""
+		}
+		return stack[len(stack)-1].path
+	}
+	join := func(path, key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	counts := make(map[string]int) // number of items seen so far in each list
+	paths := make([]string, len(lines))
+	for i, line := range lines {
+		text := strings.TrimSpace(line)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		if text == "-" || strings.HasPrefix(text, "- ") {
+			rest := strings.TrimSpace(strings.TrimPrefix(text, "-"))
+			index := counts[parent()]
+			counts[parent()]++
+			key, value, isMap := splitYAMLKey(rest)
+			if !isMap {
+				paths[i] = fmt.Sprintf("%s[%s]", parent(), rest)
+				continue
+			}
+			// the item is an object, which is named by its "name" key if that comes first
+			item := fmt.Sprintf("%s[%d]", parent(), index)
+			if key == "name" {
+				item = fmt.Sprintf("%s[%s]", parent(), value)
+			}
+			stack = append(stack, frame{indent: indent, path: item})
+			stack = append(stack, frame{indent: indent + 2, path: join(item, key)})
+			paths[i] = join(item, key)
+			continue
+		}
+
+		if key, _, ok := splitYAMLKey(text); ok {
+			path := join(parent(), key)
+			stack = append(stack, frame{indent: indent, path: path})
+			paths[i] = path
+		}
+	}
+	return paths
+}
+
+func blame(ctx context.Context, args *args) error {
+	// find the project spec
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(filepath.Ext(spec.path), ".json") {
+		return fmt.Errorf("blame is not supported for JSON specs")
+	}
+
+	lines, err := gitBlame(spec.path)
+	if err != nil {
+		return err
+	}
+
+	var texts []string
+	for _, line := range lines {
+		texts = append(texts, line.Text)
+	}
+	paths := yamlFieldPaths(texts)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i, line := range lines {
+		if paths[i] == "" {
+			continue
+		}
+		commit := line.Commit
+		if len(commit) > 8 {
+			commit = commit[:8]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", commit, line.Time.Format("2006-01-02"), line.Author, paths[i])
+	}
+	return w.Flush()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpecRevisionIgnoresOtherCommits(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		out, err := gitOutput(dir, append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if err != nil {
+			t.Fatalf("git %v: %v", args, err)
+		}
+		return out
+	}
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), filePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "-q")
+	spec := filepath.Join(dir, "googlecloudproject.yaml")
+	if rev, _ := specRevision(spec); rev != "" {
+		t.Errorf("got revision %q before the spec was committed", rev)
+	}
+
+	write("googlecloudproject.yaml", "id: app\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "add spec")
+	want := git("log", "-1", "--format=%h", "--abbrev=12")
+
+	write("other.yaml", "id: other\n")
+	git("add", ".")
+	git("commit", "-q", "-m", "unrelated")
+
+	rev, dirty := specRevision(spec)
+	if rev != want {
+		t.Errorf("got revision %q, want %q", rev, want)
+	}
+	if dirty {
+		t.Error("spec reported as dirty")
+	}
+
+	write("googlecloudproject.yaml", "id: app\nname: App\n")
+	if _, dirty := specRevision(spec); !dirty {
+		t.Error("spec with uncommitted changes not reported as dirty")
+	}
+}
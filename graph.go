@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// status of a node while the graph is running
+type nodeStatus int
+
+const (
+	nodePending nodeStatus = iota
+	nodeRunning
+	nodeDone
+	nodeFailed
+	nodeSkipped
+)
+
+// node is a single step in applying a spec, such as creating the project or enabling an API
+type node struct {
+	name string                                  // unique name, e.g. "project" or "api:run.googleapis.com"
+	deps []string                                // names of the nodes that must complete before this one
+	run  func(ctx context.Context) (bool, error) // performs the step and reports whether anything changed
+}
+
+// graph is a set of nodes with dependencies between them, executed in dependency order
+type graph struct {
+	nodes map[string]*node
+	order []string // names of the nodes in the order they were added
+	err   error    // first error encountered while adding nodes
+
+	// if not nil, called when a node fails to decide what to do about it
+	recover func(name string, err error) recovery
+
+	skip   map[string]bool   // nodes that completed in an earlier run, which are treated as done
+	onDone func(name string) // if not nil, called each time a node completes successfully
+
+	checked int       // number of nodes that ran successfully, filled in by run
+	changed []string  // names of the nodes that changed something, filled in by run
+	actions []*Action // record of every node in the order they were added, filled in by run
+}
+
+// add a node to the graph. Dependencies must be added before their dependents, which
+// guarantees that the graph has no cycles.
+func (g *graph) add(name string, deps []string, run func(ctx context.Context) (bool, error)) {
+	if g.nodes == nil {
+		g.nodes = make(map[string]*node)
+	}
+	if _, exists := g.nodes[name]; exists {
+		if g.err == nil {
+			g.err = fmt.Errorf("duplicate step %q in apply graph", name)
+		}
+		return
+	}
+	for _, dep := range deps {
+		if _, exists := g.nodes[dep]; !exists && g.err == nil {
+			g.err = fmt.Errorf("step %q depends on unknown step %q", name, dep)
+		}
+	}
+	g.nodes[name] = &node{name: name, deps: deps, run: run}
+	g.order = append(g.order, name)
+}
+
+// recovery is what to do when a node fails
+type recovery int
+
+const (
+	recoverFail  recovery = iota // record the failure and skip the node's dependents
+	recoverRetry                 // run the node again
+	recoverSkip                  // ignore the failure and carry on with the node's dependents
+	recoverAbort                 // record the failure and do not start any further nodes
+)
+
+// result of running a single node
+type nodeResult struct {
+	name    string
+	changed bool
+	err     error
+}
+
+// run executes all nodes, running up to parallelism nodes at once. Nodes whose
+// dependencies failed are skipped. The returned error lists every failed step.
+func (g *graph) run(ctx context.Context, parallelism int) error {
+	if g.err != nil {
+		return g.err
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	status := make(map[string]nodeStatus)
+	errs := make(map[string]error)
+	actions := make(map[string]*Action)
+	results := make(chan nodeResult)
+	var running int
+	var aborted bool
+
+	launch := func(n *node) {
+		status[n.name] = nodeRunning
+		running++
+		action := &Action{Resource: n.name}
+		actions[n.name] = action
+		go func() {
+			begin := time.Now()
+			spanCtx, span := startSpan(ctx, n.name, 1)
+			changed, err := n.run(context.WithValue(spanCtx, actionKey{}, action))
+			span.set("gproj.changed", strconv.FormatBool(changed))
+			span.finish(err)
+			action.Seconds = time.Since(begin).Seconds()
+			results <- nodeResult{name: n.name, changed: changed, err: err}
+		}()
+	}
+
+	for {
+		// since nodes were added in dependency order, a single pass in insertion order
+		// sees the final status of every dependency before considering its dependents
+		for _, name := range g.order {
+			if status[name] != nodePending {
+				continue
+			}
+			if aborted {
+				status[name] = nodeSkipped
+				continue
+			}
+
+			n := g.nodes[name]
+			ready := true
+			for _, dep := range n.deps {
+				switch status[dep] {
+				case nodeFailed, nodeSkipped:
+					status[name] = nodeSkipped
+				case nodeDone:
+				default:
+					ready = false
+				}
+			}
+			if status[name] == nodeSkipped || !ready {
+				continue
+			}
+			if g.skip[name] {
+				status[name] = nodeDone
+				actions[name] = &Action{Resource: name, Result: "done earlier"}
+				continue
+			}
+			if running >= parallelism {
+				continue
+			}
+
+			launch(n)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+
+		choice := recoverFail
+		if r.err != nil && g.recover != nil && !aborted {
+			choice = g.recover(r.name, r.err)
+		}
+
+		switch {
+		case r.err != nil && choice == recoverRetry:
+			launch(g.nodes[r.name])
+		case r.err != nil && choice == recoverSkip:
+			status[r.name] = nodeDone
+			actions[r.name].Result = "skipped"
+			actions[r.name].Error = r.err.Error()
+		case r.err != nil:
+			if choice == recoverAbort {
+				aborted = true
+			}
+			status[r.name] = nodeFailed
+			errs[r.name] = r.err
+			actions[r.name].Result = "failed"
+			actions[r.name].Error = r.err.Error()
+		case r.changed:
+			status[r.name] = nodeDone
+			g.checked++
+			g.changed = append(g.changed, r.name)
+			actions[r.name].Result = "changed"
+		default:
+			status[r.name] = nodeDone
+			g.checked++
+			actions[r.name].Result = "unchanged"
+		}
+		if r.err == nil && g.onDone != nil {
+			g.onDone(r.name)
+		}
+	}
+
+	for _, name := range g.order {
+		if status[name] == nodeSkipped {
+			actions[name] = &Action{Resource: name, Result: "skipped"}
+		}
+		g.actions = append(g.actions, actions[name])
+	}
+
+	// attribute failures to the steps that caused them
+	var failed, skipped []string
+	for _, name := range g.order {
+		switch status[name] {
+		case nodeFailed:
+			failed = append(failed, fmt.Sprintf("  %s: %v", name, errs[name]))
+		case nodeSkipped:
+			skipped = append(skipped, name)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("%d step(s) failed:\n%s", len(failed), strings.Join(failed, "\n"))
+	if len(skipped) > 0 && aborted {
+		msg += fmt.Sprintf("\nskipped because apply was aborted: %s", strings.Join(skipped, ", "))
+	} else if len(skipped) > 0 {
+		msg += fmt.Sprintf("\nskipped because a dependency failed: %s", strings.Join(skipped, ", "))
+	}
+	return fmt.Errorf("%s", msg)
+}
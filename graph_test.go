@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// steps that need an API must be added after the step that enables it, or else building
+// the graph fails
+func TestApplyGraphBuilds(t *testing.T) {
+	tests := []struct {
+		name string
+		spec ProjectSpec
+		step string // a step that the graph must contain
+	}{
+		{
+			name: "act as",
+			spec: ProjectSpec{ActAs: map[string][]string{"runtime": {"serviceAccount:deployer@ci.iam.gserviceaccount.com"}}},
+			step: "act-as",
+		},
+		{
+			name: "oauth clients",
+			spec: ProjectSpec{OAuth: &OAuthBrand{ApplicationTitle: "Acme", Clients: []OAuthClient{{Name: "backend-iap"}}}},
+			step: "oauth-clients",
+		},
+		{
+			name: "identity platform",
+			spec: ProjectSpec{IdentityPlatform: &IdentityPlatform{}},
+			step: "identity-platform",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.spec.ID = "acme-app"
+			a := &applier{args: &args{Apply: &applyArgs{}}, spec: &test.spec}
+			g := a.graph()
+			if g.err != nil {
+				t.Fatal(g.err)
+			}
+			if _, ok := g.nodes[test.step]; !ok {
+				t.Errorf("no step %s", test.step)
+			}
+		})
+	}
+}
+
+func TestApplyGraphPrunesAfterRecording(t *testing.T) {
+	a := &applier{
+		args: &args{Apply: &applyArgs{}},
+		spec: &ProjectSpec{
+			ID: "acme-app",
+			OAuth: &OAuthBrand{
+				ApplicationTitle: "Acme",
+				Clients:          []OAuthClient{{Name: "backend-iap"}},
+			},
+			CloudRun:  []CloudRunService{{Name: "web"}},
+			Datasets:  []BigQueryDataset{{Name: "events"}},
+			CloudSQL:  []CloudSQLInstance{{Name: "db"}},
+			APIKeys:   []APIKey{{Name: "maps"}},
+			Scheduler: []SchedulerJob{{Name: "nightly"}},
+			Resources: []GenericResource{{Name: "cache", API: "redis.googleapis.com"}},
+		},
+		state: &State{},
+	}
+	g := a.graph()
+	if g.err != nil {
+		t.Fatal(g.err)
+	}
+	prune, ok := g.nodes["prune"]
+	if !ok {
+		t.Fatal("no prune step")
+	}
+
+	// every step that records resources in the state must finish before the state is pruned
+	for _, name := range []string{"oauth-clients", "cloudrun:web", "dataset:events", "cloudsql:db", "apikey:maps", "scheduler:nightly", "resource:cache"} {
+		if _, ok := g.nodes[name]; !ok {
+			t.Errorf("no step %s", name)
+		}
+		if !contains(prune.deps, name) {
+			t.Errorf("prune does not depend on %s", name)
+		}
+	}
+}
+
+// recorder makes nodes that note when they start and finish
+type recorder struct {
+	mu       sync.Mutex
+	events   []string
+	running  int
+	most     int // the most nodes that were running at once
+	failures map[string]error
+}
+
+func (r *recorder) node(name string) func(ctx context.Context) (bool, error) {
+	return func(ctx context.Context) (bool, error) {
+		r.mu.Lock()
+		r.events = append(r.events, "start "+name)
+		r.running++
+		if r.running > r.most {
+			r.most = r.running
+		}
+		r.mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.events = append(r.events, "finish "+name)
+		r.running--
+		return name == "changes", r.failures[name]
+	}
+}
+
+// the position of an event, or -1 if it did not happen
+func (r *recorder) index(event string) int {
+	for i, e := range r.events {
+		if e == event {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestGraphRunsInDependencyOrder(t *testing.T) {
+	var r recorder
+	var g graph
+	g.add("project", nil, r.node("project"))
+	g.add("billing", []string{"project"}, r.node("billing"))
+	g.add("labels", []string{"project"}, r.node("labels"))
+	g.add("apis", []string{"billing"}, r.node("apis"))
+	g.add("prune", []string{"apis", "labels"}, r.node("prune"))
+
+	if err := g.run(context.Background(), 4); err != nil {
+		t.Fatal(err)
+	}
+	for name, deps := range map[string][]string{
+		"billing": {"project"},
+		"labels":  {"project"},
+		"apis":    {"billing"},
+		"prune":   {"apis", "labels"},
+	} {
+		for _, dep := range deps {
+			if r.index("finish "+dep) > r.index("start "+name) {
+				t.Errorf("%s started before %s finished: %v", name, dep, r.events)
+			}
+		}
+	}
+	if g.checked != 5 {
+		t.Errorf("got %d steps checked, want 5", g.checked)
+	}
+}
+
+func TestGraphLimitsParallelism(t *testing.T) {
+	var r recorder
+	var g graph
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		g.add(name, nil, r.node(name))
+	}
+	if err := g.run(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	if r.most > 2 {
+		t.Errorf("%d steps ran at once, want at most 2", r.most)
+	}
+	if len(r.events) != 12 {
+		t.Errorf("not every step ran: %v", r.events)
+	}
+}
+
+func TestGraphSkipsDependentsOfFailures(t *testing.T) {
+	r := recorder{failures: map[string]error{"billing": errors.New("billing account closed")}}
+	var g graph
+	g.add("project", nil, r.node("project"))
+	g.add("billing", []string{"project"}, r.node("billing"))
+	g.add("apis", []string{"billing"}, r.node("apis"))
+	g.add("budget", []string{"apis"}, r.node("budget"))
+	g.add("changes", []string{"project"}, r.node("changes"))
+
+	err := g.run(context.Background(), 1)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"1 step(s) failed", "billing: billing account closed", "skipped because a dependency failed: apis, budget"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not contain %q", err, want)
+		}
+	}
+	if r.index("start apis") >= 0 || r.index("start budget") >= 0 {
+		t.Errorf("dependents of a failed step ran: %v", r.events)
+	}
+	if !reflect.DeepEqual(g.changed, []string{"changes"}) {
+		t.Errorf("got changed steps %v", g.changed)
+	}
+
+	results := make(map[string]string)
+	for _, a := range g.actions {
+		results[a.Resource] = a.Result
+	}
+	want := map[string]string{"project": "unchanged", "billing": "failed", "apis": "skipped", "budget": "skipped", "changes": "changed"}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("got results %v, want %v", results, want)
+	}
+}
+
+func TestGraphRecovery(t *testing.T) {
+	tests := []struct {
+		choice  recovery
+		wantErr bool
+		ran     []string // steps that should have started, in order
+	}{
+		{recoverFail, true, []string{"a", "b"}},
+		{recoverRetry, false, []string{"a", "b", "b", "c"}},
+		{recoverSkip, false, []string{"a", "b", "c"}},
+		{recoverAbort, true, []string{"a", "b"}},
+	}
+	for _, test := range tests {
+		var started []string
+		attempts := 0
+		step := func(name string) func(ctx context.Context) (bool, error) {
+			return func(ctx context.Context) (bool, error) {
+				started = append(started, name)
+				if name == "b" {
+					attempts++
+					if attempts == 1 {
+						return false, errors.New("transient")
+					}
+				}
+				return false, nil
+			}
+		}
+		g := graph{recover: func(name string, err error) recovery { return test.choice }}
+		g.add("a", nil, step("a"))
+		g.add("b", []string{"a"}, step("b"))
+		g.add("c", []string{"b"}, step("c"))
+		g.add("d", nil, step("d"))
+
+		err := g.run(context.Background(), 1)
+		if (err != nil) != test.wantErr {
+			t.Errorf("recovery %d: got error %v", test.choice, err)
+		}
+		// d is independent, so it runs unless apply was aborted before it started
+		var ran []string
+		for _, name := range started {
+			if name != "d" {
+				ran = append(ran, name)
+			}
+		}
+		if !reflect.DeepEqual(ran, test.ran) {
+			t.Errorf("recovery %d: got steps %v, want %v", test.choice, ran, test.ran)
+		}
+	}
+}
+
+func TestGraphSkipsStepsDoneEarlier(t *testing.T) {
+	var r recorder
+	g := graph{skip: map[string]bool{"project": true}}
+	g.add("project", nil, r.node("project"))
+	g.add("labels", []string{"project"}, r.node("labels"))
+	if err := g.run(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.events, []string{"start labels", "finish labels"}) {
+		t.Errorf("got %v", r.events)
+	}
+	if g.actions[0].Result != "done earlier" {
+		t.Errorf("got result %q for a step done earlier", g.actions[0].Result)
+	}
+}
+
+func TestGraphAddErrors(t *testing.T) {
+	var g graph
+	g.add("labels", []string{"project"}, nil)
+	if err := g.run(context.Background(), 1); err == nil || !strings.Contains(err.Error(), "unknown step") {
+		t.Errorf("expected an error about an unknown step, got %v", err)
+	}
+
+	g = graph{}
+	g.add("project", nil, nil)
+	g.add("project", nil, nil)
+	if err := g.run(context.Background(), 1); err == nil || !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("expected an error about a duplicate step, got %v", err)
+	}
+}
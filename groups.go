@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the file at the root of a workspace that defines what its specs share
+const workspaceFile = "gproj-workspace.yaml"
+
+// WorkspaceConfig models the gproj-workspace.yaml file
+type WorkspaceConfig struct {
+	Groups map[string]SpecGroup // groups that specs in the workspace can refer to by name
+}
+
+// SpecGroup is a set of APIs and labels that several specs in a workspace have in common
+type SpecGroup struct {
+	APIs   []string          // APIs to enable, which may include bundles such as bundle:web-app
+	Labels map[string]string // labels to put on the project, unless the spec sets the same key
+}
+
+// look for the workspace file in start and then in each of its parents, stopping at the
+// root of the repository
+func findWorkspaceFile(fsys filesystem, start string) (string, error) {
+	dir := filepath.Clean(start)
+	for {
+		path := filepath.Join(dir, workspaceFile)
+		if _, err := fsys.Stat(path); err == nil {
+			return path, nil
+		}
+		if _, err := fsys.Stat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return "", fmt.Errorf("%w: no %s found in %s or above", os.ErrNotExist, workspaceFile, start)
+}
+
+// read a workspace file, rejecting fields that gproj does not know about since a
+// misspelled field in a shared file would silently affect every project
+func loadWorkspaceConfig(fsys filesystem, path string) (*WorkspaceConfig, error) {
+	buf, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	var config WorkspaceConfig
+	err = yaml.UnmarshalStrict(buf, &config)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// list the names of the groups in a workspace, for error messages
+func (c *WorkspaceConfig) groupNames() []string {
+	var names []string
+	for name := range c.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyGroups adds the APIs and labels of the groups that the spec refers to, as defined
+// in the workspace file above the spec. Labels set in the spec itself take precedence,
+// but two groups that give the same label different values are an error, since there is
+// no telling which was meant.
+func (spec *ProjectSpec) applyGroups() error {
+	if len(spec.Groups) == 0 {
+		return nil
+	}
+
+	path, err := findWorkspaceFile(fsys, filepath.Dir(spec.path))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("the spec refers to groups but %w", err)
+	}
+	if err != nil {
+		return err
+	}
+	config, err := loadWorkspaceConfig(fsys, path)
+	if err != nil {
+		return err
+	}
+
+	var apis []string
+	labels := make(map[string]string)
+	labelFrom := make(map[string]string) // the group that set each label
+	for _, name := range spec.Groups {
+		group, ok := config.Groups[name]
+		if !ok {
+			return fmt.Errorf("no group named %q in %s; groups are: %s", name, path, strings.Join(config.groupNames(), ", "))
+		}
+		apis = append(apis, group.APIs...)
+		for k, v := range group.Labels {
+			if other, ok := labelFrom[k]; ok && labels[k] != v {
+				return fmt.Errorf("groups %q and %q in %s give label %s different values (%q and %q); set it in the spec instead",
+					other, name, path, k, labels[k], v)
+			}
+			labels[k] = v
+			labelFrom[k] = name
+		}
+	}
+
+	spec.APIs = append(apis, spec.APIs...)
+	if spec.Labels == nil && len(labels) > 0 {
+		spec.Labels = make(map[string]string)
+	}
+	for k, v := range labels {
+		if _, ok := spec.Labels[k]; !ok {
+			spec.Labels[k] = v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+)
+
+// number of times a request is attempted before giving up on transient errors
+const maxAttempts = 4
+
+// retryTransport retries requests that fail with a rate limit or server error. Only requests
+// that are safe to repeat are retried: GETs, and anything that was rejected with 429 before
+// the server acted on it.
+type retryTransport struct {
+	base http.RoundTripper
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay := time.Second
+	attempt := req
+	for n := 1; ; n++ {
+		resp, err := t.base.RoundTrip(attempt)
+		if n == maxAttempts || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		// a request whose body cannot be rewound cannot be repeated, in which case the
+		// response is returned as it is, with its body still open
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+
+		// a round tripper must not modify the request it was given, so each retry is made
+		// with a copy that has a fresh body
+		attempt = req.Clone(req.Context())
+		if req.Body != nil {
+			attempt.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// determine whether a request should be repeated given the outcome of the last attempt
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return req.Method == http.MethodGet && req.Context().Err() == nil
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return req.Method == http.MethodGet && resp.StatusCode >= 500
+}
+
+// traceTransport prints each request and the time it took
+type traceTransport struct {
+	base http.RoundTripper
+}
+
+func (t traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	begin := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	elapsed := time.Since(begin).Round(time.Millisecond)
+	if err != nil {
+		fmt.Printf("%s %s: %v (%v)\n", req.Method, req.URL, err, elapsed)
+	} else {
+		fmt.Printf("%s %s: %s (%v)\n", req.Method, req.URL, resp.Status, elapsed)
+	}
+	return resp, err
+}
+
+// headerTransport puts gproj at the front of the user agent of each request so that org
+// admins can identify gproj traffic in audit logs, and attributes usage to a quota project
+// if one was given. The API clients ignore option.WithUserAgent and option.WithQuotaProject
+// when they are given an HTTP client, so it is done here instead.
+type headerTransport struct {
+	base         http.RoundTripper
+	userAgent    string
+	quotaProject string // if empty then usage is attributed to the project being called
+}
+
+func (t headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a round tripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	if existing := req.Header.Get("User-Agent"); existing != "" {
+		req.Header.Set("User-Agent", t.userAgent+" "+existing)
+	} else {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	if t.quotaProject != "" {
+		req.Header.Set("X-Goog-User-Project", t.quotaProject)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// endpointTransport sends requests for some google APIs to other base URLs, such as a mock
+// server, a Private Service Connect endpoint, or restricted.googleapis.com. Doing this here
+// rather than with option.WithEndpoint covers every API client, including those with
+// regional endpoints.
+type endpointTransport struct {
+	base      http.RoundTripper
+	overrides map[string]*url.URL // keyed by the host being replaced, e.g. "run.googleapis.com"
+}
+
+func (t endpointTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, ok := t.overrides[req.URL.Host]
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	// a round tripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	req.URL.Scheme = target.Scheme
+	req.URL.Host = target.Host
+	req.URL.Path = strings.TrimSuffix(target.Path, "/") + req.URL.Path
+	req.Host = ""
+	return t.base.RoundTrip(req)
+}
+
+// parseEndpointOverrides parses a comma-separated list of service=url pairs, such as
+// "cloudresourcemanager=http://localhost:8080,run=https://run-myendpoint.p.googleapis.com".
+// A service may be given by its short name or by its full hostname.
+func parseEndpointOverrides(s string) (map[string]*url.URL, error) {
+	overrides := make(map[string]*url.URL)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid endpoint override %q (expected service=url)", pair)
+		}
+		target, err := url.Parse(parts[1])
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return nil, fmt.Errorf("invalid endpoint override %q: %q is not an absolute URL", pair, parts[1])
+		}
+		host := parts[0]
+		if !strings.Contains(host, ".") {
+			host += ".googleapis.com"
+		}
+		overrides[host] = target
+	}
+	return overrides, nil
+}
+
+// newHTTPClient creates an authenticated http client to be shared between google API
+// services so that they reuse connections and behave consistently with respect to retries.
+// If trace is true then each request is printed. Each attempt is also recorded as a span
+// if the request context is being traced. Every request carries the given user agent, and
+// is billed to the given quota project unless it is empty. Requests to the hosts in
+// overrides are sent to the corresponding URLs instead, and requests to the hosts in limiters
+// wait for a token first.
+func newHTTPClient(creds *google.Credentials, trace bool, userAgent, quotaProject string, overrides map[string]*url.URL, limiters map[string]*tokenBucket) *http.Client {
+	var base http.RoundTripper = spanTransport{base: http.DefaultTransport}
+	if len(overrides) > 0 {
+		base = endpointTransport{base: base, overrides: overrides}
+	}
+	if len(limiters) > 0 {
+		base = rateLimitTransport{base: base, limiters: limiters}
+	}
+	if trace {
+		base = traceTransport{base: base}
+	}
+	base = retryTransport{base: base}
+	base = headerTransport{base: base, userAgent: userAgent, quotaProject: quotaProject}
+
+	// the oauth2 transport adds the access token to each request
+	return &http.Client{
+		Transport: &oauth2.Transport{
+			Source: oauth2.ReuseTokenSource(nil, creds.TokenSource),
+			Base:   base,
+		},
+	}
+}
+
+// call a JSON API with the shared HTTP client, decoding the response into out if it is
+// not nil. Errors are returned as *googleapi.Error so that they can be checked for 404.
+func callJSON(ctx context.Context, conn *connection, method, url string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("error marshalling request: %w", err)
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := conn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := googleapi.CheckResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// roundTripFunc makes a round tripper from a function
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// closableBody fails reads once it has been closed, like a real response body
+type closableBody struct {
+	r      io.Reader
+	closed bool
+}
+
+func (b *closableBody) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, errors.New("read on closed response body")
+	}
+	return b.r.Read(p)
+}
+
+func (b *closableBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func response(status int, body string) *http.Response {
+	return &http.Response{StatusCode: status, Body: &closableBody{r: strings.NewReader(body)}}
+}
+
+func TestRetryTransportRetriesWithFreshBody(t *testing.T) {
+	var bodies []string
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		buf, _ := io.ReadAll(req.Body)
+		bodies = append(bodies, string(buf))
+		if len(bodies) == 1 {
+			return response(http.StatusTooManyRequests, "slow down"), nil
+		}
+		return response(http.StatusOK, "ok"), nil
+	})
+
+	req, err := http.NewRequest(http.MethodPost, "https://example.com/", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := req.Body
+	resp, err := retryTransport{base: base}.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d", resp.StatusCode)
+	}
+	if len(bodies) != 2 || bodies[0] != "payload" || bodies[1] != "payload" {
+		t.Errorf("got bodies %q", bodies)
+	}
+	if req.Body != body {
+		t.Error("the request given to RoundTrip was modified")
+	}
+}
+
+func TestRetryTransportReturnsReadableResponseWithoutGetBody(t *testing.T) {
+	var calls int
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return response(http.StatusTooManyRequests, "slow down"), nil
+	})
+
+	// a body that is not one of the types for which NewRequest sets GetBody, as for media uploads
+	req, err := http.NewRequest(http.MethodPost, "https://example.com/", io.MultiReader(strings.NewReader("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := retryTransport{base: base}.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading the response body: %v", err)
+	}
+	if string(buf) != "slow down" {
+		t.Errorf("got body %q", buf)
+	}
+}
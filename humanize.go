@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+)
+
+// humanDuration formats a duration for people, e.g. "850ms", "42s", "3m 10s", or "2h 5m"
+func humanDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Round(time.Second).Seconds()))
+	case d < time.Hour:
+		d = d.Round(time.Second)
+		return fmt.Sprintf("%dm %ds", int(d/time.Minute), int(d%time.Minute/time.Second))
+	case d < 48*time.Hour:
+		d = d.Round(time.Minute)
+		return fmt.Sprintf("%dh %dm", int(d/time.Hour), int(d%time.Hour/time.Minute))
+	}
+	return fmt.Sprintf("%d days", int(d/(24*time.Hour)))
+}
+
+// humanSize formats a number of bytes for people, e.g. "512 B" or "23.4 MB"
+func humanSize(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
+
+// plural formats a count of things, e.g. "1 day" or "3 days"
+func plural(n int, thing string) string {
+	if n == 1 {
+		return "1 " + thing
+	}
+	return fmt.Sprintf("%d %ss", n, thing)
+}
+
+// humanAgo formats a time relative to now for people, e.g. "just now", "5 minutes ago",
+// or "in 3 days"
+func humanAgo(t time.Time) string {
+	d := time.Since(t)
+	prefix, suffix := "", " ago"
+	if d < 0 {
+		d = -d
+		prefix, suffix = "in ", ""
+	}
+
+	var s string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		s = plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		s = plural(int(d/time.Hour), "hour")
+	case d < 60*24*time.Hour:
+		s = plural(int(d/(24*time.Hour)), "day")
+	default:
+		// months are vague enough that the date itself is more useful
+		return "on " + t.Local().Format("2 Jan 2006")
+	}
+	return prefix + s + suffix
+}
+
+// whether output lines are being prefixed with the time, in which case log lines need not
+// carry their own
+var timestamped bool
+
+// timestampOutput prefixes each line written to stdout with the time, for --timestamps.
+// The returned function must be called before exiting so that the last lines are written.
+func timestampOutput() (flush func()) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		fmt.Println("warning: unable to add timestamps to output:", err)
+		return func() {}
+	}
+
+	out := os.Stdout
+	os.Stdout = w
+	timestamped = true
+
+	// copy whatever is written as soon as it is written, rather than line by line, so that
+	// prompts show up before their answers are typed
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 4096)
+		lineStart := true
+		for {
+			n, err := r.Read(buf)
+			chunk := buf[:n]
+			for len(chunk) > 0 {
+				if lineStart {
+					fmt.Fprintf(out, "%s ", time.Now().Format(time.RFC3339))
+				}
+				end := bytes.IndexByte(chunk, '\n') + 1
+				if end == 0 {
+					end = len(chunk)
+				}
+				out.Write(chunk[:end])
+				lineStart = chunk[end-1] == '\n'
+				chunk = chunk[end:]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		os.Stdout = out
+		w.Close()
+		<-done
+	}
+}
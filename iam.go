@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/iam/v1"
+)
+
+// the role that allows a member to deploy resources that run as a service account
+const serviceAccountUserRole = "roles/iam.serviceAccountUser"
+
+// expand a member of the form "serviceAccount:NAME@PROJECT", which refers to a service
+// account in another project by the ID of that project, to the full email address of the
+// service account. Other members are returned unchanged.
+func expandMember(member string) string {
+	if !strings.HasPrefix(member, "serviceAccount:") {
+		return member
+	}
+	at := strings.LastIndex(member, "@")
+	if at < 0 || strings.Contains(member[at:], ".") {
+		return member
+	}
+	return member + ".iam.gserviceaccount.com"
+}
+
+// expand the members in a set of role bindings with expandMember
+func expandBindings(bindings map[string][]string) map[string][]string {
+	out := make(map[string][]string)
+	for role, members := range bindings {
+		for _, member := range members {
+			out[role] = append(out[role], expandMember(member))
+		}
+	}
+	return out
+}
+
+// get the email address of a service account in a project, which may be given by name
+// alone, e.g. "deployer", or by its full email address
+func serviceAccountEmail(projectID, account string) string {
+	if strings.Contains(account, "@") {
+		return account
+	}
+	return fmt.Sprintf("%s@%s.iam.gserviceaccount.com", account, projectID)
+}
+
+// the org policy constraint that restricts IAM members to particular workspace customers
+const domainRestrictionConstraint = "constraints/iam.allowedPolicyMemberDomains"
+
+// get the domain of an IAM member such as "user:alice@example.com" or "domain:example.com",
+// or the empty string if the member is not in a domain, as with "allUsers"
+func memberDomain(member string) string {
+	parts := strings.SplitN(member, ":", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	if parts[0] == "domain" {
+		return strings.ToLower(parts[1])
+	}
+	at := strings.LastIndex(parts[1], "@")
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(parts[1][at+1:])
+}
+
+// determine whether a domain is one of the allowed domains or a subdomain of one
+func domainAllowed(domain string, allowed []string) bool {
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if domain == a || strings.HasSuffix(domain, "."+a) {
+			return true
+		}
+	}
+	return false
+}
+
+// find the members in a set of role bindings that are not in any of the allowed domains.
+// Service accounts are always allowed since they belong to projects rather than domains.
+func disallowedMembers(bindings map[string][]string, allowed []string) []string {
+	var bad []string
+	for _, members := range bindings {
+		for _, member := range members {
+			domain := memberDomain(member)
+			if strings.HasPrefix(member, "serviceAccount:") && strings.HasSuffix(domain, "gserviceaccount.com") {
+				continue
+			}
+			if !domainAllowed(domain, allowed) && !contains(bad, member) {
+				bad = append(bad, member)
+			}
+		}
+	}
+	sort.Strings(bad)
+	return bad
+}
+
+// return an error if the spec grants roles to members outside of its allowed domains
+func (spec *ProjectSpec) checkMemberDomains() error {
+	if len(spec.AllowedMemberDomains) == 0 {
+		return nil
+	}
+	bindings := expandBindings(spec.IAM)
+	for _, members := range spec.ActAs {
+		for _, member := range members {
+			bindings[serviceAccountUserRole] = append(bindings[serviceAccountUserRole], expandMember(member))
+		}
+	}
+	bad := disallowedMembers(bindings, spec.AllowedMemberDomains)
+	if len(bad) > 0 {
+		return fmt.Errorf("%s are not in the allowed member domains (%s)",
+			strings.Join(bad, ", "), strings.Join(spec.AllowedMemberDomains, ", "))
+	}
+	return nil
+}
+
+// add the members in the spec to the project's IAM policy, and return the grants that
+// were added in the form "role=member". Members that are not in the spec are left alone,
+// as are conditional bindings.
+func addBindings(policy *cloudresourcemanager.Policy, want map[string][]string) []string {
+	var roles []string
+	for role := range want {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	var added []string
+	for _, role := range roles {
+		var binding *cloudresourcemanager.Binding
+		for _, b := range policy.Bindings {
+			if b.Role == role && b.Condition == nil {
+				binding = b
+				break
+			}
+		}
+		if binding == nil {
+			binding = &cloudresourcemanager.Binding{Role: role}
+			policy.Bindings = append(policy.Bindings, binding)
+		}
+		for _, member := range want[role] {
+			if !contains(binding.Members, member) {
+				binding.Members = append(binding.Members, member)
+				added = append(added, role+"="+member)
+			}
+		}
+	}
+	return added
+}
+
+// grant the roles in the spec, and report whether the IAM policy was changed
+func (a *applier) ensureIAM(ctx context.Context) (bool, error) {
+	// catch typos and personal accounts before they get into the policy
+	err := a.spec.checkMemberDomains()
+	if err != nil {
+		return false, err
+	}
+
+	var policy *cloudresourcemanager.Policy
+	err = a.retryAfterCreate(ctx, func() (err error) {
+		policy, err = a.resources.Projects.GetIamPolicy(a.spec.ID, &cloudresourcemanager.GetIamPolicyRequest{}).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error getting IAM policy for %s: %w", a.spec.ID, err)
+	}
+
+	added := addBindings(policy, expandBindings(a.spec.IAM))
+	if len(added) == 0 {
+		return false, nil
+	}
+
+	// the policy carries the etag from the get, so this fails rather than overwriting a concurrent change
+	_, err = a.resources.Projects.SetIamPolicy(a.spec.ID, &cloudresourcemanager.SetIamPolicyRequest{
+		Policy: policy,
+	}).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error updating IAM policy for %s: %w", a.spec.ID, err)
+	}
+
+	for _, grant := range added {
+		fmt.Println("granted", grant)
+		noteChange(ctx, "", grant)
+	}
+	return true, nil
+}
+
+// allow the members in the actAs section of the spec to act as service accounts in this
+// project, and report whether any IAM policy was changed. The role is granted on each
+// service account rather than on the project so that members can act as only those
+// service accounts that the spec lists.
+func (a *applier) ensureActAs(ctx context.Context) (bool, error) {
+	err := a.spec.checkMemberDomains()
+	if err != nil {
+		return false, err
+	}
+
+	svc, err := iam.NewService(ctx, a.conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the IAM API: %w", err)
+	}
+
+	var accounts []string
+	for account := range a.spec.ActAs {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	var changed bool
+	for _, account := range accounts {
+		email := serviceAccountEmail(a.spec.ID, account)
+		resource := fmt.Sprintf("projects/%s/serviceAccounts/%s", a.spec.ID, email)
+		policy, err := svc.Projects.ServiceAccounts.GetIamPolicy(resource).Context(ctx).Do()
+		if err != nil {
+			return changed, fmt.Errorf("error getting IAM policy for %s: %w", email, err)
+		}
+
+		var binding *iam.Binding
+		for _, b := range policy.Bindings {
+			if b.Role == serviceAccountUserRole && b.Condition == nil {
+				binding = b
+				break
+			}
+		}
+		if binding == nil {
+			binding = &iam.Binding{Role: serviceAccountUserRole}
+			policy.Bindings = append(policy.Bindings, binding)
+		}
+
+		var added []string
+		for _, member := range a.spec.ActAs[account] {
+			member = expandMember(member)
+			if !contains(binding.Members, member) {
+				binding.Members = append(binding.Members, member)
+				added = append(added, member)
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+
+		// the policy carries the etag from the get, so this fails rather than overwriting a concurrent change
+		_, err = svc.Projects.ServiceAccounts.SetIamPolicy(resource, &iam.SetIamPolicyRequest{
+			Policy: policy,
+		}).Context(ctx).Do()
+		if err != nil {
+			return changed, fmt.Errorf("error updating IAM policy for %s: %w", email, err)
+		}
+
+		for _, member := range added {
+			fmt.Printf("allowed %s to act as %s\n", member, email)
+			noteChange(ctx, "", member+" as "+email)
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// enforce domain restricted sharing with the customer IDs in the spec, and report whether
+// the org policy was changed
+func (a *applier) ensureDomainRestriction(ctx context.Context) (bool, error) {
+	resource := "projects/" + a.spec.ID
+	var policy *cloudresourcemanager.OrgPolicy
+	err := a.retryAfterCreate(ctx, func() (err error) {
+		policy, err = a.resources.Projects.GetOrgPolicy(resource, &cloudresourcemanager.GetOrgPolicyRequest{
+			Constraint: domainRestrictionConstraint,
+		}).Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("error getting org policy for %s: %w", a.spec.ID, err)
+	}
+
+	want := append([]string(nil), a.spec.AllowedCustomerIDs...)
+	sort.Strings(want)
+	var have []string
+	if policy.ListPolicy != nil {
+		have = append(have, policy.ListPolicy.AllowedValues...)
+	}
+	sort.Strings(have)
+	if strings.Join(have, ",") == strings.Join(want, ",") {
+		return false, nil
+	}
+
+	_, err = a.resources.Projects.SetOrgPolicy(resource, &cloudresourcemanager.SetOrgPolicyRequest{
+		Policy: &cloudresourcemanager.OrgPolicy{
+			Constraint: domainRestrictionConstraint,
+			Etag:       policy.Etag,
+			ListPolicy: &cloudresourcemanager.ListPolicy{AllowedValues: want},
+		},
+	}).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error setting org policy for %s: %w", a.spec.ID, err)
+	}
+
+	fmt.Printf("restricted IAM members to customers %s\n", strings.Join(want, ", "))
+	noteChange(ctx, strings.Join(have, ", "), strings.Join(want, ", "))
+	return true, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// endpoint that describes an access token, including the email of the principal it belongs to
+const tokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+
+// principalEmail looks up the email address of the user or service account that the
+// credentials belong to
+func principalEmail(ctx context.Context, conn *connection) (string, error) {
+	token, err := conn.creds.TokenSource.Token()
+	if err != nil {
+		return "", fmt.Errorf("error getting access token: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenInfoURL+"?access_token="+url.QueryEscape(token.AccessToken), nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error looking up the authenticated principal: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error looking up the authenticated principal: %s", resp.Status)
+	}
+
+	var info struct {
+		Email string `json:"email"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		return "", fmt.Errorf("error decoding token info: %w", err)
+	}
+	if info.Email == "" {
+		return "", fmt.Errorf("the credentials do not include an email address (is the userinfo.email scope missing?)")
+	}
+	return info.Email, nil
+}
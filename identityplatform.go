@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// the identity toolkit admin API, which has no generated client in the version of the
+// google API library that gproj uses
+const identityToolkitBase = "https://identitytoolkit.googleapis.com"
+
+// IdentityPlatform models the "identityPlatform" section of googlecloudproject.yaml, which
+// enables Identity Platform and the ways in which users can sign in
+type IdentityPlatform struct {
+	EmailPassword bool          `yaml:"emailPassword"` // allow users to sign in with an email address and password
+	Google        *GoogleSignIn // allow users to sign in with their google account
+}
+
+// GoogleSignIn configures sign in with google, which needs a web OAuth client
+type GoogleSignIn struct {
+	ClientID     string `yaml:"clientID"`     // ID of the OAuth client
+	ClientSecret string `yaml:"clientSecret"` // secret of the OAuth client, best kept in a sops-encrypted spec
+}
+
+// identityConfig is the part of the identity platform config that gproj manages
+type identityConfig struct {
+	SignIn struct {
+		Email struct {
+			Enabled          bool `json:"enabled"`
+			PasswordRequired bool `json:"passwordRequired"`
+		} `json:"email"`
+	} `json:"signIn"`
+}
+
+// idpConfig is the config of a built-in identity provider such as google
+type idpConfig struct {
+	Name         string `json:"name,omitempty"`
+	Enabled      bool   `json:"enabled"`
+	ClientID     string `json:"clientId"`
+	ClientSecret string `json:"clientSecret"`
+}
+
+// determine whether an error from the identity toolkit API means that something does not exist,
+// which it reports for an uninitialized project with a message rather than a status code
+func identityNotFound(err error) bool {
+	e, ok := err.(*googleapi.Error)
+	return ok && (e.Code == 404 || strings.Contains(e.Message, "CONFIGURATION_NOT_FOUND"))
+}
+
+// enable identity platform and the sign in methods in the spec, and report whether
+// anything changed. Sign in methods that are not in the spec are left alone.
+func (a *applier) ensureIdentityPlatform(ctx context.Context) (bool, error) {
+	want := a.spec.IdentityPlatform
+	project := fmt.Sprintf("%s/admin/v2/projects/%s", identityToolkitBase, a.spec.ID)
+
+	var changes []string
+	var config identityConfig
+	err := callJSON(ctx, a.conn, http.MethodGet, project+"/config", nil, &config)
+	if identityNotFound(err) {
+		fmt.Println("enabling identity platform")
+		url := fmt.Sprintf("%s/v2/projects/%s/identityPlatform:initializeAuth", identityToolkitBase, a.spec.ID)
+		err = callJSON(ctx, a.conn, http.MethodPost, url, struct{}{}, nil)
+		if err != nil {
+			return false, fmt.Errorf("error enabling identity platform: %w", err)
+		}
+		changes = append(changes, "enabled")
+		err = callJSON(ctx, a.conn, http.MethodGet, project+"/config", nil, &config)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error getting identity platform config: %w", err)
+	}
+
+	email := config.SignIn.Email
+	if want.EmailPassword && (!email.Enabled || !email.PasswordRequired) {
+		var update identityConfig
+		update.SignIn.Email.Enabled = true
+		update.SignIn.Email.PasswordRequired = true
+		url := project + "/config?updateMask=signIn.email.enabled,signIn.email.passwordRequired"
+		err = callJSON(ctx, a.conn, http.MethodPatch, url, &update, nil)
+		if err != nil {
+			return len(changes) > 0, fmt.Errorf("error enabling email sign in: %w", err)
+		}
+		fmt.Println("enabled sign in with email and password")
+		changes = append(changes, "email")
+	}
+
+	if want.Google != nil {
+		changed, err := a.ensureGoogleSignIn(ctx, project, want.Google)
+		if err != nil {
+			return len(changes) > 0, err
+		}
+		if changed {
+			changes = append(changes, "google")
+		}
+	}
+
+	if len(changes) == 0 {
+		return false, nil
+	}
+	noteChange(ctx, "", strings.Join(changes, ", "))
+	return true, nil
+}
+
+// enable sign in with google using the OAuth client in the spec, and report whether it changed
+func (a *applier) ensureGoogleSignIn(ctx context.Context, project string, want *GoogleSignIn) (bool, error) {
+	if want.ClientID == "" || want.ClientSecret == "" {
+		return false, fmt.Errorf("identityPlatform.google needs a clientID and a clientSecret")
+	}
+
+	desired := idpConfig{Enabled: true, ClientID: want.ClientID, ClientSecret: want.ClientSecret}
+	url := project + "/defaultSupportedIdpConfigs"
+
+	var existing idpConfig
+	err := callJSON(ctx, a.conn, http.MethodGet, url+"/google.com", nil, &existing)
+	if identityNotFound(err) {
+		err = callJSON(ctx, a.conn, http.MethodPost, url+"?idpId=google.com", &desired, nil)
+		if err != nil {
+			return false, fmt.Errorf("error enabling sign in with google: %w", err)
+		}
+		fmt.Println("enabled sign in with google")
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error getting google sign in config: %w", err)
+	}
+	if existing.Enabled && existing.ClientID == desired.ClientID && existing.ClientSecret == desired.ClientSecret {
+		return false, nil
+	}
+
+	err = callJSON(ctx, a.conn, http.MethodPatch, url+"/google.com?updateMask=enabled,clientId,clientSecret", &desired, nil)
+	if err != nil {
+		return false, fmt.Errorf("error updating sign in with google: %w", err)
+	}
+	fmt.Println("updated sign in with google")
+	return true, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// list the liens on a project, which prevent it from being deleted
+func projectLiens(ctx context.Context, resources *cloudresourcemanager.Service, projectID string) ([]*cloudresourcemanager.Lien, error) {
+	var liens []*cloudresourcemanager.Lien
+	err := resources.Liens.List().Parent("projects/"+projectID).Pages(ctx, func(resp *cloudresourcemanager.ListLiensResponse) error {
+		liens = append(liens, resp.Liens...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing liens on %s: %w", projectID, err)
+	}
+	return liens, nil
+}
+
+// format a lien as a single line
+func formatLien(l *cloudresourcemanager.Lien) string {
+	return fmt.Sprintf("%s  origin=%s  reason=%q  restricts=%s",
+		strings.TrimPrefix(l.Name, "liens/"), l.Origin, l.Reason, strings.Join(l.Restrictions, ","))
+}
+
+func liens(ctx context.Context, args *args) error {
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
+
+	if args.Liens.Remove != nil {
+		for _, name := range args.Liens.Remove.Liens {
+			if !strings.HasPrefix(name, "liens/") {
+				name = "liens/" + name
+			}
+			_, err := resources.Liens.Delete(name).Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("error removing %s: %w", name, err)
+			}
+			fmt.Printf("removed %s\n", name)
+		}
+		return nil
+	}
+
+	found, err := projectLiens(ctx, resources, spec.ID)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		fmt.Printf("project %s has no liens\n", spec.ID)
+		return nil
+	}
+	for _, l := range found {
+		fmt.Println(formatLien(l))
+	}
+	return nil
+}
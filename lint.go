@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// APIs that can run up significant bills if left unattended
+var costlyAPIs = []string{
+	"compute.googleapis.com",
+	"container.googleapis.com",
+	"bigquery.googleapis.com",
+	"sqladmin.googleapis.com",
+	"redis.googleapis.com",
+	"spanner.googleapis.com",
+	"aiplatform.googleapis.com",
+	"dataflow.googleapis.com",
+	"dataproc.googleapis.com",
+}
+
+// strings that google cloud does not allow in project IDs
+var restrictedIDWords = []string{"google", "ssl", "null", "undefined"}
+
+var (
+	projectIDPattern  = regexp.MustCompile(`^[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+	labelKeyPattern   = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,62}$`)
+	labelValuePattern = regexp.MustCompile(`^[a-z0-9_-]{0,63}$`)
+	labelInvalidChars = regexp.MustCompile(`[^a-z0-9_-]`)
+)
+
+// lintIssue is a single problem found by lintSpec
+type lintIssue struct {
+	Field   string // the part of the spec that has the problem, e.g. "labels.Team"
+	Message string // description of the problem
+	Fixable bool   // whether "gproj lint --fix" can fix it
+}
+
+// convert a string to something that is valid as a label key or value
+func sanitizeLabel(s string) string {
+	s = labelInvalidChars.ReplaceAllString(strings.ToLower(s), "-")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
+
+// expand a short API name such as "compute" to its full name
+func expandAPIName(api string) string {
+	if !strings.Contains(api, ".") {
+		return api + ".googleapis.com"
+	}
+	return api
+}
+
+// lintSpec looks for things in the spec that are legal but probably not what the user intended
+func lintSpec(spec *ProjectSpec) []lintIssue {
+	var issues []lintIssue
+	add := func(field string, fixable bool, format string, args ...interface{}) {
+		issues = append(issues, lintIssue{Field: field, Message: fmt.Sprintf(format, args...), Fixable: fixable})
+	}
+
+	// project ID and name
+	if !projectIDPattern.MatchString(spec.ID) {
+		add("id", false, "%q is not a valid project ID (6-30 lowercase letters, digits, or hyphens, starting with a letter)", spec.ID)
+	}
+	for _, word := range restrictedIDWords {
+		if strings.Contains(spec.ID, word) {
+			add("id", false, "project IDs may not contain %q", word)
+		}
+	}
+	if len(spec.Name) < 4 || len(spec.Name) > 30 {
+		add("name", false, "project names must be between 4 and 30 characters long")
+	}
+
+	// labels, in sorted order so that the output is deterministic
+	var keys []string
+	for k := range spec.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := spec.Labels[k]
+		if !labelKeyPattern.MatchString(k) {
+			add("labels."+k, true, "label key %q should be lowercase letters, digits, underscores, or hyphens, starting with a letter", k)
+		}
+		if !labelValuePattern.MatchString(v) {
+			add("labels."+k, true, "label value %q should be lowercase letters, digits, underscores, or hyphens", v)
+		}
+	}
+
+	// APIs
+	seen := make(map[string]bool)
+	for _, api := range spec.APIs {
+		full := expandAPIName(api)
+		if full != api {
+			add("apis", true, "%q is shorthand for %q; spelling it out avoids ambiguity", api, full)
+		}
+		if seen[full] {
+			add("apis", true, "%s is listed more than once", full)
+		}
+		seen[full] = true
+
+		if contains(costlyAPIs, full) && spec.Budget == nil {
+			add("apis", false, "%s can incur significant cost; consider setting a budget", full)
+		}
+	}
+
+	// billing
+	if billingStrategy(spec.Billing) == billingAuto {
+		add("billing", false, `"auto" picks whichever billing account happens to be the only open one; consider giving the billing account ID explicitly`)
+	}
+
+	// the billing export needs a billing account to export from
+	if spec.BillingExport != nil && billingStrategy(spec.Billing) == billingNone {
+		add("billingExport", false, "billing is not managed by gproj, so the project may not have a billing account to export")
+	}
+
+	// IAM members outside the allowed domains, which are often typos or personal accounts
+	if len(spec.AllowedMemberDomains) > 0 {
+		for _, member := range disallowedMembers(spec.IAM, spec.AllowedMemberDomains) {
+			add("iam", false, "%s is not in the allowed member domains", member)
+		}
+	}
+
+	// shared VPC
+	if spec.Compute != nil && spec.Compute.SharedVPC != nil {
+		vpc := spec.Compute.SharedVPC
+		switch {
+		case vpc.Host && vpc.HostProject != "":
+			add("compute.sharedVPC", false, "a project cannot be both a host and attached to host %s", vpc.HostProject)
+		case vpc.HostProject != "" && !contains(spec.DependsOn, vpc.HostProject):
+			add("dependsOn", false, "%s is the shared VPC host so should be listed here for gproj workspace to apply it first", vpc.HostProject)
+		}
+	}
+
+	// private service access needs a range large enough for google to carve subnets out of
+	if sn := spec.ServiceNetworking; sn != nil && sn.PrefixLength != 0 && (sn.PrefixLength < 8 || sn.PrefixLength > 24) {
+		add("serviceNetworking.prefixLength", false, "/%d is not a valid size for a private service access range; use /8 to /24", sn.PrefixLength)
+	}
+
+	// cloud SQL
+	for _, inst := range spec.CloudSQL {
+		if _, err := inst.withDefaults().databaseVersion(); err != nil {
+			add("cloudsql", false, "%s has an unknown engine %q", inst.Name, inst.Engine)
+		}
+	}
+
+	// generic resources
+	for i, r := range spec.Resources {
+		if r.Name == "" || r.API == "" || r.Version == "" || r.Method == "" {
+			add(fmt.Sprintf("resources[%d]", i), false, "resources need a name, api, version, and method")
+		}
+	}
+
+	// plugins
+	for i, p := range spec.Plugins {
+		if p.Name == "" || (p.Kind == "" && p.Command == "") {
+			add(fmt.Sprintf("plugins[%d]", i), false, "plugins need a name, and a kind or a command")
+		}
+	}
+
+	// API keys
+	for _, key := range spec.APIKeys {
+		switch {
+		case len(key.Referrers) > 0 && len(key.IPs) > 0:
+			add("apiKeys", false, "%s can be restricted to referrers or IPs but not both", key.Name)
+		case len(key.APIs) == 0 && len(key.Referrers) == 0 && len(key.IPs) == 0:
+			add("apiKeys", false, "%s is unrestricted, so anyone who finds it can use it with any API", key.Name)
+		}
+	}
+
+	// secrets belong in an encrypted spec
+	if spec.IdentityPlatform != nil && spec.IdentityPlatform.Google != nil && !spec.encrypted {
+		if spec.IdentityPlatform.Google.ClientSecret != "" {
+			add("identityPlatform.google.clientSecret", false, "the OAuth client secret is stored in plain text; consider encrypting the spec with sops")
+		}
+	}
+
+	// notes are not sent to google, but a note with no value is probably a mistake
+	var notes []string
+	for key := range spec.Notes {
+		notes = append(notes, key)
+	}
+	sort.Strings(notes)
+	for _, key := range notes {
+		if strings.TrimSpace(spec.Notes[key]) == "" {
+			add("notes."+key, false, "note has no value")
+		}
+	}
+
+	// lifecycle
+	if spec.Lifecycle != nil {
+		for _, field := range spec.Lifecycle.IgnoreChanges {
+			if !contains(ignorableFields, field) {
+				add("lifecycle.ignoreChanges", false, "%q cannot be ignored, only %s", field, strings.Join(ignorableFields, " and "))
+			}
+		}
+	}
+
+	return issues
+}
+
+// fixSpecFile rewrites the spec file with the auto-fixable issues fixed. It operates on the
+// raw YAML document so that the order of keys is preserved, although comments are lost.
+func fixSpecFile(spec *ProjectSpec) error {
+	if strings.EqualFold(filepath.Ext(spec.path), ".json") {
+		return fmt.Errorf("--fix is not supported for JSON specs")
+	}
+	if spec.encrypted {
+		return errSpecEncrypted
+	}
+
+	buf, err := fsys.ReadFile(spec.path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", spec.path, err)
+	}
+
+	var doc yaml.MapSlice
+	err = yaml.Unmarshal(buf, &doc)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %w", spec.path, err)
+	}
+
+	for i, item := range doc {
+		switch item.Key {
+		case "labels":
+			labels, ok := item.Value.(yaml.MapSlice)
+			if !ok {
+				continue
+			}
+			for j := range labels {
+				labels[j].Key = sanitizeLabel(fmt.Sprint(labels[j].Key))
+				labels[j].Value = sanitizeLabel(fmt.Sprint(labels[j].Value))
+			}
+			doc[i].Value = labels
+		case "apis":
+			apis, ok := item.Value.([]interface{})
+			if !ok {
+				continue
+			}
+			var fixed []interface{}
+			var seen []string
+			for _, api := range apis {
+				// bundles are left as they are, however they are written
+				name, ok := api.(string)
+				if _, bundle := bundleRef(name); !ok || bundle {
+					fixed = append(fixed, api)
+					continue
+				}
+				full := expandAPIName(name)
+				if !contains(seen, full) {
+					seen = append(seen, full)
+					fixed = append(fixed, full)
+				}
+			}
+			doc[i].Value = fixed
+		}
+	}
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("error marshalling fixed spec: %w", err)
+	}
+	return fsys.WriteFile(spec.path, out, filePerm)
+}
+
+func lint(ctx context.Context, args *args) error {
+	// find the project spec
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	issues := lintSpec(spec)
+	if args.Naming != "" {
+		nc, err := loadNamingConvention(args.Naming)
+		if err != nil {
+			return err
+		}
+		violations, err := nc.check(spec)
+		if err != nil {
+			return err
+		}
+		issues = append(issues, violations...)
+	}
+	if len(issues) == 0 {
+		fmt.Printf("%s: no issues found\n", spec.path)
+		return nil
+	}
+
+	var fixable int
+	for _, issue := range issues {
+		var suffix string
+		if issue.Fixable {
+			fixable++
+			suffix = " (fixable)"
+		}
+		fmt.Printf("%s: %s: %s%s\n", spec.path, issue.Field, issue.Message, suffix)
+	}
+
+	if args.Lint.Fix && fixable > 0 {
+		err = fixSpecFile(spec)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("fixed %d issues in %s (note that comments are not preserved)\n", fixable, spec.path)
+		if fixable == len(issues) {
+			return nil
+		}
+		return fmt.Errorf("%d issues in %s must be fixed by hand", len(issues)-fixable, spec.path)
+	}
+
+	return fmt.Errorf("found %d issues in %s", len(issues), spec.path)
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/storage/v1"
+)
+
+// how long a lock is held before others may assume its holder has crashed
+const lockTTL = 30 * time.Minute
+
+// how often a lock is extended while it is held, so that long applies keep it
+const lockRefreshInterval = lockTTL / 3
+
+// how long to keep trying to save state and release the lock once apply has finished or
+// been interrupted
+const cleanupTimeout = 30 * time.Second
+
+// cleanupContext returns a context in which to save state and release the lock. It is not
+// cancelled along with ctx, since an interrupt is exactly when these must still happen, but
+// gives up after cleanupTimeout so that an unreachable backend cannot hang the exit.
+func cleanupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+}
+
+// lockingBackend is implemented by state backends that support advisory locking
+type lockingBackend interface {
+	Lock(ctx context.Context) error
+	Refresh(ctx context.Context) error
+	Unlock(ctx context.Context) error
+	ForceUnlock(ctx context.Context) error
+}
+
+// LockInfo is the content of a lock object
+type LockInfo struct {
+	Holder  string    // identity of whoever holds the lock, e.g. "alex@laptop (pid 1234)"
+	Created time.Time // when the lock was acquired
+	Expires time.Time // after this time the lock may be taken over by others
+}
+
+// get a description of the current user and machine to record as the lock holder
+func lockHolder() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s@%s (pid %d)", name, host, os.Getpid())
+}
+
+func (b *gcsBackend) lockObject() string {
+	return b.object + ".lock"
+}
+
+// write the lock object, provided that its generation is still the one given, and
+// return its new generation
+func (b *gcsBackend) writeLock(ctx context.Context, info *LockInfo, generation int64) (int64, error) {
+	buf, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return 0, fmt.Errorf("error marshalling lock info to json: %w", err)
+	}
+
+	obj := storage.Object{Name: b.lockObject(), ContentType: "application/json"}
+	written, err := b.svc.Objects.Insert(b.bucket, &obj).
+		IfGenerationMatch(generation).
+		Media(bytes.NewReader(buf)).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return 0, err
+	}
+	return written.Generation, nil
+}
+
+// Lock acquires the lock, failing if someone else holds an unexpired lock
+func (b *gcsBackend) Lock(ctx context.Context) error {
+	now := time.Now()
+	info := LockInfo{
+		Holder:  lockHolder(),
+		Created: now,
+		Expires: now.Add(lockTTL),
+	}
+
+	// generation 0 means "only create the object if it does not already exist"
+	gen, err := b.writeLock(ctx, &info, 0)
+	if err == nil {
+		b.lockGen = gen
+		return nil
+	}
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != 412 {
+		return fmt.Errorf("error acquiring lock on %s: %w", b, err)
+	}
+
+	// someone else holds the lock, so find out who and whether it has expired
+	existing, generation, err := b.readLock(ctx)
+	if err != nil {
+		return err
+	}
+	if existing != nil && now.Before(existing.Expires) {
+		return fmt.Errorf(
+			"state at %s was locked by %s %s; if you are sure that nobody else is running gproj, run:\n  $ gproj force-unlock",
+			b, existing.Holder, humanAgo(existing.Created))
+	}
+
+	// the lock has expired, so delete it (only if nobody else got there first) and try again
+	fmt.Printf("taking over expired lock on %s\n", b)
+	err = b.svc.Objects.Delete(b.bucket, b.lockObject()).IfGenerationMatch(generation).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error removing expired lock on %s: %w", b, err)
+	}
+	return b.Lock(ctx)
+}
+
+// read the current lock object and its generation, returning nil if it does not exist
+func (b *gcsBackend) readLock(ctx context.Context) (*LockInfo, int64, error) {
+	obj, err := b.svc.Objects.Get(b.bucket, b.lockObject()).Context(ctx).Do()
+	if e, ok := err.(*googleapi.Error); ok && e.Code == 404 {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading lock on %s: %w", b, err)
+	}
+
+	resp, err := b.svc.Objects.Get(b.bucket, b.lockObject()).IfGenerationMatch(obj.Generation).Context(ctx).Download()
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading lock on %s: %w", b, err)
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error reading lock on %s: %w", b, err)
+	}
+
+	var info LockInfo
+	err = json.Unmarshal(buf, &info)
+	if err != nil {
+		// treat an unreadable lock as expired
+		return nil, obj.Generation, nil
+	}
+	return &info, obj.Generation, nil
+}
+
+// Refresh pushes back the expiry of a lock that was acquired by Lock, failing if the lock
+// has since been taken over by someone else
+func (b *gcsBackend) Refresh(ctx context.Context) error {
+	if b.lockGen == 0 {
+		return nil
+	}
+	info, _, err := b.readLock(ctx)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		info = &LockInfo{Holder: lockHolder(), Created: time.Now()}
+	}
+	info.Expires = time.Now().Add(lockTTL)
+
+	gen, err := b.writeLock(ctx, info, b.lockGen)
+	if e, ok := err.(*googleapi.Error); ok && e.Code == 412 {
+		b.lockGen = 0
+		return fmt.Errorf("lock on %s expired and was taken over by another run", b)
+	}
+	if err != nil {
+		return fmt.Errorf("error refreshing lock on %s: %w", b, err)
+	}
+	b.lockGen = gen
+	return nil
+}
+
+// Unlock releases a lock that was acquired by Lock. A lock that has since been taken over
+// by someone else is left alone.
+func (b *gcsBackend) Unlock(ctx context.Context) error {
+	if b.lockGen == 0 {
+		return nil
+	}
+	err := b.svc.Objects.Delete(b.bucket, b.lockObject()).IfGenerationMatch(b.lockGen).Context(ctx).Do()
+	b.lockGen = 0
+	if e, ok := err.(*googleapi.Error); ok && (e.Code == 412 || e.Code == 404) {
+		return fmt.Errorf("lock on %s expired and was taken over by another run, so it was not released", b)
+	}
+	if err != nil {
+		return fmt.Errorf("error releasing lock on %s: %w", b, err)
+	}
+	return nil
+}
+
+// ForceUnlock removes the lock regardless of who holds it
+func (b *gcsBackend) ForceUnlock(ctx context.Context) error {
+	existing, generation, err := b.readLock(ctx)
+	if err != nil {
+		return err
+	}
+	if generation == 0 {
+		fmt.Printf("state at %s is not locked\n", b)
+		return nil
+	}
+
+	err = b.svc.Objects.Delete(b.bucket, b.lockObject()).Context(ctx).Do()
+	if e, ok := err.(*googleapi.Error); ok && e.Code == 404 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error removing lock on %s: %w", b, err)
+	}
+
+	holder := "unknown"
+	if existing != nil {
+		holder = existing.Holder
+	}
+	fmt.Printf("removed lock on %s held by %s\n", b, holder)
+	return nil
+}
+
+// acquire the state lock if the backend supports locking, and return a function that releases it
+func lockState(ctx context.Context, backend stateBackend) (func(), error) {
+	lb, ok := backend.(lockingBackend)
+	if !ok {
+		return func() {}, nil
+	}
+
+	err := lb.Lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the lock from expiring for as long as we hold it
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lockRefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := lb.Refresh(ctx); err != nil {
+					fmt.Println("warning:", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		ctx, cancel := cleanupContext(ctx)
+		defer cancel()
+		if err := lb.Unlock(ctx); err != nil {
+			fmt.Println("warning:", err)
+		}
+	}, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/option"
+	"google.golang.org/api/storage/v1"
+)
+
+// fakeLockingBackend records the calls made to it
+type fakeLockingBackend struct {
+	localBackend
+	locked    bool
+	unlockErr error // the error of the context that Unlock was given
+	deadline  bool  // whether the context that Unlock was given had a deadline
+}
+
+func (b *fakeLockingBackend) Lock(ctx context.Context) error {
+	b.locked = true
+	return nil
+}
+
+func (b *fakeLockingBackend) Refresh(ctx context.Context) error { return nil }
+
+func (b *fakeLockingBackend) Unlock(ctx context.Context) error {
+	b.locked = false
+	b.unlockErr = ctx.Err()
+	_, b.deadline = ctx.Deadline()
+	return nil
+}
+
+func (b *fakeLockingBackend) ForceUnlock(ctx context.Context) error {
+	b.locked = false
+	return nil
+}
+
+func TestLockStateUnlocksAfterInterrupt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	backend := &fakeLockingBackend{}
+	unlock, err := lockState(ctx, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !backend.locked {
+		t.Fatal("state was not locked")
+	}
+
+	cancel() // as on Ctrl-C
+	unlock()
+	if backend.locked {
+		t.Error("state was not unlocked")
+	}
+	if backend.unlockErr != nil {
+		t.Errorf("Unlock was given a context that was already done: %v", backend.unlockErr)
+	}
+	if !backend.deadline {
+		t.Error("Unlock was given a context without a deadline")
+	}
+}
+
+func TestLockStateWithoutLocking(t *testing.T) {
+	unlock, err := lockState(context.Background(), &localBackend{path: "state.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
+
+// fakeStorage is an in-memory stand-in for the parts of the cloud storage API that the
+// gcs backend uses, including generation preconditions
+type fakeStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	gens    map[string]int64
+	nextGen int64
+}
+
+func (f *fakeStorage) put(name string, data []byte) int64 {
+	f.nextGen++
+	f.objects[name] = data
+	f.gens[name] = f.nextGen
+	return f.nextGen
+}
+
+// check the ifGenerationMatch precondition, where zero means the object must not exist
+func (f *fakeStorage) preconditionOK(req *http.Request, name string) bool {
+	s := req.URL.Query().Get("ifGenerationMatch")
+	if s == "" {
+		return true
+	}
+	want, _ := strconv.ParseInt(s, 10, 64)
+	return f.gens[name] == want
+}
+
+func (f *fakeStorage) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fail := func(code int) {
+		w.WriteHeader(code)
+		fmt.Fprintf(w, `{"error": {"code": %d, "message": "%s"}}`, code, http.StatusText(code))
+	}
+	reply := func(name string) {
+		json.NewEncoder(w).Encode(&storage.Object{Name: name, Generation: f.gens[name]})
+	}
+
+	if req.Method == http.MethodPost && strings.HasPrefix(req.URL.Path, "/upload/storage/v1/b/bucket/o") {
+		// multipart uploads send the object metadata followed by its content
+		_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if err != nil {
+			fail(http.StatusBadRequest)
+			return
+		}
+		r := multipart.NewReader(req.Body, params["boundary"])
+		var obj storage.Object
+		part, err := r.NextPart()
+		if err == nil {
+			err = json.NewDecoder(part).Decode(&obj)
+		}
+		var data []byte
+		if err == nil {
+			part, err = r.NextPart()
+		}
+		if err == nil {
+			data, err = io.ReadAll(part)
+		}
+		if err != nil {
+			fail(http.StatusBadRequest)
+			return
+		}
+		if !f.preconditionOK(req, obj.Name) {
+			fail(http.StatusPreconditionFailed)
+			return
+		}
+		f.put(obj.Name, data)
+		reply(obj.Name)
+		return
+	}
+
+	name := strings.TrimPrefix(req.URL.Path, "/storage/v1/b/bucket/o/")
+	if _, ok := f.objects[name]; !ok {
+		fail(http.StatusNotFound)
+		return
+	}
+	if !f.preconditionOK(req, name) {
+		fail(http.StatusPreconditionFailed)
+		return
+	}
+	switch {
+	case req.Method == http.MethodGet && req.URL.Query().Get("alt") == "media":
+		w.Write(f.objects[name])
+	case req.Method == http.MethodGet:
+		reply(name)
+	case req.Method == http.MethodDelete:
+		delete(f.objects, name)
+		delete(f.gens, name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		fail(http.StatusMethodNotAllowed)
+	}
+}
+
+// start a fake storage server and return a function that creates backends that use it
+func newFakeStorage(t *testing.T) (*fakeStorage, func() *gcsBackend) {
+	f := &fakeStorage{objects: make(map[string][]byte), gens: make(map[string]int64)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, func() *gcsBackend {
+		svc, err := storage.NewService(context.Background(),
+			option.WithEndpoint(srv.URL+"/storage/v1/"),
+			option.WithoutAuthentication())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &gcsBackend{svc: svc, bucket: "bucket", object: "p.gproj.state.json"}
+	}
+}
+
+func TestGCSBackendLocking(t *testing.T) {
+	ctx := context.Background()
+	f, newBackend := newFakeStorage(t)
+	a, b := newBackend(), newBackend()
+
+	if err := a.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.objects[a.lockObject()]; !ok {
+		t.Fatal("Lock did not create the lock object")
+	}
+	if err := b.Lock(ctx); err == nil || !strings.Contains(err.Error(), "was locked by") {
+		t.Fatalf("second Lock returned %v, want an error saying who holds the lock", err)
+	}
+	if err := a.Refresh(ctx); err != nil {
+		t.Fatalf("error refreshing a lock that is still held: %v", err)
+	}
+	if err := a.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.objects[a.lockObject()]; ok {
+		t.Fatal("Unlock did not remove the lock object")
+	}
+	if err := b.Lock(ctx); err != nil {
+		t.Fatalf("error acquiring a lock that was released: %v", err)
+	}
+}
+
+func TestGCSBackendTakesOverExpiredLock(t *testing.T) {
+	ctx := context.Background()
+	f, newBackend := newFakeStorage(t)
+	backend := newBackend()
+
+	expired, err := json.Marshal(&LockInfo{
+		Holder:  "someone@elsewhere (pid 1)",
+		Created: time.Now().Add(-2 * lockTTL),
+		Expires: time.Now().Add(-lockTTL),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.put(backend.lockObject(), expired)
+
+	if err := backend.Lock(ctx); err != nil {
+		t.Fatalf("error taking over an expired lock: %v", err)
+	}
+	if backend.lockGen != f.gens[backend.lockObject()] {
+		t.Errorf("holding lock generation %d, but the lock object is at generation %d", backend.lockGen, f.gens[backend.lockObject()])
+	}
+}
+
+func TestGCSBackendLosesLockToForceUnlock(t *testing.T) {
+	ctx := context.Background()
+	f, newBackend := newFakeStorage(t)
+	a, b := newBackend(), newBackend()
+
+	if err := a.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.ForceUnlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Refresh(ctx); err == nil {
+		t.Error("refreshing a lock that was taken over succeeded")
+	}
+	if err := a.Unlock(ctx); err != nil {
+		t.Errorf("error from Unlock after losing the lock: %v", err)
+	}
+	if f.gens[b.lockObject()] != b.lockGen {
+		t.Error("releasing a lock that was taken over removed the new holder's lock")
+	}
+}
+
+func TestGCSBackendRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	_, newBackend := newFakeStorage(t)
+	backend := newBackend()
+
+	state, err := backend.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Resources) != 0 {
+		t.Fatalf("missing state object loaded %d resources, want 0", len(state.Resources))
+	}
+
+	state.ProjectID = "p"
+	state.Record(kindDataset, datasetName("p", "events"), "US")
+	if err := backend.Save(ctx, state); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := backend.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ProjectID != "p" || loaded.Find(kindDataset, datasetName("p", "events")) == nil {
+		t.Errorf("state did not survive a round trip: %+v", loaded)
+	}
+}
@@ -6,399 +6,262 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"os/signal"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alexflint/go-arg"
-	"github.com/kr/pretty"
-	"google.golang.org/api/cloudbilling/v1"
 	"google.golang.org/api/cloudresourcemanager/v1"
 	"google.golang.org/api/googleapi"
-	"google.golang.org/api/option"
 	"google.golang.org/api/serviceusage/v1"
-	"gopkg.in/yaml.v2"
 )
 
-const gprojFile = "googlecloudproject.yaml"
-
-var ErrSpecNotFound = errors.New(gprojFile + " file not found")
-
-func findProjectSpec() (string, error) {
-	path, err := os.Getwd()
-	if err != nil {
-		return "", err
-	}
-
-	for i := 0; i < 100; i++ {
-		x := filepath.Join(path, gprojFile)
-		if st, err := os.Stat(x); err == nil && st.Mode().IsRegular() {
-			return x, nil
-		}
-
-		parent := filepath.Dir(path)
-		if parent == path {
-			return "", ErrSpecNotFound
-		}
-
-		path = parent
-	}
-	return "", errors.New("took more than 100 steps up parent hierarchy")
-}
-
-// ProjectSpec models the googlecloudproject.yaml file
-type ProjectSpec struct {
-	Name    string            // human readable name of the project
-	ID      string            // ID of the project (must also be input by hand)
-	Number  int               // Project number (will be filled in by gcloud apply)
-	Labels  map[string]string // arbitrary key/value labels to assign to the project
-	APIs    []string
-	Billing string // ID of billing account, e.g. "012345-6789AB-CDEFG0" - leave empty to use default
-}
-
-func readProjectSpec(specPath string) (*ProjectSpec, error) {
-	// if no spec path given on command line then work our way up from current dir
-	if specPath == "" {
-		var err error
-		specPath, err = findProjectSpec()
-		if err != nil {
-			return nil, fmt.Errorf("error finding project specification: %w", err)
-		}
-	}
-
-	// open the file
-	f, err := os.Open(specPath)
-	if err != nil {
-		return nil, fmt.Errorf("error opening project spec: %w", err)
-	}
-	defer f.Close()
-
-	// decode it
-	var spec ProjectSpec
-	err = yaml.NewDecoder(f).Decode(&spec)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing project spec at %s: %w", specPath, err)
-	}
-	return &spec, nil
-}
-
+// wait for a project operation to complete
 func waitForCreate(
 	ctx context.Context,
 	svc *cloudresourcemanager.OperationsService,
 	op *cloudresourcemanager.Operation) error {
 
-	name := op.Name
-
 	if op.Error != nil {
-		return fmt.Errorf("error performing operation: %v %v", op.Error.Code, op.Error.Message)
+		return newOperationError(op.Error.Code, op.Error.Message, op.Error.Details)
 	}
 	if op.Done {
 		return nil
 	}
+	return poll(ctx, "operation "+op.Name, createChecker(ctx, svc, op.Name))
+}
 
-	t := time.NewTicker(400 * time.Millisecond)
-	defer t.Stop()
-	for range t.C {
+// make a function that checks on a project operation, for use with poll
+func createChecker(ctx context.Context, svc *cloudresourcemanager.OperationsService, name string) func() (bool, error) {
+	return func() (bool, error) {
 		op, err := svc.Get(name).Context(ctx).Do()
 		if err != nil {
-			return fmt.Errorf("error getting operation info: %w", err)
+			return false, fmt.Errorf("error getting operation info: %w", err)
 		}
 		if op.Error != nil {
-			return fmt.Errorf("error performing operation: %v %v", op.Error.Code, op.Error.Message)
-		}
-		if op.Done {
-			return nil
+			return false, newOperationError(op.Error.Code, op.Error.Message, op.Error.Details)
 		}
+		return op.Done, nil
 	}
-
-	return errors.New("ticker chanel was closed in waitForCreate")
 }
 
+// wait for an API enablement operation to complete
 func waitForEnable(
 	ctx context.Context,
 	svc *serviceusage.OperationsService,
 	op *serviceusage.Operation) error {
 
-	name := op.Name
-
 	if op.Error != nil {
-		return fmt.Errorf("error performing operation: %v %v", op.Error.Code, op.Error.Message)
+		return newOperationError(op.Error.Code, op.Error.Message, op.Error.Details)
 	}
 	if op.Done {
 		return nil
 	}
+	return poll(ctx, "operation "+op.Name, enableChecker(ctx, svc, op.Name))
+}
 
-	t := time.NewTicker(400 * time.Millisecond)
-	defer t.Stop()
-	for range t.C {
+// make a function that checks on an API enablement operation, for use with poll
+func enableChecker(ctx context.Context, svc *serviceusage.OperationsService, name string) func() (bool, error) {
+	return func() (bool, error) {
 		op, err := svc.Get(name).Context(ctx).Do()
 		if err != nil {
-			return fmt.Errorf("error getting operation info: %w", err)
+			return false, fmt.Errorf("error getting operation info: %w", err)
 		}
 		if op.Error != nil {
-			return fmt.Errorf("error performing operation: %v %v", op.Error.Code, op.Error.Message)
-		}
-		if op.Done {
-			return nil
+			return false, newOperationError(op.Error.Code, op.Error.Message, op.Error.Details)
 		}
+		return op.Done, nil
 	}
-
-	return errors.New("ticker chanel was closed in waitForEnable")
 }
 
 func apis(ctx context.Context, args *args) error {
-	creds, err := googleCredentials(ctx)
-	if err != nil {
-		return err
-	}
-
 	// find the project spec
-	spec, err := readProjectSpec(args.Spec)
+	spec, err := readProjectSpec(args)
 	if err != nil {
 		return err
 	}
 
-	// create the resourcemanager service with which we will look up the project
-	resources, err := cloudresourcemanager.NewService(ctx,
-		option.WithScopes(cloudresourcemanager.CloudPlatformScope),
-		option.WithCredentials(creds))
+	apis, err := projectAPIs(ctx, args, spec)
 	if err != nil {
 		return err
 	}
 
-	// fetch the project
-	project, err := resources.Projects.Get(spec.ID).Context(ctx).Do()
-	if err != nil {
-		fmt.Println("error getting the project:", err)
-		return fmt.Errorf("cannot list the available APIs before the project has been created... eep sorry")
+	// select the APIs to print
+	var selected []*api
+	for _, api := range apis {
+		if serviceKind(api.Name) != serviceGoogle && !args.APIs.All {
+			continue
+		}
+		selected = append(selected, api)
 	}
 
-	// fetch the list of available APIs from google cloud or from cache
-	apis, err := availableAPIs(ctx, project.ProjectNumber)
-	if err != nil {
-		return fmt.Errorf("error fetching available APIs: %w", err)
+	// group by category if requested, keeping the alphabetical order within each category
+	if args.APIs.Group {
+		sort.SliceStable(selected, func(i, j int) bool {
+			return selected[i].Category < selected[j].Category
+		})
 	}
 
 	// print the APIs
-	for _, api := range apis {
-		if !strings.HasSuffix(api.Name, ".googleapis.com") && !args.APIs.All {
-			continue
+	var category string
+	for i, api := range selected {
+		if args.APIs.Group && (i == 0 || api.Category != category) {
+			category = api.Category
+			fmt.Printf("\n%s:\n", category)
+		}
+
+		name := api.Name
+		if api.FreeTier {
+			name += " (free tier)"
+		}
+		if args.APIs.Group {
+			name = "  " + name
 		}
+
 		if args.APIs.Description {
-			fmt.Printf("%-50s %s\n", api.Name, api.Summary)
+			fmt.Printf("%-50s %s\n", name, api.Summary)
 		} else {
-			fmt.Println(api.Name)
+			fmt.Println(name)
 		}
 	}
 
 	return nil
 }
 
-func apply(ctx context.Context, args *args) error {
-	// we do some hacky stuff to remove quota_project_id from the credentials json... ouch
-	creds, err := googleCredentials(ctx)
-	if err != nil {
-		return err
+// get the APIs available to the project in the spec, from cache if gproj is offline
+func projectAPIs(ctx context.Context, args *args, spec *ProjectSpec) ([]*api, error) {
+	if args.Offline {
+		if args.APIs.Refresh {
+			return nil, fmt.Errorf("--refresh cannot be used with --offline")
+		}
+		return offlineAPIs(spec.ID)
 	}
 
-	// find the project spec
-	spec, err := readProjectSpec(args.Spec)
+	conn, err := connect(ctx, args)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	resources, err := cloudresourcemanager.NewService(ctx,
-		option.WithScopes(cloudresourcemanager.CloudPlatformScope),
-		option.WithCredentials(creds))
+	// create the resourcemanager service with which we will look up the project
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// now enable the appropriate APIs
-	apis, err := serviceusage.NewService(ctx)
+	// fetch the project number
+	number, err := resolveProjectNumber(ctx, resources, spec.ID)
 	if err != nil {
-		return fmt.Errorf("error initializing the service usage API: %w", err)
+		fmt.Println("error getting the project:", err)
+		return nil, fmt.Errorf("cannot list the available APIs before the project has been created... eep sorry")
 	}
 
-	// fetch the project, creating it if necessary
-	project, err := resources.Projects.Get(spec.ID).Context(ctx).Do()
+	// fetch the list of available APIs from google cloud or from cache
+	apis, err := availableAPIs(ctx, conn, number, args.APIs.Refresh)
 	if err != nil {
-		// we get "403 Forbidden" if the project does not exist since projects IDs
-		// are global and Google doesn't want to reveal whether the project exists
-		// in someone else's account or not
-		if e, ok := err.(*googleapi.Error); ok && e.Code == 403 {
-			fmt.Printf("project %s does not exist, attempting to create it...\n", spec.ID)
-
-			if len(spec.Name) < 4 {
-				return fmt.Errorf("project name %q invalid: must be at least 4 characters long (required by Google Cloud)", spec.Name)
-			}
-
-			project = &cloudresourcemanager.Project{
-				Name:      spec.Name,
-				ProjectId: spec.ID,
-				Labels:    make(map[string]string),
-			}
-
-			// deep copy the labels so that we can safely modify the map
-			for k, v := range spec.Labels {
-				project.Labels[k] = v
-			}
-			project.Labels["managed-by"] = "gproj"
-
-			// creating projects is a long-running operation so we have to poll
-			createOp, err := resources.Projects.Create(project).Context(ctx).Do()
-			if err != nil {
-				return fmt.Errorf("error creating project: %w", err)
-			}
-
-			waitCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
-			defer cancel()
-
-			err = waitForCreate(waitCtx, resources.Operations, createOp)
-			if err != nil {
-				return fmt.Errorf("error creating project: %w", err)
-			}
-
-			// now fetch the final project info containing the data filled in by the server
-			project, err = resources.Projects.Get(spec.ID).Context(ctx).Do()
-			if err != nil {
-				return fmt.Errorf("error getting project info right after creating it: %w", err)
-			}
-
-			// enable the billing API, which we need in order to enable further APIs
-			projNum := formatProjectNumber(project.ProjectNumber)
-			enableOp, err := apis.Services.BatchEnable(projNum, &serviceusage.BatchEnableServicesRequest{
-				ServiceIds: []string{"cloudbilling.googleapis.com"},
-			}).Context(ctx).Do()
-			if err != nil {
-				return fmt.Errorf("error in API call to enable APIs: %w", err)
-			}
-
-			err = waitForEnable(ctx, apis.Operations, enableOp)
-			if err != nil {
-				return fmt.Errorf("error enabling billing API: %v", err)
-			}
-
-			fmt.Printf("created project %s\n", spec.ID)
-		} else {
-			return err
-		}
+		return nil, fmt.Errorf("error fetching available APIs: %w", err)
 	}
-
-	// initialize the billing service
-	billing, err := cloudbilling.NewService(ctx, option.WithCredentials(creds))
-	if err != nil {
-		return fmt.Errorf("error initializing the billing API: %w", err)
+	if fetched, ok := catalogFetched(number); ok && time.Since(fetched) > time.Minute {
+		fmt.Printf("API catalog refreshed %s; use --refresh to fetch it again\n", humanAgo(fetched))
 	}
+	return apis, nil
+}
 
-	// get billing info for this account so that we know whether we need to change it
-	projNum := formatProjectNumber(project.ProjectNumber)
-	billingInfo, err := billing.Projects.GetBillingInfo(projNum).Context(ctx).Do()
-	if err != nil {
-		return fmt.Errorf("error getting billing info for %s: %w", spec.ID, err)
-	}
+// the most APIs that can be enabled in a single BatchEnable call
+const maxBatchEnable = 20
 
-	// find the requested billing account or look up the default
-	account := spec.Billing
-	if account == "enable" {
-		fmt.Println("looking up available billing accounts...")
-		accounts, err := billing.BillingAccounts.List().Context(ctx).Do()
-		if err != nil {
-			return fmt.Errorf("error listing billing accounts: %w", err)
-		}
+// batchEnable enables APIs outside of an apply, and waits until they are reported as enabled
+func batchEnable(ctx context.Context, usage *serviceusage.Service, projectNumber int64, toEnable []string) error {
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
 
-		// make a list of open accounts
-		var openAccounts []*cloudbilling.BillingAccount
-		for _, a := range accounts.BillingAccounts {
-			if a.Open {
-				openAccounts = append(openAccounts, a)
-			}
+	// a batch can contain at most 20 APIs, so submit several batches and wait for them together
+	checks := make(map[string]func() (bool, error))
+	for i := 0; i < len(toEnable); i += maxBatchEnable {
+		batch := toEnable[i:]
+		if len(batch) > maxBatchEnable {
+			batch = batch[:maxBatchEnable]
 		}
-
-		if len(openAccounts) == 1 {
-			fmt.Printf("using the only open billing account: %s (%s)\n", openAccounts[0].Name, openAccounts[0].DisplayName)
-			account = openAccounts[0].Name
-		} else {
-			return fmt.Errorf(
-				"no billing account in spec and found %d billing accounts (of which %d were open)",
-				len(accounts.BillingAccounts),
-				len(openAccounts))
-		}
-	}
-
-	// update the billing account
-	if billingInfo.BillingAccountName != account {
-		fmt.Printf("updating billing account to %s\n", account)
-		updatedBilling, err := billing.Projects.UpdateBillingInfo(projNum, &cloudbilling.ProjectBillingInfo{
-			BillingAccountName: account,
+		op, err := usage.Services.BatchEnable(formatProjectNumber(projectNumber), &serviceusage.BatchEnableServicesRequest{
+			ServiceIds: batch,
 		}).Context(ctx).Do()
 		if err != nil {
-			return fmt.Errorf("error updating billing info: %w", err)
+			return fmt.Errorf("error in API call to enable APIs: %w", err)
 		}
-
-		// check that billing is now enabled
-		if !updatedBilling.BillingEnabled {
-			return fmt.Errorf(
-				"billing account was updated but API response shows billing still not enabled:\n%s",
-				pretty.Sprint(updatedBilling))
+		if !op.Done {
+			checks["operation "+op.Name] = enableChecker(waitCtx, usage.Operations, op.Name)
 		}
+	}
 
-		fmt.Println("updated billing info")
+	err := pollAll(waitCtx, checks)
+	if err != nil {
+		return fmt.Errorf("error enabling APIs: %w", err)
 	}
+	err = verifyEnabled(waitCtx, usage, projectNumber, toEnable)
+	if err != nil {
+		return fmt.Errorf("error enabling APIs: %w", err)
+	}
+	return nil
+}
 
-	// now make a list of APIs to enable
-	var toEnable []string
-	for _, requestedAPI := range spec.APIs {
-		if !strings.Contains(requestedAPI, ".") {
-			repl := requestedAPI + ".googleapis.com"
-			fmt.Printf("assuming that %q means %q\n", requestedAPI, repl)
-			requestedAPI = repl
-		}
+// enable APIs given on the command line, for one-off changes outside of the spec
+func enableAPIs(ctx context.Context, args *args) error {
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
 
-		toEnable = append(toEnable, requestedAPI)
+	// find the project spec
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
 	}
 
-	if len(toEnable) > 0 {
-		fmt.Printf("enabling %d APIs:\n", len(toEnable))
-		for _, api := range toEnable {
-			fmt.Printf("  %s\n", api)
-		}
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
 
-		if len(toEnable) > 20 {
-			return fmt.Errorf("cannot enable more than 20 APIs at a time")
-		}
+	usage, err := serviceusage.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the service usage API: %w", err)
+	}
 
-		// do a batch update
-		enableOp, err := apis.Services.BatchEnable(projNum, &serviceusage.BatchEnableServicesRequest{
-			ServiceIds: toEnable,
-		}).Context(ctx).Do()
-		if err != nil {
-			return fmt.Errorf("error in API call to enable APIs: %w", err)
-		}
+	project, err := resources.Projects.Get(spec.ID).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error getting project %s: %w", spec.ID, err)
+	}
 
-		waitCtx, cancel := context.WithTimeout(ctx, 5*time.Minute) // this can really take a while
-		defer cancel()
+	var toEnable []string
+	for _, api := range args.APIs.Enable.APIs {
+		toEnable = append(toEnable, expandAPIName(api))
+	}
 
-		fmt.Println("this may take a minute or two...")
-		err = waitForEnable(waitCtx, apis.Operations, enableOp)
-		if err != nil {
-			return fmt.Errorf("error enabling %d APIs: %w", len(toEnable), err)
-		}
+	missing, err := missingServices(ctx, usage, project.ProjectNumber, toEnable)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s not found in the service catalog (%s)", missing[0], missingServiceHint(missing[0]))
 	}
 
-	// TODO: disable API that have been removed from the config
+	err = batchEnable(ctx, usage, project.ProjectNumber, toEnable)
+	if err != nil {
+		return err
+	}
 
-	fmt.Println("success")
+	fmt.Printf("enabled %s in %s\n", strings.Join(toEnable, ", "), spec.ID)
 	return nil
 }
 
 func gcloud(ctx context.Context, args *args) error {
 	// read the project spec
-	spec, err := readProjectSpec(args.Spec)
-	// if there is no .gcloud file so do nothing, or else "gcloud" will be inoperable
+	spec, err := readProjectSpec(args)
+	// if there is no spec then do not add any arguments, or else "gcloud" will be inoperable
 	if errors.Is(err, ErrSpecNotFound) {
 		if args.Verbose {
-			fmt.Println("no .gcloud file found, ignoring")
+			fmt.Println("no " + gprojFile + " found, ignoring")
 		}
 	} else if err != nil {
 		return err
@@ -419,7 +282,7 @@ func gcloud(ctx context.Context, args *args) error {
 	}
 
 	// run the subcommand
-	gcloudPath, err := exec.LookPath("gcloud")
+	gcloudPath, err := findGcloud()
 	if err != nil {
 		return err
 	}
@@ -448,27 +311,76 @@ func gcloud(ctx context.Context, args *args) error {
 }
 
 func cmdDelete(ctx context.Context, args *args) error {
-	// we do some hacky stuff to remove quota_project_id from the credentials json... ouch
-	creds, err := googleCredentials(ctx)
+	conn, err := connect(ctx, args)
 	if err != nil {
 		return err
 	}
 
 	// find the project spec
-	spec, err := readProjectSpec(args.Spec)
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
 	if err != nil {
 		return err
 	}
 
-	resources, err := cloudresourcemanager.NewService(ctx,
-		option.WithScopes(cloudresourcemanager.CloudPlatformScope),
-		option.WithCredentials(creds))
+	err = spec.checkDestroyAllowed()
 	if err != nil {
 		return err
 	}
 
+	if args.Delete.After != "" && args.Delete.UnlinkBilling {
+		return fmt.Errorf("--unlink-billing cannot be combined with --after, since the project keeps running until it is deleted")
+	}
+
+	var project *cloudresourcemanager.Project
+	if !args.Delete.Adopt || args.Delete.After != "" || args.Delete.Cancel {
+		project, err = resources.Projects.Get(spec.ID).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("error getting project %s: %w", spec.ID, err)
+		}
+	}
+	if !args.Delete.Adopt {
+		err = checkManaged(project)
+		if err != nil {
+			return err
+		}
+	}
+
+	if args.Delete.Cancel {
+		return cancelDeletion(ctx, resources, project)
+	}
+
+	if !args.Delete.Yes {
+		ok, err := confirmByTyping(args, spec.ID, "--yes")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("confirmation did not match, not deleting anything")
+		}
+	}
+
+	if args.Delete.After != "" {
+		return scheduleDeletion(ctx, resources, project, args.Delete.After)
+	}
+
+	if args.Delete.UnlinkBilling {
+		err = unlinkBilling(ctx, conn, spec.ID)
+		if err != nil {
+			return err
+		}
+	}
+
 	_, err = resources.Projects.Delete(spec.ID).Context(ctx).Do()
 	if err != nil {
+		// point out the liens if that is why the project could not be deleted
+		if found, lienErr := projectLiens(ctx, resources, spec.ID); lienErr == nil && len(found) > 0 {
+			return fmt.Errorf("%w\nproject %s has %d lien(s), which can be listed with gproj liens", err, spec.ID, len(found))
+		}
 		return err
 	}
 
@@ -476,22 +388,68 @@ func cmdDelete(ctx context.Context, args *args) error {
 	return nil
 }
 
+// delete a project without asking for confirmation but subject to the same checks as
+// gproj delete, succeeding if the project is already gone. This is for the operator, the
+// server, and scheduled deletions, which have nobody to ask. If unlink is set then the
+// project is detached from its billing account first, as with gproj delete --unlink-billing.
+func deleteManagedProject(ctx context.Context, args *args, spec *ProjectSpec, unlink bool) error {
+	err := spec.checkDestroyAllowed()
+	if err != nil {
+		return err
+	}
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
+	project, err := resources.Projects.Get(spec.ID).Context(ctx).Do()
+	if e, ok := err.(*googleapi.Error); ok && e.Code == 404 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error getting project %s: %w", spec.ID, err)
+	}
+	if project.LifecycleState == "DELETE_REQUESTED" {
+		return nil
+	}
+	err = checkManaged(project)
+	if err != nil {
+		return err
+	}
+	if unlink {
+		err = unlinkBilling(ctx, conn, spec.ID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = resources.Projects.Delete(spec.ID).Context(ctx).Do()
+	if err != nil {
+		// point out the liens if that is why the project could not be deleted
+		if found, lienErr := projectLiens(ctx, resources, spec.ID); lienErr == nil && len(found) > 0 {
+			return fmt.Errorf("error deleting project %s: %w; it has %d lien(s), which can be listed with gproj liens", spec.ID, err, len(found))
+		}
+		return fmt.Errorf("error deleting project %s: %w", spec.ID, err)
+	}
+	logf("deleted project %s", spec.ID)
+	return nil
+}
+
 func undelete(ctx context.Context, args *args) error {
-	// we do some hacky stuff to remove quota_project_id from the credentials json... ouch
-	creds, err := googleCredentials(ctx)
+	conn, err := connect(ctx, args)
 	if err != nil {
 		return err
 	}
 
 	// find the project spec
-	spec, err := readProjectSpec(args.Spec)
+	spec, err := readProjectSpec(args)
 	if err != nil {
 		return err
 	}
 
-	resources, err := cloudresourcemanager.NewService(ctx,
-		option.WithScopes(cloudresourcemanager.CloudPlatformScope),
-		option.WithCredentials(creds))
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
 	if err != nil {
 		return err
 	}
@@ -506,22 +464,105 @@ func undelete(ctx context.Context, args *args) error {
 	return nil
 }
 
+func forceUnlock(ctx context.Context, args *args) error {
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	// find the project spec
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	backend, err := newStateBackend(ctx, conn, spec)
+	if err != nil {
+		return err
+	}
+	if backend == nil {
+		return errors.New("the spec does not configure a state backend")
+	}
+
+	lb, ok := backend.(lockingBackend)
+	if !ok {
+		return fmt.Errorf("state backend %s does not support locking", backend)
+	}
+	return lb.ForceUnlock(ctx)
+}
+
 // args for "gproj apply", which updates the project, the APIs, and the billing account
 type applyArgs struct {
+	Prune          bool     `help:"delete resources created by gproj that are no longer in the spec"`
+	Parallelism    int      `default:"4" help:"maximum number of steps to run at once"`
+	Report         string   `help:"write a JSON report of every action taken to this path"`
+	Adopt          bool     `help:"manage an existing project even though it was not created by gproj"`
+	OwnerLabel     bool     `arg:"--owner-label" help:"when creating the project, label it with the email of the current user and the gproj version"`
+	PlanFile       string   `arg:"positional" help:"apply a plan written by gproj plan --out instead of the spec"`
+	FromRequest    string   `arg:"--from-request" help:"apply a request written by gproj request instead of the spec"`
+	RequestKey     string   `arg:"--request-key,env:GPROJ_REQUEST_KEY" help:"key with which the request given by --from-request was signed"`
+	AllowedPlugins []string `arg:"--allowed-plugins" help:"kinds of plugin that specs in requests may use, which run from the PATH with the credentials of whoever applies the request"`
+	Notify         string   `help:"set to \"desktop\" to show a desktop notification when apply finishes"`
+	NotifyWebhook  string   `arg:"--notify-webhook,env:GPROJ_NOTIFY_WEBHOOK" help:"post to this slack or other webhook URL when apply finishes"`
+	VerifyKey      string   `arg:"--verify-key,env:GPROJ_VERIFY_KEY" help:"apply only if the spec or plan is signed with the private half of this cosign or minisign public key"`
+	Signature      string   `help:"detached signature of the spec or plan (default: the file with .sig, or .minisig for minisign, appended)"`
 }
 
 // args for "gproj delete", which deletes the project
 type deleteArgs struct {
+	Yes           bool   `help:"do not ask for confirmation"`
+	Adopt         bool   `help:"delete the project even though it was not created by gproj"`
+	UnlinkBilling bool   `arg:"--unlink-billing" help:"unlink the billing account first so that charges stop immediately"`
+	After         string `help:"schedule the deletion for this long from now, e.g. 7d or 2w, for gproj reminders --execute to carry out"`
+	Cancel        bool   `help:"cancel a deletion scheduled with --after"`
+}
+
+// args for "gproj destroy", which deletes the resources in the spec and then the project
+type destroyArgs struct {
+	Adopt         bool `help:"destroy the project even though it was not created by gproj"`
+	UnlinkBilling bool `arg:"--unlink-billing" help:"unlink the billing account before deleting the project so that charges stop immediately"`
+	ForceEmpty    bool `arg:"--force-empty" help:"delete the objects in buckets so that the buckets can be deleted"`
+	AssumeOwned   bool `arg:"--assume-owned" help:"without state, delete every resource in the spec even if gproj did not create it"`
 }
 
 // args for "gproj undelete", which undeletes a project (within 30 days of deletion)
 type undeleteArgs struct {
 }
 
+// args for "gproj force-unlock", which removes a stale lock on the state
+type forceUnlockArgs struct {
+}
+
+// args for "gproj lint", which checks the spec for likely mistakes
+type lintArgs struct {
+	Fix bool `help:"rewrite the spec to fix the issues that can be fixed automatically"`
+}
+
+// args for "gproj doctor", which checks that the environment is set up for gproj
+type doctorArgs struct {
+}
+
+// args for "gproj migrate-spec", which upgrades the spec to the current layout
+type migrateSpecArgs struct {
+	DryRun bool `arg:"--dry-run" help:"print the upgraded spec instead of rewriting the file"`
+}
+
+// args for "gproj schema", which prints a JSON schema for the spec
+type schemaArgs struct {
+}
+
+// args for "gproj apis enable"
+type apisEnableArgs struct {
+	APIs []string `arg:"positional,required" help:"APIs to enable, e.g. logging or logging.googleapis.com"`
+}
+
 // args for "gproj apis", which lists available APIs
 type apisArgs struct {
-	All         bool `help:"Include third-party services"`
-	Description bool `help:"Print a line-line description of each API"`
+	Enable      *apisEnableArgs `arg:"subcommand" help:"enable APIs outside of the spec"`
+	All         bool            `help:"Include endpoints and partner services"`
+	Description bool            `help:"Print a line-line description of each API"`
+	Group       bool            `help:"Group APIs by category"`
+	Refresh     bool            `help:"Fetch the list of APIs again rather than using the cache"`
 }
 
 // args for "gproj gcloud", which calls gcloud with a --project and --account added
@@ -529,35 +570,440 @@ type gcloudArgs struct {
 	Args []string `arg:"positional"`
 }
 
+// args for "gproj describe", which prints what google cloud knows about the project
+type describeArgs struct {
+	Raw  bool `help:"print the full API responses rather than a summary"`
+	JSON bool `arg:"--json" help:"with --raw, print JSON rather than YAML"`
+}
+
+// args for "gproj search", which finds projects across the organization
+type searchArgs struct {
+	Terms []string `arg:"positional" help:"filter terms such as label=team:payments or state:ACTIVE"`
+}
+
+// args for "gproj foreach", which runs a gproj command in every matching project
+type foreachArgs struct {
+	Filter      []string `help:"search terms selecting the projects, as for gproj search"`
+	Parallelism int      `default:"4" help:"number of projects to process at once"`
+	Yes         bool     `help:"do not ask for confirmation"`
+	Command     []string `arg:"positional" help:"the gproj command to run, after --"`
+}
+
+// args for "gproj workspace", which applies every spec in a directory tree
+type workspaceArgs struct {
+	Dir         string `arg:"positional" default:"." help:"directory to search for specs"`
+	Parallelism int    `default:"4" help:"number of projects to apply at once"`
+	List        bool   `help:"print the projects in the order they would be applied instead of applying them"`
+}
+
+// args for "gproj diff", which compares the spec to another spec or to the live project
+type diffArgs struct {
+	Other string `arg:"positional" help:"spec to compare against (default: the live project)"`
+}
+
+// args for "gproj blame", which shows the commit that last changed each field of the spec
+type blameArgs struct {
+}
+
+// args for "gproj plan", which shows what apply would change
+type planArgs struct {
+	Format    string `default:"text" help:"text or markdown"`
+	Out       string `help:"also write the plan to this file, to be applied later with gproj apply FILE"`
+	PolicyDir string `arg:"--policy-dir,env:GPROJ_POLICY_DIR" help:"fail if the plan violates the rego or CUE policies in this directory"`
+}
+
+// args for "gproj version"
+type versionArgs struct {
+	Check bool `help:"check whether a newer version has been released"`
+}
+
+// args for "gproj self-update", which installs the latest release
+type selfUpdateArgs struct {
+}
+
+// args for "gproj explain", which documents parts of the spec
+type explainArgs struct {
+	Topic string `arg:"positional,required" help:"spec field to explain, e.g. billing or apis"`
+}
+
+// args for "gproj cost", which shows recent spend from a bigquery billing export
+type costArgs struct {
+	Last  string `default:"30d" help:"period to report on, e.g. 30d or 2w"`
+	Table string `arg:"--table,env:GPROJ_BILLING_EXPORT_TABLE" help:"billing export table, as PROJECT.DATASET.TABLE"`
+}
+
+// args for "gproj open", which opens the cloud console for the project
+type openArgs struct {
+	Page  string `arg:"positional" default:"dashboard" help:"dashboard, billing, apis, iam, or logs"`
+	Print bool   `help:"only print the URL, do not open a browser"`
+}
+
+// args for "gproj factory", which creates a project from an org-level template
+type factoryArgs struct {
+	Template      string `arg:"--template,required,env:GPROJ_FACTORY_TEMPLATE" help:"spec giving the parent, billing, baseline APIs, IAM, and budget for every project"`
+	Request       string `arg:"positional,required" help:"file giving the name, id, and owner of the project to create"`
+	Parallelism   int    `default:"4" help:"maximum number of steps to run at once"`
+	Report        string `help:"write a JSON report of every action taken to this path"`
+	NotifyWebhook string `arg:"--notify-webhook,env:GPROJ_NOTIFY_WEBHOOK" help:"post to this slack or other webhook URL when apply finishes"`
+}
+
+// args for "gproj request", which asks someone else to apply the spec
+type requestArgs struct {
+	Key      string `arg:"--key,env:GPROJ_REQUEST_KEY" help:"key with which to sign the request, shared with whoever applies it"`
+	Out      string `help:"write the request to this path (default: PROJECT.request.json)"`
+	Endpoint string `help:"post the request to this URL instead of writing a file"`
+	Topic    string `help:"publish the request to this pub/sub topic, as projects/PROJECT/topics/TOPIC, instead of writing a file"`
+}
+
+// args for "gproj resolve", which converts between project IDs and numbers
+type resolveArgs struct {
+	Projects []string `arg:"positional,required" help:"project IDs to convert to numbers, or numbers to convert to IDs"`
+}
+
+// args for "gproj snapshot", which records the live configuration of the project
+type snapshotArgs struct {
+	Out string `arg:"required" help:"path to which to write the snapshot"`
+}
+
+// args for "gproj resume", which continues an interrupted apply
+type resumeArgs struct {
+	Parallelism int `help:"maximum number of steps to run at once (default: as for the interrupted apply)"`
+}
+
+// args for "gproj restore", which applies a snapshot
+type restoreArgs struct {
+	Snapshot    string `arg:"positional,required" help:"snapshot written by gproj snapshot"`
+	ID          string `arg:"--id" help:"restore to this project instead of the one the snapshot was taken from"`
+	Name        string `help:"name to give the project (default: the name in the snapshot)"`
+	Adopt       bool   `help:"restore to an existing project even though it was not created by gproj"`
+	Parallelism int    `default:"4" help:"maximum number of steps to run at once"`
+	Report      string `help:"write a JSON report of every action taken to this path"`
+}
+
+// args for "gproj clone", which creates a project configured like another
+type cloneArgs struct {
+	From        string `arg:"required" help:"ID of the project to copy"`
+	ID          string `arg:"--id,required" help:"ID of the project to create"`
+	Name        string `help:"name of the project to create (default: the name of the project being copied)"`
+	Out         string `help:"also write the spec for the new project to this path"`
+	Parallelism int    `default:"4" help:"maximum number of steps to run at once"`
+}
+
+// args for "gproj liens remove"
+type liensRemoveArgs struct {
+	Liens []string `arg:"positional,required" help:"names of the liens to remove, as printed by gproj liens"`
+}
+
+// args for "gproj liens", which lists the liens that prevent the project from being deleted
+type liensArgs struct {
+	Remove *liensRemoveArgs `arg:"subcommand" help:"remove liens from the project"`
+}
+
+// args for "gproj policy check"
+type policyCheckArgs struct {
+	PolicyDir string `arg:"--policy-dir,required,env:GPROJ_POLICY_DIR" help:"directory of rego or CUE policies"`
+}
+
+// args for "gproj policy", which evaluates the spec against the organization's policies
+type policyArgs struct {
+	Check *policyCheckArgs `arg:"subcommand" help:"check the spec and plan against the policies"`
+}
+
+// args for "gproj id suggest"
+type idSuggestArgs struct {
+	Team  string   `help:"value for the {team} placeholder"`
+	Env   string   `help:"value for the {env} placeholder"`
+	App   string   `help:"value for the {app} placeholder"`
+	Set   []string `help:"values for other placeholders, as key=value"`
+	Count int      `default:"1" help:"number of IDs to suggest"`
+}
+
+// args for "gproj id", which works with the naming convention for project IDs
+type idArgs struct {
+	Suggest *idSuggestArgs `arg:"subcommand" help:"generate available project IDs that follow the naming convention"`
+}
+
+// args for "gproj ui", which shows a dashboard of the projects managed by gproj
+type uiArgs struct {
+	Dir string `arg:"positional" default:"." help:"directory to search for the specs of the projects, to show drift"`
+}
+
+// args for "gproj watch", which re-plans the project periodically
+type watchArgs struct {
+	Interval      time.Duration `default:"1h" help:"how often to check the project, e.g. 15m or 1h"`
+	AutoApply     bool          `arg:"--auto-apply" help:"apply the spec when the project has drifted, rather than only reporting it"`
+	Notify        string        `help:"set to \"desktop\" to show a desktop notification on drift or errors"`
+	NotifyWebhook string        `arg:"--notify-webhook,env:GPROJ_NOTIFY_WEBHOOK" help:"post to this slack or other webhook URL on drift or errors"`
+}
+
+// args for "gproj operator", which reconciles GoogleCloudProject resources in kubernetes
+type operatorArgs struct {
+	Namespace      string        `env:"GPROJ_OPERATOR_NAMESPACE" help:"watch resources in this namespace only (default: all namespaces)"`
+	Interval       time.Duration `default:"30s" help:"how often to look for new or changed resources"`
+	Resync         time.Duration `default:"1h" help:"how often to check unchanged resources for drift"`
+	StateDir       string        `arg:"--state-dir" default:"." help:"directory under which local state for each resource is kept"`
+	APIServer      string        `arg:"--api-server" help:"address of the kubernetes API server, e.g. from kubectl proxy (default: the cluster the operator runs in)"`
+	PrintCRD       bool          `arg:"--print-crd" help:"print the CustomResourceDefinition for GoogleCloudProject and exit"`
+	AllowedPlugins []string      `arg:"--allowed-plugins" help:"kinds of plugin that GoogleCloudProject resources may use, which run from the PATH with the operator's credentials"`
+}
+
+// args for "gproj serve", which exposes plan, apply, status, and delete over HTTP
+type serveArgs struct {
+	Addr           string   `default:"localhost:8080" help:"address to listen on"`
+	TokensFile     string   `arg:"--tokens-file" help:"file of bearer tokens that clients may present, one per line; GPROJ_SERVE_TOKEN adds another"`
+	StateDir       string   `arg:"--state-dir" default:"." help:"directory under which local state and the last applied spec for each project are kept"`
+	AllowedPlugins []string `arg:"--allowed-plugins" help:"kinds of plugin that specs sent to the server may use, which run from the PATH with the server's credentials"`
+}
+
+// args for "gproj generate ci", which writes a CI pipeline that plans and applies the spec
+type generateCIArgs struct {
+	Provider                 string `default:"github" help:"CI system to generate a pipeline for: github or cloudbuild"`
+	WorkloadIdentityProvider string `arg:"--workload-identity-provider" help:"workload identity provider through which GitHub Actions authenticates, as projects/NUMBER/locations/global/workloadIdentityPools/POOL/providers/PROVIDER"`
+	ServiceAccount           string `arg:"--service-account" help:"email of the service account that plan and apply run as"`
+	Branch                   string `default:"main" help:"branch on which merges are applied"`
+	Out                      string `help:"path to write the pipeline to, or - for stdout (default: the usual place for the CI system)"`
+	Force                    bool   `help:"overwrite the pipeline if it exists"`
+}
+
+// args for "gproj generate"
+type generateArgs struct {
+	CI *generateCIArgs `arg:"subcommand:ci" help:"write a CI pipeline that runs plan on pull requests and apply on merge"`
+}
+
+// args for "gproj billing migrate"
+type billingMigrateArgs struct {
+	From   string   `arg:"required" help:"billing account to move projects from"`
+	To     string   `arg:"required" help:"billing account to move projects to"`
+	Filter []string `help:"search terms further selecting the projects, as for gproj search"`
+	DryRun bool     `arg:"--dry-run" help:"show which projects would move without moving them"`
+	Yes    bool     `help:"do not ask for confirmation"`
+}
+
+// args for "gproj billing", which works with the billing accounts of many projects at once
+type billingArgs struct {
+	Migrate *billingMigrateArgs `arg:"subcommand" help:"relink the gproj-managed projects on one billing account to another"`
+}
+
+// args for "gproj sa rotate-keys"
+type saRotateKeysArgs struct {
+	Name   string `arg:"positional,required" help:"service account declared under actAs in the spec, e.g. runtime"`
+	MaxAge string `arg:"--max-age" default:"90d" help:"retire keys older than this, e.g. 90d or 12w"`
+	Delete bool   `help:"delete old keys rather than disabling them"`
+	Out    string `help:"write the new key to this file, or - for stdout"`
+	Secret string `help:"store the new key as a new version of this secret in the project"`
+}
+
+// args for "gproj sa", which works with the service accounts declared in the spec
+type saArgs struct {
+	RotateKeys *saRotateKeysArgs `arg:"subcommand:rotate-keys" help:"create a new key and retire old ones"`
+}
+
+// args for "gproj pause", which mothballs the project without deleting it
+type pauseArgs struct {
+	Yes bool `help:"do not ask for confirmation"`
+}
+
+// args for "gproj resume-project", which undoes "gproj pause"
+type resumeProjectArgs struct{}
+
+// args for "gproj reminders", which warns about and carries out scheduled deletions
+type remindersArgs struct {
+	Within        string `default:"3d" help:"remind owners of projects that will be deleted within this long, e.g. 3d or 1w"`
+	Execute       bool   `help:"delete the projects whose scheduled time has passed"`
+	UnlinkBilling bool   `arg:"--unlink-billing" help:"unlink the billing account from each project before deleting it"`
+	Notify        string `help:"set to \"desktop\" to show a desktop notification for each reminder"`
+	NotifyWebhook string `arg:"--notify-webhook,env:GPROJ_NOTIFY_WEBHOOK" help:"post each reminder to this slack or other webhook URL"`
+}
+
 // args for the top-level gproj command
 type args struct {
-	Spec     string        `help:"path to config file"`
-	Apply    *applyArgs    `arg:"subcommand"`
-	Delete   *deleteArgs   `arg:"subcommand" help:"delete the current project"`
-	Undelete *undeleteArgs `arg:"subcommand" help:"un-delete the current project"`
-	Gcloud   *gcloudArgs   `arg:"subcommand"`
-	APIs     *apisArgs     `arg:"subcommand" help:"list available APIs"`
-	Verbose  bool
+	Spec              string             `help:"path to config file"`
+	SpecDir           string             `arg:"--spec-dir" help:"do not search for the config file above this directory"`
+	Project           string             `help:"operate on this project ID without reading a spec"`
+	SpecBoundary      string             `arg:"--spec-boundary,env:GPROJ_SPEC_BOUNDARY" default:"git,home" help:"stop searching for the config file at a repository root (git), the home directory (home), or neither (none)"`
+	Apply             *applyArgs         `arg:"subcommand"`
+	Plan              *planArgs          `arg:"subcommand" help:"show what apply would change"`
+	Resume            *resumeArgs        `arg:"subcommand" help:"continue an interrupted apply from the last completed step"`
+	Factory           *factoryArgs       `arg:"subcommand" help:"create a project from an org-level template and a minimal request"`
+	Request           *requestArgs       `arg:"subcommand" help:"ask an admin to apply the spec, for those who cannot create projects"`
+	Resolve           *resolveArgs       `arg:"subcommand" help:"print the number of a project given its ID, or its ID given its number"`
+	Snapshot          *snapshotArgs      `arg:"subcommand" help:"write the live configuration of the project to a file"`
+	Restore           *restoreArgs       `arg:"subcommand" help:"apply a snapshot to the project it was taken from or to a new project"`
+	Clone             *cloneArgs         `arg:"subcommand" help:"create a project with the same labels, billing, and APIs as another"`
+	Liens             *liensArgs         `arg:"subcommand" help:"list the liens that prevent the project from being deleted"`
+	Policy            *policyArgs        `arg:"subcommand" help:"evaluate the spec against policies"`
+	ID                *idArgs            `arg:"subcommand:id" help:"suggest project IDs that follow the naming convention"`
+	Delete            *deleteArgs        `arg:"subcommand" help:"delete the current project"`
+	Destroy           *destroyArgs       `arg:"subcommand" help:"delete the resources in the spec and then the project"`
+	Undelete          *undeleteArgs      `arg:"subcommand" help:"un-delete the current project"`
+	Pause             *pauseArgs         `arg:"subcommand" help:"unlink billing and disable APIs, keeping the project and its data"`
+	ResumeProject     *resumeProjectArgs `arg:"subcommand:resume-project" help:"relink billing and re-enable the APIs turned off by gproj pause"`
+	Reminders         *remindersArgs     `arg:"subcommand" help:"warn owners of projects scheduled for deletion, and delete those that are due"`
+	Gcloud            *gcloudArgs        `arg:"subcommand"`
+	ForceUnlock       *forceUnlockArgs   `arg:"subcommand:force-unlock" help:"remove the lock on the state"`
+	Doctor            *doctorArgs        `arg:"subcommand" help:"check credentials, network, and the spec, and suggest fixes"`
+	Lint              *lintArgs          `arg:"subcommand" help:"check the spec for likely mistakes"`
+	MigrateSpec       *migrateSpecArgs   `arg:"subcommand:migrate-spec" help:"upgrade the spec to the current layout"`
+	Schema            *schemaArgs        `arg:"subcommand" help:"print a JSON schema for the spec, for use by editors"`
+	APIs              *apisArgs          `arg:"subcommand" help:"list available APIs"`
+	Open              *openArgs          `arg:"subcommand" help:"open the project in the cloud console"`
+	Describe          *describeArgs      `arg:"subcommand" help:"print the project, its billing info, its ancestry, and its liens"`
+	Cost              *costArgs          `arg:"subcommand" help:"show recent spend by service"`
+	Search            *searchArgs        `arg:"subcommand" help:"find projects by label or state"`
+	Foreach           *foreachArgs       `arg:"subcommand" help:"run a gproj command in every project matching a filter"`
+	Billing           *billingArgs       `arg:"subcommand" help:"move projects between billing accounts"`
+	SA                *saArgs            `arg:"subcommand:sa" help:"manage keys for the service accounts in the spec"`
+	Workspace         *workspaceArgs     `arg:"subcommand" help:"apply every spec in a directory tree, in dependency order"`
+	UI                *uiArgs            `arg:"subcommand:ui" help:"show a dashboard of gproj-managed projects, and plan or apply them"`
+	Watch             *watchArgs         `arg:"subcommand" help:"re-plan the project periodically, and report or fix drift"`
+	Operator          *operatorArgs      `arg:"subcommand" help:"reconcile GoogleCloudProject resources in a kubernetes cluster"`
+	Serve             *serveArgs         `arg:"subcommand" help:"expose plan, apply, status, and delete over an authenticated HTTP API"`
+	Generate          *generateArgs      `arg:"subcommand" help:"generate files for working with the spec"`
+	Diff              *diffArgs          `arg:"subcommand" help:"compare the spec to another spec or to the live project"`
+	Blame             *blameArgs         `arg:"subcommand" help:"show the commit that last changed each field of the spec"`
+	Explain           *explainArgs       `arg:"subcommand" help:"explain part of the spec in detail"`
+	Version           *versionArgs       `arg:"subcommand" help:"print the version of gproj"`
+	SelfUpdate        *selfUpdateArgs    `arg:"subcommand:self-update" help:"download and install the latest release of gproj"`
+	Verbose           bool
+	CI                bool   `arg:"--ci" help:"never prompt, group log output, and write a summary and outputs for the CI system"`
+	OTLPEndpoint      string `arg:"--otlp-endpoint,env:OTEL_EXPORTER_OTLP_ENDPOINT" help:"export a trace of each apply to this OpenTelemetry collector (OTLP over HTTP)"`
+	QuotaProject      string `arg:"--quota-project,env:GPROJ_QUOTA_PROJECT" help:"attribute API usage to this project, which must have the APIs being called enabled"`
+	UserAgent         string `arg:"--user-agent,env:GPROJ_USER_AGENT" help:"identify requests to google APIs with this product token, in front of gproj's own"`
+	Offline           bool   `help:"never call google APIs; plan against the cached project and list APIs from the cached catalog"`
+	RateLimit         string `arg:"--rate-limit,env:GPROJ_RATE_LIMIT" help:"most requests per second to send to some APIs, as comma-separated service=rps pairs, e.g. cloudresourcemanager=2"`
+	EndpointOverrides string `arg:"--endpoint-overrides,env:GPROJ_ENDPOINT_OVERRIDES" help:"send requests for some APIs elsewhere, as comma-separated service=url pairs, e.g. cloudresourcemanager=http://localhost:8080"`
+	Naming            string `arg:"--naming,env:GPROJ_NAMING" help:"YAML file giving the organization's naming convention for project IDs and names"`
+	NoInput           bool   `arg:"--no-input,env:GPROJ_NO_INPUT" help:"fail rather than prompt when an answer was not given by flags, the environment, or the spec"`
+	Timestamps        bool   `help:"prefix each line of output with the time, for logs"`
+}
+
+// cancel the returned context on the first interrupt so that waits return promptly and
+// state is saved, and exit immediately on the second
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ch
+		fmt.Println("interrupted, finishing up (press Ctrl-C again to exit immediately)")
+		cancel()
+		<-ch
+		os.Exit(130)
+	}()
+	return ctx
 }
 
 func main() {
-	ctx := context.Background()
+	ctx := interruptContext()
 
 	var args args
 	p := arg.MustParse(&args)
 
+	flush := func() {}
+	if args.Timestamps {
+		flush = timestampOutput()
+	}
+
 	var err error
 	switch {
 	case args.Apply != nil:
 		err = apply(ctx, &args)
+	case args.Plan != nil:
+		err = planCmd(ctx, &args)
+	case args.Resume != nil:
+		err = resume(ctx, &args)
+	case args.Factory != nil:
+		err = factory(ctx, &args)
+	case args.Request != nil:
+		err = request(ctx, &args)
+	case args.Resolve != nil:
+		err = resolve(ctx, &args)
+	case args.Snapshot != nil:
+		err = snapshot(ctx, &args)
+	case args.Restore != nil:
+		err = restore(ctx, &args)
+	case args.Clone != nil:
+		err = clone(ctx, &args)
+	case args.Liens != nil:
+		err = liens(ctx, &args)
+	case args.Policy != nil && args.Policy.Check != nil:
+		err = policyCheck(ctx, &args)
+	case args.Policy != nil:
+		err = errors.New("expected a subcommand: gproj policy check")
+	case args.ID != nil && args.ID.Suggest != nil:
+		err = idSuggest(ctx, &args)
+	case args.ID != nil:
+		err = errors.New("expected a subcommand: gproj id suggest")
 	case args.Delete != nil:
 		err = cmdDelete(ctx, &args)
+	case args.Destroy != nil:
+		err = destroy(ctx, &args)
 	case args.Undelete != nil:
 		err = undelete(ctx, &args)
+	case args.Pause != nil:
+		err = pause(ctx, &args)
+	case args.ResumeProject != nil:
+		err = resumeProject(ctx, &args)
+	case args.Reminders != nil:
+		err = reminders(ctx, &args)
 	case args.Gcloud != nil:
 		err = gcloud(ctx, &args)
+	case args.APIs != nil && args.APIs.Enable != nil:
+		err = enableAPIs(ctx, &args)
 	case args.APIs != nil:
 		err = apis(ctx, &args)
+	case args.Doctor != nil:
+		err = doctor(ctx, &args)
+	case args.Lint != nil:
+		err = lint(ctx, &args)
+	case args.MigrateSpec != nil:
+		err = migrateSpec(ctx, &args)
+	case args.Schema != nil:
+		err = schema(ctx, &args)
+	case args.ForceUnlock != nil:
+		err = forceUnlock(ctx, &args)
+	case args.Open != nil:
+		err = open(ctx, &args)
+	case args.Describe != nil:
+		err = describe(ctx, &args)
+	case args.Cost != nil:
+		err = cost(ctx, &args)
+	case args.Search != nil:
+		err = search(ctx, &args)
+	case args.Foreach != nil:
+		err = foreach(ctx, &args)
+	case args.Billing != nil && args.Billing.Migrate != nil:
+		err = billingMigrate(ctx, &args)
+	case args.Billing != nil:
+		err = errors.New("expected a subcommand: gproj billing migrate")
+	case args.SA != nil && args.SA.RotateKeys != nil:
+		err = saRotateKeys(ctx, &args)
+	case args.SA != nil:
+		err = errors.New("expected a subcommand: gproj sa rotate-keys")
+	case args.Workspace != nil:
+		err = workspace(ctx, &args)
+	case args.UI != nil:
+		err = ui(ctx, &args)
+	case args.Watch != nil:
+		err = watch(ctx, &args)
+	case args.Operator != nil:
+		err = operator(ctx, &args)
+	case args.Serve != nil:
+		err = serve(ctx, &args)
+	case args.Generate != nil && args.Generate.CI != nil:
+		err = generateCI(ctx, &args)
+	case args.Generate != nil:
+		err = errors.New("expected a subcommand: gproj generate ci")
+	case args.Diff != nil:
+		err = diff(ctx, &args)
+	case args.Blame != nil:
+		err = blame(ctx, &args)
+	case args.Explain != nil:
+		err = explain(ctx, &args)
+	case args.Version != nil:
+		err = versionCmd(ctx, &args)
+	case args.SelfUpdate != nil:
+		err = selfUpdate(ctx, &args)
 	default:
 		p.Fail("you must specify a command")
 	}
@@ -567,7 +1013,13 @@ func main() {
 		if !strings.HasPrefix(msg, "error") {
 			msg = "error: " + msg
 		}
+		if args.CI && githubActions() {
+			// an annotation makes the error visible on the workflow run page
+			msg = "::error::" + strings.ReplaceAll(msg, "\n", "%0A")
+		}
 		fmt.Println(msg)
+		flush()
 		os.Exit(1)
 	}
+	flush()
 }
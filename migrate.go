@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// specVersion is the current layout of the spec. Specs that do not give a version are
+// version 1, which is the layout from before the version field existed.
+const specVersion = 2
+
+// migration upgrades a spec from one version to the next. Migrations operate on the raw
+// YAML document so that they can rename or restructure fields that the current ProjectSpec
+// no longer has, and so that the order of keys is preserved when rewriting the file.
+type migration struct {
+	from        int                                           // version that this migration upgrades from
+	description string                                        // what changed, shown in deprecation warnings
+	migrate     func(doc yaml.MapSlice) (yaml.MapSlice, bool) // rewrites the document and reports whether anything changed
+}
+
+// migrations in the order they are applied. Add one here whenever a field is renamed or its
+// meaning changes, and increment specVersion.
+var migrations = []migration{
+	{
+		from:        1,
+		description: `billing: "enable" is now billing: "auto"`,
+		migrate: func(doc yaml.MapSlice) (yaml.MapSlice, bool) {
+			for i, item := range doc {
+				if item.Key == "billing" && item.Value == "enable" {
+					doc[i].Value = billingAuto
+					return doc, true
+				}
+			}
+			return doc, false
+		},
+	},
+}
+
+// get the version declared in a raw spec document
+func docVersion(doc yaml.MapSlice) (int, error) {
+	for _, item := range doc {
+		if item.Key != "version" {
+			continue
+		}
+		v, ok := item.Value.(int)
+		if !ok || v < 1 {
+			return 0, fmt.Errorf("version must be a positive integer, not %v", item.Value)
+		}
+		return v, nil
+	}
+	return 1, nil
+}
+
+// set the version in a raw spec document, putting it first if it was not there before
+func setDocVersion(doc yaml.MapSlice, version int) yaml.MapSlice {
+	for i, item := range doc {
+		if item.Key == "version" {
+			doc[i].Value = version
+			return doc
+		}
+	}
+	return append(yaml.MapSlice{{Key: "version", Value: version}}, doc...)
+}
+
+// migrateDoc upgrades a raw spec document to the current version and returns the
+// descriptions of the migrations that changed something
+func migrateDoc(doc yaml.MapSlice) (yaml.MapSlice, []string, error) {
+	version, err := docVersion(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version > specVersion {
+		return nil, nil, fmt.Errorf("the spec is version %d but this gproj only understands up to version %d; "+
+			"run gproj self-update", version, specVersion)
+	}
+
+	var applied []string
+	for _, m := range migrations {
+		if m.from < version {
+			continue
+		}
+		var changed bool
+		doc, changed = m.migrate(doc)
+		if changed {
+			applied = append(applied, m.description)
+		}
+	}
+	return setDocVersion(doc, specVersion), applied, nil
+}
+
+// migrateSpecBytes upgrades the encoded spec read from path to the current version, warning
+// about each change so that old specs keep working but do not go unnoticed
+func migrateSpecBytes(buf []byte, path string) ([]byte, error) {
+	var doc yaml.MapSlice
+	err := yaml.Unmarshal(buf, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing project spec at %s: %w", path, err)
+	}
+
+	doc, applied, err := migrateDoc(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(applied) == 0 {
+		return buf, nil
+	}
+
+	for _, description := range applied {
+		fmt.Printf("warning: %s uses an old layout: %s\n", path, description)
+	}
+	fmt.Printf("warning: run gproj migrate-spec to upgrade %s to version %d\n", path, specVersion)
+	return yaml.Marshal(doc)
+}
+
+// migrateSpec rewrites the spec file in the current layout
+func migrateSpec(ctx context.Context, args *args) error {
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+	if spec.path == "" {
+		return fmt.Errorf("there is no spec file to migrate")
+	}
+	if strings.EqualFold(filepath.Ext(spec.path), ".json") {
+		return fmt.Errorf("migrating JSON specs is not supported; set \"version\": %d and make the changes listed above by hand", specVersion)
+	}
+	if spec.encrypted {
+		return errSpecEncrypted
+	}
+
+	buf, err := fsys.ReadFile(spec.path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", spec.path, err)
+	}
+
+	var doc yaml.MapSlice
+	err = yaml.Unmarshal(buf, &doc)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %w", spec.path, err)
+	}
+
+	version, err := docVersion(doc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", spec.path, err)
+	}
+	if version == specVersion {
+		fmt.Printf("%s is already version %d\n", spec.path, specVersion)
+		return nil
+	}
+
+	doc, applied, err := migrateDoc(doc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", spec.path, err)
+	}
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("error marshalling migrated spec: %w", err)
+	}
+
+	if args.MigrateSpec.DryRun {
+		fmt.Print(string(out))
+		return nil
+	}
+
+	err = fsys.WriteFile(spec.path, out, filePerm)
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", spec.path, err)
+	}
+	fmt.Printf("upgraded %s from version %d to %d (note that comments are not preserved)\n", spec.path, version, specVersion)
+	for _, description := range applied {
+		fmt.Printf("  %s\n", description)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+	"gopkg.in/yaml.v2"
+)
+
+// NamingConvention is an organization's rules for project IDs and names, read from the file
+// given by --naming. Each rule is either a regular expression, if it starts with "^", or a
+// template such as "{team}-{env}-{app}" in which each placeholder stands for a word of
+// lowercase letters and digits, or for one of the listed values if there are any.
+type NamingConvention struct {
+	ID     string              // rule for project IDs
+	Name   string              // rule for project names
+	Values map[string][]string // allowed values for placeholders, e.g. env: [dev, staging, prod]
+}
+
+// matches a placeholder in a naming template
+var placeholderPattern = regexp.MustCompile(`\{([a-z][a-z0-9_]*)\}`)
+
+// loadNamingConvention reads a naming convention from a YAML file
+func loadNamingConvention(path string) (*NamingConvention, error) {
+	buf, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading naming convention: %w", err)
+	}
+	var nc NamingConvention
+	err = yaml.UnmarshalStrict(buf, &nc)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing naming convention in %s: %w", path, err)
+	}
+	return &nc, nil
+}
+
+// compile a rule to a regular expression that matches the whole of a compliant string
+func (nc *NamingConvention) compile(rule string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(rule, "^") {
+		return regexp.Compile(rule)
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	last := 0
+	for _, m := range placeholderPattern.FindAllStringSubmatchIndex(rule, -1) {
+		b.WriteString(regexp.QuoteMeta(rule[last:m[0]]))
+		name := rule[m[2]:m[3]]
+		if values := nc.Values[name]; len(values) > 0 {
+			var quoted []string
+			for _, v := range values {
+				quoted = append(quoted, regexp.QuoteMeta(v))
+			}
+			fmt.Fprintf(&b, "(?P<%s>%s)", name, strings.Join(quoted, "|"))
+		} else {
+			fmt.Fprintf(&b, "(?P<%s>[a-z0-9]+)", name)
+		}
+		last = m[1]
+	}
+	b.WriteString(regexp.QuoteMeta(rule[last:]))
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// check the ID and name in a spec against the convention, and return the violations
+func (nc *NamingConvention) check(spec *ProjectSpec) ([]lintIssue, error) {
+	var violations []lintIssue
+	for _, c := range []struct{ field, rule, value string }{
+		{"id", nc.ID, spec.ID},
+		{"name", nc.Name, spec.Name},
+	} {
+		if c.rule == "" {
+			continue
+		}
+		re, err := nc.compile(c.rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid naming rule for %s: %w", c.field, err)
+		}
+		if !re.MatchString(c.value) {
+			violations = append(violations, lintIssue{
+				Field:   c.field,
+				Message: fmt.Sprintf("%q does not follow the naming convention %q", c.value, c.rule),
+			})
+		}
+	}
+	return violations, nil
+}
+
+// check the spec against the naming convention given by --naming, if any
+func checkNaming(args *args, spec *ProjectSpec) error {
+	if args.Naming == "" {
+		return nil
+	}
+	nc, err := loadNamingConvention(args.Naming)
+	if err != nil {
+		return err
+	}
+	violations, err := nc.check(spec)
+	if err != nil {
+		return err
+	}
+	for _, v := range violations {
+		fmt.Printf("%s: %s: %s\n", spec.path, v.Field, v.Message)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%d naming violations", len(violations))
+	}
+	return nil
+}
+
+// fill in the placeholders of an ID template. The {suffix} placeholder, if present, is
+// filled with random characters so that several candidates can be generated.
+func fillTemplate(template string, values map[string]string) (string, error) {
+	var missing []string
+	out := placeholderPattern.ReplaceAllStringFunc(template, func(p string) string {
+		name := p[1 : len(p)-1]
+		if name == "suffix" {
+			return randomSuffix()
+		}
+		v, ok := values[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", fmt.Errorf("no value given for %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// generate four random lowercase letters and digits
+func randomSuffix() string {
+	const chars = "abcdefghijklmnopqrstuvwxyz0123456789"
+	buf := make([]byte, 4)
+	rand.Read(buf)
+	for i := range buf {
+		buf[i] = chars[int(buf[i])%len(chars)]
+	}
+	return string(buf)
+}
+
+// determine whether a project ID is free to use. Google reports a project that belongs to
+// someone else in the same way as one that does not exist, so an ID that is reported as
+// available may still be taken; creating the project is the only way to be sure.
+func idAvailable(ctx context.Context, resources *cloudresourcemanager.Service, id string) (bool, error) {
+	_, err := resources.Projects.Get(id).Context(ctx).Do()
+	var e *googleapi.Error
+	if errors.As(err, &e) && (e.Code == 403 || e.Code == 404) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error looking up %s: %w", id, err)
+	}
+	return false, nil
+}
+
+// the most candidates that idSuggest tries before giving up
+const maxSuggestAttempts = 20
+
+func idSuggest(ctx context.Context, args *args) error {
+	if args.Naming == "" {
+		return errors.New("gproj id suggest needs a naming convention given by --naming")
+	}
+	nc, err := loadNamingConvention(args.Naming)
+	if err != nil {
+		return err
+	}
+	if nc.ID == "" || strings.HasPrefix(nc.ID, "^") {
+		return fmt.Errorf("the naming convention in %s must give a template for IDs, such as {team}-{env}-{app}", args.Naming)
+	}
+	re, err := nc.compile(nc.ID)
+	if err != nil {
+		return fmt.Errorf("invalid naming rule for id: %w", err)
+	}
+
+	s := args.ID.Suggest
+	values := map[string]string{"team": s.Team, "env": s.Env, "app": s.App}
+	for k, v := range values {
+		if v == "" {
+			delete(values, k)
+		}
+	}
+	for _, kv := range s.Set {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("expected key=value but got %q", kv)
+		}
+		values[parts[0]] = parts[1]
+	}
+
+	var resources *cloudresourcemanager.Service
+	if !args.Offline {
+		conn, err := connect(ctx, args)
+		if err != nil {
+			return err
+		}
+		resources, err = cloudresourcemanager.NewService(ctx, conn.options()...)
+		if err != nil {
+			return err
+		}
+	}
+
+	// without a {suffix} placeholder there is only one candidate
+	attempts := maxSuggestAttempts
+	if !strings.Contains(nc.ID, "{suffix}") {
+		attempts = 1
+	}
+	var found int
+	seen := make(map[string]bool)
+	for i := 0; i < attempts && found < s.Count; i++ {
+		id, err := fillTemplate(nc.ID, values)
+		if err != nil {
+			return err
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if !re.MatchString(id) {
+			return fmt.Errorf("%s does not follow the naming convention %q; check the values given", id, nc.ID)
+		}
+		if !projectIDPattern.MatchString(id) {
+			return fmt.Errorf("%s is not a valid project ID (6-30 lowercase letters, digits, or hyphens, starting with a letter)", id)
+		}
+
+		if resources != nil {
+			ok, err := idAvailable(ctx, resources, id)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				if args.Verbose {
+					fmt.Printf("%s is taken\n", id)
+				}
+				continue
+			}
+		}
+		fmt.Println(id)
+		found++
+	}
+	if found == 0 && attempts == 1 {
+		return fmt.Errorf("the only ID that follows the naming convention is taken; add {suffix} to the template to generate alternatives")
+	}
+	if found == 0 {
+		return fmt.Errorf("no available IDs found after %d attempts", attempts)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/servicenetworking/v1"
+)
+
+// the service that peers google-managed services such as cloud SQL with a VPC network
+const serviceNetworkingService = "services/servicenetworking.googleapis.com"
+
+// ServiceNetworking models the "serviceNetworking" section of googlecloudproject.yaml, which
+// sets up private service access so that cloud SQL, memorystore, and similar services can
+// be reached on private IPs from a VPC network
+type ServiceNetworking struct {
+	Network      string // VPC network to peer with (default: "default")
+	Range        string // name of the IP range to allocate for google-managed services (default: "google-managed-services-NETWORK")
+	Address      string // first address of the IP range (default: chosen by google)
+	PrefixLength int64  `yaml:"prefixLength"` // size of the IP range (default: 16)
+}
+
+// fill in the defaults for the fields that are not given in the spec
+func (s ServiceNetworking) withDefaults() ServiceNetworking {
+	if s.Network == "" {
+		s.Network = "default"
+	}
+	if s.Range == "" {
+		s.Range = "google-managed-services-" + s.Network
+	}
+	if s.PrefixLength == 0 {
+		s.PrefixLength = 16
+	}
+	return s
+}
+
+// wait for a service networking operation to complete
+func waitForServiceNetworking(ctx context.Context, svc *servicenetworking.APIService, op *servicenetworking.Operation) error {
+	check := func() (bool, error) {
+		if op.Error != nil {
+			return false, newOperationError(op.Error.Code, op.Error.Message, op.Error.Details)
+		}
+		return op.Done, nil
+	}
+	if done, err := check(); done || err != nil {
+		return err
+	}
+	return poll(ctx, "operation "+op.Name, func() (bool, error) {
+		var err error
+		op, err = svc.Operations.Get(op.Name).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error getting operation info: %w", err)
+		}
+		return check()
+	})
+}
+
+// allocate the IP range for private service access and peer it with the VPC network, and
+// report whether anything changed. Ranges that are already peered but not in the spec are
+// kept, since removing them would break the services that use them.
+func (a *applier) ensureServiceNetworking(ctx context.Context) (bool, error) {
+	want := a.spec.ServiceNetworking.withDefaults()
+
+	computeSvc, err := compute.NewService(ctx, a.conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the compute API: %w", err)
+	}
+	svc, err := servicenetworking.NewService(ctx, a.conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the service networking API: %w", err)
+	}
+
+	var changes []string
+	_, err = computeSvc.GlobalAddresses.Get(a.spec.ID, want.Range).Context(ctx).Do()
+	if e, ok := err.(*googleapi.Error); ok && e.Code == 404 {
+		op, err := computeSvc.GlobalAddresses.Insert(a.spec.ID, &compute.Address{
+			Name:         want.Range,
+			Address:      want.Address,
+			AddressType:  "INTERNAL",
+			Purpose:      "VPC_PEERING",
+			PrefixLength: want.PrefixLength,
+			Network:      fmt.Sprintf("projects/%s/global/networks/%s", a.spec.ID, want.Network),
+		}).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error allocating IP range %s: %w", want.Range, err)
+		}
+		noteOperation(ctx, op.Name)
+		err = waitForCompute(ctx, computeSvc, a.spec.ID, op)
+		if err != nil {
+			return false, fmt.Errorf("error allocating IP range %s: %w", want.Range, err)
+		}
+		fmt.Printf("allocated IP range %s for private service access\n", want.Range)
+		changes = append(changes, want.Range)
+	} else if err != nil {
+		return false, fmt.Errorf("error getting IP range %s: %w", want.Range, err)
+	}
+
+	// service networking identifies the network by project number rather than project ID
+	network := fmt.Sprintf("projects/%d/global/networks/%s", a.project.ProjectNumber, want.Network)
+	conns, err := svc.Services.Connections.List(serviceNetworkingService).Network(network).Context(ctx).Do()
+	if err != nil {
+		return len(changes) > 0, fmt.Errorf("error listing service networking connections: %w", err)
+	}
+
+	var op *servicenetworking.Operation
+	if len(conns.Connections) == 0 {
+		op, err = svc.Services.Connections.Create(serviceNetworkingService, &servicenetworking.Connection{
+			Network:               network,
+			ReservedPeeringRanges: []string{want.Range},
+		}).Context(ctx).Do()
+	} else {
+		conn := conns.Connections[0]
+		if contains(conn.ReservedPeeringRanges, want.Range) {
+			if len(changes) == 0 {
+				return false, nil
+			}
+			noteChange(ctx, "", want.Range)
+			return true, nil
+		}
+		op, err = svc.Services.Connections.Patch(serviceNetworkingService+"/connections/-", &servicenetworking.Connection{
+			Network:               network,
+			ReservedPeeringRanges: append(conn.ReservedPeeringRanges, want.Range),
+		}).UpdateMask("reservedPeeringRanges").Context(ctx).Do()
+	}
+	if err != nil {
+		return len(changes) > 0, fmt.Errorf("error peering %s with google-managed services: %w", want.Network, err)
+	}
+	noteOperation(ctx, op.Name)
+	err = waitForServiceNetworking(ctx, svc, op)
+	if err != nil {
+		return len(changes) > 0, fmt.Errorf("error peering %s with google-managed services: %w", want.Network, err)
+	}
+
+	fmt.Printf("peered network %s with google-managed services\n", want.Network)
+	noteChange(ctx, "", want.Network+" via "+want.Range)
+	return true, nil
+}
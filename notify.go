@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// notification is what gets sent when an apply finishes
+type notification struct {
+	Text      string   `json:"text"` // the field that slack displays
+	ProjectID string   `json:"project"`
+	Success   bool     `json:"success"`
+	Changed   []string `json:"changed"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// show a native desktop notification
+func notifyDesktop(title, message string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", message, title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("notify-send", title, message)
+	default:
+		return fmt.Errorf("desktop notifications are not supported on %s", runtime.GOOS)
+	}
+	return cmd.Run()
+}
+
+// post a notification as JSON to a webhook. The "text" field is what slack incoming
+// webhooks display, and the other fields are there for other kinds of webhook.
+func notifyWebhook(ctx context.Context, url string, n *notification) error {
+	buf, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("error marshalling notification: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// send notifications that an apply has finished, to wherever the user asked for them
+func notifyApplyFinished(ctx context.Context, args *args, projectID string, changed []string, applyErr error) {
+	if args.Apply.Notify == "" && args.Apply.NotifyWebhook == "" {
+		return
+	}
+
+	n := notification{
+		ProjectID: projectID,
+		Success:   applyErr == nil,
+		Changed:   changed,
+	}
+	switch {
+	case applyErr != nil:
+		n.Error = applyErr.Error()
+		n.Text = fmt.Sprintf("gproj apply failed for %s: %s", projectID, firstLine(n.Error))
+	case len(changed) == 0:
+		n.Text = fmt.Sprintf("gproj apply finished for %s, no changes", projectID)
+	default:
+		n.Text = fmt.Sprintf("gproj apply finished for %s, changed %s", projectID, strings.Join(changed, ", "))
+	}
+
+	if args.Apply.Notify == "desktop" {
+		if err := notifyDesktop("gproj", n.Text); err != nil {
+			fmt.Println("warning: unable to show desktop notification:", err)
+		}
+	}
+	if args.Apply.NotifyWebhook != "" {
+		if err := notifyWebhook(ctx, args.Apply.NotifyWebhook, &n); err != nil {
+			fmt.Println("warning: unable to send webhook notification:", err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/iap/v1"
+)
+
+// OAuthBrand models the "oauth" section of googlecloudproject.yaml, which configures the
+// OAuth consent screen. This must exist before OAuth clients can be created. Brands created
+// through the API are internal, so only users in the organization can sign in.
+type OAuthBrand struct {
+	ApplicationTitle string `yaml:"applicationTitle"` // name shown to users on the consent screen
+	SupportEmail     string `yaml:"supportEmail"`     // the current user or a group that they own
+
+	Clients []OAuthClient // OAuth clients to create, e.g. for IAP
+}
+
+// create the OAuth brand if the project does not have one, and report whether it was
+// created. Brands cannot be changed or deleted through the API, so a brand that differs
+// from the spec is left alone with a warning.
+func (a *applier) ensureOAuthBrand(ctx context.Context) (bool, error) {
+	want := a.spec.OAuth
+	if want.ApplicationTitle == "" || want.SupportEmail == "" {
+		return false, fmt.Errorf("oauth needs an applicationTitle and a supportEmail")
+	}
+
+	svc, err := iap.NewService(ctx, a.conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the IAP API: %w", err)
+	}
+
+	parent := "projects/" + a.spec.ID
+	brands, err := svc.Projects.Brands.List(parent).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error listing OAuth brands: %w", err)
+	}
+
+	// a project has at most one brand
+	if len(brands.Brands) > 0 {
+		brand := brands.Brands[0]
+		a.brand = brand.Name
+		if brand.ApplicationTitle != want.ApplicationTitle || brand.SupportEmail != want.SupportEmail {
+			fmt.Printf("warning: the OAuth consent screen is %q <%s> rather than %q <%s>; brands cannot be changed "+
+				"through the API so change it at %s\n", brand.ApplicationTitle, brand.SupportEmail,
+				want.ApplicationTitle, want.SupportEmail, consoleURL("apis/credentials/consent", a.spec.ID))
+		}
+		return false, nil
+	}
+
+	brand, err := svc.Projects.Brands.Create(parent, &iap.Brand{
+		ApplicationTitle: want.ApplicationTitle,
+		SupportEmail:     want.SupportEmail,
+	}).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error creating OAuth brand: %w", err)
+	}
+	a.brand = brand.Name
+
+	fmt.Printf("created OAuth consent screen %q\n", want.ApplicationTitle)
+	noteChange(ctx, "", fmt.Sprintf("%s <%s>", want.ApplicationTitle, want.SupportEmail))
+	return true, nil
+}
+
+// OAuthClient models an OAuth client declared in the "oauth" section of the spec, such
+// as the client that IAP uses to sign users in to a protected backend
+type OAuthClient struct {
+	Name string // display name of the client, e.g. "backend-iap"
+}
+
+// create the OAuth clients in the spec that do not exist yet, and report whether any were
+// created. Clients are matched by display name since google assigns their IDs. The ID of
+// each client is recorded in the state and the report, and published as a CI output.
+func (a *applier) ensureOAuthClients(ctx context.Context) (bool, error) {
+	svc, err := iap.NewService(ctx, a.conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the IAP API: %w", err)
+	}
+
+	existing := make(map[string]*iap.IdentityAwareProxyClient)
+	err = svc.Projects.Brands.IdentityAwareProxyClients.List(a.brand).Pages(ctx, func(r *iap.ListIdentityAwareProxyClientsResponse) error {
+		for _, c := range r.IdentityAwareProxyClients {
+			existing[c.DisplayName] = c
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("error listing OAuth clients: %w", err)
+	}
+
+	var created []string
+	for _, want := range a.spec.OAuth.Clients {
+		client, ok := existing[want.Name]
+		if !ok {
+			client, err = svc.Projects.Brands.IdentityAwareProxyClients.Create(a.brand, &iap.IdentityAwareProxyClient{
+				DisplayName: want.Name,
+			}).Context(ctx).Do()
+			if err != nil {
+				return len(created) > 0, fmt.Errorf("error creating OAuth client %s: %w", want.Name, err)
+			}
+			a.state.RecordLabeled(kindOAuthClient, client.Name, want.Name)
+			fmt.Printf("created OAuth client %s, get its secret with\n  $ gcloud iap oauth-clients list %s\n", want.Name, a.brand)
+			created = append(created, want.Name+"="+oauthClientID(client.Name))
+		}
+		a.oauthClients = append(a.oauthClients, [2]string{"oauth-client-" + want.Name, oauthClientID(client.Name)})
+	}
+
+	if len(created) == 0 {
+		return false, nil
+	}
+	noteChange(ctx, "", strings.Join(created, ", "))
+	return true, nil
+}
+
+// get the client ID from the resource name of an OAuth client, which is of the form
+// "projects/123/brands/123/identityAwareProxyClients/CLIENT_ID"
+func oauthClientID(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// returned by anything that needs the network when gproj is run with --offline
+var errOffline = errors.New("this needs to call google APIs, which --offline does not allow")
+
+// the value shown for a field of the live project that cannot be known without the network
+const unknownOffline = "unknown (offline)"
+
+// the fields of the live project that liveProjectSpec fetches from google APIs
+var liveFields = []string{"name", "labels", "apis", "billing"}
+
+// liveSpecCache is the last live project spec that was fetched, which offline plans are
+// made against
+type liveSpecCache struct {
+	Fetched time.Time
+	Spec    *ProjectSpec
+}
+
+// path to the file in which the live spec of a project is cached
+func liveSpecCachePath(projectNumber int64) (string, error) {
+	dir, err := cacheDir(projectNumber)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "live-spec.json"), nil
+}
+
+// cache the live spec of a project so that it can be planned against with --offline
+func cacheLiveSpec(projectNumber int64, live *ProjectSpec) error {
+	path, err := liveSpecCachePath(projectNumber)
+	if err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(liveSpecCache{Fetched: time.Now(), Spec: live}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling live spec: %w", err)
+	}
+	err = fsys.WriteFile(path, buf, filePerm)
+	if err != nil {
+		return fmt.Errorf("error writing live spec to %s: %w", path, err)
+	}
+	return nil
+}
+
+// offlineProjectSpec stands in for liveProjectSpec when gproj is run with --offline. It uses
+// the live spec cached by the last online plan, apply, or diff if there is one, and
+// otherwise marks every field that would have been fetched as unknown.
+func offlineProjectSpec(desired *ProjectSpec) *ProjectSpec {
+	if number, ok := loadProjectNumbers()[desired.ID]; ok {
+		if cached, err := loadLiveSpec(number); err == nil {
+			fmt.Printf("comparing against project %s as it was %s (offline)\n",
+				desired.ID, humanAgo(cached.Fetched))
+			live := *cached.Spec
+			live.Labels = liveLabels(cached.Spec.Labels, desired)
+			copyUnfetchedFields(&live, desired)
+			return &live
+		}
+	}
+
+	fmt.Printf("no cached copy of project %s, so its name, labels, APIs, and billing are unknown (offline)\n", desired.ID)
+	live := ProjectSpec{ID: desired.ID, unknown: liveFields}
+	copyUnfetchedFields(&live, desired)
+	return &live
+}
+
+// load the live spec cached by cacheLiveSpec
+func loadLiveSpec(projectNumber int64) (*liveSpecCache, error) {
+	path, err := liveSpecCachePath(projectNumber)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cached liveSpecCache
+	err = json.Unmarshal(buf, &cached)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding cached live spec; try deleting %s: %w", path, err)
+	}
+	if cached.Spec == nil {
+		return nil, fmt.Errorf("%s does not contain a spec", path)
+	}
+	return &cached, nil
+}
+
+// load the API catalog for a project from cache, however old it is, since it cannot be
+// refreshed without the network
+func offlineAPIs(projectID string) ([]*api, error) {
+	number, ok := loadProjectNumbers()[projectID]
+	if !ok {
+		return nil, fmt.Errorf("the number of project %s is not cached, so its APIs cannot be listed offline", projectID)
+	}
+	dir, err := cacheDir(number)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "available-apis.json")
+	buf, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("the APIs available to project %s are not cached, so cannot be listed offline", projectID)
+	}
+
+	var apis []*api
+	err = json.Unmarshal(buf, &apis)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding cached API listing; try deleting %s: %v", path, err)
+	}
+	enrichAPIs(apis)
+	return apis, nil
+}
+
+// determine whether the value of a field in a live spec is unknown because gproj is offline
+func (s *ProjectSpec) isUnknown(field string) bool {
+	for _, prefix := range s.unknown {
+		if field == prefix || strings.HasPrefix(field, prefix+".") || strings.HasPrefix(field, prefix+"[") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// the custom resource through which projects are managed by the operator
+const (
+	crdGroup    = "gproj.dev"
+	crdVersion  = "v1alpha1"
+	crdKind     = "GoogleCloudProject"
+	crdPlural   = "googlecloudprojects"
+	crdSingular = "googlecloudproject"
+
+	// finalizer that keeps a resource around until the operator has handled its deletion
+	operatorFinalizer = crdGroup + "/finalizer"
+
+	// annotation that says what to do with the project when the resource is deleted:
+	// "delete" to delete the project, or "orphan" (the default) to leave it be
+	deletionPolicyAnnotation = crdGroup + "/deletion-policy"
+)
+
+// where the service account of a pod is mounted
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeClient makes requests to the kubernetes API server
+type kubeClient struct {
+	server string
+	token  string // bearer token, or empty when talking to kubectl proxy
+	client *http.Client
+}
+
+// connect to the API server of the cluster in which the operator is running, using the
+// pod's service account, or to the given server without credentials, as for kubectl proxy
+func newKubeClient(server string) (*kubeClient, error) {
+	if server != "" {
+		return &kubeClient{server: server, client: http.DefaultClient}, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a kubernetes cluster; use --api-server to give the address of the API server, e.g. from kubectl proxy")
+	}
+	token, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading service account token: %w", err)
+	}
+	ca, err := os.ReadFile(filepath.Join(serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading cluster CA certificate: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificates found in the cluster CA certificate")
+	}
+
+	return &kubeClient{
+		server: "https://" + host + ":" + port,
+		token:  string(bytes.TrimSpace(token)),
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+	}, nil
+}
+
+// make a request to the API server, decoding the response into out if it is not nil
+func (k *kubeClient) do(ctx context.Context, method, path, contentType string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("error marshalling request: %w", err)
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, k.server+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		// the API server explains errors with a Status object
+		var status struct {
+			Message string `json:"message"`
+		}
+		buf, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(buf, &status) == nil && status.Message != "" {
+			return fmt.Errorf("%s %s: %s", method, path, status.Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// googleCloudProject is a GoogleCloudProject custom resource. Its spec has the same fields
+// as googlecloudproject.yaml.
+type googleCloudProject struct {
+	Metadata struct {
+		Name              string            `json:"name"`
+		Namespace         string            `json:"namespace"`
+		Generation        int64             `json:"generation"`
+		DeletionTimestamp string            `json:"deletionTimestamp,omitempty"`
+		Finalizers        []string          `json:"finalizers,omitempty"`
+		Annotations       map[string]string `json:"annotations,omitempty"`
+	} `json:"metadata"`
+	Spec   json.RawMessage          `json:"spec"`
+	Status googleCloudProjectStatus `json:"status"`
+}
+
+// googleCloudProjectStatus is what the operator reports about a resource
+type googleCloudProjectStatus struct {
+	Phase              string `json:"phase,omitempty"` // Ready, Failed, or Deleting
+	Message            string `json:"message,omitempty"`
+	ProjectID          string `json:"projectId,omitempty"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	LastReconciled     string `json:"lastReconciled,omitempty"`
+}
+
+// the API path for GoogleCloudProject resources, in one namespace or in all of them
+func crdPath(namespace string) string {
+	if namespace == "" {
+		return fmt.Sprintf("/apis/%s/%s/%s", crdGroup, crdVersion, crdPlural)
+	}
+	return fmt.Sprintf("/apis/%s/%s/namespaces/%s/%s", crdGroup, crdVersion, namespace, crdPlural)
+}
+
+// the API path for a single resource
+func (r *googleCloudProject) path() string {
+	return crdPath(r.Metadata.Namespace) + "/" + r.Metadata.Name
+}
+
+// determine whether the resource carries the operator's finalizer
+func (r *googleCloudProject) hasFinalizer() bool {
+	for _, f := range r.Metadata.Finalizers {
+		if f == operatorFinalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// decode the spec of a resource, giving it a path under the state dir so that local state
+// for each resource is kept apart. Anyone who can create a resource can write its spec, so
+// it may not run commands or refer to files outside that directory.
+func (r *googleCloudProject) projectSpec(stateDir string, allowedPlugins []string) (*ProjectSpec, error) {
+	path := filepath.Join(stateDir, r.Metadata.Namespace, r.Metadata.Name, gprojFile)
+	spec, err := decodeRemoteSpec(r.Spec, path, allowedPlugins)
+	if err != nil {
+		return nil, err
+	}
+	if spec.ID == "" {
+		return nil, errors.New("spec.id is required")
+	}
+	return spec, nil
+}
+
+// operatorState holds what the operator needs to reconcile resources
+type operatorState struct {
+	args *args
+	kube *kubeClient
+}
+
+// record the outcome of reconciling a resource in its status
+func (o *operatorState) setStatus(ctx context.Context, r *googleCloudProject, status googleCloudProjectStatus) error {
+	status.ObservedGeneration = r.Metadata.Generation
+	status.LastReconciled = time.Now().UTC().Format(time.RFC3339)
+	patch := map[string]interface{}{"status": status}
+	return o.kube.do(ctx, "PATCH", r.path()+"/status", "application/merge-patch+json", patch, nil)
+}
+
+// replace the finalizers of a resource
+func (o *operatorState) setFinalizers(ctx context.Context, r *googleCloudProject, finalizers []string) error {
+	patch := map[string]interface{}{"metadata": map[string]interface{}{"finalizers": finalizers}}
+	return o.kube.do(ctx, "PATCH", r.path(), "application/merge-patch+json", patch, nil)
+}
+
+// how long to wait before trying again to reconcile a resource that failed, if its spec
+// has not changed in the meantime
+const operatorRetryDelay = 5 * time.Minute
+
+// determine whether a resource is due to be reconciled: when its spec has changed since it
+// was last reconciled, when the last attempt failed, or when it is time to check for drift
+func (o *operatorState) due(r *googleCloudProject) bool {
+	if r.Metadata.DeletionTimestamp != "" {
+		return r.hasFinalizer()
+	}
+	if r.Status.ObservedGeneration != r.Metadata.Generation {
+		return true
+	}
+	last, err := time.Parse(time.RFC3339, r.Status.LastReconciled)
+	if err != nil {
+		return true
+	}
+	if r.Status.Phase != "Ready" {
+		return time.Since(last) >= operatorRetryDelay
+	}
+	return time.Since(last) >= o.args.Operator.Resync
+}
+
+// bring the project for a resource in line with its spec, or handle the deletion of the
+// resource, and return the status to record
+func (o *operatorState) reconcile(ctx context.Context, r *googleCloudProject) (googleCloudProjectStatus, error) {
+	// deletion is handled before the spec is checked against the restrictions, which may
+	// have changed since it was applied, so that a resource whose spec is no longer allowed
+	// can still be deleted
+	if r.Metadata.DeletionTimestamp != "" {
+		return o.finalize(ctx, r)
+	}
+
+	spec, err := r.projectSpec(o.args.Operator.StateDir, o.args.Operator.AllowedPlugins)
+	if err != nil {
+		return googleCloudProjectStatus{}, err
+	}
+	status := googleCloudProjectStatus{ProjectID: spec.ID}
+
+	if !r.hasFinalizer() {
+		err = o.setFinalizers(ctx, r, append(r.Metadata.Finalizers, operatorFinalizer))
+		if err != nil {
+			return status, err
+		}
+	}
+
+	p, err := makePlan(ctx, o.args, spec)
+	if err != nil {
+		return status, err
+	}
+	if !p.Create && len(p.Changes) == 0 {
+		status.Phase = "Ready"
+		status.Message = p.summary()
+		return status, nil
+	}
+
+	logf("%s/%s: %s", r.Metadata.Namespace, r.Metadata.Name, p.summary())
+	err = fsys.MkdirAll(filepath.Dir(spec.path), dirPerm)
+	if err != nil {
+		return status, fmt.Errorf("error creating state dir: %w", err)
+	}
+	o.args.Apply = &applyArgs{Parallelism: 4}
+	err = applySpec(ctx, o.args, spec)
+	if err != nil {
+		return status, err
+	}
+	status.Phase = "Ready"
+	status.Message = fmt.Sprintf("applied %d changes", len(p.Changes))
+	return status, nil
+}
+
+// handle the deletion of a resource by deleting its project if the deletion policy says
+// to, and then removing the operator's finalizer so that the resource can go
+func (o *operatorState) finalize(ctx context.Context, r *googleCloudProject) (googleCloudProjectStatus, error) {
+	status := googleCloudProjectStatus{Phase: "Deleting", ProjectID: r.Status.ProjectID}
+	if r.Metadata.Annotations[deletionPolicyAnnotation] == "delete" {
+		// only the ID and the lifecycle settings are needed, and nothing in the spec is
+		// run, so it is decoded without the restrictions
+		path := filepath.Join(o.args.Operator.StateDir, r.Metadata.Namespace, r.Metadata.Name, gprojFile)
+		spec, err := parseProjectSpec(r.Spec, path)
+		if err == nil && spec.ID == "" {
+			err = errors.New("spec.id is required")
+		}
+		if err != nil {
+			// without the spec there is no telling whether the project may be deleted, so
+			// it is kept rather than leaving the resource stuck
+			logf("%s/%s: not deleting the project since the spec cannot be read: %v", r.Metadata.Namespace, r.Metadata.Name, err)
+		} else {
+			status.ProjectID = spec.ID
+			if err := deleteManagedProject(ctx, o.args, spec, false); err != nil {
+				return status, err
+			}
+		}
+	}
+
+	var rest []string
+	for _, f := range r.Metadata.Finalizers {
+		if f != operatorFinalizer {
+			rest = append(rest, f)
+		}
+	}
+	return status, o.setFinalizers(ctx, r, rest)
+}
+
+// list the resources and reconcile those that are due, one at a time
+func (o *operatorState) reconcileAll(ctx context.Context) error {
+	var list struct {
+		Items []*googleCloudProject `json:"items"`
+	}
+	err := o.kube.do(ctx, "GET", crdPath(o.args.Operator.Namespace), "", nil, &list)
+	if err != nil {
+		return fmt.Errorf("error listing %s resources: %w", crdKind, err)
+	}
+
+	for _, r := range list.Items {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !o.due(r) {
+			continue
+		}
+		name := r.Metadata.Namespace + "/" + r.Metadata.Name
+		status, err := o.reconcile(ctx, r)
+		if err != nil {
+			logf("error reconciling %s: %v", name, err)
+			status.Phase = "Failed"
+			status.Message = err.Error()
+		}
+		if r.Metadata.DeletionTimestamp != "" && err == nil {
+			continue // the resource is gone now that its finalizer is removed
+		}
+		if err := o.setStatus(ctx, r, status); err != nil {
+			logf("error updating status of %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// the CustomResourceDefinition for GoogleCloudProject, for gproj operator --print-crd. The
+// spec is not described in the schema because the operator checks it when decoding it, and
+// reports problems in the status.
+func customResourceDefinition() map[string]interface{} {
+	preserve := map[string]interface{}{"type": "object", "x-kubernetes-preserve-unknown-fields": true}
+	return map[string]interface{}{
+		"apiVersion": "apiextensions.k8s.io/v1",
+		"kind":       "CustomResourceDefinition",
+		"metadata":   map[string]interface{}{"name": crdPlural + "." + crdGroup},
+		"spec": map[string]interface{}{
+			"group": crdGroup,
+			"scope": "Namespaced",
+			"names": map[string]interface{}{
+				"kind":       crdKind,
+				"plural":     crdPlural,
+				"singular":   crdSingular,
+				"shortNames": []string{"gcp"},
+			},
+			"versions": []interface{}{
+				map[string]interface{}{
+					"name":         crdVersion,
+					"served":       true,
+					"storage":      true,
+					"subresources": map[string]interface{}{"status": map[string]interface{}{}},
+					"schema": map[string]interface{}{
+						"openAPIV3Schema": map[string]interface{}{
+							"type": "object",
+							"properties": map[string]interface{}{
+								"spec":   preserve,
+								"status": preserve,
+							},
+						},
+					},
+					"additionalPrinterColumns": []interface{}{
+						map[string]interface{}{"name": "Project", "type": "string", "jsonPath": ".spec.id"},
+						map[string]interface{}{"name": "Phase", "type": "string", "jsonPath": ".status.phase"},
+						map[string]interface{}{"name": "Message", "type": "string", "jsonPath": ".status.message", "priority": 1},
+						map[string]interface{}{"name": "Age", "type": "date", "jsonPath": ".metadata.creationTimestamp"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// operator reconciles GoogleCloudProject resources in a kubernetes cluster until interrupted
+func operator(ctx context.Context, args *args) error {
+	if args.Operator.PrintCRD {
+		buf, err := yaml.Marshal(customResourceDefinition())
+		if err != nil {
+			return fmt.Errorf("error marshalling custom resource definition: %w", err)
+		}
+		fmt.Print(string(buf))
+		return nil
+	}
+	if args.Offline {
+		return errors.New("gproj operator cannot be used with --offline")
+	}
+
+	kube, err := newKubeClient(args.Operator.APIServer)
+	if err != nil {
+		return err
+	}
+
+	// there is nobody to answer prompts
+	args.CI = true
+	o := operatorState{args: args, kube: kube}
+
+	where := "all namespaces"
+	if args.Operator.Namespace != "" {
+		where = "namespace " + args.Operator.Namespace
+	}
+	logf("reconciling %s resources in %s", crdKind, where)
+
+	t := time.NewTicker(args.Operator.Interval)
+	defer t.Stop()
+	for {
+		if err := o.reconcileAll(ctx); err != nil {
+			logf("%v", err)
+		}
+		select {
+		case <-ctx.Done():
+			logf("stopping")
+			return nil
+		case <-t.C:
+		}
+	}
+}
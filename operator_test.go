@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOperatorProjectSpec(t *testing.T) {
+	r := &googleCloudProject{}
+	r.Metadata.Namespace = "team-a"
+	r.Metadata.Name = "app"
+
+	r.Spec = []byte(`{"id": "acme-app", "state": {"local": "state.json"}}`)
+	spec, err := r.projectSpec("/var/lib/gproj", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("/var/lib/gproj", "team-a", "app", gprojFile); spec.path != want {
+		t.Errorf("got path %q, want %q", spec.path, want)
+	}
+
+	// state of another resource in another namespace
+	r.Spec = []byte(`{"id": "acme-app", "state": {"local": "../../team-b/app/.gproj.state.json"}}`)
+	if _, err := r.projectSpec("/var/lib/gproj", nil); err == nil || !strings.Contains(err.Error(), "state.local") {
+		t.Errorf("expected a state.local error, got %v", err)
+	}
+
+	r.Spec = []byte(`{"id": "acme-app", "plugins": [{"name": "x", "command": "./run.sh"}]}`)
+	if _, err := r.projectSpec("/var/lib/gproj", []string{"dnsimple"}); err == nil {
+		t.Error("expected a plugin command to be refused")
+	}
+}
+
+func TestOperatorFinalize(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		policy  string
+		wantErr string // empty means the finalizer is removed
+	}{
+		{
+			name: "spec that is no longer allowed",
+			spec: `{"id": "acme-app", "plugins": [{"name": "x", "command": "./run.sh"}]}`,
+		},
+		{
+			name:   "spec that cannot be read, with the delete policy",
+			spec:   `{"id": ["not", "a", "string"]}`,
+			policy: "delete",
+		},
+		{
+			name:    "project that must not be destroyed",
+			spec:    `{"id": "acme-app", "lifecycle": {"preventDestroy": true}}`,
+			policy:  "delete",
+			wantErr: "preventDestroy",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var patched bool
+			kube := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.Method == "PATCH" {
+					patched = true
+				}
+				w.Write([]byte("{}"))
+			}))
+			defer kube.Close()
+
+			o := &operatorState{
+				args: &args{Operator: &operatorArgs{StateDir: t.TempDir()}},
+				kube: &kubeClient{server: kube.URL, client: kube.Client()},
+			}
+			r := &googleCloudProject{Spec: []byte(test.spec)}
+			r.Metadata.Namespace = "team-a"
+			r.Metadata.Name = "app"
+			r.Metadata.DeletionTimestamp = "2026-01-01T00:00:00Z"
+			r.Metadata.Finalizers = []string{operatorFinalizer}
+			if test.policy != "" {
+				r.Metadata.Annotations = map[string]string{deletionPolicyAnnotation: test.policy}
+			}
+
+			_, err := o.reconcile(context.Background(), r)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("expected an error containing %q, got %v", test.wantErr, err)
+				}
+				if patched {
+					t.Error("the finalizer was removed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !patched {
+				t.Error("the finalizer was not removed")
+			}
+		})
+	}
+}
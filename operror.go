@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// operationError is the error from a long-running operation, with the structured details
+// that google attaches to it, which often say exactly which permission or service is
+// missing where the message does not
+type operationError struct {
+	Code     int64
+	Message  string
+	Reason   string            // from google.rpc.ErrorInfo, e.g. "SERVICE_DISABLED"
+	Domain   string            // from google.rpc.ErrorInfo, e.g. "googleapis.com"
+	Metadata map[string]string // from google.rpc.ErrorInfo, e.g. service: compute.googleapis.com
+	Details  []string          // precondition, quota, and field violations, one per line
+	Links    []string          // help links, as "description: url"
+}
+
+// a single entry in the details of a google.rpc.Status, which may be any of several types
+// distinguished by "@type"
+type statusDetail struct {
+	Type       string            `json:"@type"`
+	Reason     string            `json:"reason"`
+	Domain     string            `json:"domain"`
+	Metadata   map[string]string `json:"metadata"`
+	Violations []struct {
+		Type        string `json:"type"`
+		Subject     string `json:"subject"`
+		Description string `json:"description"`
+	} `json:"violations"`
+	FieldViolations []struct {
+		Field       string `json:"field"`
+		Description string `json:"description"`
+	} `json:"fieldViolations"`
+	Links []struct {
+		Description string `json:"description"`
+		URL         string `json:"url"`
+	} `json:"links"`
+}
+
+// newOperationError makes an error from the fields of the google.rpc.Status in a failed
+// operation. Details that cannot be decoded are skipped.
+func newOperationError(code int64, message string, details []googleapi.RawMessage) error {
+	e := operationError{Code: code, Message: message}
+	for _, raw := range details {
+		var d statusDetail
+		if err := json.Unmarshal(raw, &d); err != nil {
+			continue
+		}
+		switch strings.TrimPrefix(d.Type, "type.googleapis.com/") {
+		case "google.rpc.ErrorInfo":
+			e.Reason, e.Domain, e.Metadata = d.Reason, d.Domain, d.Metadata
+		case "google.rpc.PreconditionFailure", "google.rpc.QuotaFailure":
+			for _, v := range d.Violations {
+				e.Details = append(e.Details, strings.TrimSpace(fmt.Sprintf("%s %s: %s", v.Type, v.Subject, v.Description)))
+			}
+		case "google.rpc.BadRequest":
+			for _, v := range d.FieldViolations {
+				e.Details = append(e.Details, fmt.Sprintf("%s: %s", v.Field, v.Description))
+			}
+		case "google.rpc.Help":
+			for _, l := range d.Links {
+				e.Links = append(e.Links, fmt.Sprintf("%s: %s", l.Description, l.URL))
+			}
+		}
+	}
+	return &e
+}
+
+// decode the "error" field of an operation that was fetched as untyped JSON
+func operationErrorFromJSON(v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error performing operation: %v", v)
+	}
+	var status struct {
+		Code    int64                  `json:"code"`
+		Message string                 `json:"message"`
+		Details []googleapi.RawMessage `json:"details"`
+	}
+	if err := json.Unmarshal(buf, &status); err != nil {
+		return fmt.Errorf("error performing operation: %s", buf)
+	}
+	return newOperationError(status.Code, status.Message, status.Details)
+}
+
+func (e *operationError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "error performing operation: %v %v", e.Code, e.Message)
+	if e.Reason != "" {
+		fmt.Fprintf(&b, "\n  reason: %s", e.Reason)
+		if e.Domain != "" {
+			fmt.Fprintf(&b, " (%s)", e.Domain)
+		}
+	}
+	var keys []string
+	for k := range e.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n  %s: %s", k, e.Metadata[k])
+	}
+	for _, d := range e.Details {
+		fmt.Fprintf(&b, "\n  %s", d)
+	}
+	for _, l := range e.Links {
+		fmt.Fprintf(&b, "\n  see %s", l)
+	}
+	if hint := e.remediation(); hint != "" {
+		fmt.Fprintf(&b, "\n%s", hint)
+	}
+	return b.String()
+}
+
+// remediation suggests what to do about the most common reasons for an operation to fail
+func (e *operationError) remediation() string {
+	m := e.Metadata
+	switch e.Reason {
+	case "SERVICE_DISABLED":
+		if m["service"] != "" {
+			return fmt.Sprintf("%s is not enabled in %s; add it to the apis in the spec, or enable it with\n  $ gproj apis enable %s",
+				m["service"], orDefault(m["consumer"], "the project"), m["service"])
+		}
+		return "a service that the operation needs is not enabled; add it to the apis in the spec"
+	case "IAM_PERMISSION_DENIED":
+		if m["permission"] != "" {
+			return fmt.Sprintf("you lack %s on %s; ask an owner of it for a role that includes that permission",
+				m["permission"], orDefault(m["resource"], "the resource"))
+		}
+		return "you lack a permission that the operation needs; ask a project owner for a role that includes it"
+	case "BILLING_DISABLED":
+		return fmt.Sprintf("%s has no billing account; set billing in the spec (see \"gproj explain billing\")",
+			orDefault(m["consumer"], "the project"))
+	case "USER_PROJECT_DENIED":
+		return "you lack serviceusage.services.use on the quota project; set --quota-project to a project in which you have it"
+	case "RATE_LIMIT_EXCEEDED", "RESOURCE_EXHAUSTED":
+		if m["quota_metric"] != "" {
+			return fmt.Sprintf("the %s quota is used up; try again later, or request more in the console under IAM & Admin > Quotas",
+				m["quota_metric"])
+		}
+		return "a quota is used up; try again later, or request more in the console under IAM & Admin > Quotas"
+	case "ACCESS_TOKEN_SCOPE_INSUFFICIENT":
+		return "the credentials lack the scope that the operation needs; log in again with\n  $ gcloud auth application-default login"
+	case "SERVICE_CONFIG_NOT_FOUND_OR_PERMISSION_DENIED":
+		return fmt.Sprintf("%s does not exist or is not visible to you; see \"gproj apis\" for the services that are available",
+			orDefault(m["service"], "the service"))
+	}
+	return ""
+}
+
+// return s, or def if s is empty
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
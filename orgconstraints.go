@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+	crmv3 "google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/essentialcontacts/v1"
+)
+
+// the permission needed on an organization or folder in order to create projects in it
+const projectCreatePermission = "resourcemanager.projects.create"
+
+// the org policy constraint that limits which services can be enabled
+const servicesConstraint = "constraints/serviceuser.services"
+
+// work out where a new project would be created: the parent in the spec, or otherwise the
+// organization that the caller belongs to, since that is where google puts projects
+// created without a parent by members of an organization. Returns the empty string if
+// this cannot be known.
+func creationParent(ctx context.Context, resources *cloudresourcemanager.Service, spec *ProjectSpec) string {
+	if spec.Parent != "" {
+		return spec.Parent
+	}
+	resp, err := resources.Organizations.Search(&cloudresourcemanager.SearchOrganizationsRequest{}).Context(ctx).Do()
+	if err != nil || len(resp.Organizations) != 1 {
+		return ""
+	}
+	return resp.Organizations[0].Name
+}
+
+// get the effective list policy for a constraint on an organization or folder, or nil if
+// there is none
+func effectiveListPolicy(ctx context.Context, resources *cloudresourcemanager.Service, parent, constraint string) (*cloudresourcemanager.ListPolicy, error) {
+	req := &cloudresourcemanager.GetEffectiveOrgPolicyRequest{Constraint: constraint}
+	var policy *cloudresourcemanager.OrgPolicy
+	var err error
+	if strings.HasPrefix(parent, "folders/") {
+		policy, err = resources.Folders.GetEffectiveOrgPolicy(parent, req).Context(ctx).Do()
+	} else {
+		policy, err = resources.Organizations.GetEffectiveOrgPolicy(parent, req).Context(ctx).Do()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy.ListPolicy, nil
+}
+
+// strip the "is:" prefix that list policy values may carry
+func policyValue(v string) string {
+	return strings.TrimPrefix(v, "is:")
+}
+
+// determine whether a list policy allows a value. Values in the "under:" and "in:" forms
+// refer to groups that cannot be expanded here, and are taken to allow everything.
+func listPolicyAllows(policy *cloudresourcemanager.ListPolicy, value string) bool {
+	if policy == nil {
+		return true
+	}
+	switch policy.AllValues {
+	case "ALLOW":
+		return true
+	case "DENY":
+		return false
+	}
+	for _, v := range policy.DeniedValues {
+		if policyValue(v) == value {
+			return false
+		}
+	}
+	if len(policy.AllowedValues) == 0 {
+		return true
+	}
+	for _, v := range policy.AllowedValues {
+		if policyValue(v) == value || strings.HasPrefix(v, "under:") || strings.HasPrefix(v, "in:") {
+			return true
+		}
+	}
+	return false
+}
+
+// look up the people to ask about the policies on an organization or folder, from its
+// essential contacts for technical matters
+func technicalContacts(ctx context.Context, conn *connection, parent string) []string {
+	svc, err := essentialcontacts.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil
+	}
+	var resp *essentialcontacts.GoogleCloudEssentialcontactsV1ComputeContactsResponse
+	if strings.HasPrefix(parent, "folders/") {
+		resp, err = svc.Folders.Contacts.Compute(parent).NotificationCategories("TECHNICAL").Context(ctx).Do()
+	} else {
+		resp, err = svc.Organizations.Contacts.Compute(parent).NotificationCategories("TECHNICAL").Context(ctx).Do()
+	}
+	if err != nil {
+		return nil
+	}
+	var emails []string
+	for _, c := range resp.Contacts {
+		emails = append(emails, c.Email)
+	}
+	return emails
+}
+
+// creationFailures looks at the permissions and the effective org policies on the parent
+// in which a project would be created, and explains each reason that creating the project
+// as the spec describes is expected to fail, along with policies that may reject parts of
+// it. It is best-effort: policies that cannot be read are not reported, and apply remains
+// the final word.
+func creationFailures(ctx context.Context, args *args, spec *ProjectSpec) (failures, warnings []string, err error) {
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parent := creationParent(ctx, resources, spec)
+	if parent == "" {
+		return nil, nil, nil
+	}
+
+	// creating projects in an organization or folder is often limited to a few people or
+	// to particular folders
+	var granted []string
+	var tested bool
+	if strings.HasPrefix(parent, "folders/") {
+		v3, err := crmv3.NewService(ctx, conn.options()...)
+		if err != nil {
+			return nil, nil, err
+		}
+		resp, err := v3.Folders.TestIamPermissions(parent, &crmv3.TestIamPermissionsRequest{
+			Permissions: []string{projectCreatePermission},
+		}).Context(ctx).Do()
+		if err == nil {
+			granted, tested = resp.Permissions, true
+		}
+	} else {
+		resp, err := resources.Organizations.TestIamPermissions(parent, &cloudresourcemanager.TestIamPermissionsRequest{
+			Permissions: []string{projectCreatePermission},
+		}).Context(ctx).Do()
+		if err == nil {
+			granted, tested = resp.Permissions, true
+		}
+	}
+	if tested && !contains(granted, projectCreatePermission) {
+		failures = append(failures, fmt.Sprintf("you lack %s on %s, so the project cannot be created there; "+
+			"ask for the Project Creator role on it, or set the parent in the spec to a folder in which you may create projects",
+			projectCreatePermission, parent))
+	}
+
+	// the organization may limit which services can be enabled
+	if policy, err := effectiveListPolicy(ctx, resources, parent, servicesConstraint); err == nil {
+		var denied []string
+		for _, api := range desiredAPIs(spec) {
+			if !listPolicyAllows(policy, api) {
+				denied = append(denied, api)
+			}
+		}
+		if len(denied) > 0 {
+			failures = append(failures, fmt.Sprintf("the %s org policy on %s does not allow %s to be enabled",
+				servicesConstraint, parent, strings.Join(denied, ", ")))
+		}
+	}
+
+	// restricted workspace domains limit who can be granted roles, but which domains belong
+	// to which workspace customers cannot be looked up, so this is only a warning
+	if policy, err := effectiveListPolicy(ctx, resources, parent, domainRestrictionConstraint); err == nil && policy != nil && len(policy.AllowedValues) > 0 {
+		if len(spec.IAM) > 0 || len(spec.ActAs) > 0 {
+			var customers []string
+			for _, v := range policy.AllowedValues {
+				customers = append(customers, policyValue(v))
+			}
+			warnings = append(warnings, fmt.Sprintf("the %s org policy on %s only allows members of workspace customers %s "+
+				"to be granted roles, so grants in the spec to anyone else will fail (see \"gproj explain allowedMemberDomains\")",
+				domainRestrictionConstraint, parent, strings.Join(customers, ", ")))
+		}
+	}
+
+	// say who to ask
+	if len(failures) > 0 {
+		if contacts := technicalContacts(ctx, conn, parent); len(contacts) > 0 {
+			for i := range failures {
+				failures[i] += fmt.Sprintf(" (technical contacts for %s: %s)", parent, strings.Join(contacts, ", "))
+			}
+		}
+	}
+	return failures, warnings, nil
+}
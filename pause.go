@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/cloudbilling/v1"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/serviceusage/v1"
+)
+
+// the label on a project that "gproj pause" has mothballed, whose value is the date on
+// which it was paused
+const pausedLabel = "gproj-paused"
+
+// APIs that are left enabled when a project is paused, since gproj needs them to resume it
+// and they cost nothing on their own
+var essentialAPIs = []string{
+	"cloudbilling.googleapis.com",
+	"cloudresourcemanager.googleapis.com",
+	"iam.googleapis.com",
+	"iamcredentials.googleapis.com",
+	"logging.googleapis.com",
+	"monitoring.googleapis.com",
+	"serviceusage.googleapis.com",
+	"storage-api.googleapis.com",
+	"storage-component.googleapis.com",
+	"storage.googleapis.com",
+}
+
+// PausedProject records what "gproj pause" turned off, so that it can be turned back on
+type PausedProject struct {
+	PausedAt       time.Time
+	BillingAccount string   `json:",omitempty"` // billing account that was unlinked, if any
+	APIs           []string // APIs that were disabled
+}
+
+// get where the record of a paused project is kept: the state configured in the spec, or
+// the default local state file if the spec does not configure one, since the record must
+// outlive the command
+func pauseBackend(ctx context.Context, conn *connection, spec *ProjectSpec) (stateBackend, error) {
+	backend, err := newStateBackend(ctx, conn, spec)
+	if err != nil || backend != nil {
+		return backend, err
+	}
+	return &localBackend{path: filepath.Join(filepath.Dir(spec.path), defaultStateFile)}, nil
+}
+
+// load the state, change it, and save it again while holding the lock
+func updateState(ctx context.Context, backend stateBackend, update func(*State) error) error {
+	unlock, err := lockState(ctx, backend)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := backend.Load(ctx)
+	if err != nil {
+		return err
+	}
+	err = update(state)
+	if err != nil {
+		return err
+	}
+	return backend.Save(ctx, state)
+}
+
+// set or remove the paused label on a project
+func setPausedLabel(ctx context.Context, resources *cloudresourcemanager.Service, project *cloudresourcemanager.Project, value string) error {
+	updated := *project
+	updated.Labels = make(map[string]string)
+	for k, v := range project.Labels {
+		updated.Labels[k] = v
+	}
+	if value == "" {
+		delete(updated.Labels, pausedLabel)
+	} else {
+		updated.Labels[pausedLabel] = value
+	}
+	_, err := resources.Projects.Update(project.ProjectId, &updated).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error updating labels on %s: %w", project.ProjectId, err)
+	}
+	return nil
+}
+
+// pause unlinks the project from its billing account and disables every API that is not
+// needed to resume it, recording both so that "gproj resume-project" can undo it. Data in
+// the project is kept, but services that need billing or the disabled APIs stop working.
+func pause(ctx context.Context, args *args) error {
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
+	billing, err := cloudbilling.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the billing API: %w", err)
+	}
+	usage, err := serviceusage.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the service usage API: %w", err)
+	}
+
+	project, err := resources.Projects.Get(spec.ID).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error getting project %s: %w", spec.ID, err)
+	}
+	err = checkManaged(project)
+	if err != nil {
+		return err
+	}
+	if date := project.Labels[pausedLabel]; date != "" {
+		return fmt.Errorf("project %s was already paused on %s", spec.ID, date)
+	}
+
+	info, err := billing.Projects.GetBillingInfo("projects/" + spec.ID).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error getting billing info for %s: %w", spec.ID, err)
+	}
+	enabled, err := enabledAPIs(ctx, usage, project.ProjectNumber)
+	if err != nil {
+		return err
+	}
+	var toDisable []string
+	for _, api := range enabled {
+		if !contains(essentialAPIs, api) {
+			toDisable = append(toDisable, api)
+		}
+	}
+	sort.Strings(toDisable)
+
+	// show what is about to happen and confirm
+	fmt.Printf("will pause project %s:\n", spec.ID)
+	if info.BillingAccountName != "" {
+		fmt.Printf("  - unlink billing account %s\n", info.BillingAccountName)
+	}
+	for _, api := range toDisable {
+		fmt.Printf("  - disable %s\n", api)
+	}
+	if !args.Pause.Yes {
+		ok, err := promptConfirm(args, "continue?", "--yes")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("not confirmed, not pausing anything")
+		}
+	}
+
+	// record what is about to be turned off before turning anything off, so that a pause
+	// that fails halfway can still be resumed
+	backend, err := pauseBackend(ctx, conn, spec)
+	if err != nil {
+		return err
+	}
+	err = updateState(ctx, backend, func(state *State) error {
+		state.ProjectID = spec.ID
+		state.Paused = &PausedProject{PausedAt: time.Now(), BillingAccount: info.BillingAccountName, APIs: toDisable}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error recording the pause, nothing was changed: %w", err)
+	}
+	fmt.Printf("recorded billing and APIs in %s\n", backend)
+
+	err = setPausedLabel(ctx, resources, project, time.Now().Format("2006-01-02"))
+	if err != nil {
+		return err
+	}
+
+	err = unlinkBilling(ctx, conn, spec.ID)
+	if err != nil {
+		return err
+	}
+
+	// disabling an API also disables those that depend on it, which may be later in the list,
+	// and disabling an API that is already disabled does nothing
+	waitCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+	for _, api := range toDisable {
+		op, err := usage.Services.Disable(fmt.Sprintf("%s/services/%s", formatProjectNumber(project.ProjectNumber), api), &serviceusage.DisableServiceRequest{
+			DisableDependentServices: true,
+			CheckIfServiceHasUsage:   "SKIP",
+		}).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("error disabling %s: %w", api, err)
+		}
+		err = waitForEnable(waitCtx, usage.Operations, op)
+		if err != nil {
+			return fmt.Errorf("error disabling %s: %w", api, err)
+		}
+		fmt.Printf("disabled %s\n", api)
+	}
+
+	fmt.Printf("paused %s; run \"gproj resume-project\" to turn it back on\n", spec.ID)
+	return nil
+}
+
+// resumeProject relinks the billing account and re-enables the APIs that "gproj pause"
+// turned off
+func resumeProject(ctx context.Context, args *args) error {
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
+	billing, err := cloudbilling.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the billing API: %w", err)
+	}
+	usage, err := serviceusage.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the service usage API: %w", err)
+	}
+
+	backend, err := pauseBackend(ctx, conn, spec)
+	if err != nil {
+		return err
+	}
+	state, err := backend.Load(ctx)
+	if err != nil {
+		return err
+	}
+	paused := state.Paused
+	if paused == nil {
+		return fmt.Errorf("there is no record in %s of project %s being paused", backend, spec.ID)
+	}
+	fmt.Printf("resuming %s, which was paused %s\n", spec.ID, humanAgo(paused.PausedAt))
+
+	project, err := resources.Projects.Get(spec.ID).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error getting project %s: %w", spec.ID, err)
+	}
+
+	// billing first, since many APIs cannot be enabled without it
+	if paused.BillingAccount != "" {
+		err = checkBillingAccount(ctx, billing, paused.BillingAccount)
+		if err != nil {
+			return err
+		}
+		_, err = billing.Projects.UpdateBillingInfo("projects/"+spec.ID, &cloudbilling.ProjectBillingInfo{
+			BillingAccountName: paused.BillingAccount,
+		}).Context(ctx).Do()
+		if err != nil {
+			return explainBillingError(ctx, billing, spec.ID, paused.BillingAccount, err)
+		}
+		fmt.Printf("linked %s to billing account %s\n", spec.ID, paused.BillingAccount)
+	}
+
+	if len(paused.APIs) > 0 {
+		err = batchEnable(ctx, usage, project.ProjectNumber, paused.APIs)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("enabled %s\n", strings.Join(paused.APIs, ", "))
+	}
+
+	err = setPausedLabel(ctx, resources, project, "")
+	if err != nil {
+		return err
+	}
+	err = updateState(ctx, backend, func(state *State) error {
+		state.Paused = nil
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("project %s was resumed but the record of the pause could not be removed: %w", spec.ID, err)
+	}
+
+	fmt.Printf("resumed %s\n", spec.ID)
+	return nil
+}
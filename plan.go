@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// plan is the set of changes that apply would make to the project. Changes to existing
+// cloud run services and scheduler jobs are not included since apply compares those one
+// at a time as it goes.
+type plan struct {
+	ProjectID   string
+	Create      bool         // whether the project will be created
+	Changes     []specChange // differences between the live project and the spec
+	Blocked     []string     // APIs to be enabled that need billing, which the project will not have
+	Failures    []string     // reasons that creating the project is expected to fail, from the parent's policies
+	Warnings    []string     // policies on the parent that may reject parts of the spec
+	Spec        *ProjectSpec // the spec from which the plan was made
+	SpecPath    string       // path to the spec from which the plan was made
+	Fingerprint string       // fingerprint of the live project when the plan was made
+	Created     time.Time    // when the plan was made
+}
+
+// compute a fingerprint of a spec that changes if any field of the spec changes
+func fingerprint(spec *ProjectSpec) string {
+	var lines []string
+	for field, value := range flattenSpec(spec) {
+		lines = append(lines, field+"="+value)
+	}
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// makePlan compares the spec to the live project to work out what apply would change
+func makePlan(ctx context.Context, args *args, spec *ProjectSpec) (*plan, error) {
+	live, desired, err := comparableSpecs(ctx, args, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	// APIs that need billing cannot be enabled if billing is neither linked nor going to be
+	var blocked []string
+	if live.Billing == "" && desired.Billing == "" && !live.isUnknown("billing") {
+		var toEnable []string
+		for _, api := range desired.APIs {
+			if !contains(live.APIs, api) {
+				toEnable = append(toEnable, api)
+			}
+		}
+		blocked = needBilling(toEnable)
+	}
+
+	// explain up front why creating the project would fail, since the errors from the
+	// create itself say little more than that it was forbidden
+	create := live.ID == ""
+	var failures, warnings []string
+	if create && !args.Offline {
+		failures, warnings, err = creationFailures(ctx, args, spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &plan{
+		ProjectID:   spec.ID,
+		Blocked:     blocked,
+		Failures:    failures,
+		Warnings:    warnings,
+		Create:      create,
+		Changes:     diffSpecs(live, desired),
+		Spec:        spec,
+		SpecPath:    spec.path,
+		Fingerprint: fingerprint(live),
+		Created:     time.Now(),
+	}, nil
+}
+
+// write a plan to a file so that it can be reviewed and then applied later
+func writePlan(path string, p *plan) error {
+	buf, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling plan to json: %w", err)
+	}
+	// plans of encrypted specs contain the decrypted secrets, so only the owner may read them
+	if p.Spec.encrypted {
+		fmt.Printf("warning: %s contains the decrypted spec, so keep it somewhere safe\n", path)
+		err = writePrivateFile(fsys, path, buf)
+	} else {
+		err = fsys.WriteFile(path, buf, filePerm)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing plan to %s: %w", path, err)
+	}
+	return nil
+}
+
+// readPlan reads a plan written by writePlan
+func readPlan(path string) (*plan, error) {
+	buf, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading plan: %w", err)
+	}
+
+	var p plan
+	err = json.Unmarshal(buf, &p)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding plan in %s: %w", path, err)
+	}
+	if p.Spec == nil {
+		return nil, fmt.Errorf("%s does not contain a spec; was it written by gproj plan --out?", path)
+	}
+	p.Spec.path = p.SpecPath
+	return &p, nil
+}
+
+// checkPlan makes sure that the live project has not changed since a plan was made, so
+// that applying the plan makes exactly the changes that were reviewed
+func checkPlan(ctx context.Context, args *args, p *plan) error {
+	current, err := makePlan(ctx, args, p.Spec)
+	if err != nil {
+		return err
+	}
+	if current.Fingerprint != p.Fingerprint {
+		return fmt.Errorf("project %s has changed since the plan was made %s; run gproj plan again",
+			p.ProjectID, humanAgo(p.Created))
+	}
+	return nil
+}
+
+// a one-line description of the plan
+func (p *plan) summary() string {
+	switch {
+	case p.Create:
+		return fmt.Sprintf("project %s will be created with %d settings", p.ProjectID, len(p.Changes))
+	case len(p.Changes) == 0:
+		return fmt.Sprintf("project %s is up to date", p.ProjectID)
+	}
+	return fmt.Sprintf("project %s has %d changes", p.ProjectID, len(p.Changes))
+}
+
+// render the plan as plain text
+func (p *plan) text() string {
+	var b strings.Builder
+	b.WriteString(p.summary() + "\n")
+	for _, c := range p.Changes {
+		b.WriteString("  " + c.String() + "\n")
+	}
+	if len(p.Blocked) > 0 {
+		fmt.Fprintf(&b, "warning: billing is not linked, which is needed to enable %s\n", strings.Join(p.Blocked, ", "))
+	}
+	for _, w := range p.Warnings {
+		fmt.Fprintf(&b, "warning: %s\n", w)
+	}
+	for _, f := range p.Failures {
+		fmt.Fprintf(&b, "error: %s\n", f)
+	}
+	return b.String()
+}
+
+// render the plan as markdown that is compact enough to post as a pull request comment
+func (p *plan) markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "### gproj plan for `%s`\n\n", p.ProjectID)
+	fmt.Fprintf(&b, "**%s**\n", p.summary())
+	if len(p.Blocked) > 0 {
+		fmt.Fprintf(&b, "\n> **Warning:** billing is not linked, which is needed to enable %s\n", strings.Join(p.Blocked, ", "))
+	}
+	for _, w := range p.Warnings {
+		fmt.Fprintf(&b, "\n> **Warning:** %s\n", w)
+	}
+	for _, f := range p.Failures {
+		fmt.Fprintf(&b, "\n> **Error:** %s\n", f)
+	}
+	if len(p.Changes) == 0 {
+		return b.String()
+	}
+
+	// a diff block gets red and green highlighting on github
+	b.WriteString("\n```diff\n")
+	for _, c := range p.Changes {
+		if c.Before != "" && c.After != "" {
+			fmt.Fprintf(&b, "- %s: %s\n+ %s: %s\n", c.Field, c.Before, c.Field, c.After)
+		} else {
+			b.WriteString(c.String() + "\n")
+		}
+	}
+	b.WriteString("```\n")
+	return b.String()
+}
+
+func planCmd(ctx context.Context, args *args) error {
+	// check the flags before doing any work
+	if args.Plan.Format != "text" && args.Plan.Format != "markdown" {
+		return fmt.Errorf("unknown format %q, expected text or markdown", args.Plan.Format)
+	}
+	if args.Plan.Out != "" && args.Offline {
+		return fmt.Errorf("plans made with --offline cannot be applied, so --out is not allowed")
+	}
+
+	// find the project spec
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	err = checkNaming(args, spec)
+	if err != nil {
+		return err
+	}
+
+	p, err := makePlan(ctx, args, spec)
+	if err != nil {
+		return err
+	}
+
+	// the cached catalog is enough, and there is none if the project does not exist yet
+	catalog, _ := offlineAPIs(spec.ID)
+	for _, w := range deprecationWarnings(spec, catalog) {
+		fmt.Println("warning:", w)
+	}
+
+	// check policies before writing the plan so that a plan that violates them cannot be applied
+	if args.Plan.PolicyDir != "" {
+		violations, err := checkPolicies(ctx, args.Plan.PolicyDir, spec, p)
+		if err != nil {
+			return err
+		}
+		if err := reportViolations(spec.path, violations); err != nil {
+			return err
+		}
+	}
+
+	if args.Plan.Format == "markdown" {
+		fmt.Print(p.markdown())
+	} else {
+		fmt.Print(p.text())
+	}
+
+	// a plan that is expected to fail is not written, so that it cannot be applied
+	if len(p.Failures) > 0 {
+		if args.Plan.Out != "" {
+			fmt.Printf("not writing the plan to %s since it is expected to fail\n", args.Plan.Out)
+		}
+		return fmt.Errorf("creating project %s is expected to fail, see above", spec.ID)
+	}
+
+	if args.Plan.Out != "" {
+		err = writePlan(args.Plan.Out, p)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote plan to %s, apply it with\n  $ gproj apply %s\n", args.Plan.Out, args.Plan.Out)
+	}
+	return nil
+}
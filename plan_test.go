@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanCmdChecksFlagsFirst(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    args
+		wantErr string
+	}{
+		{"unknown format", args{Plan: &planArgs{Format: "html"}}, "unknown format"},
+		{"offline plan file", args{Offline: true, Plan: &planArgs{Format: "text"}}, "--offline"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), "plan.json")
+			test.args.Plan.Out = out
+			// the spec does not exist, so any error other than the one about the flags
+			// means that they were checked too late
+			test.args.Spec = filepath.Join(t.TempDir(), "missing.yaml")
+
+			err := planCmd(context.Background(), &test.args)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", test.wantErr, err)
+			}
+			if _, err := os.Stat(out); err == nil {
+				t.Errorf("plan was written to %s", out)
+			}
+		})
+	}
+}
+
+func TestPlanRoundTrip(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		t.Run(fmt.Sprintf("encrypted=%v", encrypted), func(t *testing.T) {
+			dir := t.TempDir()
+			specPath := filepath.Join(dir, "googlecloudproject.yaml")
+			spec := &ProjectSpec{ID: "p", Name: "P", APIs: []string{"run.googleapis.com"}, path: specPath, encrypted: encrypted}
+			p := &plan{
+				ProjectID:   "p",
+				Changes:     []specChange{{Field: "name", Before: "Old", After: "P"}},
+				Spec:        spec,
+				SpecPath:    specPath,
+				Fingerprint: fingerprint(spec),
+				Created:     time.Now(),
+			}
+
+			out := filepath.Join(dir, "plan.json")
+			if err := writePlan(out, p); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readPlan(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Spec.path != specPath {
+				t.Errorf("spec read from the plan has path %q, want %q", got.Spec.path, specPath)
+			}
+			if fingerprint(got.Spec) != fingerprint(spec) {
+				t.Error("spec changed in a round trip through the plan file")
+			}
+			if len(got.Changes) != 1 || got.Changes[0] != p.Changes[0] {
+				t.Errorf("got changes %+v, want %+v", got.Changes, p.Changes)
+			}
+			if !got.Created.Equal(p.Created) {
+				t.Errorf("got created time %v, want %v", got.Created, p.Created)
+			}
+
+			if runtime.GOOS == "windows" {
+				return
+			}
+			st, err := os.Stat(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if private := st.Mode().Perm()&0077 == 0; private != encrypted {
+				t.Errorf("plan file has mode %v, but the spec encrypted=%v", st.Mode().Perm(), encrypted)
+			}
+		})
+	}
+}
+
+func TestReadPlanWithoutSpec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := os.WriteFile(path, []byte(`{"ProjectID": "p"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := readPlan(path)
+	if err == nil || !strings.Contains(err.Error(), "does not contain a spec") {
+		t.Errorf("expected an error saying there is no spec, got %v", err)
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := &ProjectSpec{ID: "p", APIs: []string{"a", "b"}, Labels: map[string]string{"x": "1", "y": "2"}}
+	b := &ProjectSpec{ID: "p", APIs: []string{"b", "a"}, Labels: map[string]string{"y": "2", "x": "1"}}
+	if fingerprint(a) != fingerprint(b) {
+		t.Error("reordering a spec changed its fingerprint")
+	}
+	b.Labels["x"] = "3"
+	if fingerprint(a) == fingerprint(b) {
+		t.Error("changing a label did not change the fingerprint")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// the version of the protocol that gproj speaks with plugins, sent in every request so that
+// plugins can refuse requests that they do not understand
+const pluginProtocolVersion = 1
+
+// Plugin models an entry in the "plugins" section of googlecloudproject.yaml, which declares
+// a resource that is reconciled by an external program rather than by gproj itself.
+//
+// A plugin is an executable that gproj runs once per entry during apply. It receives a
+// pluginRequest as JSON on stdin, and must write a pluginResponse as JSON to stdout.
+// Anything it writes to stderr is shown to the user.
+type Plugin struct {
+	Name    string      // name of this entry, e.g. "dns-records"
+	Kind    string      // kind of resource, e.g. "dnsimple"; the plugin is "gproj-plugin-KIND" on the PATH
+	Command string      // path to the plugin, relative to the spec (default "gproj-plugin-KIND" on the PATH)
+	Config  requestBody // configuration passed to the plugin as is
+}
+
+// pluginRequest is sent to a plugin on stdin
+type pluginRequest struct {
+	Version       int                    `json:"version"`       // protocol version
+	Action        string                 `json:"action"`        // what to do; currently always "apply"
+	Name          string                 `json:"name"`          // name of the entry in the spec
+	ProjectID     string                 `json:"projectId"`     // ID of the project
+	ProjectNumber int64                  `json:"projectNumber"` // number of the project
+	AccessToken   string                 `json:"accessToken"`   // OAuth token for the identity that gproj uses
+	Config        map[string]interface{} `json:"config"`        // the config from the spec
+}
+
+// pluginResponse is read from a plugin's stdout
+type pluginResponse struct {
+	Changes []string          `json:"changes"` // what the plugin changed, if anything
+	Outputs map[string]string `json:"outputs"` // values to publish as CI outputs, prefixed by the entry name
+	Error   string            `json:"error"`   // why the plugin failed, if it did
+}
+
+// find the executable for a plugin
+func pluginPath(spec *ProjectSpec, p Plugin) (string, error) {
+	if p.Command == "" {
+		if p.Kind == "" {
+			return "", fmt.Errorf("plugin %s must have a kind or a command", p.Name)
+		}
+		path, err := exec.LookPath("gproj-plugin-" + p.Kind)
+		if err != nil {
+			return "", fmt.Errorf("plugin %s: gproj-plugin-%s was not found on the PATH", p.Name, p.Kind)
+		}
+		return path, nil
+	}
+	if strings.ContainsRune(p.Command, os.PathSeparator) && !filepath.IsAbs(p.Command) {
+		return filepath.Join(filepath.Dir(spec.path), p.Command), nil
+	}
+	return p.Command, nil
+}
+
+// run a plugin with a request and return its response. A plugin that exits with a non-zero
+// status has failed even if it wrote a response.
+func runPlugin(ctx context.Context, spec *ProjectSpec, p Plugin, req *pluginRequest) (*pluginResponse, error) {
+	path, err := pluginPath(spec, p)
+	if err != nil {
+		return nil, err
+	}
+
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling request for plugin %s: %w", p.Name, err)
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	runErr := cmd.Run()
+
+	var resp pluginResponse
+	decodeErr := json.Unmarshal(stdout.Bytes(), &resp)
+	switch {
+	case decodeErr == nil && resp.Error != "":
+		return nil, fmt.Errorf("plugin %s: %s", p.Name, resp.Error)
+	case runErr != nil:
+		return nil, fmt.Errorf("error running plugin %s: %w", p.Name, runErr)
+	case decodeErr != nil:
+		return nil, fmt.Errorf("plugin %s wrote an invalid response: %w", p.Name, decodeErr)
+	}
+	return &resp, nil
+}
+
+// run a plugin to reconcile the resource it manages, and report whether it changed anything
+func (a *applier) applyPlugin(ctx context.Context, p Plugin) (bool, error) {
+	token, err := a.conn.creds.TokenSource.Token()
+	if err != nil {
+		return false, fmt.Errorf("error getting an access token for plugin %s: %w", p.Name, err)
+	}
+
+	resp, err := runPlugin(ctx, a.spec, p, &pluginRequest{
+		Version:       pluginProtocolVersion,
+		Action:        "apply",
+		Name:          p.Name,
+		ProjectID:     a.spec.ID,
+		ProjectNumber: a.project.ProjectNumber,
+		AccessToken:   token.AccessToken,
+		Config:        p.Config,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	var keys []string
+	for key := range resp.Outputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	a.outputsMu.Lock()
+	for _, key := range keys {
+		a.pluginOutputs = append(a.pluginOutputs, [2]string{p.Name + "-" + key, resp.Outputs[key]})
+	}
+	a.outputsMu.Unlock()
+
+	if len(resp.Changes) == 0 {
+		return false, nil
+	}
+	for _, change := range resp.Changes {
+		fmt.Printf("%s: %s\n", p.Name, change)
+	}
+	noteChange(ctx, "", strings.Join(resp.Changes, ", "))
+	return true, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// policyInput is what policies are evaluated against. The spec uses the same field names as
+// googlecloudproject.yaml, and the plan lists the changes that apply would make.
+type policyInput struct {
+	Spec    interface{}    `json:"spec"`
+	Create  bool           `json:"create"`  // whether apply would create the project
+	Changes []policyChange `json:"changes"` // changes that apply would make
+}
+
+type policyChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// build the input for policies from a spec and the plan for it
+func makePolicyInput(spec *ProjectSpec, p *plan) (*policyInput, error) {
+	// go through YAML so that policies see the field names that people write in the spec
+	buf, err := yaml.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling spec: %w", err)
+	}
+	var doc interface{}
+	err = yaml.Unmarshal(buf, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshalling spec: %w", err)
+	}
+
+	in := policyInput{Spec: jsonCompatible(doc), Create: p.Create}
+	for _, c := range p.Changes {
+		in.Changes = append(in.Changes, policyChange{Field: c.Field, Before: c.Before, After: c.After})
+	}
+	return &in, nil
+}
+
+// find the policy files in a directory with the given extension
+func policyFiles(dir, ext string) ([]string, error) {
+	entries, err := fsys.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy dir: %w", err)
+	}
+	var files []string
+	for _, e := range entries {
+		if !e.IsDir() && filepath.Ext(e.Name()) == ext {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// evaluate rego policies with the opa binary. Policies report violations as messages in the
+// set data.gproj.deny, in the style of conftest.
+func evalRego(ctx context.Context, dir string, input []byte) ([]string, error) {
+	opaPath, err := exec.LookPath("opa")
+	if err != nil {
+		return nil, fmt.Errorf("%s contains rego policies but opa was not found on the PATH: %w", dir, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, opaPath, "eval", "--format", "json", "--data", dir, "--stdin-input", "data.gproj.deny")
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("error evaluating rego policies: %s", msg)
+	}
+
+	var out struct {
+		Result []struct {
+			Expressions []struct {
+				Value []interface{} `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	err = json.Unmarshal(stdout.Bytes(), &out)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding output of opa: %w", err)
+	}
+
+	var violations []string
+	for _, r := range out.Result {
+		for _, e := range r.Expressions {
+			for _, v := range e.Value {
+				violations = append(violations, fmt.Sprint(v))
+			}
+		}
+	}
+	return violations, nil
+}
+
+// evaluate CUE constraints with the cue binary. The input must unify with every
+// constraint, and each error that cue reports is a violation.
+func evalCUE(ctx context.Context, files []string, input []byte) ([]string, error) {
+	cuePath, err := exec.LookPath("cue")
+	if err != nil {
+		return nil, fmt.Errorf("found CUE policies but cue was not found on the PATH: %w", err)
+	}
+
+	// cue reads data from files rather than stdin
+	tmp, err := os.CreateTemp("", "gproj-policy-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary file for policy input: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(input)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error writing policy input: %w", err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, cuePath, append(append([]string{"vet"}, files...), tmp.Name())...)
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("error running cue: %w", err)
+	}
+
+	var violations []string
+	for _, line := range strings.Split(stderr.String(), "\n") {
+		// cue follows each error with indented lines giving the positions involved
+		if line != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			violations = append(violations, line)
+		}
+	}
+	if err != nil && len(violations) == 0 {
+		return nil, fmt.Errorf("error evaluating CUE policies: %w", err)
+	}
+	return violations, nil
+}
+
+// evaluate the policies in a directory against a spec and its plan, and return the violations
+func checkPolicies(ctx context.Context, dir string, spec *ProjectSpec, p *plan) ([]string, error) {
+	in, err := makePolicyInput(spec, p)
+	if err != nil {
+		return nil, err
+	}
+	input, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling policy input: %w", err)
+	}
+
+	rego, err := policyFiles(dir, ".rego")
+	if err != nil {
+		return nil, err
+	}
+	cue, err := policyFiles(dir, ".cue")
+	if err != nil {
+		return nil, err
+	}
+	if len(rego) == 0 && len(cue) == 0 {
+		return nil, fmt.Errorf("no .rego or .cue policies found in %s", dir)
+	}
+
+	var violations []string
+	if len(rego) > 0 {
+		v, err := evalRego(ctx, dir, input)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, v...)
+	}
+	if len(cue) > 0 {
+		v, err := evalCUE(ctx, cue, input)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, v...)
+	}
+	return violations, nil
+}
+
+// print policy violations and return an error if there were any
+func reportViolations(specPath string, violations []string) error {
+	for _, v := range violations {
+		fmt.Printf("%s: policy violation: %s\n", specPath, v)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%d policy violations", len(violations))
+	}
+	return nil
+}
+
+func policyCheck(ctx context.Context, args *args) error {
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	p, err := makePlan(ctx, args, spec)
+	if err != nil {
+		return err
+	}
+
+	violations, err := checkPolicies(ctx, args.Policy.Check.PolicyDir, spec, p)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		fmt.Printf("%s complies with the policies in %s\n", spec.path, args.Policy.Check.PolicyDir)
+		return nil
+	}
+	return reportViolations(spec.path, violations)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// bounds on the interval between checks on a long-running operation
+const (
+	minPollInterval = 500 * time.Millisecond
+	maxPollInterval = 10 * time.Second
+)
+
+// get the delay that the server asked for in a Retry-After header, if any
+func retryAfter(err error) (time.Duration, bool) {
+	var e *googleapi.Error
+	if !errors.As(err, &e) || (e.Code != http.StatusTooManyRequests && e.Code != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+	secs, convErr := strconv.Atoi(e.Header.Get("Retry-After"))
+	if convErr != nil || secs < 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// poll calls check until it reports that an operation is done or returns an error. The
+// interval between checks starts short, since many operations finish quickly, and doubles
+// up to maxPollInterval so that slow operations do not hammer the API. If a check is
+// rejected with a Retry-After header then the server's delay is used instead of failing.
+// The name identifies the operation in the error returned on timeout or interruption.
+func poll(ctx context.Context, name string, check func() (bool, error)) error {
+	interval := minPollInterval
+	for {
+		delay := interval
+		done, err := check()
+		if d, ok := retryAfter(err); ok {
+			delay = d
+		} else if err != nil {
+			return err
+		} else if done {
+			return nil
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("timed out waiting for %s", name)
+			}
+			return fmt.Errorf("interrupted while waiting for %s", name)
+		case <-t.C:
+		}
+
+		interval *= 2
+		if interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
+}
+
+// pollAll polls several operations concurrently, keyed by name, and returns the first
+// error, if any, after all of them have finished
+func pollAll(ctx context.Context, checks map[string]func() (bool, error)) error {
+	errs := make(chan error, len(checks))
+	for name, check := range checks {
+		name, check := name, check
+		go func() { errs <- poll(ctx, name, check) }()
+	}
+
+	var first error
+	for range checks {
+		if err := <-errs; err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
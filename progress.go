@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// progress records how far an apply got, so that "gproj resume" can carry on from the
+// last completed step after a crash or a network drop rather than starting over
+type progress struct {
+	Spec      *ProjectSpec // the spec being applied
+	SpecPath  string       // path from which the spec was read
+	Args      *applyArgs   // the options with which apply was run
+	Started   time.Time    // when the interrupted apply started
+	Completed []string     // names of the steps that completed, in the order they did
+}
+
+// steps that are run again when resuming even though they completed, because they look up
+// things that later steps need, such as the project number and the enabled APIs
+var repeatOnResume = map[string]bool{
+	"project":       true,
+	"billing":       true,
+	"enabled-apis":  true,
+	"oauth-brand":   true,
+	"oauth-clients": true,
+}
+
+// path to the file in which the progress of applying a spec to a project is recorded. It
+// is keyed by project ID since the project may not have a number yet.
+func progressPath(projectID string) (string, error) {
+	cacheDir, err := fsys.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting user cache dir: %w", err)
+	}
+
+	dir := filepath.Join(cacheDir, "gproj", "progress")
+	err = fsys.MkdirAll(dir, dirPerm)
+	if err != nil {
+		return "", fmt.Errorf("error creating cache dir: %w", err)
+	}
+	return filepath.Join(dir, projectID+".json"), nil
+}
+
+// load the progress of an interrupted apply to a project
+func loadProgress(projectID string) (*progress, error) {
+	path, err := progressPath(projectID)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := fsys.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("there is no interrupted apply to project %s to resume", projectID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading progress: %w", err)
+	}
+
+	var p progress
+	err = json.Unmarshal(buf, &p)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding progress; try deleting %s: %w", path, err)
+	}
+	if p.Spec == nil || p.Args == nil {
+		return nil, fmt.Errorf("%s does not contain a spec and options to resume with", path)
+	}
+	p.Spec.path = p.SpecPath
+	return &p, nil
+}
+
+// save the progress of an apply
+func (p *progress) save() error {
+	path, err := progressPath(p.Spec.ID)
+	if err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling progress: %w", err)
+	}
+	// the spec in the progress is decrypted, so it may contain secrets
+	err = writePrivateFile(fsys, path, buf)
+	if err != nil {
+		return fmt.Errorf("error writing progress to %s: %w", path, err)
+	}
+	return nil
+}
+
+// remove the record of an apply once it has finished successfully
+func (p *progress) remove() error {
+	path, err := progressPath(p.Spec.ID)
+	if err != nil {
+		return err
+	}
+	err = fsys.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error removing %s: %w", path, err)
+	}
+	return nil
+}
+
+// get the steps of an interrupted apply that need not be run again
+func (p *progress) skippable() map[string]bool {
+	skip := make(map[string]bool)
+	for _, name := range p.Completed {
+		if !repeatOnResume[name] {
+			skip[name] = true
+		}
+	}
+	return skip
+}
+
+// checkProgressSpec checks that the spec recorded in the progress of an apply that had to
+// be signed still matches the signed spec. The progress file itself is not signed.
+func checkProgressSpec(p *progress) error {
+	if p.Args.VerifyKey == "" {
+		return nil
+	}
+	err := verifySpecFiles(p.SpecPath, p.Args.VerifyKey, p.Args.Signature)
+	if err != nil {
+		return err
+	}
+	signed, err := loadProjectSpec(fsys, p.SpecPath)
+	if err != nil {
+		return err
+	}
+	if fingerprint(signed) != fingerprint(p.Spec) {
+		return fmt.Errorf("%s has changed since the interrupted apply; run gproj apply instead", p.SpecPath)
+	}
+	return nil
+}
+
+// resume continues an apply that was interrupted, using the spec and options that it was
+// run with and skipping the steps that it completed
+func resume(ctx context.Context, args *args) error {
+	projectID := args.Project
+	if projectID == "" {
+		spec, err := readProjectSpec(args)
+		if err != nil {
+			return err
+		}
+		projectID = spec.ID
+	}
+
+	p, err := loadProgress(projectID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("resuming apply to %s started %s, %d steps already done\n",
+		projectID, humanAgo(p.Started), len(p.Completed))
+
+	err = checkProgressSpec(p)
+	if err != nil {
+		return err
+	}
+
+	args.Apply = p.Args
+	if args.Resume.Parallelism > 0 {
+		args.Apply.Parallelism = args.Resume.Parallelism
+	}
+	return applySpec(ctx, args, p.Spec)
+}
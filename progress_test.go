@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// install a stand-in for minisign that accepts every signature, so that tests can check
+// which files gproj insists are signed
+func fakeMinisign(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the stand-in for minisign is a shell script")
+	}
+	bin := t.TempDir()
+	err := os.WriteFile(filepath.Join(bin, "minisign"), []byte("#!/bin/sh\nexit 0\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestCheckProgressSpec(t *testing.T) {
+	fakeMinisign(t)
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), filePerm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("minisign.pub", "untrusted comment: minisign public key\nRWQ\n")
+	write("googlecloudproject.yaml", "id: acme-app\n")
+	write("googlecloudproject.yaml.minisig", "signature")
+
+	specPath := filepath.Join(dir, "googlecloudproject.yaml")
+	spec, err := loadProjectSpec(fsys, specPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &progress{
+		Spec:     spec,
+		SpecPath: specPath,
+		Args:     &applyArgs{VerifyKey: filepath.Join(dir, "minisign.pub")},
+	}
+	if err := checkProgressSpec(p); err != nil {
+		t.Fatalf("signed spec was refused: %v", err)
+	}
+
+	// the workspace file can change the spec, so it must be signed too
+	write(workspaceFile, "groups: {}\n")
+	if err := checkProgressSpec(p); err == nil || !strings.Contains(err.Error(), "workspace") {
+		t.Errorf("expected an error about the unsigned workspace file, got %v", err)
+	}
+	write(workspaceFile+".minisig", "signature")
+	if err := checkProgressSpec(p); err != nil {
+		t.Errorf("signed spec and workspace file were refused: %v", err)
+	}
+
+	// a spec that changed since the interrupted apply
+	write("googlecloudproject.yaml", "id: acme-app\nlabels: {env: prod}\n")
+	if err := checkProgressSpec(p); err == nil || !strings.Contains(err.Error(), "changed") {
+		t.Errorf("expected an error about the changed spec, got %v", err)
+	}
+}
+
+func TestProgressRoundTrip(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir()) // for platforms that put the cache dir under the home dir
+
+	if _, err := loadProgress("acme-app"); err == nil || !strings.Contains(err.Error(), "no interrupted apply") {
+		t.Fatalf("expected an error saying there is nothing to resume, got %v", err)
+	}
+
+	p := &progress{
+		Spec:      &ProjectSpec{ID: "acme-app", Name: "Acme"},
+		SpecPath:  "/repo/googlecloudproject.yaml",
+		Args:      &applyArgs{Parallelism: 4},
+		Completed: []string{"project", "billing", "api-run.googleapis.com", "service-account-web"},
+	}
+	if err := p.save(); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" {
+		path, err := progressPath("acme-app")
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Mode().Perm()&0077 != 0 {
+			t.Errorf("progress file has mode %v, but it may contain secrets", st.Mode().Perm())
+		}
+	}
+
+	got, err := loadProgress("acme-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Spec.path != p.SpecPath {
+		t.Errorf("spec read from the progress has path %q, want %q", got.Spec.path, p.SpecPath)
+	}
+	if got.Args.Parallelism != 4 || len(got.Completed) != len(p.Completed) {
+		t.Errorf("got %+v, want %+v", got, p)
+	}
+
+	// the project and billing steps look up things that later steps need, so they run again
+	skip := got.skippable()
+	for name, want := range map[string]bool{
+		"project":                false,
+		"billing":                false,
+		"api-run.googleapis.com": true,
+		"service-account-web":    true,
+		"enabled-apis":           false, // never completed
+	} {
+		if skip[name] != want {
+			t.Errorf("skippable()[%q] = %v, want %v", name, skip[name], want)
+		}
+	}
+
+	if err := got.remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadProgress("acme-app"); err == nil {
+		t.Error("progress could still be loaded after it was removed")
+	}
+}
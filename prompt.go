@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Prompts ask the user for answers that were not given by flags, the environment, or the
+// spec. Without a terminal, or with --ci or --no-input, a prompt fails with an error that
+// says how to give the answer instead, so that scripts fail fast rather than hang.
+
+// errNoInput is returned by prompts that cannot ask
+var errNoInput = errors.New("cannot prompt for input")
+
+// stdin is shared by all prompts so that input buffered by one is not lost to the next
+var stdin = bufio.NewReader(os.Stdin)
+
+// the error for a prompt that cannot ask, naming how to give the answer instead
+func noInputError(args *args, question, instead string) error {
+	why := "not running in a terminal"
+	switch {
+	case args.NoInput:
+		why = "--no-input was given"
+	case args.CI:
+		why = "running with --ci"
+	}
+	if instead == "" {
+		return fmt.Errorf("%w for %q: %s", errNoInput, question, why)
+	}
+	return fmt.Errorf("%w for %q: %s; use %s instead", errNoInput, question, why, instead)
+}
+
+// read a line from stdin after printing the question
+func readAnswer(question string) (string, error) {
+	fmt.Print(question + " ")
+	line, err := stdin.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("error reading answer: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+// promptInput asks for a line of text. The instead argument says how to give the answer
+// without a prompt, e.g. "--yes", or is empty if there is no other way.
+func promptInput(args *args, question, instead string) (string, error) {
+	if !isInteractive(args) {
+		return "", noInputError(args, question, instead)
+	}
+	return readAnswer(question)
+}
+
+// promptConfirm asks a yes or no question, with no as the default
+func promptConfirm(args *args, question, instead string) (bool, error) {
+	answer, err := promptInput(args, question+" [y/N]", instead)
+	if err != nil {
+		return false, err
+	}
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes", nil
+}
+
+// promptSelect asks the user to choose one of several options by number, and returns the
+// index of the one chosen
+func promptSelect(args *args, question string, options []string, instead string) (int, error) {
+	if !isInteractive(args) {
+		return 0, noInputError(args, question, instead)
+	}
+	for i, option := range options {
+		fmt.Printf("  %d. %s\n", i+1, option)
+	}
+	answer, err := readAnswer(question + ":")
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(answer)
+	if err != nil || n < 1 || n > len(options) {
+		return 0, fmt.Errorf("%q is not one of the options listed", answer)
+	}
+	return n - 1, nil
+}
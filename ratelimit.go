@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows requests at a steady rate with bursts of up to one second's worth
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	tokens float64 // tokens currently available
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	return &tokenBucket{rate: rate, tokens: rate, last: time.Now()}
+}
+
+// burst is the most tokens that can accumulate, which is one second's worth but at least one
+func (b *tokenBucket) burst() float64 {
+	if b.rate < 1 {
+		return 1
+	}
+	return b.rate
+}
+
+// wait until a token is available and take it
+func (b *tokenBucket) wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst() {
+			b.tokens = b.burst()
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// rateLimitTransport holds back requests to google APIs so that they stay within the
+// per-minute quotas, which matters when many projects are applied at once since exceeding a
+// quota gets every request throttled for the rest of the minute
+type rateLimitTransport struct {
+	base     http.RoundTripper
+	limiters map[string]*tokenBucket // keyed by host, e.g. "cloudresourcemanager.googleapis.com"
+}
+
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if b, ok := t.limiters[req.URL.Host]; ok {
+		if err := b.wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// parse a comma-separated list of service=rps pairs, such as "cloudresourcemanager=2,serviceusage=5",
+// into requests per second keyed by service. A service may be given by its short name or by
+// its full hostname.
+func parseRateLimits(s string) (map[string]float64, error) {
+	limits := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid rate limit %q (expected service=requests per second)", pair)
+		}
+		rps, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rps <= 0 {
+			return nil, fmt.Errorf("invalid rate limit %q: %q is not a positive number", pair, parts[1])
+		}
+		host := parts[0]
+		if !strings.Contains(host, ".") {
+			host += ".googleapis.com"
+		}
+		limits[host] = rps
+	}
+	return limits, nil
+}
+
+// create a token bucket for each of the given rate limits
+func newLimiters(limits map[string]float64) map[string]*tokenBucket {
+	limiters := make(map[string]*tokenBucket)
+	for host, rps := range limits {
+		limiters[host] = newTokenBucket(rps)
+	}
+	return limiters
+}
+
+// divide rate limits between n processes running at once, as foreach and workspace do, so
+// that together they stay within the limits given to the parent
+func divideRateLimits(s string, n int) (string, error) {
+	limits, err := parseRateLimits(s)
+	if err != nil {
+		return "", err
+	}
+	var pairs []string
+	for host, rps := range limits {
+		pairs = append(pairs, host+"="+strconv.FormatFloat(rps/float64(n), 'g', -1, 64))
+	}
+	return strings.Join(pairs, ","), nil
+}
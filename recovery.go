@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// determine whether it is ok to prompt, which it is if stdin is an interactive terminal
+// and gproj is not running in CI mode or with --no-input
+func isInteractive(args *args) bool {
+	if args.CI || args.NoInput {
+		return false
+	}
+	return isTerminal(os.Stdin)
+}
+
+// determine whether a file is a terminal rather than a pipe or a regular file
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// recoverInteractively asks the user what to do about a failed step, so that a failure
+// part-way through an apply does not force a full rerun
+func (a *applier) recoverInteractively(name string, err error) recovery {
+	fmt.Printf("\n%s failed: %v\n", name, err)
+	for {
+		answer, promptErr := promptInput(a.args, "[r]etry, [s]kip this step, [o]pen in console, or [a]bort?", "")
+		if promptErr != nil {
+			return recoverFail
+		}
+
+		switch strings.ToLower(answer) {
+		case "r", "retry":
+			return recoverRetry
+		case "s", "skip":
+			return recoverSkip
+		case "a", "abort":
+			return recoverAbort
+		case "o", "open":
+			url := nodeConsoleURL(name, a.spec.ID)
+			fmt.Println(url)
+			if err := openBrowser(url); err != nil {
+				fmt.Println("unable to open a browser:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Specs that arrive over the network, through "gproj serve", a GoogleCloudProject
+// resource for the operator, or a request for "gproj apply --from-request", come from
+// people who may manage projects but who are not trusted with the machine that gproj runs
+// on. The parts of the spec that run programs or refer to local files are therefore
+// restricted for such specs.
+
+// decodeRemoteSpec decodes a spec that arrived over the network and that is taken to live
+// at specPath, and checks that it keeps to the restrictions on such specs. Plugins may be
+// used only if their kind is one of allowedPlugins.
+func decodeRemoteSpec(buf []byte, specPath string, allowedPlugins []string) (*ProjectSpec, error) {
+	spec, err := parseProjectSpec(buf, specPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkRemoteSpec(spec, allowedPlugins)
+	if err != nil {
+		return nil, err
+	}
+	err = spec.expandBundles()
+	if err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// checkRemoteSpec checks that a spec that has already been decoded keeps to the
+// restrictions on specs that arrive over the network
+func checkRemoteSpec(spec *ProjectSpec, allowedPlugins []string) error {
+	// groups are defined in a file found by searching upwards from the spec, which for
+	// remote specs would be the directories of the machine running gproj
+	if len(spec.Groups) > 0 {
+		return errors.New("groups cannot be used in specs sent over the network, since they refer to files on the machine running gproj")
+	}
+
+	for _, p := range spec.Plugins {
+		if p.Command != "" {
+			return fmt.Errorf("plugin %s: command cannot be set in specs sent over the network; use a kind that is allowed with --allowed-plugins", p.Name)
+		}
+		if !contains(allowedPlugins, p.Kind) {
+			allowed := "none are"
+			if len(allowedPlugins) > 0 {
+				allowed = "allowed kinds are " + strings.Join(allowedPlugins, ", ")
+			}
+			return fmt.Errorf("plugin %s: kind %q is not allowed (%s; see --allowed-plugins)", p.Name, p.Kind, allowed)
+		}
+	}
+
+	if spec.State != nil && spec.State.Local != "" && !isLocalPath(spec.State.Local) {
+		return fmt.Errorf("state.local must be a relative path that stays within the directory of the spec, not %q", spec.State.Local)
+	}
+	return nil
+}
+
+// determine whether a path is relative and stays within the directory it is relative to
+func isLocalPath(path string) bool {
+	clean := filepath.Clean(path)
+	if path == "" || filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || strings.HasPrefix(clean, string(filepath.Separator)) {
+		return false
+	}
+	return clean != ".." && !strings.HasPrefix(clean, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRemoteSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		allowed []string
+		wantErr string // empty means the spec is accepted
+	}{
+		{"plain", `{"id": "acme-app"}`, nil, ""},
+		{"plugin of allowed kind", `{"id": "acme-app", "plugins": [{"name": "dns", "kind": "dnsimple"}]}`, []string{"dnsimple"}, ""},
+		{"plugin of other kind", `{"id": "acme-app", "plugins": [{"name": "dns", "kind": "dnsimple"}]}`, []string{"cloudflare"}, "not allowed"},
+		{"plugins not allowed at all", `{"id": "acme-app", "plugins": [{"name": "dns", "kind": "dnsimple"}]}`, nil, "none are"},
+		{"plugin command", `{"id": "acme-app", "plugins": [{"name": "x", "kind": "dnsimple", "command": "/bin/sh"}]}`, []string{"dnsimple"}, "command cannot be set"},
+		{"plugin kind with a path", `{"id": "acme-app", "plugins": [{"name": "x", "kind": "../../tmp/x"}]}`, []string{"dnsimple"}, "not allowed"},
+		{"relative state", `{"id": "acme-app", "state": {"local": "state/app.json"}}`, nil, ""},
+		{"absolute state", `{"id": "acme-app", "state": {"local": "/etc/cron.d/x"}}`, nil, "state.local"},
+		{"state escaping the directory", `{"id": "acme-app", "state": {"local": "../../x.json"}}`, nil, "state.local"},
+		{"state escaping after cleaning", `{"id": "acme-app", "state": {"local": "a/../../x.json"}}`, nil, "state.local"},
+		{"groups", `{"id": "acme-app", "groups": ["web"]}`, nil, "groups"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeRemoteSpec([]byte(test.spec), "request", test.allowed)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestServerAuthorized(t *testing.T) {
+	s := &server{tokens: []string{"s3cret"}}
+	tests := map[string]bool{
+		"Bearer s3cret": true,
+		"bearer s3cret": true,
+		"s3cret":        false,
+		"Bearer ":       false,
+		"Basic s3cret":  false,
+		"Bearer wrong":  false,
+		"":              false,
+	}
+	for header, want := range tests {
+		r, _ := http.NewRequest(http.MethodGet, "http://localhost/v1/status", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if got := s.authorized(r); got != want {
+			t.Errorf("authorized with %q = %v, want %v", header, got, want)
+		}
+	}
+}
+
+func TestServerDeleteHonorsStoredSpec(t *testing.T) {
+	s := &server{args: &args{Serve: &serveArgs{StateDir: t.TempDir()}}}
+
+	// a project that was never applied through the server
+	_, err := s.delete(context.Background(), "acme-app")
+	var httpErr *httpError
+	if !errors.As(err, &httpErr) || httpErr.code != http.StatusConflict {
+		t.Errorf("expected a conflict for a project without a stored spec, got %v", err)
+	}
+
+	raw := []byte(`{"id": "acme-app", "lifecycle": {"preventDestroy": true}}`)
+	spec, err := decodeRemoteSpec(raw, "request", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec.path = filepath.Join(s.args.Serve.StateDir, spec.ID, gprojFile)
+	if err := s.storeSpec(spec, raw); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.delete(context.Background(), "acme-app")
+	if !errors.As(err, &httpErr) || httpErr.code != http.StatusConflict || !strings.Contains(err.Error(), "preventDestroy") {
+		t.Errorf("expected a conflict about preventDestroy, got %v", err)
+	}
+}
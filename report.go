@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Report is the structured record of an apply, written to the path given by --report
+type Report struct {
+	ProjectID string
+	Started   time.Time
+	Finished  time.Time
+	Success   bool
+	Actions   []*Action
+}
+
+// Action is the record of a single step in an apply
+type Action struct {
+	Resource  string  // name of the step, e.g. "project" or "api:run.googleapis.com"
+	Result    string  // one of "changed", "unchanged", "failed", "skipped", or "done earlier"
+	Before    string  `json:",omitempty"` // state of the resource before the step, if it changed
+	After     string  `json:",omitempty"` // state of the resource after the step, if it changed
+	Operation string  `json:",omitempty"` // name of the long-running operation, if there was one
+	Seconds   float64 // how long the step took
+	Error     string  `json:",omitempty"` // error message if the step failed
+}
+
+type actionKey struct{}
+
+// get the action for the step that is running in the given context, or nil if there is none
+func actionFromContext(ctx context.Context) *Action {
+	a, _ := ctx.Value(actionKey{}).(*Action)
+	return a
+}
+
+// record the before and after state of the resource being changed in the current step
+func noteChange(ctx context.Context, before, after string) {
+	if a := actionFromContext(ctx); a != nil {
+		a.Before = before
+		a.After = after
+	}
+}
+
+// record the long-running operation that was started in the current step
+func noteOperation(ctx context.Context, name string) {
+	if a := actionFromContext(ctx); a != nil {
+		a.Operation = name
+	}
+}
+
+// write the report as JSON
+func writeReport(path string, report *Report) error {
+	buf, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling report to json: %w", err)
+	}
+	err = fsys.WriteFile(path, buf, filePerm)
+	if err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	crmv3 "google.golang.org/api/cloudresourcemanager/v3"
+	"google.golang.org/api/pubsub/v1"
+)
+
+// projectRequest asks an admin or an automated system to apply a spec on behalf of
+// someone who does not have permission to create projects
+type projectRequest struct {
+	Spec      *ProjectSpec // the spec to apply
+	SpecPath  string       // path to the spec on the requester's machine, for information only
+	Requester string       // email of the person who made the request, as they stated it
+	Created   time.Time    // when the request was made
+	Signature string       // HMAC-SHA256 of the other fields, keyed by the shared request key
+}
+
+// compute the signature of a request, which covers every field except the signature
+func (r *projectRequest) signature(key string) (string, error) {
+	unsigned := *r
+	unsigned.Signature = ""
+	buf, err := json.Marshal(&unsigned)
+	if err != nil {
+		return "", fmt.Errorf("error marshalling request to json: %w", err)
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(buf)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// sign a request with the shared request key
+func (r *projectRequest) sign(key string) error {
+	sig, err := r.signature(key)
+	if err != nil {
+		return err
+	}
+	r.Signature = sig
+	return nil
+}
+
+// return an error unless the request was signed with the given key and has not been
+// modified since
+func (r *projectRequest) verify(key string) error {
+	sig, err := r.signature(key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(sig), []byte(r.Signature)) {
+		return errors.New("request signature does not match; it was modified or signed with a different key")
+	}
+	return nil
+}
+
+// readRequest reads and verifies a request written by gproj request. The key is shared
+// with everyone who may make requests, so the signature shows only that the request came
+// from one of them, and the spec is restricted like any other that arrives over the
+// network. Plugins may be used only if their kind is one of allowedPlugins.
+func readRequest(path, key string, allowedPlugins []string) (*projectRequest, error) {
+	if key == "" {
+		return nil, errors.New("a key is needed to verify the request: set GPROJ_REQUEST_KEY or pass --request-key")
+	}
+
+	buf, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading request: %w", err)
+	}
+
+	var r projectRequest
+	err = json.Unmarshal(buf, &r)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding request in %s: %w", path, err)
+	}
+	if r.Spec == nil {
+		return nil, fmt.Errorf("%s does not contain a spec; was it written by gproj request?", path)
+	}
+	err = r.verify(key)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying %s: %w", path, err)
+	}
+
+	// the requester applied any groups when making the request, and the path they gave
+	// refers to their own machine, so the spec is taken to live where the request does
+	r.Spec.Groups = nil
+	r.Spec.path = path
+	err = checkRemoteSpec(r.Spec, allowedPlugins)
+	if err != nil {
+		return nil, fmt.Errorf("error in request %s: %w", path, err)
+	}
+	return &r, nil
+}
+
+// determine whether the caller can create projects in an organization or folder
+func canCreateProjects(ctx context.Context, conn *connection, parent string) (bool, error) {
+	svc, err := crmv3.NewService(ctx, conn.options()...)
+	if err != nil {
+		return false, err
+	}
+
+	const perm = "resourcemanager.projects.create"
+	req := &crmv3.TestIamPermissionsRequest{Permissions: []string{perm}}
+	var resp *crmv3.TestIamPermissionsResponse
+	if strings.HasPrefix(parent, "folders/") {
+		resp, err = svc.Folders.TestIamPermissions(parent, req).Context(ctx).Do()
+	} else {
+		resp, err = svc.Organizations.TestIamPermissions(parent, req).Context(ctx).Do()
+	}
+	if err != nil {
+		return false, fmt.Errorf("error checking permissions on %s: %w", parent, err)
+	}
+	return contains(resp.Permissions, perm), nil
+}
+
+// publish a request to a pub/sub topic
+func publishRequest(ctx context.Context, conn *connection, topic string, buf []byte) error {
+	svc, err := pubsub.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the pub/sub API: %w", err)
+	}
+	_, err = svc.Projects.Topics.Publish(topic, &pubsub.PublishRequest{
+		Messages: []*pubsub.PubsubMessage{{Data: base64.StdEncoding.EncodeToString(buf)}},
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error publishing request to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// post a request as JSON to an endpoint
+func postRequest(ctx context.Context, url string, buf []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error posting request to %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("error posting request to %s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// write a request to a file for the requester to send to an admin
+func writeRequestFile(fsys filesystem, path string, buf []byte, encrypted bool) error {
+	var err error
+	// requests made from encrypted specs contain the decrypted secrets, so only the owner
+	// may read them
+	if encrypted {
+		fmt.Printf("warning: %s contains the decrypted spec, so send it only over a secure channel\n", path)
+		err = writePrivateFile(fsys, path, buf)
+	} else {
+		err = fsys.WriteFile(path, buf, filePerm)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing request to %s: %w", path, err)
+	}
+	return nil
+}
+
+// request packages the spec for someone else to apply with gproj apply --from-request,
+// for users who cannot create projects themselves
+func request(ctx context.Context, args *args) error {
+	if args.Request.Key == "" {
+		return errors.New("a key is needed to sign the request: set GPROJ_REQUEST_KEY or pass --key")
+	}
+
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	requester, err := principalEmail(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	// a request is unnecessary if the caller could just apply the spec
+	if _, err := spec.parentResource(); err != nil {
+		return err
+	}
+	if spec.Parent != "" {
+		allowed, err := canCreateProjects(ctx, conn, spec.Parent)
+		if err != nil {
+			fmt.Println("warning:", err)
+		} else if allowed {
+			fmt.Printf("note: you can create projects in %s, so gproj apply would work directly\n", spec.Parent)
+		}
+	}
+
+	r := projectRequest{
+		Spec:      spec,
+		SpecPath:  spec.path,
+		Requester: requester,
+		Created:   time.Now().UTC().Truncate(time.Second),
+	}
+	err = r.sign(args.Request.Key)
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.MarshalIndent(&r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling request to json: %w", err)
+	}
+
+	switch {
+	case args.Request.Endpoint != "":
+		err = postRequest(ctx, args.Request.Endpoint, buf)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("sent request for %s to %s\n", spec.ID, args.Request.Endpoint)
+	case args.Request.Topic != "":
+		err = publishRequest(ctx, conn, args.Request.Topic, buf)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("published request for %s to %s\n", spec.ID, args.Request.Topic)
+	default:
+		out := args.Request.Out
+		if out == "" {
+			out = spec.ID + ".request.json"
+		}
+		err = writeRequestFile(fsys, out, buf, spec.encrypted)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("wrote request for %s to %s; send it to an admin to run gproj apply --from-request %s\n", spec.ID, out, out)
+	}
+	return nil
+}
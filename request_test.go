@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadRequest(t *testing.T) {
+	const key = "shared-key"
+	tests := []struct {
+		name    string
+		spec    ProjectSpec
+		allowed []string
+		wantErr string // empty means the request is accepted
+	}{
+		{
+			name: "plain",
+			spec: ProjectSpec{ID: "acme-app", APIs: []string{"run.googleapis.com"}},
+		},
+		{
+			name:    "plugin command",
+			spec:    ProjectSpec{ID: "acme-app", Plugins: []Plugin{{Name: "x", Command: "/any/binary"}}},
+			allowed: []string{"dnsimple"},
+			wantErr: "command cannot be set",
+		},
+		{
+			name:    "plugin of allowed kind",
+			spec:    ProjectSpec{ID: "acme-app", Plugins: []Plugin{{Name: "dns", Kind: "dnsimple"}}},
+			allowed: []string{"dnsimple"},
+		},
+		{
+			name:    "plugin of other kind",
+			spec:    ProjectSpec{ID: "acme-app", Plugins: []Plugin{{Name: "dns", Kind: "dnsimple"}}},
+			wantErr: "not allowed",
+		},
+		{
+			name:    "absolute state",
+			spec:    ProjectSpec{ID: "acme-app", State: &StateConfig{Local: "/etc/cron.d/x"}},
+			wantErr: "state.local",
+		},
+		{
+			name: "groups already applied by the requester",
+			spec: ProjectSpec{ID: "acme-app", Groups: []string{"web"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := projectRequest{
+				Spec:      &test.spec,
+				SpecPath:  "/home/requester/app/googlecloudproject.yaml",
+				Requester: "requester@example.com",
+				Created:   time.Now().UTC().Truncate(time.Second),
+			}
+			if err := r.sign(key); err != nil {
+				t.Fatal(err)
+			}
+			buf, err := json.Marshal(&r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), test.spec.ID+".request.json")
+			if err := os.WriteFile(path, buf, filePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readRequest(path, key, test.allowed)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("expected an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Spec.path != path {
+				t.Errorf("got spec path %q, want the path of the request %q", got.Spec.path, path)
+			}
+		})
+	}
+}
+
+func TestReadRequestModified(t *testing.T) {
+	r := projectRequest{Spec: &ProjectSpec{ID: "acme-app"}, Requester: "requester@example.com"}
+	if err := r.sign("shared-key"); err != nil {
+		t.Fatal(err)
+	}
+	r.Spec.Plugins = []Plugin{{Name: "x", Command: "/any/binary"}}
+	buf, err := json.Marshal(&r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "acme-app.request.json")
+	if err := os.WriteFile(path, buf, filePerm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readRequest(path, "shared-key", nil); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("expected a signature error, got %v", err)
+	}
+}
+
+func TestWriteRequestFile(t *testing.T) {
+	f := newFakeFilesystem("/work")
+	for _, test := range []struct {
+		path      string
+		encrypted bool
+		want      os.FileMode
+	}{
+		{"/work/plain.request.json", false, filePerm},
+		{"/work/secret.request.json", true, privateFilePerm},
+	} {
+		if err := writeRequestFile(f, test.path, []byte("{}"), test.encrypted); err != nil {
+			t.Fatal(err)
+		}
+		st, err := f.Stat(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Mode().Perm() != test.want {
+			t.Errorf("%s: got permissions %v, want %v", test.path, st.Mode().Perm(), test.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// guards the project number cache, since lookups may happen concurrently
+var projectNumbersMu sync.Mutex
+
+// path to the file that maps project IDs to project numbers. The mapping never changes
+// since project IDs cannot be reused, even after a project is deleted, so entries in this
+// cache never expire.
+func projectNumbersPath() (string, error) {
+	cacheDir, err := fsys.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting user cache dir: %w", err)
+	}
+
+	dir := filepath.Join(cacheDir, "gproj")
+	err = fsys.MkdirAll(dir, dirPerm)
+	if err != nil {
+		return "", fmt.Errorf("error creating cache dir: %w", err)
+	}
+	return filepath.Join(dir, "project-numbers.json"), nil
+}
+
+// load the cached project numbers, keyed by project ID. Errors are not fatal since the
+// numbers can always be looked up again.
+func loadProjectNumbers() map[string]int64 {
+	numbers := make(map[string]int64)
+	path, err := projectNumbersPath()
+	if err != nil {
+		return numbers
+	}
+	buf, err := fsys.ReadFile(path)
+	if err != nil {
+		return numbers
+	}
+	json.Unmarshal(buf, &numbers)
+	return numbers
+}
+
+// add a project to the cache of project numbers
+func cacheProjectNumber(id string, number int64) error {
+	numbers := loadProjectNumbers()
+	if numbers[id] == number {
+		return nil
+	}
+	numbers[id] = number
+
+	path, err := projectNumbersPath()
+	if err != nil {
+		return err
+	}
+	buf, err := json.MarshalIndent(numbers, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling project numbers: %w", err)
+	}
+	err = fsys.WriteFile(path, buf, filePerm)
+	if err != nil {
+		return fmt.Errorf("error writing project numbers to %s: %w", path, err)
+	}
+	return nil
+}
+
+// look up a project, which may be given by ID or number, and add it to the cache
+func lookupProject(ctx context.Context, resources *cloudresourcemanager.Service, idOrNumber string) (string, int64, error) {
+	project, err := resources.Projects.Get(idOrNumber).Context(ctx).Do()
+	if err != nil {
+		return "", 0, fmt.Errorf("error getting project %s: %w", idOrNumber, err)
+	}
+	if err := cacheProjectNumber(project.ProjectId, project.ProjectNumber); err != nil {
+		fmt.Println("warning:", err)
+	}
+	return project.ProjectId, project.ProjectNumber, nil
+}
+
+// resolveProjectNumber gets the number of a project from its ID, which is what many
+// Google APIs want
+func resolveProjectNumber(ctx context.Context, resources *cloudresourcemanager.Service, id string) (int64, error) {
+	projectNumbersMu.Lock()
+	defer projectNumbersMu.Unlock()
+
+	if number, ok := loadProjectNumbers()[id]; ok {
+		return number, nil
+	}
+	_, number, err := lookupProject(ctx, resources, id)
+	return number, err
+}
+
+// resolveProjectID gets the ID of a project from its number, which is what humans want
+func resolveProjectID(ctx context.Context, resources *cloudresourcemanager.Service, number int64) (string, error) {
+	projectNumbersMu.Lock()
+	defer projectNumbersMu.Unlock()
+
+	for id, n := range loadProjectNumbers() {
+		if n == number {
+			return id, nil
+		}
+	}
+	id, _, err := lookupProject(ctx, resources, strconv.FormatInt(number, 10))
+	return id, err
+}
+
+// resolve prints the number of a project given its ID, or its ID given its number
+func resolve(ctx context.Context, args *args) error {
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
+
+	for _, project := range args.Resolve.Projects {
+		var out string
+		if number, err := strconv.ParseInt(project, 10, 64); err == nil {
+			out, err = resolveProjectID(ctx, resources, number)
+			if err != nil {
+				return err
+			}
+		} else {
+			number, err := resolveProjectNumber(ctx, resources, project)
+			if err != nil {
+				return err
+			}
+			out = strconv.FormatInt(number, 10)
+		}
+		fmt.Println(out)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// the label on a project that is scheduled for deletion, whose value is the time after
+// which "gproj reminders --execute" deletes it, in seconds since the unix epoch. Label
+// values cannot contain colons, which rules out RFC 3339.
+const deleteAfterLabel = "gproj-delete-after"
+
+// get the time after which a project is scheduled to be deleted, if it is
+func scheduledDeletion(project *cloudresourcemanager.Project) (time.Time, bool) {
+	secs, err := strconv.ParseInt(project.Labels[deleteAfterLabel], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0), true
+}
+
+// set or remove the deletion label on a project
+func setDeleteAfterLabel(ctx context.Context, resources *cloudresourcemanager.Service, project *cloudresourcemanager.Project, value string) error {
+	updated := *project
+	updated.Labels = make(map[string]string)
+	for k, v := range project.Labels {
+		updated.Labels[k] = v
+	}
+	if value == "" {
+		delete(updated.Labels, deleteAfterLabel)
+	} else {
+		updated.Labels[deleteAfterLabel] = value
+	}
+	_, err := resources.Projects.Update(project.ProjectId, &updated).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error updating labels on %s: %w", project.ProjectId, err)
+	}
+	return nil
+}
+
+// scheduleDeletion labels a project to be deleted once a grace period has passed, during
+// which "gproj reminders" warns its owners
+func scheduleDeletion(ctx context.Context, resources *cloudresourcemanager.Service, project *cloudresourcemanager.Project, after string) error {
+	days, err := parseLookback(after)
+	if err != nil {
+		return fmt.Errorf("invalid --after: %w", err)
+	}
+	when := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+	err = setDeleteAfterLabel(ctx, resources, project, strconv.FormatInt(when.Unix(), 10))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("project %s will be deleted %s (%s) by \"gproj reminders --execute\". To cancel, run\n  $ gproj delete --cancel\n",
+		project.ProjectId, humanAgo(when), when.Local().Format("2 Jan 2006 15:04"))
+	return nil
+}
+
+// cancelDeletion removes the schedule set by "gproj delete --after"
+func cancelDeletion(ctx context.Context, resources *cloudresourcemanager.Service, project *cloudresourcemanager.Project) error {
+	if _, ok := scheduledDeletion(project); !ok {
+		return fmt.Errorf("project %s is not scheduled for deletion", project.ProjectId)
+	}
+	err := setDeleteAfterLabel(ctx, resources, project, "")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("project %s is no longer scheduled for deletion\n", project.ProjectId)
+	return nil
+}
+
+// send a reminder to wherever the user asked for them
+func notifyReminder(ctx context.Context, args *args, n *notification) {
+	if args.Reminders.Notify == "desktop" {
+		if err := notifyDesktop("gproj", n.Text); err != nil {
+			fmt.Println("warning: unable to show desktop notification:", err)
+		}
+	}
+	if args.Reminders.NotifyWebhook != "" {
+		if err := notifyWebhook(ctx, args.Reminders.NotifyWebhook, n); err != nil {
+			fmt.Println("warning: unable to send webhook notification:", err)
+		}
+	}
+}
+
+// the people to mention in a reminder, from the labels that conventionally name them
+func projectOwner(project *cloudresourcemanager.Project) string {
+	for _, key := range []string{"owner", "team"} {
+		if v := project.Labels[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// reminders finds the gproj-managed projects that are scheduled for deletion, warns their
+// owners about those that will be deleted soon, and, with --execute, deletes those whose
+// grace period is over. It is meant to be run periodically from cron or CI.
+func reminders(ctx context.Context, args *args) error {
+	days, err := parseLookback(args.Reminders.Within)
+	if err != nil {
+		return fmt.Errorf("invalid --within: %w", err)
+	}
+	within := time.Duration(days) * 24 * time.Hour
+
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
+
+	filter := projectFilter([]string{
+		fmt.Sprintf("label=%s:%s", managedByLabel, managedByValue),
+		fmt.Sprintf("label=%s:*", deleteAfterLabel),
+		"state:ACTIVE",
+	})
+	projects, err := searchProjects(ctx, resources, filter)
+	if err != nil {
+		return err
+	}
+
+	type scheduled struct {
+		project *cloudresourcemanager.Project
+		when    time.Time
+	}
+	var due []scheduled
+	for _, p := range projects {
+		if when, ok := scheduledDeletion(p); ok {
+			due = append(due, scheduled{p, when})
+		}
+	}
+	if len(due) == 0 {
+		fmt.Println("no projects are scheduled for deletion")
+		return nil
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].when.Before(due[j].when)
+	})
+
+	var failed int
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tDELETION\tOWNER\tSTATUS")
+	for _, s := range due {
+		id, owner := s.project.ProjectId, projectOwner(s.project)
+		status := "scheduled"
+		switch {
+		case time.Now().After(s.when) && args.Reminders.Execute:
+			// go through the same checks as the operator, including the lien check
+			err := deleteManagedProject(ctx, args, &ProjectSpec{ID: id}, args.Reminders.UnlinkBilling)
+			if err != nil {
+				failed++
+				status = "failed: " + firstLine(err.Error())
+				break
+			}
+			status = "deleted"
+			notifyReminder(ctx, args, &notification{
+				Text:      fmt.Sprintf("gproj deleted project %s as scheduled; it can be undeleted within 30 days", id),
+				ProjectID: id,
+				Success:   true,
+			})
+		case time.Now().After(s.when):
+			status = "due, run with --execute to delete"
+		case time.Until(s.when) < within:
+			status = "reminded"
+			text := fmt.Sprintf("project %s is scheduled for deletion %s; run \"gproj --project %s delete --cancel\" to keep it", id, humanAgo(s.when), id)
+			if owner != "" {
+				text = owner + ": " + text
+			}
+			notifyReminder(ctx, args, &notification{Text: text, ProjectID: id, Success: true})
+		}
+		if owner == "" {
+			owner = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", id, humanAgo(s.when), owner, status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d projects", failed)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/api/appengine/v1"
+	"google.golang.org/api/cloudscheduler/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// SchedulerJob models a cloud scheduler cron job declared in googlecloudproject.yaml
+type SchedulerJob struct {
+	Name     string // name of the job, e.g. "heartbeat"
+	Region   string // region in which to run the job, e.g. "us-central1"
+	Schedule string // cron schedule, e.g. "*/5 * * * *"
+	TimeZone string `yaml:"timeZone"` // time zone for the schedule, e.g. "America/New_York" (default UTC)
+
+	// exactly one of the following targets must be given
+	HTTP   *SchedulerHTTPTarget   // make an HTTP request on each tick
+	PubSub *SchedulerPubSubTarget `yaml:"pubsub"` // publish a message on each tick
+}
+
+// SchedulerHTTPTarget is a cron job target that makes an HTTP request
+type SchedulerHTTPTarget struct {
+	URL    string // URL to request, e.g. "https://example.com/cron/cleanup"
+	Method string // HTTP method, e.g. "POST" (default GET)
+	Body   string // request body, sent as-is
+}
+
+// SchedulerPubSubTarget is a cron job target that publishes to a pubsub topic
+type SchedulerPubSubTarget struct {
+	Topic string // name of the topic within this project, e.g. "cleanup"
+	Data  string // message payload, sent as-is
+}
+
+// converts a cloud region such as "us-central1" to an app engine location such as "us-central"
+func appEngineLocation(region string) string {
+	// app engine drops the trailing "1" for the two original regions
+	switch region {
+	case "us-central1", "europe-west1":
+		return strings.TrimSuffix(region, "1")
+	}
+	return region
+}
+
+// create an app engine application if there is not one already, since cloud scheduler requires one,
+// and report whether one was created
+func ensureAppEngineApp(ctx context.Context, conn *connection, projectID, region string) (bool, error) {
+	svc, err := appengine.NewService(ctx, conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the app engine API: %w", err)
+	}
+
+	_, err = svc.Apps.Get(projectID).Context(ctx).Do()
+	if err == nil {
+		return false, nil
+	}
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != 404 {
+		return false, fmt.Errorf("error getting app engine application: %w", err)
+	}
+
+	location := appEngineLocation(region)
+	fmt.Printf("creating app engine application in %s (required by cloud scheduler)...\n", location)
+	op, err := svc.Apps.Create(&appengine.Application{
+		Id:         projectID,
+		LocationId: location,
+	}).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error creating app engine application: %w", err)
+	}
+	noteOperation(ctx, op.Name)
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	err = timeOperation("create-appengine-app", "app engine application to be created", func() error {
+		return waitForAppEngine(waitCtx, svc.Apps.Operations, projectID, op)
+	})
+	return err == nil, err
+}
+
+func waitForAppEngine(
+	ctx context.Context,
+	svc *appengine.AppsOperationsService,
+	appID string,
+	op *appengine.Operation) error {
+
+	// operation names are of the form "apps/my-project/operations/abc-123"
+	opID := op.Name[strings.LastIndex(op.Name, "/")+1:]
+
+	if op.Error != nil {
+		return newOperationError(op.Error.Code, op.Error.Message, op.Error.Details)
+	}
+	if op.Done {
+		return nil
+	}
+
+	return poll(ctx, "app engine operation "+op.Name, func() (bool, error) {
+		op, err := svc.Get(appID, opID).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error getting operation info: %w", err)
+		}
+		if op.Error != nil {
+			return false, newOperationError(op.Error.Code, op.Error.Message, op.Error.Details)
+		}
+		return op.Done, nil
+	})
+}
+
+// create a scheduler job if it does not already exist, and report whether it was created
+func applySchedulerJob(ctx context.Context, conn *connection, projectID string, job SchedulerJob, state *State) (bool, error) {
+	svc, err := cloudscheduler.NewService(ctx, conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the cloud scheduler API: %w", err)
+	}
+	return ensureSchedulerJob(ctx, svc, projectID, job, state)
+}
+
+// convert a job from the spec to the form expected by the cloud scheduler API
+func schedulerJobFromSpec(projectID string, job SchedulerJob) (*cloudscheduler.Job, error) {
+	if job.Name == "" || job.Region == "" || job.Schedule == "" {
+		return nil, fmt.Errorf("scheduler jobs must have a name, region, and schedule")
+	}
+
+	j := cloudscheduler.Job{
+		Name:     fmt.Sprintf("projects/%s/locations/%s/jobs/%s", projectID, job.Region, job.Name),
+		Schedule: job.Schedule,
+		TimeZone: job.TimeZone,
+	}
+
+	switch {
+	case job.HTTP != nil && job.PubSub != nil:
+		return nil, fmt.Errorf("scheduler job %s has both an http and a pubsub target", job.Name)
+	case job.HTTP != nil:
+		j.HttpTarget = &cloudscheduler.HttpTarget{
+			Uri:        job.HTTP.URL,
+			HttpMethod: strings.ToUpper(job.HTTP.Method),
+			Body:       base64.StdEncoding.EncodeToString([]byte(job.HTTP.Body)),
+		}
+	case job.PubSub != nil:
+		j.PubsubTarget = &cloudscheduler.PubsubTarget{
+			TopicName: fmt.Sprintf("projects/%s/topics/%s", projectID, job.PubSub.Topic),
+			Data:      base64.StdEncoding.EncodeToString([]byte(job.PubSub.Data)),
+		}
+	default:
+		return nil, fmt.Errorf("scheduler job %s has no target (expected http or pubsub)", job.Name)
+	}
+
+	return &j, nil
+}
+
+// create a scheduler job if it does not exist
+func ensureSchedulerJob(ctx context.Context, svc *cloudscheduler.Service, projectID string, job SchedulerJob, state *State) (bool, error) {
+	j, err := schedulerJobFromSpec(projectID, job)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = svc.Projects.Locations.Jobs.Get(j.Name).Context(ctx).Do()
+	if err == nil {
+		return false, nil
+	}
+	if e, ok := err.(*googleapi.Error); !ok || e.Code != 404 {
+		return false, fmt.Errorf("error getting scheduler job %s: %w", job.Name, err)
+	}
+
+	fmt.Printf("creating scheduler job %s (%s)\n", job.Name, job.Schedule)
+	parent := fmt.Sprintf("projects/%s/locations/%s", projectID, job.Region)
+	_, err = svc.Projects.Locations.Jobs.Create(parent, j).Context(ctx).Do()
+	if err != nil {
+		return false, fmt.Errorf("error creating scheduler job %s: %w", job.Name, err)
+	}
+	state.Record(kindSchedulerJob, j.Name, job.Region)
+	return true, nil
+}
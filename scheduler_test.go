@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestAppEngineLocation(t *testing.T) {
+	tests := map[string]string{
+		"us-central1":  "us-central",
+		"europe-west1": "europe-west",
+		"us-east1":     "us-east1",
+		"asia-east2":   "asia-east2",
+	}
+	for region, want := range tests {
+		if got := appEngineLocation(region); got != want {
+			t.Errorf("appEngineLocation(%q) = %q, want %q", region, got, want)
+		}
+	}
+}
+
+func TestSchedulerJobFromSpec(t *testing.T) {
+	tests := []struct {
+		name    string
+		job     SchedulerJob
+		wantErr string
+	}{
+		{
+			name: "http",
+			job: SchedulerJob{Name: "cleanup", Region: "us-central1", Schedule: "0 * * * *",
+				HTTP: &SchedulerHTTPTarget{URL: "https://example.com/cleanup", Method: "post", Body: "{}"}},
+		},
+		{
+			name: "pubsub",
+			job: SchedulerJob{Name: "tick", Region: "us-central1", Schedule: "*/5 * * * *", TimeZone: "America/New_York",
+				PubSub: &SchedulerPubSubTarget{Topic: "ticks", Data: "tick"}},
+		},
+		{
+			name:    "no schedule",
+			job:     SchedulerJob{Name: "tick", Region: "us-central1", PubSub: &SchedulerPubSubTarget{Topic: "ticks"}},
+			wantErr: "must have a name, region, and schedule",
+		},
+		{
+			name:    "no target",
+			job:     SchedulerJob{Name: "tick", Region: "us-central1", Schedule: "* * * * *"},
+			wantErr: "has no target",
+		},
+		{
+			name: "two targets",
+			job: SchedulerJob{Name: "tick", Region: "us-central1", Schedule: "* * * * *",
+				HTTP: &SchedulerHTTPTarget{URL: "https://example.com"}, PubSub: &SchedulerPubSubTarget{Topic: "ticks"}},
+			wantErr: "both an http and a pubsub target",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			j, err := schedulerJobFromSpec("p", test.job)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Errorf("expected an error containing %q, got %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the name is what gets recorded in the state, so it must be the one that
+			// desiredResources expects or prune would delete the job
+			spec := &ProjectSpec{ID: "p", Scheduler: []SchedulerJob{test.job}}
+			if want := desiredResources(spec)[0].Name; j.Name != want {
+				t.Errorf("got job name %q, want %q", j.Name, want)
+			}
+			if j.Schedule != test.job.Schedule || j.TimeZone != test.job.TimeZone {
+				t.Errorf("got schedule %q in %q, want %q in %q", j.Schedule, j.TimeZone, test.job.Schedule, test.job.TimeZone)
+			}
+
+			switch {
+			case test.job.HTTP != nil:
+				if j.HttpTarget.Uri != test.job.HTTP.URL || j.HttpTarget.HttpMethod != strings.ToUpper(test.job.HTTP.Method) {
+					t.Errorf("got http target %s %s", j.HttpTarget.HttpMethod, j.HttpTarget.Uri)
+				}
+				if body, _ := base64.StdEncoding.DecodeString(j.HttpTarget.Body); string(body) != test.job.HTTP.Body {
+					t.Errorf("got body %q, want %q", body, test.job.HTTP.Body)
+				}
+			case test.job.PubSub != nil:
+				if want := "projects/p/topics/" + test.job.PubSub.Topic; j.PubsubTarget.TopicName != want {
+					t.Errorf("got topic %q, want %q", j.PubsubTarget.TopicName, want)
+				}
+				if data, _ := base64.StdEncoding.DecodeString(j.PubsubTarget.Data); string(data) != test.job.PubSub.Data {
+					t.Errorf("got data %q, want %q", data, test.job.PubSub.Data)
+				}
+			}
+		})
+	}
+}
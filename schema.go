@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// the yaml key for a struct field, following the rules used by gopkg.in/yaml.v2, or the
+// empty string if the field is not serialized
+func yamlFieldName(f reflect.StructField) string {
+	if f.PkgPath != "" {
+		return "" // unexported
+	}
+	tag := f.Tag.Get("yaml")
+	if tag == "-" {
+		return ""
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name
+	}
+	return strings.ToLower(f.Name)
+}
+
+// jsonSchemaFor generates a JSON schema for a go type by reflection
+func jsonSchemaFor(t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchemaFor(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": jsonSchemaFor(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": jsonSchemaFor(t.Elem()),
+		}
+	case reflect.Struct:
+		props := make(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if name := yamlFieldName(f); name != "" {
+				props[name] = jsonSchemaFor(f.Type)
+			}
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+	}
+	// anything else (e.g. interface{}) is unconstrained
+	return map[string]interface{}{}
+}
+
+// specSchema generates the JSON schema for googlecloudproject.yaml
+func specSchema() map[string]interface{} {
+	schema := jsonSchemaFor(reflect.TypeOf(ProjectSpec{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = gprojFile
+	schema["required"] = []string{"id"}
+	return schema
+}
+
+func schema(ctx context.Context, args *args) error {
+	buf, err := json.MarshalIndent(specSchema(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling schema to json: %w", err)
+	}
+	fmt.Println(string(buf))
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// translate a search term into the cloud resource manager filter syntax, which is
+// documented at https://cloud.google.com/resource-manager/reference/rest/v1/projects/list.
+// Terms such as "label=team:payments" and "state:ACTIVE" are accepted as shorthand for
+// "labels.team:payments" and "lifecycleState:ACTIVE", and anything else is passed through.
+func projectFilterTerm(term string) string {
+	switch {
+	case strings.HasPrefix(term, "label="):
+		return "labels." + strings.TrimPrefix(term, "label=")
+	case strings.HasPrefix(term, "state:"):
+		return "lifecycleState:" + strings.ToUpper(strings.TrimPrefix(term, "state:"))
+	}
+	return term
+}
+
+// build a filter from a list of search terms, all of which must match
+func projectFilter(terms []string) string {
+	var filter []string
+	for _, term := range terms {
+		filter = append(filter, projectFilterTerm(term))
+	}
+	return strings.Join(filter, " ")
+}
+
+// searchProjects finds all projects visible to the current user that match a filter
+func searchProjects(ctx context.Context, resources *cloudresourcemanager.Service, filter string) ([]*cloudresourcemanager.Project, error) {
+	var projects []*cloudresourcemanager.Project
+	err := resources.Projects.List().Filter(filter).Pages(ctx, func(r *cloudresourcemanager.ListProjectsResponse) error {
+		projects = append(projects, r.Projects...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error searching projects: %w", err)
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].ProjectId < projects[j].ProjectId
+	})
+	return projects, nil
+}
+
+// format labels as a sorted, comma-separated list of key=value pairs
+func formatLabels(labels map[string]string) string {
+	var pairs []string
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func search(ctx context.Context, args *args) error {
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
+
+	filter := projectFilter(args.Search.Terms)
+	if args.Verbose {
+		fmt.Printf("filter: %s\n", filter)
+	}
+
+	projects, err := searchProjects(ctx, resources, filter)
+	if err != nil {
+		return err
+	}
+	if len(projects) == 0 {
+		fmt.Println("no matching projects")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNUMBER\tSTATE\tLABELS")
+	for _, p := range projects {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", p.ProjectId, p.ProjectNumber, p.LifecycleState, formatLabels(p.Labels))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the largest request body that the server accepts
+const maxRequestBytes = 1 << 20
+
+// server exposes plan, apply, status, and delete over HTTP, for internal platforms that
+// would otherwise run gproj and parse its output
+type server struct {
+	args   *args
+	tokens []string
+
+	// gproj commands share the args and print as they go, so requests are handled one at a time
+	mu sync.Mutex
+}
+
+// the body of plan and apply requests
+type serveRequest struct {
+	Spec  json.RawMessage `json:"spec"`  // the same fields as googlecloudproject.yaml
+	Prune bool            `json:"prune"` // for apply, as for gproj apply --prune
+}
+
+// the response to apply requests
+type serveApplyResponse struct {
+	Report *Report `json:"report,omitempty"`
+	Error  string  `json:"error,omitempty"`
+}
+
+// httpError is an error with the status code with which to report it
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string {
+	return e.err.Error()
+}
+
+// load the bearer tokens that clients may present, one per line in the given file or else
+// from the environment
+func loadServeTokens(path string) ([]string, error) {
+	var tokens []string
+	if path != "" {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading tokens: %w", err)
+		}
+		for _, line := range strings.Split(string(buf), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				tokens = append(tokens, line)
+			}
+		}
+	}
+	if token := os.Getenv("GPROJ_SERVE_TOKEN"); token != "" {
+		tokens = append(tokens, token)
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("gproj serve needs tokens for clients to authenticate with, from --tokens-file or GPROJ_SERVE_TOKEN")
+	}
+	return tokens, nil
+}
+
+// determine whether a request carries one of the tokens, in the bearer scheme
+func (s *server) authorized(r *http.Request) bool {
+	const scheme = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= len(scheme) || !strings.EqualFold(auth[:len(scheme)], scheme) {
+		return false
+	}
+	token := auth[len(scheme):]
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// write a value as the JSON body of a response
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// decode the spec in the body of a plan or apply request. Local state for each project is
+// kept under the state dir, and the spec may not run commands or refer to files outside it.
+func (s *server) readSpec(r *http.Request) (*serveRequest, *ProjectSpec, error) {
+	var req serveRequest
+	err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestBytes)).Decode(&req)
+	if err != nil {
+		return nil, nil, &httpError{http.StatusBadRequest, fmt.Errorf("error decoding request: %w", err)}
+	}
+	if len(req.Spec) == 0 {
+		return nil, nil, &httpError{http.StatusBadRequest, errors.New("the request has no spec")}
+	}
+
+	// the ID determines where the spec is taken to live
+	spec, err := decodeRemoteSpec(req.Spec, "request", s.args.Serve.AllowedPlugins)
+	if err != nil {
+		return nil, nil, &httpError{http.StatusBadRequest, err}
+	}
+	if !projectIDPattern.MatchString(spec.ID) {
+		return nil, nil, &httpError{http.StatusBadRequest, fmt.Errorf("%q is not a valid project ID", spec.ID)}
+	}
+	spec.path = filepath.Join(s.args.Serve.StateDir, spec.ID, gprojFile)
+	return &req, spec, nil
+}
+
+// handle POST /v1/plan, which responds with the plan for a spec
+func (s *server) plan(ctx context.Context, r *http.Request) (interface{}, error) {
+	_, spec, err := s.readSpec(r)
+	if err != nil {
+		return nil, err
+	}
+	return makePlan(ctx, s.args, spec)
+}
+
+// handle POST /v1/apply, which applies a spec and responds with the report of the apply
+func (s *server) apply(ctx context.Context, r *http.Request) (interface{}, error) {
+	req, spec, err := s.readSpec(r)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.CreateTemp("", "gproj-report-*.json")
+	if err != nil {
+		return nil, fmt.Errorf("error creating report file: %w", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	err = s.storeSpec(spec, req.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	s.args.Apply = &applyArgs{Parallelism: 4, Prune: req.Prune, Report: f.Name()}
+	var resp serveApplyResponse
+	if applyErr := applySpec(ctx, s.args, spec); applyErr != nil {
+		resp.Error = applyErr.Error()
+	}
+
+	// an apply that fails before it starts has no report
+	if buf, err := os.ReadFile(f.Name()); err == nil && len(buf) > 0 {
+		var report Report
+		if err := json.Unmarshal(buf, &report); err == nil {
+			resp.Report = &report
+		}
+	}
+	return &resp, nil
+}
+
+// handle GET /v1/projects/ID, which responds with the project, its billing info, its
+// ancestry, and its liens
+func (s *server) status(ctx context.Context, projectID string) (interface{}, error) {
+	conn, err := connect(ctx, s.args)
+	if err != nil {
+		return nil, err
+	}
+	return describeProject(ctx, conn, projectID)
+}
+
+// keep the spec that is about to be applied in the directory where the project's local
+// state is kept, so that later requests can check its lifecycle settings
+func (s *server) storeSpec(spec *ProjectSpec, raw []byte) error {
+	err := fsys.MkdirAll(filepath.Dir(spec.path), dirPerm)
+	if err != nil {
+		return fmt.Errorf("error creating state dir for %s: %w", spec.ID, err)
+	}
+	err = fsys.WriteFile(spec.path, raw, filePerm)
+	if err != nil {
+		return fmt.Errorf("error storing spec for %s: %w", spec.ID, err)
+	}
+	return nil
+}
+
+// load the spec that was last applied to a project through the server
+func (s *server) storedSpec(projectID string) (*ProjectSpec, error) {
+	path := filepath.Join(s.args.Serve.StateDir, projectID, gprojFile)
+	buf, err := fsys.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, &httpError{http.StatusConflict, fmt.Errorf("project %s was not applied through this server, so "+
+			"whether it may be deleted is unknown; delete it with gproj delete instead", projectID)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading the stored spec for %s: %w", projectID, err)
+	}
+	// only the lifecycle settings are needed, so the spec is not held to the restrictions
+	// of decodeRemoteSpec, which may have changed since it was applied
+	return parseProjectSpec(buf, path)
+}
+
+// handle DELETE /v1/projects/ID, which deletes a project managed by gproj, subject to the
+// lifecycle settings in the spec that was last applied to it
+func (s *server) delete(ctx context.Context, projectID string) (interface{}, error) {
+	spec, err := s.storedSpec(projectID)
+	if err != nil {
+		return nil, err
+	}
+	if spec.ID != projectID {
+		return nil, fmt.Errorf("the stored spec for %s is for project %s", projectID, spec.ID)
+	}
+	err = spec.checkDestroyAllowed()
+	if err != nil {
+		return nil, &httpError{http.StatusConflict, err}
+	}
+	err = deleteManagedProject(ctx, s.args, spec, false)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"deleted": projectID}, nil
+}
+
+// route a request to its handler
+func (s *server) route(r *http.Request) (interface{}, error) {
+	ctx := r.Context()
+	projectID := strings.TrimPrefix(r.URL.Path, "/v1/projects/")
+	switch {
+	case r.URL.Path == "/v1/plan" && r.Method == "POST":
+		return s.plan(ctx, r)
+	case r.URL.Path == "/v1/apply" && r.Method == "POST":
+		return s.apply(ctx, r)
+	case projectID != r.URL.Path && projectIDPattern.MatchString(projectID) && r.Method == "GET":
+		return s.status(ctx, projectID)
+	case projectID != r.URL.Path && projectIDPattern.MatchString(projectID) && r.Method == "DELETE":
+		return s.delete(ctx, projectID)
+	}
+	return nil, &httpError{http.StatusNotFound, fmt.Errorf("no such endpoint: %s %s", r.Method, r.URL.Path)}
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid bearer token"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := time.Now()
+	resp, err := s.route(r)
+	code := http.StatusOK
+	if err != nil {
+		code = http.StatusInternalServerError
+		var e *httpError
+		if errors.As(err, &e) {
+			code = e.code
+		}
+		resp = map[string]string{"error": err.Error()}
+	}
+	logf("%s %s %d %s", r.Method, r.URL.Path, code, time.Since(start).Round(time.Millisecond))
+	writeJSON(w, code, resp)
+}
+
+// serve runs the HTTP server until interrupted
+func serve(ctx context.Context, args *args) error {
+	if args.Offline {
+		return errors.New("gproj serve cannot be used with --offline")
+	}
+	tokens, err := loadServeTokens(args.Serve.TokensFile)
+	if err != nil {
+		return err
+	}
+
+	// there is nobody to answer prompts
+	args.CI = true
+	srv := &http.Server{
+		Addr:    args.Serve.Addr,
+		Handler: &server{args: args, tokens: tokens},
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	logf("listening on %s", args.Serve.Addr)
+	err = srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iam/v1"
+	logging "google.golang.org/api/logging/v2"
+	"google.golang.org/api/secretmanager/v1"
+)
+
+// the log in the project to which key rotations are recorded
+const keyRotationLog = "gproj-key-rotation"
+
+// find the service account in the spec with the given name, which may be either the key
+// under actAs or the full email of the account
+func (spec *ProjectSpec) declaredServiceAccount(name string) (string, error) {
+	var declared []string
+	for account := range spec.ActAs {
+		email := serviceAccountEmail(spec.ID, account)
+		if name == account || name == email {
+			return email, nil
+		}
+		declared = append(declared, account)
+	}
+	if len(declared) == 0 {
+		return "", fmt.Errorf("the spec declares no service accounts; only those under actAs can have their keys rotated")
+	}
+	sort.Strings(declared)
+	return "", fmt.Errorf("service account %q is not declared under actAs in the spec, which has: %s", name, strings.Join(declared, ", "))
+}
+
+// store a new version of a secret, creating the secret if it does not exist
+func storeSecret(ctx context.Context, conn *connection, projectID, secretID, base64Data string) (string, error) {
+	svc, err := secretmanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return "", fmt.Errorf("error initializing the secret manager API: %w", err)
+	}
+
+	name := fmt.Sprintf("projects/%s/secrets/%s", projectID, secretID)
+	_, err = svc.Projects.Secrets.Get(name).Context(ctx).Do()
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == 404 {
+		_, err = svc.Projects.Secrets.Create("projects/"+projectID, &secretmanager.Secret{
+			Replication: &secretmanager.Replication{Automatic: &secretmanager.Automatic{}},
+			Labels:      map[string]string{managedByLabel: managedByValue},
+		}).SecretId(secretID).Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("error creating secret %s: %w", secretID, err)
+		}
+		fmt.Printf("created secret %s\n", secretID)
+	} else if err != nil {
+		return "", fmt.Errorf("error getting secret %s: %w", secretID, err)
+	}
+
+	version, err := svc.Projects.Secrets.AddVersion(name, &secretmanager.AddSecretVersionRequest{
+		Payload: &secretmanager.SecretPayload{Data: base64Data},
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("error adding a version to secret %s: %w", secretID, err)
+	}
+	return version.Name, nil
+}
+
+// keyRotation is the audit entry recorded for each rotation
+type keyRotation struct {
+	ServiceAccount string   `json:"serviceAccount"`
+	Principal      string   `json:"principal,omitempty"`
+	CreatedKey     string   `json:"createdKey"`
+	StoredIn       string   `json:"storedIn,omitempty"`
+	DisabledKeys   []string `json:"disabledKeys,omitempty"`
+	DeletedKeys    []string `json:"deletedKeys,omitempty"`
+}
+
+// record a key rotation in the project's logs, alongside the admin activity audit log
+// entries that google writes for each individual key operation
+func recordKeyRotation(ctx context.Context, conn *connection, projectID string, rotation keyRotation) error {
+	svc, err := logging.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the logging API: %w", err)
+	}
+	payload, err := json.Marshal(rotation)
+	if err != nil {
+		return fmt.Errorf("error marshalling audit entry: %w", err)
+	}
+	_, err = svc.Entries.Write(&logging.WriteLogEntriesRequest{
+		LogName:  fmt.Sprintf("projects/%s/logs/%s", projectID, keyRotationLog),
+		Resource: &logging.MonitoredResource{Type: "project", Labels: map[string]string{"project_id": projectID}},
+		Entries: []*logging.LogEntry{{
+			Severity:    "NOTICE",
+			JsonPayload: googleapi.RawMessage(payload),
+		}},
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error writing to log %s: %w", keyRotationLog, err)
+	}
+	return nil
+}
+
+// saRotateKeys creates a new key for a service account in the spec, writes it to a file or
+// to secret manager, and then disables or deletes the account's keys that are older than
+// the maximum age. Old keys are only retired once the new key is safely stored.
+func saRotateKeys(ctx context.Context, args *args) error {
+	cmd := args.SA.RotateKeys
+	if cmd.Out == "" && cmd.Secret == "" {
+		return fmt.Errorf("give --out to write the new key to a file (or - for stdout), or --secret to store it in secret manager")
+	}
+	days, err := parseLookback(cmd.MaxAge)
+	if err != nil {
+		return fmt.Errorf("invalid --max-age: %w", err)
+	}
+	maxAge := time.Duration(days) * 24 * time.Hour
+
+	// keep progress out of the way of the key when it is written to stdout
+	var status io.Writer = os.Stdout
+	if cmd.Out == "-" {
+		status = os.Stderr
+	}
+
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+	email, err := spec.declaredServiceAccount(cmd.Name)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+	svc, err := iam.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the IAM API: %w", err)
+	}
+
+	account := fmt.Sprintf("projects/%s/serviceAccounts/%s", spec.ID, email)
+	existing, err := svc.Projects.ServiceAccounts.Keys.List(account).KeyTypes("USER_MANAGED").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error listing keys for %s: %w", email, err)
+	}
+
+	key, err := svc.Projects.ServiceAccounts.Keys.Create(account, &iam.CreateServiceAccountKeyRequest{}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error creating a key for %s: %w", email, err)
+	}
+	keyID := key.Name[strings.LastIndex(key.Name, "/")+1:]
+	fmt.Fprintf(status, "created key %s for %s\n", keyID, email)
+	rotation := keyRotation{ServiceAccount: email, CreatedKey: keyID}
+
+	// store the new key before touching the old ones, so that a failure here leaves the
+	// account with working keys
+	if cmd.Secret != "" {
+		version, err := storeSecret(ctx, conn, spec.ID, cmd.Secret, key.PrivateKeyData)
+		if err != nil {
+			return fmt.Errorf("%w (key %s was created but not stored; old keys were left alone)", err, keyID)
+		}
+		fmt.Fprintf(status, "stored the key in %s\n", version)
+		rotation.StoredIn = version
+	}
+	if cmd.Out != "" {
+		buf, err := base64.StdEncoding.DecodeString(key.PrivateKeyData)
+		if err != nil {
+			return fmt.Errorf("error decoding key %s: %w", keyID, err)
+		}
+		if cmd.Out == "-" {
+			os.Stdout.Write(buf)
+		} else {
+			// the key grants whatever the service account can do, so only its owner may read it
+			err = fsys.WriteFile(cmd.Out, buf, 0600)
+			if err != nil {
+				return fmt.Errorf("error writing key %s to %s; old keys were left alone: %w", keyID, cmd.Out, err)
+			}
+			fmt.Fprintf(status, "wrote the key to %s\n", cmd.Out)
+		}
+	}
+
+	// retire the keys that are older than the maximum age
+	for _, old := range existing.Keys {
+		created, err := time.Parse(time.RFC3339, old.ValidAfterTime)
+		if err != nil || time.Since(created) < maxAge {
+			continue
+		}
+		oldID := old.Name[strings.LastIndex(old.Name, "/")+1:]
+		switch {
+		case cmd.Delete:
+			_, err = svc.Projects.ServiceAccounts.Keys.Delete(old.Name).Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("error deleting key %s: %w", oldID, err)
+			}
+			fmt.Fprintf(status, "deleted key %s, created %s\n", oldID, humanAgo(created))
+			rotation.DeletedKeys = append(rotation.DeletedKeys, oldID)
+		case !old.Disabled:
+			_, err = svc.Projects.ServiceAccounts.Keys.Disable(old.Name, &iam.DisableServiceAccountKeyRequest{}).Context(ctx).Do()
+			if err != nil {
+				return fmt.Errorf("error disabling key %s: %w", oldID, err)
+			}
+			fmt.Fprintf(status, "disabled key %s, created %s\n", oldID, humanAgo(created))
+			rotation.DisabledKeys = append(rotation.DisabledKeys, oldID)
+		}
+	}
+
+	rotation.Principal, _ = principalEmail(ctx, conn)
+	err = recordKeyRotation(ctx, conn, spec.ID, rotation)
+	if err != nil {
+		fmt.Fprintln(status, "warning: the rotation was not recorded:", err)
+	}
+	return nil
+}
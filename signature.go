@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// tools that gproj can verify detached signatures with
+const (
+	signerCosign   = "cosign"
+	signerMinisign = "minisign"
+)
+
+// work out which tool made a public key: minisign keys start with a comment naming minisign,
+// while cosign keys are PEM-encoded
+func signerForKey(key []byte) string {
+	if bytes.Contains(key, []byte("minisign")) {
+		return signerMinisign
+	}
+	return signerCosign
+}
+
+// get the default path of the detached signature for a file
+func defaultSignaturePath(path, signer string) string {
+	if signer == signerMinisign {
+		return path + ".minisig"
+	}
+	return path + ".sig"
+}
+
+// verifySignature checks a detached signature over a file with cosign or minisign, so that
+// gproj applies only specs that have been approved and signed. If sigPath is empty then the
+// signature is looked for next to the file.
+func verifySignature(path, keyPath, sigPath string) error {
+	key, err := fsys.ReadFile(keyPath)
+	if err != nil {
+		return fmt.Errorf("error reading verification key: %w", err)
+	}
+	signer := signerForKey(key)
+	if sigPath == "" {
+		sigPath = defaultSignaturePath(path, signer)
+	}
+	if _, err := fsys.Stat(sigPath); err != nil {
+		return fmt.Errorf("%s is not signed: no signature found at %s", path, sigPath)
+	}
+
+	toolPath, err := exec.LookPath(signer)
+	if err != nil {
+		return fmt.Errorf("verifying signatures made with %s needs %s on the PATH: %w", signer, signer, err)
+	}
+
+	var cmd *exec.Cmd
+	if signer == signerMinisign {
+		cmd = exec.Command(toolPath, "-V", "-q", "-p", keyPath, "-m", path, "-x", sigPath)
+	} else {
+		cmd = exec.Command(toolPath, "verify-blob", "--key", keyPath, "--signature", sigPath, path)
+	}
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	err = cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(output.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("signature of %s is not valid: %s", path, msg)
+	}
+	return nil
+}
+
+// verifySpecFiles verifies the signature of a spec and of the workspace file above it, if
+// there is one, since the groups in the workspace file can add APIs and labels to the spec.
+// The workspace file is expected to have its signature in the default place.
+func verifySpecFiles(specPath, keyPath, sigPath string) error {
+	err := verifySignature(specPath, keyPath, sigPath)
+	if err != nil {
+		return err
+	}
+	workspace, err := findWorkspaceFile(fsys, filepath.Dir(specPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = verifySignature(workspace, keyPath, "")
+	if err != nil {
+		return fmt.Errorf("%w (the workspace file must be signed too, since groups in it change the spec)", err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/cloudbilling/v1"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/serviceusage/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// takeSnapshot captures the live configuration of a project as a spec: its labels, parent,
+// billing account, every enabled API, and the unconditional bindings in its IAM policy.
+// The resources managed by gproj are taken from the spec, if there is one, and only those
+// that gproj has recorded creating are kept if state tracking is enabled.
+func takeSnapshot(ctx context.Context, conn *connection, spec *ProjectSpec) (*ProjectSpec, error) {
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := serviceusage.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing the service usage API: %w", err)
+	}
+	billing, err := cloudbilling.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing the billing API: %w", err)
+	}
+
+	project, err := resources.Projects.Get(spec.ID).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error getting project %s: %w", spec.ID, err)
+	}
+
+	snap := ProjectSpec{
+		Name:      project.Name,
+		ID:        project.ProjectId,
+		Number:    int(project.ProjectNumber),
+		Labels:    make(map[string]string),
+		IAM:       make(map[string][]string),
+		Lifecycle: spec.Lifecycle,
+		Notes:     spec.Notes,
+	}
+	if project.Parent != nil {
+		snap.Parent = project.Parent.Type + "s/" + project.Parent.Id
+	}
+
+	// gproj sets these labels itself, and a restored project should be neither paused nor
+	// scheduled for deletion
+	for k, v := range project.Labels {
+		if !gprojLabels[k] {
+			snap.Labels[k] = v
+		}
+	}
+
+	snap.APIs, err = enabledAPIs(ctx, usage, project.ProjectNumber)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(snap.APIs)
+
+	billingInfo, err := billing.Projects.GetBillingInfo(formatProjectNumber(project.ProjectNumber)).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error getting billing info for %s: %w", spec.ID, err)
+	}
+	snap.Billing = strings.TrimPrefix(billingInfo.BillingAccountName, "billingAccounts/")
+
+	policy, err := resources.Projects.GetIamPolicy(spec.ID, &cloudresourcemanager.GetIamPolicyRequest{}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error getting IAM policy for %s: %w", spec.ID, err)
+	}
+	for _, b := range policy.Bindings {
+		if b.Condition == nil {
+			snap.IAM[b.Role] = append(snap.IAM[b.Role], b.Members...)
+		}
+	}
+
+	// keep only the resources that gproj actually created, if it has been keeping track
+	backend, err := newStateBackend(ctx, conn, spec)
+	if err != nil {
+		return nil, err
+	}
+	var state *State
+	if backend != nil {
+		state, err = backend.Load(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, svc := range spec.CloudRun {
+		name := fmt.Sprintf("namespaces/%s/services/%s", spec.ID, svc.Name)
+		if state == nil || state.Find(kindCloudRunService, name) != nil {
+			snap.CloudRun = append(snap.CloudRun, svc)
+		}
+	}
+	for _, job := range spec.Scheduler {
+		name := fmt.Sprintf("projects/%s/locations/%s/jobs/%s", spec.ID, job.Region, job.Name)
+		if state == nil || state.Find(kindSchedulerJob, name) != nil {
+			snap.Scheduler = append(snap.Scheduler, job)
+		}
+	}
+
+	return &snap, nil
+}
+
+// drop the members of IAM bindings that belong to the project itself, such as its default
+// service accounts and google's service agents, since google creates new ones for the
+// project that a snapshot is restored to
+func dropProjectMembers(bindings map[string][]string, projectID string, projectNumber int) map[string][]string {
+	number := fmt.Sprint(projectNumber)
+	out := make(map[string][]string)
+	for role, members := range bindings {
+		for _, member := range members {
+			if !strings.HasPrefix(member, "serviceAccount:") {
+				out[role] = append(out[role], member)
+				continue
+			}
+			email := strings.TrimPrefix(member, "serviceAccount:")
+			if strings.Contains(email, projectID) || strings.HasPrefix(email, number+"-") || strings.Contains(email, "-"+number+"@") {
+				continue
+			}
+			out[role] = append(out[role], member)
+		}
+	}
+	return out
+}
+
+// marshal a spec to YAML without the fields that are empty, which yaml.v2 would otherwise
+// write out as empty strings and nulls
+func marshalSpec(spec *ProjectSpec) ([]byte, error) {
+	versioned := *spec
+	versioned.Version = specVersion
+	buf, err := yaml.Marshal(&versioned)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.MapSlice
+	err = yaml.Unmarshal(buf, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	var nonempty yaml.MapSlice
+	for _, item := range doc {
+		switch v := item.Value.(type) {
+		case nil:
+			continue
+		case string:
+			if v == "" {
+				continue
+			}
+		case int:
+			if v == 0 {
+				continue
+			}
+		case []interface{}:
+			if len(v) == 0 {
+				continue
+			}
+		case yaml.MapSlice:
+			if len(v) == 0 {
+				continue
+			}
+		}
+		nonempty = append(nonempty, item)
+	}
+	return yaml.Marshal(nonempty)
+}
+
+// snapshot writes the live configuration of the project to a file
+func snapshot(ctx context.Context, args *args) error {
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		return err
+	}
+
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	snap, err := takeSnapshot(ctx, conn, spec)
+	if err != nil {
+		return err
+	}
+
+	buf, err := marshalSpec(snap)
+	if err != nil {
+		return fmt.Errorf("error marshalling snapshot to yaml: %w", err)
+	}
+	header := fmt.Sprintf("# snapshot of %s taken %s by gproj snapshot; restore with gproj restore\n",
+		spec.ID, time.Now().Format(time.RFC3339))
+	err = fsys.WriteFile(args.Snapshot.Out, append([]byte(header), buf...), filePerm)
+	if err != nil {
+		return fmt.Errorf("error writing snapshot to %s: %w", args.Snapshot.Out, err)
+	}
+
+	fmt.Printf("wrote snapshot of %s with %d APIs, %d roles, and %d resources to %s\n",
+		spec.ID, len(snap.APIs), len(snap.IAM), len(snap.CloudRun)+len(snap.Scheduler), args.Snapshot.Out)
+	return nil
+}
+
+// restore applies a snapshot to the project it was taken from, or to a new project
+func restore(ctx context.Context, args *args) error {
+	spec, err := loadProjectSpec(fsys, args.Restore.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	if args.Restore.ID != "" && args.Restore.ID != spec.ID {
+		spec.IAM = dropProjectMembers(spec.IAM, spec.ID, spec.Number)
+		spec.ID = args.Restore.ID
+		spec.Number = 0
+	}
+	if args.Restore.Name != "" {
+		spec.Name = args.Restore.Name
+	}
+
+	args.Apply = &applyArgs{
+		Parallelism: args.Restore.Parallelism,
+		Adopt:       args.Restore.Adopt,
+		Report:      args.Restore.Report,
+	}
+	return applySpec(ctx, args, spec)
+}
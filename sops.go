@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// determine whether a spec was encrypted with sops, which adds a top-level "sops" key
+// holding the metadata needed to decrypt it
+func isSopsEncrypted(buf []byte) bool {
+	var doc struct {
+		Sops interface{} `yaml:"sops"`
+	}
+	if err := yaml.Unmarshal(buf, &doc); err != nil {
+		return false
+	}
+	return doc.Sops != nil
+}
+
+// decrypt a spec encrypted with sops by running the sops binary, which knows how to get
+// the data key from KMS, age, PGP, or whatever else the file was encrypted with
+func decryptSops(path string) ([]byte, error) {
+	sopsPath, err := exec.LookPath("sops")
+	if err != nil {
+		return nil, fmt.Errorf("%s is encrypted with sops but sops was not found on the PATH: %w", path, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(sopsPath, "--decrypt", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("error decrypting %s with sops: %s", path, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// returned when asked to rewrite a spec that is encrypted, since doing so would leave
+// the decrypted contents on disk
+var errSpecEncrypted = errors.New("the spec is encrypted with sops; edit it with \"sops\" instead")
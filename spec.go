@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"gopkg.in/yaml.v2"
+)
+
+const gprojFile = "googlecloudproject.yaml"
+
+// specFileNames are the names under which the spec may be stored, in order of precedence.
+// The JSON variant is decoded with the same YAML decoder since JSON is a subset of YAML.
+var specFileNames = []string{
+	gprojFile,
+	"googlecloudproject.yml",
+	"googlecloudproject.json",
+	".gproj.yaml",
+}
+
+var ErrSpecNotFound = errors.New(gprojFile + " file not found")
+
+// ProjectSpec models the googlecloudproject.yaml file
+type ProjectSpec struct {
+	Version int               // layout of the spec, currently 2 (see "gproj migrate-spec")
+	Name    string            // human readable name of the project
+	ID      string            // ID of the project (must also be input by hand)
+	Number  int               // Project number (will be filled in by gcloud apply)
+	Labels  map[string]string // arbitrary key/value labels to assign to the project
+	Tags    map[string]string // tag values to bind, keyed by namespaced tag key, e.g. 123456789/environment: production
+	APIs    []string          // APIs to enable, by name or as bundles such as bundle:web-app (see "gproj explain apis")
+	Billing string            // billing account ID or display name, or "auto", "prompt", or "none" (see "gproj explain billing")
+
+	Parent string              // organization or folder in which to create the project, e.g. "folders/123"
+	IAM    map[string][]string // members to grant each role, e.g. roles/viewer: [group:eng@example.com]
+	Budget *Budget             // monthly budget for the project, which requires billing
+	ActAs  map[string][]string `yaml:"actAs"` // members to allow to act as each service account in this project, e.g. runtime: [serviceAccount:deployer@ci-project]
+
+	BillingExport *BillingExport `yaml:"billingExport"` // bigquery dataset to which to export the billing account's cost data
+
+	Bundles map[string][]string // bundles of APIs to refer to from the apis list, in addition to the built in ones
+	Groups  []string            // groups of APIs and labels to include, as defined in gproj-workspace.yaml (see "gproj explain groups")
+
+	DependsOn []string `yaml:"dependsOn"` // IDs of projects to apply first in "gproj workspace", e.g. a shared VPC host project
+
+	AllowedMemberDomains []string `yaml:"allowedMemberDomains"` // domains to which IAM members must belong, e.g. example.com
+	AllowedCustomerIDs   []string `yaml:"allowedCustomerIDs"`   // workspace customer IDs to enforce with domain restricted sharing, e.g. C0abc123
+
+	OAuth             *OAuthBrand        `yaml:"oauth"` // OAuth consent screen, needed before OAuth clients can be created
+	Compute           *ComputeSettings   // project-wide compute engine settings, applied once the compute API is enabled
+	CloudRun          []CloudRunService  // cloud run services to create
+	Scheduler         []SchedulerJob     // cron jobs to create
+	Datasets          []BigQueryDataset  // bigquery datasets to create
+	CloudSQL          []CloudSQLInstance `yaml:"cloudsql"`          // cloud SQL instances to create
+	APIKeys           []APIKey           `yaml:"apiKeys"`           // restricted API keys to create
+	IdentityPlatform  *IdentityPlatform  `yaml:"identityPlatform"`  // user sign in for apps built on the project
+	ServiceNetworking *ServiceNetworking `yaml:"serviceNetworking"` // private service access for cloud SQL, memorystore, and the like
+	Resources         []GenericResource  // resources of other types, created with any google API
+	Plugins           []Plugin           // resources reconciled by external plugins
+	State             *StateConfig       // where to record the resources created by gproj (default: not recorded)
+	Lifecycle         *Lifecycle         // guards against unwanted changes to the project
+
+	// free-form notes for the people who own the project, such as the owning team or a link
+	// to a runbook. These are kept in the spec and in snapshots but never sent to google.
+	Notes map[string]string
+
+	path      string   // path from which the spec was read
+	encrypted bool     // whether the spec file is encrypted with sops
+	unknown   []string // fields of a live spec that could not be fetched because gproj is offline
+}
+
+// Budget models the "budget" section of googlecloudproject.yaml
+type Budget struct {
+	Amount     int64     // monthly amount, in whole units of the currency
+	Currency   string    // e.g. "USD" (default: the currency of the billing account)
+	Thresholds []float64 // fractions of the amount at which to alert (default: 0.5, 0.9, and 1.0)
+}
+
+// Lifecycle models the "lifecycle" section of googlecloudproject.yaml
+type Lifecycle struct {
+	PreventDestroy bool     `yaml:"preventDestroy"` // refuse to delete or destroy the project
+	IgnoreChanges  []string `yaml:"ignoreChanges"`  // fields that apply should not reconcile on an existing project
+}
+
+// the fields that can be listed in lifecycle.ignoreChanges
+var ignorableFields = []string{"labels", "billing"}
+
+// determine whether apply should leave a field of an existing project alone
+func (spec *ProjectSpec) ignoresChanges(field string) bool {
+	return spec.Lifecycle != nil && contains(spec.Lifecycle.IgnoreChanges, field)
+}
+
+// parse the parent in the spec into the form used by the resource manager API, or
+// return nil if the spec has no parent
+func (spec *ProjectSpec) parentResource() (*cloudresourcemanager.ResourceId, error) {
+	if spec.Parent == "" {
+		return nil, nil
+	}
+	parts := strings.Split(spec.Parent, "/")
+	if len(parts) == 2 && parts[1] != "" {
+		switch parts[0] {
+		case "organizations":
+			return &cloudresourcemanager.ResourceId{Type: "organization", Id: parts[1]}, nil
+		case "folders":
+			return &cloudresourcemanager.ResourceId{Type: "folder", Id: parts[1]}, nil
+		}
+	}
+	return nil, fmt.Errorf("parent %q invalid: expected organizations/ID or folders/ID", spec.Parent)
+}
+
+// return an error if the spec does not allow the project to be deleted
+func (spec *ProjectSpec) checkDestroyAllowed() error {
+	if spec.Lifecycle != nil && spec.Lifecycle.PreventDestroy {
+		return fmt.Errorf("%s sets lifecycle.preventDestroy for %s; remove it from the spec to delete the project", spec.path, spec.ID)
+	}
+	return nil
+}
+
+// resolve symlinks so that paths can be compared, falling back to the cleaned path
+// if the path cannot be resolved (e.g. because it does not exist)
+func canonicalPath(fsys filesystem, path string) string {
+	resolved, err := fsys.EvalSymlinks(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	return resolved
+}
+
+// specSearch bounds the upward search for the spec
+type specSearch struct {
+	Stop      string // if non-empty, do not search above this directory
+	StopAtGit bool   // do not search above a directory containing .git (i.e. a repository root)
+	Home      string // if non-empty, do not search in or above this directory unless starting in it
+}
+
+// get the search bounds from the command line
+func specSearchFromArgs(args *args) (specSearch, error) {
+	search := specSearch{Stop: args.SpecDir}
+	for _, b := range strings.Split(args.SpecBoundary, ",") {
+		switch strings.TrimSpace(b) {
+		case "git":
+			search.StopAtGit = true
+		case "home":
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return search, fmt.Errorf("error getting home directory: %w", err)
+			}
+			search.Home = home
+		case "none", "":
+		default:
+			return search, fmt.Errorf("invalid spec boundary %q (expected git, home, or none)", b)
+		}
+	}
+	return search, nil
+}
+
+// findProjectSpec looks for the spec in start and then in each of its parents, within the
+// bounds given by search. Symlinks in start are not resolved, so the search walks up the
+// path as the user sees it, but a spec that is itself a symlink is followed.
+func findProjectSpec(fsys filesystem, start string, search specSearch) (string, error) {
+	path := filepath.Clean(start)
+
+	var stop, home string
+	if search.Stop != "" {
+		stop = canonicalPath(fsys, search.Stop)
+	}
+	if search.Home != "" {
+		home = canonicalPath(fsys, search.Home)
+	}
+
+	for i := 0; i < 100; i++ {
+		canonical := canonicalPath(fsys, path)
+
+		// a spec in the home directory only counts if we started there, otherwise a
+		// stray spec in $HOME would be picked up from every unrelated directory
+		if home != "" && canonical == home && i > 0 {
+			return "", ErrSpecNotFound
+		}
+
+		if x := specInDir(fsys, path); x != "" {
+			return x, nil
+		}
+
+		if stop != "" && canonical == stop {
+			return "", ErrSpecNotFound
+		}
+
+		// .git is a directory in ordinary repositories and a file in worktrees
+		if search.StopAtGit {
+			if _, err := fsys.Stat(filepath.Join(path, ".git")); err == nil {
+				return "", ErrSpecNotFound
+			}
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return "", ErrSpecNotFound
+		}
+
+		path = parent
+	}
+	return "", errors.New("took more than 100 steps up parent hierarchy")
+}
+
+// look for a spec in a single directory, returning the empty string if there is none and
+// warning if there is more than one
+func specInDir(fsys filesystem, dir string) string {
+	var found []string
+	for _, name := range specFileNames {
+		x := filepath.Join(dir, name)
+		if st, err := fsys.Stat(x); err == nil && st.Mode().IsRegular() {
+			found = append(found, x)
+		}
+	}
+	if len(found) == 0 {
+		return ""
+	}
+	if len(found) > 1 {
+		fmt.Printf("warning: found %d spec files in %s, using %s\n", len(found), dir, filepath.Base(found[0]))
+	}
+	return found[0]
+}
+
+// readProjectSpec reads the spec given on the command line, or else searches for it
+// starting at the current directory
+func readProjectSpec(args *args) (*ProjectSpec, error) {
+	// --project names a project directly, in which case there is no spec to read
+	if args.Project != "" {
+		return &ProjectSpec{ID: args.Project}, nil
+	}
+
+	specPath, err := projectSpecPath(args)
+	if err != nil {
+		return nil, err
+	}
+	return loadProjectSpec(fsys, specPath)
+}
+
+// projectSpecPath gets the path of the spec given on the command line, or else searches
+// for it starting at the current directory
+func projectSpecPath(args *args) (string, error) {
+	if args.Spec != "" {
+		return args.Spec, nil
+	}
+
+	cwd, err := fsys.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	search, err := specSearchFromArgs(args)
+	if err != nil {
+		return "", err
+	}
+
+	specPath, err := findProjectSpec(fsys, cwd, search)
+	if err != nil {
+		return "", fmt.Errorf("error finding project specification: %w", err)
+	}
+	return specPath, nil
+}
+
+// loadProjectSpec reads and decodes the spec at the given path
+func loadProjectSpec(fsys filesystem, specPath string) (*ProjectSpec, error) {
+	// read the file
+	buf, err := fsys.ReadFile(specPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening project spec: %w", err)
+	}
+
+	// sensitive fields such as the billing account or IAM members may be encrypted at rest
+	encrypted := isSopsEncrypted(buf)
+	if encrypted {
+		buf, err = decryptSops(specPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	spec, err := decodeProjectSpec(buf, specPath)
+	if err != nil {
+		return nil, err
+	}
+	spec.encrypted = encrypted
+	return spec, nil
+}
+
+// decodeProjectSpec decodes a spec that has already been read and decrypted. Specs that
+// arrive as JSON, such as from the operator or the server, are decoded in the same way,
+// since JSON is YAML.
+func decodeProjectSpec(buf []byte, specPath string) (*ProjectSpec, error) {
+	spec, err := parseProjectSpec(buf, specPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// groups come first since they may refer to bundles
+	err = spec.applyGroups()
+	if err != nil {
+		return nil, fmt.Errorf("error in project spec at %s: %w", specPath, err)
+	}
+	err = spec.expandBundles()
+	if err != nil {
+		return nil, fmt.Errorf("error in project spec at %s: %w", specPath, err)
+	}
+	return spec, nil
+}
+
+// parseProjectSpec upgrades and unmarshals a spec, without applying its groups or
+// expanding its bundles
+func parseProjectSpec(buf []byte, specPath string) (*ProjectSpec, error) {
+	// upgrade specs written for older versions of gproj
+	buf, err := migrateSpecBytes(buf, specPath)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err = rewriteBundleRefs(buf)
+	if err != nil {
+		return nil, fmt.Errorf("error reading API bundles in %s: %w", specPath, err)
+	}
+
+	// decode it
+	var spec ProjectSpec
+	err = yaml.Unmarshal(buf, &spec)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing project spec at %s: %w", specPath, err)
+	}
+	spec.path = specPath
+	return &spec, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindProjectSpec(t *testing.T) {
+	root := string(filepath.Separator)
+	p := func(parts ...string) string {
+		return filepath.Join(append([]string{root}, parts...)...)
+	}
+
+	tests := []struct {
+		name   string
+		setup  func(f *fakeFilesystem)
+		start  string
+		search specSearch
+		want   string // empty means ErrSpecNotFound
+	}{
+		{
+			name: "in start directory",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("repo", "app", "googlecloudproject.yaml"), "id: app")
+			},
+			start: p("repo", "app"),
+			want:  p("repo", "app", "googlecloudproject.yaml"),
+		},
+		{
+			name: "in a parent",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("repo", "googlecloudproject.yaml"), "id: repo")
+				f.dir(p("repo", "app", "src"))
+			},
+			start: p("repo", "app", "src"),
+			want:  p("repo", "googlecloudproject.yaml"),
+		},
+		{
+			name: "nearest wins",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("repo", "googlecloudproject.yaml"), "id: repo")
+				f.file(p("repo", "app", "googlecloudproject.yaml"), "id: app")
+				f.dir(p("repo", "app", "src"))
+			},
+			start: p("repo", "app", "src"),
+			want:  p("repo", "app", "googlecloudproject.yaml"),
+		},
+		{
+			name:  "not found",
+			setup: func(f *fakeFilesystem) { f.dir(p("repo", "app")) },
+			start: p("repo", "app"),
+		},
+		{
+			name: "a directory with the name of a spec is skipped",
+			setup: func(f *fakeFilesystem) {
+				f.dir(p("repo", "app", "googlecloudproject.yaml"))
+				f.file(p("repo", "googlecloudproject.yaml"), "id: repo")
+			},
+			start: p("repo", "app"),
+			want:  p("repo", "googlecloudproject.yaml"),
+		},
+		{
+			name: "spec that is a symlink",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("shared", "specs", "app.yaml"), "id: app")
+				f.symlink(p("repo", "app", "googlecloudproject.yaml"), filepath.Join("..", "..", "shared", "specs", "app.yaml"))
+			},
+			start: p("repo", "app"),
+			want:  p("repo", "app", "googlecloudproject.yaml"),
+		},
+		{
+			name: "broken symlink is skipped",
+			setup: func(f *fakeFilesystem) {
+				f.symlink(p("repo", "app", "googlecloudproject.yaml"), p("missing.yaml"))
+				f.file(p("repo", "googlecloudproject.yaml"), "id: repo")
+			},
+			start: p("repo", "app"),
+			want:  p("repo", "googlecloudproject.yaml"),
+		},
+		{
+			name: "search walks up the path as given rather than the resolved path",
+			setup: func(f *fakeFilesystem) {
+				f.dir(p("elsewhere", "checkout"))
+				f.symlink(p("repo", "link"), p("elsewhere", "checkout"))
+				f.file(p("repo", "googlecloudproject.yaml"), "id: repo")
+			},
+			start: p("repo", "link"),
+			want:  p("repo", "googlecloudproject.yaml"),
+		},
+		{
+			name: "yml variant",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("repo", "googlecloudproject.yml"), "id: repo")
+			},
+			start: p("repo"),
+			want:  p("repo", "googlecloudproject.yml"),
+		},
+		{
+			name: "json variant",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("repo", "googlecloudproject.json"), `{"id": "repo"}`)
+			},
+			start: p("repo"),
+			want:  p("repo", "googlecloudproject.json"),
+		},
+		{
+			name: "hidden variant",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("repo", ".gproj.yaml"), "id: repo")
+			},
+			start: p("repo"),
+			want:  p("repo", ".gproj.yaml"),
+		},
+		{
+			name: "several names in one directory follow precedence",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("repo", ".gproj.yaml"), "id: hidden")
+				f.file(p("repo", "googlecloudproject.json"), `{"id": "json"}`)
+				f.file(p("repo", "googlecloudproject.yml"), "id: yml")
+				f.file(p("repo", "googlecloudproject.yaml"), "id: yaml")
+			},
+			start: p("repo"),
+			want:  p("repo", "googlecloudproject.yaml"),
+		},
+		{
+			name: "precedence without the first name",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("repo", ".gproj.yaml"), "id: hidden")
+				f.file(p("repo", "googlecloudproject.json"), `{"id": "json"}`)
+			},
+			start: p("repo"),
+			want:  p("repo", "googlecloudproject.json"),
+		},
+		{
+			name: "stop directory is searched",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("repo", "googlecloudproject.yaml"), "id: repo")
+				f.dir(p("repo", "app"))
+			},
+			start:  p("repo", "app"),
+			search: specSearch{Stop: p("repo")},
+			want:   p("repo", "googlecloudproject.yaml"),
+		},
+		{
+			name: "nothing above the stop directory",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("googlecloudproject.yaml"), "id: root")
+				f.dir(p("repo", "app"))
+			},
+			start:  p("repo", "app"),
+			search: specSearch{Stop: p("repo")},
+		},
+		{
+			name: "stop directory given through a symlink",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("googlecloudproject.yaml"), "id: root")
+				f.dir(p("repo", "app"))
+				f.symlink(p("link-to-repo"), p("repo"))
+			},
+			start:  p("repo", "app"),
+			search: specSearch{Stop: p("link-to-repo")},
+		},
+		{
+			name: "stops at a git repository root",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("src", "googlecloudproject.yaml"), "id: src")
+				f.dir(p("src", "repo", ".git"))
+				f.dir(p("src", "repo", "app"))
+			},
+			start:  p("src", "repo", "app"),
+			search: specSearch{StopAtGit: true},
+		},
+		{
+			name: "spec at the git repository root",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("src", "repo", "googlecloudproject.yaml"), "id: repo")
+				f.dir(p("src", "repo", ".git"))
+				f.dir(p("src", "repo", "app"))
+			},
+			start:  p("src", "repo", "app"),
+			search: specSearch{StopAtGit: true},
+			want:   p("src", "repo", "googlecloudproject.yaml"),
+		},
+		{
+			name: "stops at a git worktree, where .git is a file",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("src", "googlecloudproject.yaml"), "id: src")
+				f.file(p("src", "worktree", ".git"), "gitdir: /src/repo/.git/worktrees/w")
+				f.dir(p("src", "worktree", "app"))
+			},
+			start:  p("src", "worktree", "app"),
+			search: specSearch{StopAtGit: true},
+		},
+		{
+			name: "git is ignored unless asked for",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("src", "googlecloudproject.yaml"), "id: src")
+				f.dir(p("src", "repo", ".git"))
+				f.dir(p("src", "repo", "app"))
+			},
+			start: p("src", "repo", "app"),
+			want:  p("src", "googlecloudproject.yaml"),
+		},
+		{
+			name: "spec in home is not found from below",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("home", "alex", "googlecloudproject.yaml"), "id: stray")
+				f.dir(p("home", "alex", "src", "app"))
+			},
+			start:  p("home", "alex", "src", "app"),
+			search: specSearch{Home: p("home", "alex")},
+		},
+		{
+			name: "spec in home is found when starting in home",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("home", "alex", "googlecloudproject.yaml"), "id: home")
+			},
+			start:  p("home", "alex"),
+			search: specSearch{Home: p("home", "alex")},
+			want:   p("home", "alex", "googlecloudproject.yaml"),
+		},
+		{
+			name: "spec below home is found",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("home", "alex", "src", "googlecloudproject.yaml"), "id: src")
+				f.dir(p("home", "alex", "src", "app"))
+			},
+			start:  p("home", "alex", "src", "app"),
+			search: specSearch{Home: p("home", "alex")},
+			want:   p("home", "alex", "src", "googlecloudproject.yaml"),
+		},
+		{
+			name: "nothing above home",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("home", "googlecloudproject.yaml"), "id: above")
+				f.dir(p("home", "alex", "src"))
+			},
+			start:  p("home", "alex", "src"),
+			search: specSearch{Home: p("home", "alex")},
+		},
+		{
+			name: "home outside the start path has no effect",
+			setup: func(f *fakeFilesystem) {
+				f.file(p("srv", "googlecloudproject.yaml"), "id: srv")
+				f.dir(p("srv", "app"))
+			},
+			start:  p("srv", "app"),
+			search: specSearch{Home: p("home", "alex")},
+			want:   p("srv", "googlecloudproject.yaml"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newFakeFilesystem(test.start)
+			test.setup(f)
+			got, err := findProjectSpec(f, test.start, test.search)
+			if test.want == "" {
+				if !errors.Is(err, ErrSpecNotFound) {
+					t.Errorf("expected ErrSpecNotFound, got %q, %v", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
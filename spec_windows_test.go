@@ -0,0 +1,33 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// on windows the upward search must stop at the root of the drive, where filepath.Dir
+// returns its argument unchanged
+func TestFindProjectSpecDriveRoot(t *testing.T) {
+	f := newFakeFilesystem(`C:\Users\alex\src\app`)
+	_, err := findProjectSpec(f, `C:\Users\alex\src\app`, specSearch{})
+	if !errors.Is(err, ErrSpecNotFound) {
+		t.Fatalf("expected ErrSpecNotFound, got %v", err)
+	}
+
+	f.file(`C:\googlecloudproject.yaml`, "id: root")
+	got, err := findProjectSpec(f, `C:\Users\alex\src\app`, specSearch{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != `C:\googlecloudproject.yaml` {
+		t.Errorf("got %q", got)
+	}
+
+	// a spec on another drive is never found
+	f = newFakeFilesystem(`D:\work`)
+	f.file(`C:\googlecloudproject.yaml`, "id: root")
+	_, err = findProjectSpec(f, `D:\work`, specSearch{})
+	if !errors.Is(err, ErrSpecNotFound) {
+		t.Fatalf("expected ErrSpecNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	apikeys "google.golang.org/api/apikeys/v2"
+	"google.golang.org/api/bigquery/v2"
+	"google.golang.org/api/cloudscheduler/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iap/v1"
+	run "google.golang.org/api/run/v1"
+	"google.golang.org/api/sqladmin/v1"
+	"google.golang.org/api/storage/v1"
+)
+
+const defaultStateFile = ".gproj.state.json"
+
+// kinds of resources recorded in the state file
+const (
+	kindProject         = "project"
+	kindCloudRunService = "cloudrun-service"
+	kindDomainMapping   = "domain-mapping"
+	kindSchedulerJob    = "scheduler-job"
+	kindDataset         = "bigquery-dataset"
+	kindAPIKey          = "api-key"
+	kindOAuthClient     = "oauth-client"
+	kindSQLInstance     = "cloudsql-instance"
+	kindGeneric         = "resource"
+)
+
+// StateConfig models the "state" section of googlecloudproject.yaml
+type StateConfig struct {
+	Local  string // path to a local state file, relative to the spec (default ".gproj.state.json")
+	Bucket string // GCS bucket in which to store the state - leave empty to use a local file
+	Object string // name of the object within the bucket (default "<project-id>.gproj.state.json")
+}
+
+// State records the resources that gproj has created. It is safe for concurrent use.
+type State struct {
+	ProjectID string
+	Resources []*StateResource
+	Paused    *PausedProject `json:",omitempty"` // what "gproj pause" turned off, for "gproj resume-project"
+
+	mu sync.Mutex
+}
+
+// StateResource is a single resource that was created by gproj
+type StateResource struct {
+	Kind      string           // one of the kind* constants above
+	Name      string           // fully qualified resource name
+	Region    string           `json:",omitempty"` // region for regional resources
+	Label     string           `json:",omitempty"` // name in the spec, for resources whose names are assigned by google
+	Generic   *GenericResource `json:",omitempty"` // how a generic resource was created, without its body, so that it can be deleted
+	CreatedAt time.Time        // when gproj created the resource
+}
+
+// Record adds a resource to the state. It is safe to call on a nil state, in which case
+// it does nothing, so that callers need not check whether state tracking is enabled.
+func (s *State) Record(kind, name, region string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.find(kind, name) != nil {
+		return
+	}
+	s.Resources = append(s.Resources, &StateResource{
+		Kind:      kind,
+		Name:      name,
+		Region:    region,
+		CreatedAt: time.Now(),
+	})
+}
+
+// RecordLabeled adds a resource whose name was assigned by google to the state, along
+// with the name it has in the spec
+func (s *State) RecordLabeled(kind, name, label string) {
+	if s == nil {
+		return
+	}
+	s.Record(kind, name, "")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.find(kind, name).Label = label
+}
+
+// RecordGeneric adds a generic resource to the state, along with how it was created
+func (s *State) RecordGeneric(name string, r *GenericResource) {
+	if s == nil {
+		return
+	}
+	s.Record(kindGeneric, name, "")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.find(kindGeneric, name).Generic = r
+}
+
+// Find looks up a resource in the state, returning nil if it is not present
+func (s *State) Find(kind, name string) *StateResource {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.find(kind, name)
+}
+
+func (s *State) find(kind, name string) *StateResource {
+	for _, r := range s.Resources {
+		if r.Kind == kind && r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// Forget removes a resource from the state
+func (s *State) Forget(kind, name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keep []*StateResource
+	for _, r := range s.Resources {
+		if r.Kind != kind || r.Name != name {
+			keep = append(keep, r)
+		}
+	}
+	s.Resources = keep
+}
+
+// stateBackend loads and saves state to some storage location
+type stateBackend interface {
+	Load(ctx context.Context) (*State, error)
+	Save(ctx context.Context, state *State) error
+	String() string
+}
+
+// localBackend stores state in a file on the local filesystem
+type localBackend struct {
+	path string
+}
+
+func (b *localBackend) Load(ctx context.Context) (*State, error) {
+	buf, err := fsys.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state: %w", err)
+	}
+	return decodeState(buf, b.path)
+}
+
+func (b *localBackend) Save(ctx context.Context, state *State) error {
+	buf, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling state to json: %w", err)
+	}
+	err = fsys.WriteFile(b.path, buf, filePerm)
+	if err != nil {
+		return fmt.Errorf("error writing state: %w", err)
+	}
+	return nil
+}
+
+func (b *localBackend) String() string {
+	return b.path
+}
+
+// gcsBackend stores state in an object in a google cloud storage bucket
+type gcsBackend struct {
+	svc     *storage.Service
+	bucket  string
+	object  string
+	lockGen int64 // generation of the lock object that we hold, or zero if we hold none
+}
+
+func (b *gcsBackend) Load(ctx context.Context) (*State, error) {
+	resp, err := b.svc.Objects.Get(b.bucket, b.object).Context(ctx).Download()
+	if e, ok := err.(*googleapi.Error); ok && e.Code == 404 {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error downloading state from %s: %w", b, err)
+	}
+	defer resp.Body.Close()
+
+	buf, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading state from %s: %w", b, err)
+	}
+	return decodeState(buf, b.String())
+}
+
+func (b *gcsBackend) Save(ctx context.Context, state *State) error {
+	buf, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling state to json: %w", err)
+	}
+
+	obj := storage.Object{Name: b.object, ContentType: "application/json"}
+	_, err = b.svc.Objects.Insert(b.bucket, &obj).Media(bytes.NewReader(buf)).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("error uploading state to %s: %w", b, err)
+	}
+	return nil
+}
+
+func (b *gcsBackend) String() string {
+	return fmt.Sprintf("gs://%s/%s", b.bucket, b.object)
+}
+
+func decodeState(buf []byte, where string) (*State, error) {
+	var state State
+	err := json.Unmarshal(buf, &state)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding state at %s: %w", where, err)
+	}
+	return &state, nil
+}
+
+// get the backend configured in the spec, or nil if state tracking is not enabled
+func newStateBackend(ctx context.Context, conn *connection, spec *ProjectSpec) (stateBackend, error) {
+	if spec.State == nil {
+		return nil, nil
+	}
+
+	if spec.State.Bucket != "" {
+		svc, err := storage.NewService(ctx, conn.options()...)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing the storage API: %w", err)
+		}
+		object := spec.State.Object
+		if object == "" {
+			object = spec.ID + defaultStateFile
+		}
+		return &gcsBackend{svc: svc, bucket: spec.State.Bucket, object: object}, nil
+	}
+
+	path := spec.State.Local
+	if path == "" {
+		path = defaultStateFile
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(spec.path), path)
+	}
+	return &localBackend{path: path}, nil
+}
+
+// get the resources that the spec says should exist, in the same form as they are recorded in the state
+func desiredResources(spec *ProjectSpec) []*StateResource {
+	var rs []*StateResource
+	for _, svc := range spec.CloudRun {
+		rs = append(rs, &StateResource{
+			Kind:   kindCloudRunService,
+			Name:   fmt.Sprintf("namespaces/%s/services/%s", spec.ID, svc.Name),
+			Region: svc.Region,
+		})
+		for _, domain := range svc.Domains {
+			rs = append(rs, &StateResource{
+				Kind:   kindDomainMapping,
+				Name:   fmt.Sprintf("namespaces/%s/domainmappings/%s", spec.ID, domain),
+				Region: svc.Region,
+			})
+		}
+	}
+	for _, job := range spec.Scheduler {
+		rs = append(rs, &StateResource{
+			Kind:   kindSchedulerJob,
+			Name:   fmt.Sprintf("projects/%s/locations/%s/jobs/%s", spec.ID, job.Region, job.Name),
+			Region: job.Region,
+		})
+	}
+	for _, ds := range spec.Datasets {
+		rs = append(rs, &StateResource{
+			Kind:   kindDataset,
+			Name:   datasetName(spec.ID, ds.Name),
+			Region: ds.Location,
+		})
+	}
+	if spec.BillingExport != nil {
+		ds := spec.BillingExport.dataset()
+		rs = append(rs, &StateResource{
+			Kind:   kindDataset,
+			Name:   datasetName(spec.ID, ds.Name),
+			Region: ds.Location,
+		})
+	}
+	for _, inst := range spec.CloudSQL {
+		rs = append(rs, &StateResource{
+			Kind:   kindSQLInstance,
+			Name:   sqlInstanceName(spec.ID, inst.Name),
+			Region: inst.withDefaults().Region,
+		})
+	}
+	if spec.OAuth != nil {
+		for _, client := range spec.OAuth.Clients {
+			rs = append(rs, &StateResource{
+				Kind:  kindOAuthClient,
+				Label: client.Name,
+			})
+		}
+	}
+	for _, key := range spec.APIKeys {
+		rs = append(rs, &StateResource{
+			Kind: kindAPIKey,
+			Name: apiKeyName(spec.ID, key.Name),
+		})
+	}
+	for _, r := range spec.Resources {
+		params := make(map[string]string)
+		for k, v := range r.Params {
+			params[k] = expandProject(v, spec.ID)
+		}
+		name := expandProject(r.Name, spec.ID)
+		rs = append(rs, &StateResource{
+			Kind: kindGeneric,
+			Name: name,
+			Generic: &GenericResource{
+				Name:    name,
+				API:     r.API,
+				Version: r.Version,
+				Method:  r.Method,
+				Params:  params,
+			},
+		})
+	}
+	return rs
+}
+
+// identify a resource for comparing the state with the spec. Resources whose names are
+// assigned by google are identified by the name they have in the spec instead.
+func (r *StateResource) key() string {
+	if r.Label != "" {
+		return r.Kind + " label:" + r.Label
+	}
+	return r.Kind + " " + r.Name
+}
+
+// find resources that gproj created but which are no longer declared in the spec
+func orphanedResources(spec *ProjectSpec, state *State) []*StateResource {
+	desired := make(map[string]bool)
+	for _, r := range desiredResources(spec) {
+		desired[r.key()] = true
+	}
+
+	// the state is shared by the steps of apply, which run concurrently
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	var orphans []*StateResource
+	for _, r := range state.Resources {
+		if r.Kind == kindProject {
+			continue
+		}
+		if !desired[r.key()] {
+			orphans = append(orphans, r)
+		}
+	}
+	return orphans
+}
+
+// delete a resource that was recorded in the state. Buckets that still contain objects are
+// emptied first only if forceEmpty is true.
+func deleteResource(ctx context.Context, conn *connection, r *StateResource, forceEmpty bool) error {
+	var err error
+	switch r.Kind {
+	case kindGeneric:
+		if r.Generic == nil {
+			return fmt.Errorf("the state does not say how %s was created, so it must be deleted by hand", r.Name)
+		}
+		return deleteGenericResource(ctx, conn, r.Generic, forceEmpty)
+	case kindCloudRunService, kindDomainMapping:
+		var regional *run.APIService
+		regional, err = cloudRunRegionalService(ctx, conn, r.Region)
+		if err != nil {
+			return fmt.Errorf("error initializing the cloud run API for %s: %w", r.Region, err)
+		}
+		if r.Kind == kindCloudRunService {
+			_, err = regional.Namespaces.Services.Delete(r.Name).Context(ctx).Do()
+		} else {
+			_, err = regional.Namespaces.Domainmappings.Delete(r.Name).Context(ctx).Do()
+		}
+	case kindSchedulerJob:
+		var svc *cloudscheduler.Service
+		svc, err = cloudscheduler.NewService(ctx, conn.options()...)
+		if err != nil {
+			return fmt.Errorf("error initializing the cloud scheduler API: %w", err)
+		}
+		_, err = svc.Projects.Locations.Jobs.Delete(r.Name).Context(ctx).Do()
+	case kindDataset:
+		var svc *bigquery.Service
+		svc, err = bigquery.NewService(ctx, conn.options()...)
+		if err != nil {
+			return fmt.Errorf("error initializing the bigquery API: %w", err)
+		}
+		// datasets that still contain tables are not deleted, since that would lose data
+		parts := strings.Split(r.Name, "/")
+		err = svc.Datasets.Delete(parts[1], parts[3]).Context(ctx).Do()
+	case kindSQLInstance:
+		var svc *sqladmin.Service
+		svc, err = sqladmin.NewService(ctx, conn.options()...)
+		if err != nil {
+			return fmt.Errorf("error initializing the cloud SQL API: %w", err)
+		}
+		// instances with deletion protection enabled are not deleted, and are reported as an error
+		parts := strings.Split(r.Name, "/")
+		_, err = svc.Instances.Delete(parts[1], parts[3]).Context(ctx).Do()
+	case kindOAuthClient:
+		// without state there is no way to know the client's name, but it goes with the project anyway
+		if r.Name == "" {
+			return nil
+		}
+		var svc *iap.Service
+		svc, err = iap.NewService(ctx, conn.options()...)
+		if err != nil {
+			return fmt.Errorf("error initializing the IAP API: %w", err)
+		}
+		_, err = svc.Projects.Brands.IdentityAwareProxyClients.Delete(r.Name).Context(ctx).Do()
+	case kindAPIKey:
+		var svc *apikeys.Service
+		svc, err = apikeys.NewService(ctx, conn.options()...)
+		if err != nil {
+			return fmt.Errorf("error initializing the API keys API: %w", err)
+		}
+		// deleted keys can be restored for 30 days
+		_, err = svc.Projects.Locations.Keys.Delete(r.Name).Context(ctx).Do()
+	default:
+		return fmt.Errorf("do not know how to delete resources of kind %q", r.Kind)
+	}
+
+	// a resource that has already gone is as good as deleted
+	if e, ok := err.(*googleapi.Error); ok && e.Code == 404 {
+		return nil
+	}
+	return err
+}
+
+// report resources that are no longer in the spec, and delete them if prune is true,
+// reporting whether anything was deleted
+func pruneOrphans(ctx context.Context, conn *connection, spec *ProjectSpec, state *State, prune bool) (bool, error) {
+	var changed bool
+	for _, r := range orphanedResources(spec, state) {
+		if !prune {
+			fmt.Printf("warning: %s %s was created by gproj but is no longer in the spec (use --prune to delete it)\n", r.Kind, r.Name)
+			continue
+		}
+
+		fmt.Printf("deleting %s %s...\n", r.Kind, r.Name)
+		err := deleteResource(ctx, conn, r, false)
+		if err != nil {
+			return changed, fmt.Errorf("error deleting %s %s: %w", r.Kind, r.Name, err)
+		}
+		state.Forget(r.Kind, r.Name)
+		changed = true
+	}
+	return changed, nil
+}
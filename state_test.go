@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestStateRecord(t *testing.T) {
+	var state State
+	state.Record(kindDataset, "projects/p/datasets/a", "US")
+	state.Record(kindDataset, "projects/p/datasets/a", "EU") // already recorded
+	state.RecordLabeled(kindOAuthClient, "projects/1/brands/1/identityAwareProxyClients/xyz", "web")
+	state.RecordGeneric("projects/p/topics/t", &GenericResource{API: "pubsub", Method: "projects.topics.create"})
+
+	if len(state.Resources) != 3 {
+		t.Fatalf("got %d resources, want 3", len(state.Resources))
+	}
+	if r := state.Find(kindDataset, "projects/p/datasets/a"); r == nil || r.Region != "US" {
+		t.Errorf("recording a resource twice replaced the first record: %+v", r)
+	}
+	if r := state.Find(kindOAuthClient, "projects/1/brands/1/identityAwareProxyClients/xyz"); r == nil || r.Label != "web" {
+		t.Errorf("labeled resource not recorded with its label: %+v", r)
+	}
+	if r := state.Find(kindGeneric, "projects/p/topics/t"); r == nil || r.Generic == nil || r.Generic.API != "pubsub" {
+		t.Errorf("generic resource not recorded with how it was created: %+v", r)
+	}
+	if r := state.Find(kindAPIKey, "projects/p/datasets/a"); r != nil {
+		t.Errorf("found a resource of the wrong kind: %+v", r)
+	}
+
+	state.Forget(kindDataset, "projects/p/datasets/a")
+	state.Forget(kindDataset, "projects/p/datasets/missing")
+	if r := state.Find(kindDataset, "projects/p/datasets/a"); r != nil {
+		t.Errorf("resource still present after Forget: %+v", r)
+	}
+	if len(state.Resources) != 2 {
+		t.Errorf("got %d resources after Forget, want 2", len(state.Resources))
+	}
+}
+
+func TestNilStateIgnoresRecords(t *testing.T) {
+	var state *State
+	state.Record(kindDataset, "projects/p/datasets/a", "US")
+	state.RecordLabeled(kindOAuthClient, "x", "web")
+	state.RecordGeneric("x", &GenericResource{})
+	state.Forget(kindDataset, "projects/p/datasets/a")
+	if r := state.Find(kindDataset, "projects/p/datasets/a"); r != nil {
+		t.Errorf("nil state found %+v", r)
+	}
+}
+
+func TestLocalBackendRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	backend := &localBackend{path: filepath.Join(t.TempDir(), defaultStateFile)}
+
+	state, err := backend.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Resources) != 0 {
+		t.Fatalf("missing state file loaded %d resources, want 0", len(state.Resources))
+	}
+
+	state.ProjectID = "p"
+	state.Record(kindSchedulerJob, "projects/p/locations/us-central1/jobs/j", "us-central1")
+	state.RecordLabeled(kindOAuthClient, "projects/1/brands/1/identityAwareProxyClients/xyz", "web")
+	if err := backend.Save(ctx, state); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := backend.Load(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ProjectID != "p" || len(loaded.Resources) != 2 {
+		t.Fatalf("loaded %+v, want project p with 2 resources", loaded)
+	}
+	if r := loaded.Find(kindSchedulerJob, "projects/p/locations/us-central1/jobs/j"); r == nil || r.Region != "us-central1" {
+		t.Errorf("scheduler job did not survive a round trip: %+v", r)
+	}
+	if r := loaded.Find(kindOAuthClient, "projects/1/brands/1/identityAwareProxyClients/xyz"); r == nil || r.Label != "web" {
+		t.Errorf("oauth client did not survive a round trip: %+v", r)
+	}
+}
+
+func TestNewLocalStateBackend(t *testing.T) {
+	dir := t.TempDir()
+	abs := filepath.Join(dir, "elsewhere", "state.json")
+
+	tests := []struct {
+		name  string
+		state *StateConfig
+		want  string // empty means no backend
+	}{
+		{name: "not enabled", state: nil},
+		{name: "default file", state: &StateConfig{}, want: filepath.Join(dir, defaultStateFile)},
+		{name: "relative to the spec", state: &StateConfig{Local: "state/p.json"}, want: filepath.Join(dir, "state", "p.json")},
+		{name: "absolute", state: &StateConfig{Local: abs}, want: abs},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			spec := &ProjectSpec{ID: "p", State: test.state, path: filepath.Join(dir, "googlecloudproject.yaml")}
+			backend, err := newStateBackend(context.Background(), nil, spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.want == "" {
+				if backend != nil {
+					t.Errorf("got backend %v, want none", backend)
+				}
+				return
+			}
+			if backend == nil || backend.String() != test.want {
+				t.Errorf("got backend %v, want %s", backend, test.want)
+			}
+		})
+	}
+}
+
+func TestOrphanedResources(t *testing.T) {
+	spec := &ProjectSpec{
+		ID:        "p",
+		CloudRun:  []CloudRunService{{Name: "web", Region: "us-central1", Domains: []string{"example.com"}}},
+		Scheduler: []SchedulerJob{{Name: "tick", Region: "us-central1"}},
+		Datasets:  []BigQueryDataset{{Name: "events"}},
+		APIKeys:   []APIKey{{Name: "maps"}},
+		OAuth:     &OAuthBrand{Clients: []OAuthClient{{Name: "web"}}},
+	}
+
+	// record everything the spec declares, as apply would
+	var state State
+	state.Record(kindProject, "p", "")
+	state.Record(kindCloudRunService, "namespaces/p/services/web", "us-central1")
+	state.Record(kindDomainMapping, "namespaces/p/domainmappings/example.com", "us-central1")
+	state.Record(kindSchedulerJob, "projects/p/locations/us-central1/jobs/tick", "us-central1")
+	state.Record(kindDataset, datasetName("p", "events"), "US")
+	state.Record(kindAPIKey, apiKeyName("p", "maps"), "")
+	state.RecordLabeled(kindOAuthClient, "projects/1/brands/1/identityAwareProxyClients/abc", "web")
+
+	if orphans := orphanedResources(spec, &state); len(orphans) != 0 {
+		for _, r := range orphans {
+			t.Errorf("resource declared in the spec reported as orphaned: %s", r.key())
+		}
+	}
+
+	// then record resources that are no longer declared
+	state.Record(kindCloudRunService, "namespaces/p/services/old", "us-central1")
+	state.Record(kindSchedulerJob, "projects/p/locations/europe-west1/jobs/tick", "europe-west1")
+	state.RecordLabeled(kindOAuthClient, "projects/1/brands/1/identityAwareProxyClients/def", "admin")
+
+	var got []string
+	for _, r := range orphanedResources(spec, &state) {
+		got = append(got, r.key())
+	}
+	sort.Strings(got)
+	want := []string{
+		"cloudrun-service namespaces/p/services/old",
+		"oauth-client label:admin",
+		"scheduler-job projects/p/locations/europe-west1/jobs/tick",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got orphans %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got orphans %q, want %q", got, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	crmv3 "google.golang.org/api/cloudresourcemanager/v3"
+)
+
+// the full resource name of a project, as used by the tags API
+func projectFullName(projectNumber int64) string {
+	return fmt.Sprintf("//cloudresourcemanager.googleapis.com/projects/%d", projectNumber)
+}
+
+// find the tag value with a namespaced key such as "123456789/environment", where the
+// namespace is the number of the organization or the ID of the project that owns the key,
+// and a short name such as "production"
+func findTagValue(ctx context.Context, svc *crmv3.Service, namespacedKey, value string) (*crmv3.TagValue, error) {
+	parts := strings.SplitN(namespacedKey, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("tag key %q invalid: expected ORGANIZATION_ID/KEY or PROJECT_ID/KEY", namespacedKey)
+	}
+	parent := "projects/" + parts[0]
+	if _, err := strconv.ParseInt(parts[0], 10, 64); err == nil {
+		parent = "organizations/" + parts[0]
+	}
+
+	var key *crmv3.TagKey
+	err := svc.TagKeys.List().Parent(parent).Pages(ctx, func(resp *crmv3.ListTagKeysResponse) error {
+		for _, k := range resp.TagKeys {
+			if k.ShortName == parts[1] {
+				key = k
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing tag keys in %s: %w", parent, err)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("tag key %s not found", namespacedKey)
+	}
+
+	var found *crmv3.TagValue
+	err = svc.TagValues.List().Parent(key.Name).Pages(ctx, func(resp *crmv3.ListTagValuesResponse) error {
+		for _, v := range resp.TagValues {
+			if v.ShortName == value {
+				found = v
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing values of tag key %s: %w", namespacedKey, err)
+	}
+	if found == nil {
+		return nil, fmt.Errorf("tag key %s has no value %q", namespacedKey, value)
+	}
+	return found, nil
+}
+
+// wait for a tags operation to complete
+func waitForTags(ctx context.Context, svc *crmv3.Service, op *crmv3.Operation) error {
+	check := func() (bool, error) {
+		if op.Error != nil {
+			return false, newOperationError(op.Error.Code, op.Error.Message, op.Error.Details)
+		}
+		return op.Done, nil
+	}
+	if done, err := check(); done || err != nil {
+		return err
+	}
+	return poll(ctx, "operation "+op.Name, func() (bool, error) {
+		var err error
+		op, err = svc.Operations.Get(op.Name).Context(ctx).Do()
+		if err != nil {
+			return false, fmt.Errorf("error getting operation info: %w", err)
+		}
+		return check()
+	})
+}
+
+// bind the tags in the spec to the project, and report whether any bindings were changed.
+// A binding for a key that is in the spec but with a different value is replaced, while
+// bindings for keys that are not in the spec are left alone.
+func (a *applier) ensureTags(ctx context.Context) (bool, error) {
+	svc, err := crmv3.NewService(ctx, a.conn.options()...)
+	if err != nil {
+		return false, fmt.Errorf("error initializing the resource manager v3 API: %w", err)
+	}
+	parent := projectFullName(a.project.ProjectNumber)
+
+	// the effective tags map the values bound directly to the project to their keys
+	current := make(map[string]*crmv3.EffectiveTag) // keyed by namespaced key
+	err = a.retryAfterCreate(ctx, func() error {
+		return svc.EffectiveTags.List().Parent(parent).Pages(ctx, func(resp *crmv3.ListEffectiveTagsResponse) error {
+			for _, t := range resp.EffectiveTags {
+				if !t.Inherited {
+					current[t.NamespacedTagKey] = t
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return false, fmt.Errorf("error listing tags on %s: %w", a.spec.ID, err)
+	}
+
+	var keys []string
+	for k := range a.spec.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var changed bool
+	for _, key := range keys {
+		value := a.spec.Tags[key]
+		existing := current[key]
+		if existing != nil && existing.NamespacedTagValue == key+"/"+value {
+			continue
+		}
+
+		want, err := findTagValue(ctx, svc, key, value)
+		if err != nil {
+			return changed, err
+		}
+
+		// a project can have only one value for each key, so remove the old one first
+		if existing != nil {
+			name := fmt.Sprintf("tagBindings/%s/%s", strings.ReplaceAll(parent, "/", "%2F"), existing.TagValue)
+			op, err := svc.TagBindings.Delete(name).Context(ctx).Do()
+			if err != nil {
+				return changed, fmt.Errorf("error removing tag %s from %s: %w", existing.NamespacedTagValue, a.spec.ID, err)
+			}
+			err = waitForTags(ctx, svc, op)
+			if err != nil {
+				return changed, fmt.Errorf("error removing tag %s from %s: %w", existing.NamespacedTagValue, a.spec.ID, err)
+			}
+		}
+
+		op, err := svc.TagBindings.Create(&crmv3.TagBinding{Parent: parent, TagValue: want.Name}).Context(ctx).Do()
+		if err != nil {
+			return changed, fmt.Errorf("error binding tag %s/%s to %s: %w", key, value, a.spec.ID, err)
+		}
+		noteOperation(ctx, op.Name)
+		err = waitForTags(ctx, svc, op)
+		if err != nil {
+			return changed, fmt.Errorf("error binding tag %s/%s to %s: %w", key, value, a.spec.ID, err)
+		}
+
+		before := ""
+		if existing != nil {
+			before = existing.NamespacedTagValue
+		}
+		fmt.Printf("tagged %s with %s/%s\n", a.spec.ID, key, value)
+		noteChange(ctx, before, key+"/"+value)
+		changed = true
+	}
+	return changed, nil
+}
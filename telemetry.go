@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tracer collects spans in memory and exports them in one batch at the end of a command
+// using OTLP over HTTP with the JSON encoding, which avoids pulling in the full
+// OpenTelemetry SDK for what is a short-lived process
+type tracer struct {
+	endpoint string // e.g. "http://localhost:4318"
+	traceID  string
+
+	mu    sync.Mutex
+	spans []*span
+}
+
+// span is a single timed operation, such as an apply step or a Google API call
+type span struct {
+	tracer   *tracer
+	id       string
+	parentID string
+	name     string
+	kind     int // 1 for internal operations, 3 for outgoing requests
+	start    time.Time
+	end      time.Time
+	attrs    map[string]string
+	err      error
+}
+
+type tracerKey struct{}
+type spanKey struct{}
+
+// generate a random hex ID of n bytes
+func randomID(n int) string {
+	buf := make([]byte, n)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// withTracer returns a context in which spans are recorded and exported to the given
+// OTLP endpoint. If the endpoint is empty then tracing is disabled.
+func withTracer(ctx context.Context, endpoint string) (context.Context, *tracer) {
+	if endpoint == "" {
+		return ctx, nil
+	}
+	t := &tracer{endpoint: strings.TrimSuffix(endpoint, "/"), traceID: randomID(16)}
+	return context.WithValue(ctx, tracerKey{}, t), t
+}
+
+// startSpan begins a span as a child of the span in the context, if any. The returned
+// span is nil if tracing is disabled, in which case its methods do nothing.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	t, _ := ctx.Value(tracerKey{}).(*tracer)
+	if t == nil {
+		return ctx, nil
+	}
+	s := &span{tracer: t, id: randomID(8), name: name, kind: kind, start: time.Now()}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		s.parentID = parent.id
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// set an attribute on the span
+func (s *span) set(key, value string) {
+	if s == nil {
+		return
+	}
+	if s.attrs == nil {
+		s.attrs = make(map[string]string)
+	}
+	s.attrs[key] = value
+}
+
+// finish the span, recording the error if there was one
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end = time.Now()
+	s.err = err
+	s.tracer.mu.Lock()
+	s.tracer.spans = append(s.tracer.spans, s)
+	s.tracer.mu.Unlock()
+}
+
+// the following mirror the OTLP JSON encoding, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	var out []otlpAttribute
+	for k, v := range attrs {
+		out = append(out, otlpAttribute{Key: k, Value: otlpValue{StringValue: v}})
+	}
+	return out
+}
+
+// flush sends all finished spans to the OTLP endpoint
+func (t *tracer) flush(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	var encoded []otlpSpan
+	for _, s := range spans {
+		status := otlpStatus{Code: 1} // ok
+		if s.err != nil {
+			status = otlpStatus{Code: 2, Message: s.err.Error()}
+		}
+		encoded = append(encoded, otlpSpan{
+			TraceID:           t.traceID,
+			SpanID:            s.id,
+			ParentSpanID:      s.parentID,
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.attrs),
+			Status:            status,
+		})
+	}
+
+	payload := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]string{"service.name": "gproj"}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "gproj"},
+				"spans": encoded,
+			}},
+		}},
+	}
+
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshalling traces: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint+"/v1/traces", bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("error exporting traces: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error exporting traces: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("error exporting traces: %s returned %s", t.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// spanTransport records a span for each request made within a traced context
+type spanTransport struct {
+	base http.RoundTripper
+}
+
+func (t spanTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	_, s := startSpan(req.Context(), req.Method+" "+req.URL.Host, 3)
+	s.set("http.method", req.Method)
+	s.set("http.url", req.URL.String())
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		s.set("http.status_code", strconv.Itoa(resp.StatusCode))
+		if resp.StatusCode >= 400 {
+			s.finish(fmt.Errorf("%s", resp.Status))
+			return resp, err
+		}
+	}
+	s.finish(err)
+	return resp, err
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"google.golang.org/api/cloudbilling/v1"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	logging "google.golang.org/api/logging/v2"
+)
+
+// uiRow is one project in the dashboard
+type uiRow struct {
+	project  *cloudresourcemanager.Project
+	specPath string       // spec for the project in the workspace, or empty if there is none
+	billing  string       // "enabled", "disabled", or why it is unknown
+	changes  []specChange // differences between the live project and the spec
+	drift    string       // summary of the changes, or why they are unknown
+}
+
+// the number of projects whose details are fetched at once
+const uiParallelism = 8
+
+// dashboard holds what the dashboard needs to load and refresh its rows
+type dashboard struct {
+	args      *args
+	resources *cloudresourcemanager.Service
+	billing   *cloudbilling.APIService
+	specs     map[string]*workspaceProject // specs in the workspace directory, by project ID
+	rows      []*uiRow
+}
+
+// fetch the billing and drift of a project
+func (d *dashboard) fetch(ctx context.Context, r *uiRow) {
+	info, err := d.billing.Projects.GetBillingInfo("projects/" + r.project.ProjectId).Context(ctx).Do()
+	switch {
+	case err != nil:
+		r.billing = "unknown"
+	case info.BillingEnabled:
+		r.billing = "enabled"
+	default:
+		r.billing = "disabled"
+	}
+
+	wp, ok := d.specs[r.project.ProjectId]
+	if !ok {
+		r.drift = "no spec"
+		return
+	}
+	r.specPath = wp.path
+	live, desired, err := comparableSpecs(ctx, d.args, wp.spec)
+	if err != nil {
+		r.drift = "error: " + err.Error()
+		return
+	}
+	r.changes = diffSpecs(live, desired)
+	if len(r.changes) == 0 {
+		r.drift = "in sync"
+	} else {
+		r.drift = fmt.Sprintf("%d changes", len(r.changes))
+	}
+}
+
+// load the rows of the dashboard: every project labelled as managed by gproj, matched up
+// with the specs in the workspace directory
+func (d *dashboard) load(ctx context.Context) error {
+	projects, err := searchProjects(ctx, d.resources, fmt.Sprintf("labels.%s:%s", managedByLabel, managedByValue))
+	if err != nil {
+		return err
+	}
+
+	// a directory without specs is fine: the dashboard just cannot show drift
+	d.specs = make(map[string]*workspaceProject)
+	if found, err := loadWorkspace(d.args.UI.Dir); err == nil {
+		for _, wp := range found {
+			d.specs[wp.spec.ID] = wp
+		}
+	} else if d.args.Verbose {
+		fmt.Println("not showing drift:", err)
+	}
+
+	d.rows = make([]*uiRow, len(projects))
+	sem := make(chan struct{}, uiParallelism)
+	var wg sync.WaitGroup
+	for i, p := range projects {
+		d.rows[i] = &uiRow{project: p}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(r *uiRow) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			d.fetch(ctx, r)
+		}(d.rows[i])
+	}
+	wg.Wait()
+	return nil
+}
+
+// load the spec for a project again, since it may have been edited while the dashboard was
+// open, and fetch the project's drift against it
+func (d *dashboard) refresh(ctx context.Context, r *uiRow) {
+	if spec, err := loadProjectSpec(fsys, r.specPath); err == nil {
+		d.specs[r.project.ProjectId] = &workspaceProject{path: r.specPath, spec: spec}
+	}
+	d.fetch(ctx, r)
+}
+
+// recentOperation is an entry from a project's admin activity audit log
+type recentOperation struct {
+	Time      time.Time
+	Principal string
+	Method    string
+}
+
+// fetch the most recent admin activity in a project, newest first
+func recentOperations(ctx context.Context, conn *connection, projectID string, n int64) ([]recentOperation, error) {
+	svc, err := logging.NewService(ctx, conn.options()...)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing the logging API: %w", err)
+	}
+	resp, err := svc.Entries.List(&logging.ListLogEntriesRequest{
+		ResourceNames: []string{"projects/" + projectID},
+		Filter:        fmt.Sprintf(`logName="projects/%s/logs/cloudaudit.googleapis.com%%2Factivity"`, projectID),
+		OrderBy:       "timestamp desc",
+		PageSize:      n,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("error listing recent operations: %w", err)
+	}
+
+	var ops []recentOperation
+	for _, e := range resp.Entries {
+		var payload struct {
+			MethodName         string `json:"methodName"`
+			AuthenticationInfo struct {
+				PrincipalEmail string `json:"principalEmail"`
+			} `json:"authenticationInfo"`
+		}
+		if err := json.Unmarshal(e.ProtoPayload, &payload); err != nil {
+			continue
+		}
+		t, _ := time.Parse(time.RFC3339Nano, e.Timestamp)
+		ops = append(ops, recentOperation{
+			Time:      t,
+			Principal: payload.AuthenticationInfo.PrincipalEmail,
+			Method:    payload.MethodName,
+		})
+	}
+	return ops, nil
+}
+
+// clear the terminal
+func clearScreen() {
+	fmt.Print("\033[H\033[2J")
+}
+
+// print the list of projects
+func printUIRows(rows []*uiRow) {
+	fmt.Printf("gproj-managed projects (%d)\n\n", len(rows))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tID\tSTATE\tBILLING\tDRIFT")
+	for i, r := range rows {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i+1, r.project.ProjectId, r.project.LifecycleState, r.billing, r.drift)
+	}
+	w.Flush()
+	fmt.Println("\nenter a number to see a project, r to refresh, or q to quit")
+}
+
+// print the details of one project
+func printUIProject(ctx context.Context, conn *connection, r *uiRow) {
+	p := r.project
+	fmt.Printf("%s (%s)\n\n", p.ProjectId, p.Name)
+	fmt.Printf("number:  %d\n", p.ProjectNumber)
+	fmt.Printf("state:   %s\n", p.LifecycleState)
+	fmt.Printf("billing: %s\n", r.billing)
+	fmt.Printf("labels:  %s\n", formatLabels(p.Labels))
+	if r.specPath != "" {
+		fmt.Printf("spec:    %s\n", r.specPath)
+	}
+
+	fmt.Printf("\ndrift: %s\n", r.drift)
+	for _, c := range r.changes {
+		fmt.Println("  " + c.String())
+	}
+
+	fmt.Println("\nrecent operations:")
+	ops, err := recentOperations(ctx, conn, p.ProjectId, 10)
+	switch {
+	case err != nil:
+		fmt.Println("  " + err.Error())
+	case len(ops) == 0:
+		fmt.Println("  none")
+	}
+	for _, op := range ops {
+		fmt.Printf("  %s  %-40s %s\n", op.Time.Local().Format("2006-01-02 15:04"), op.Method, op.Principal)
+	}
+
+	if r.specPath != "" {
+		fmt.Println("\nenter p to plan, a to apply, or b to go back")
+	} else {
+		fmt.Println("\nenter b to go back")
+	}
+}
+
+// run plan or apply for a spec by running this same executable, in the foreground so
+// that apply can ask for confirmation
+func runFromUI(ctx context.Context, path string, command ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error finding the gproj executable: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, exe, append([]string{"--spec", path}, command...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// ui runs an interactive dashboard of the projects managed by gproj
+func ui(ctx context.Context, args *args) error {
+	if !isInteractive(args) {
+		return errors.New("gproj ui needs a terminal; use gproj search or gproj workspace --list in scripts")
+	}
+	conn, err := connect(ctx, args)
+	if err != nil {
+		return err
+	}
+	resources, err := cloudresourcemanager.NewService(ctx, conn.options()...)
+	if err != nil {
+		return err
+	}
+	billing, err := cloudbilling.NewService(ctx, conn.options()...)
+	if err != nil {
+		return fmt.Errorf("error initializing the billing API: %w", err)
+	}
+	d := dashboard{args: args, resources: resources, billing: billing}
+
+	in := bufio.NewScanner(os.Stdin)
+	prompt := func() (string, bool) {
+		fmt.Print("> ")
+		if !in.Scan() {
+			return "", false
+		}
+		return strings.TrimSpace(in.Text()), true
+	}
+
+	fmt.Println("loading projects...")
+	if err := d.load(ctx); err != nil {
+		return err
+	}
+
+	var selected *uiRow
+	for {
+		clearScreen()
+		if selected == nil {
+			printUIRows(d.rows)
+		} else {
+			printUIProject(ctx, conn, selected)
+		}
+
+		cmd, ok := prompt()
+		if !ok || cmd == "q" {
+			return nil
+		}
+		switch {
+		case cmd == "r":
+			fmt.Println("loading projects...")
+			if err := d.load(ctx); err != nil {
+				return err
+			}
+			selected = nil
+		case selected == nil:
+			if n, err := strconv.Atoi(cmd); err == nil && n >= 1 && n <= len(d.rows) {
+				selected = d.rows[n-1]
+			}
+		case cmd == "b":
+			selected = nil
+		case (cmd == "p" || cmd == "a") && selected.specPath != "":
+			command := "plan"
+			if cmd == "a" {
+				command = "apply"
+			}
+			if err := runFromUI(ctx, selected.specPath, command); err != nil {
+				fmt.Println("error:", err)
+			}
+			// leave the output up until the user has read it, then show the drift afresh
+			fmt.Print("\npress enter to continue")
+			in.Scan()
+			d.refresh(ctx, selected)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// version information, set at build time with e.g. -ldflags "-X main.version=v1.2.3"
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// github API endpoint describing the most recent release
+const latestReleaseURL = "https://api.github.com/repos/alexflint/gproj/releases/latest"
+
+// the subset of a github release that gproj uses
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		URL string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// find the download URL of a release asset with the given file name
+func (r *release) assetURL(name string) (string, bool) {
+	for _, a := range r.Assets {
+		if filepath.Base(a.URL) == name {
+			return a.URL, true
+		}
+	}
+	return "", false
+}
+
+// name of the release binary for the current platform
+func binaryName() string {
+	name := fmt.Sprintf("gproj_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// make a GET request and return the body, or an error if the status is not 200
+func httpGet(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// fetch the most recent release from github
+func latestRelease(ctx context.Context) (*release, error) {
+	body, err := httpGet(ctx, latestReleaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error checking for the latest release: %w", err)
+	}
+	defer body.Close()
+
+	var r release
+	err = json.NewDecoder(body).Decode(&r)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding the latest release: %w", err)
+	}
+	return &r, nil
+}
+
+// parse a version such as "v1.2.3" into its numeric parts
+func parseVersion(v string) ([]int, bool) {
+	var parts []int
+	for _, s := range strings.Split(strings.TrimPrefix(v, "v"), ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
+
+// determine whether version a is older than version b. Versions that cannot be parsed,
+// such as "dev", are considered older than everything.
+func olderVersion(a, b string) bool {
+	pa, ok := parseVersion(a)
+	if !ok {
+		return true
+	}
+	pb, ok := parseVersion(b)
+	if !ok {
+		return false
+	}
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		if pa[i] != pb[i] {
+			return pa[i] < pb[i]
+		}
+	}
+	return len(pa) < len(pb)
+}
+
+// find the expected sha256 of a file in a checksums file, which has lines of the form
+// "<sha256>  <file name>"
+func expectedChecksum(checksums io.Reader, name string) (string, error) {
+	scanner := bufio.NewScanner(checksums)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+func versionCmd(ctx context.Context, args *args) error {
+	fmt.Printf("gproj %s (commit %s, built %s, %s/%s)\n", version, commit, date, runtime.GOOS, runtime.GOARCH)
+	if !args.Version.Check {
+		return nil
+	}
+	if args.Offline {
+		return fmt.Errorf("cannot check for a newer version with --offline")
+	}
+
+	latest, err := latestRelease(ctx)
+	if err != nil {
+		return err
+	}
+	if olderVersion(version, latest.TagName) {
+		fmt.Printf("gproj %s is available, run \"gproj self-update\" to install it\n", latest.TagName)
+	} else {
+		fmt.Println("gproj is up to date")
+	}
+	return nil
+}
+
+func selfUpdate(ctx context.Context, args *args) error {
+	if args.Offline {
+		return fmt.Errorf("cannot download a new version with --offline")
+	}
+	latest, err := latestRelease(ctx)
+	if err != nil {
+		return err
+	}
+	if !olderVersion(version, latest.TagName) {
+		fmt.Printf("gproj %s is already the latest version\n", version)
+		return nil
+	}
+
+	name := binaryName()
+	binaryURL, ok := latest.assetURL(name)
+	if !ok {
+		return fmt.Errorf("release %s has no binary for %s/%s", latest.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	checksumsURL, ok := latest.assetURL("checksums.txt")
+	if !ok {
+		return fmt.Errorf("release %s has no checksums, not updating", latest.TagName)
+	}
+
+	// get the expected checksum before downloading the binary
+	checksums, err := httpGet(ctx, checksumsURL)
+	if err != nil {
+		return fmt.Errorf("error downloading checksums: %w", err)
+	}
+	expected, err := expectedChecksum(checksums, name)
+	checksums.Close()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("downloading gproj %s...\n", latest.TagName)
+	body, err := httpGet(ctx, binaryURL)
+	if err != nil {
+		return fmt.Errorf("error downloading gproj: %w", err)
+	}
+	defer body.Close()
+
+	buf, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("error downloading gproj: %w", err)
+	}
+	fmt.Printf("downloaded %s\n", humanSize(int64(len(buf))))
+	sum := sha256.Sum256(buf)
+	if hex.EncodeToString(sum[:]) != expected {
+		return fmt.Errorf("checksum mismatch for %s, not updating", name)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error finding the gproj executable: %w", err)
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return fmt.Errorf("error finding the gproj executable: %w", err)
+	}
+
+	// write the new binary next to the old one and then swap them. The old binary is moved
+	// aside rather than overwritten because windows does not allow replacing a running
+	// executable.
+	err = os.WriteFile(exe+".new", buf, 0755)
+	if err != nil {
+		return fmt.Errorf("error writing new binary: %w", err)
+	}
+	os.Remove(exe + ".old")
+	err = os.Rename(exe, exe+".old")
+	if err != nil {
+		return fmt.Errorf("error moving old binary aside: %w", err)
+	}
+	err = os.Rename(exe+".new", exe)
+	if err != nil {
+		os.Rename(exe+".old", exe)
+		return fmt.Errorf("error installing new binary: %w", err)
+	}
+	os.Remove(exe + ".old") // fails harmlessly on windows while the old binary is running
+
+	fmt.Printf("updated gproj from %s to %s\n", version, latest.TagName)
+	return nil
+}
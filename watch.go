@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// print a line prefixed with the time, for the log of a long-running watch, unless
+// --timestamps is already adding the time to every line
+func logf(format string, a ...interface{}) {
+	if timestamped {
+		fmt.Printf(format+"\n", a...)
+		return
+	}
+	fmt.Printf("%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, a...))
+}
+
+// send a notification from watch, to wherever the user asked for them
+func notifyWatch(ctx context.Context, args *args, n *notification) {
+	if args.Watch.Notify == "desktop" {
+		if err := notifyDesktop("gproj", n.Text); err != nil {
+			logf("warning: unable to show desktop notification: %v", err)
+		}
+	}
+	if args.Watch.NotifyWebhook != "" {
+		if err := notifyWebhook(ctx, args.Watch.NotifyWebhook, n); err != nil {
+			logf("warning: unable to send webhook notification: %v", err)
+		}
+	}
+}
+
+// run apply for the spec by running this same executable, so that each apply starts from
+// a clean slate, and with --ci so that it never waits for input
+func applyFromWatch(ctx context.Context, args *args, path string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error finding the gproj executable: %w", err)
+	}
+	cmdArgs := append([]string{"--spec", path}, childArgs(args, 1)...)
+	if !args.CI {
+		cmdArgs = append(cmdArgs, "--ci")
+	}
+	cmdArgs = append(cmdArgs, "apply")
+	if args.Watch.NotifyWebhook != "" {
+		cmdArgs = append(cmdArgs, "--notify-webhook", args.Watch.NotifyWebhook)
+	}
+	cmd := exec.CommandContext(ctx, exe, cmdArgs...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// watchResult is what one check of the project found
+type watchResult struct {
+	projectID string
+	changed   []string // fields that differ from the spec and were not applied
+	err       error
+}
+
+// a key that is the same for two checks that found the same thing
+func (r *watchResult) key() string {
+	if r.err != nil {
+		return "error: " + r.err.Error()
+	}
+	return strings.Join(r.changed, ",")
+}
+
+// check the project once, and apply the spec if it has drifted and --auto-apply was given.
+// The spec is read afresh each time so that edits to it are picked up.
+func watchOnce(ctx context.Context, args *args) *watchResult {
+	r := watchResult{projectID: args.Project}
+	spec, err := readProjectSpec(args)
+	if err != nil {
+		r.err = err
+		logf("error: %v", err)
+		return &r
+	}
+	r.projectID = spec.ID
+
+	p, err := makePlan(ctx, args, spec)
+	if err != nil {
+		r.err = fmt.Errorf("error planning: %w", err)
+		logf("error planning %s: %v", spec.ID, err)
+		return &r
+	}
+	if len(p.Changes) == 0 {
+		logf("%s", p.summary())
+		return &r
+	}
+
+	var fields []string
+	for _, c := range p.Changes {
+		fields = append(fields, c.Field)
+	}
+	logf("%s: %s", p.summary(), strings.Join(fields, ", "))
+
+	if !args.Watch.AutoApply {
+		r.changed = fields
+		return &r
+	}
+	logf("applying %s", spec.path)
+	err = applyFromWatch(ctx, args, spec.path)
+	if err != nil {
+		r.err = fmt.Errorf("error applying: %w", err)
+		logf("error applying %s: %v", spec.ID, err)
+		return &r
+	}
+	logf("applied %s", spec.ID)
+	return &r
+}
+
+// watch re-plans the project periodically and reports or fixes drift
+func watch(ctx context.Context, args *args) error {
+	if args.Offline {
+		return fmt.Errorf("gproj watch cannot be used with --offline")
+	}
+	if args.Watch.Notify != "" && args.Watch.Notify != "desktop" {
+		return fmt.Errorf("unknown notification type %q, expected \"desktop\"", args.Watch.Notify)
+	}
+	if args.Watch.Interval < time.Minute {
+		return fmt.Errorf("the interval must be at least a minute, to stay within API quotas")
+	}
+
+	t := time.NewTicker(args.Watch.Interval)
+	defer t.Stop()
+
+	var last string
+	for {
+		r := watchOnce(ctx, args)
+
+		// report drift and errors once, rather than every time they are seen again
+		if key := r.key(); key != last && key != "" && ctx.Err() == nil {
+			n := notification{ProjectID: r.projectID, Success: r.err == nil, Changed: r.changed}
+			if r.err != nil {
+				n.Error = r.err.Error()
+				n.Text = fmt.Sprintf("gproj watch failed for %s: %s", r.projectID, firstLine(n.Error))
+			} else {
+				n.Text = fmt.Sprintf("gproj watch found drift in %s: %s", r.projectID, strings.Join(r.changed, ", "))
+			}
+			notifyWatch(ctx, args, &n)
+		}
+		last = r.key()
+
+		select {
+		case <-ctx.Done():
+			logf("stopping")
+			return nil
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// workspaceProject is one spec found in a workspace
+type workspaceProject struct {
+	path string
+	spec *ProjectSpec
+}
+
+// find every spec in a directory tree, skipping hidden directories such as .git
+func findWorkspaceSpecs(root string) ([]string, error) {
+	var paths []string
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		if path := specInDir(fsys, dir); path != "" {
+			paths = append(paths, path)
+		}
+		entries, err := fsys.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", dir, err)
+		}
+		for _, e := range entries {
+			if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+				if err := walk(filepath.Join(dir, e.Name())); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	err := walk(root)
+	return paths, err
+}
+
+// loadWorkspace reads every spec in a directory tree and orders them so that each project
+// comes after the projects it depends on
+func loadWorkspace(root string) ([]*workspaceProject, error) {
+	paths, err := findWorkspaceSpecs(root)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no specs found in %s", root)
+	}
+
+	byID := make(map[string]*workspaceProject)
+	var ids []string
+	for _, path := range paths {
+		spec, err := loadProjectSpec(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+		if other, dup := byID[spec.ID]; dup {
+			return nil, fmt.Errorf("project %s is in both %s and %s", spec.ID, other.path, path)
+		}
+		byID[spec.ID] = &workspaceProject{path: path, spec: spec}
+		ids = append(ids, spec.ID)
+	}
+	sort.Strings(ids)
+
+	// depth-first topological sort, reporting the path around any cycle
+	var ordered []*workspaceProject
+	visited := make(map[string]bool)
+	var visit func(id string, chain []string) error
+	visit = func(id string, chain []string) error {
+		for i, prev := range chain {
+			if prev == id {
+				return fmt.Errorf("dependency cycle: %s", strings.Join(append(chain[i:], id), " -> "))
+			}
+		}
+		if visited[id] {
+			return nil
+		}
+		p := byID[id]
+		for _, dep := range p.spec.DependsOn {
+			if _, ok := byID[dep]; !ok {
+				return fmt.Errorf("%s depends on %s, which is not in the workspace", p.path, dep)
+			}
+			if err := visit(dep, append(chain, id)); err != nil {
+				return err
+			}
+		}
+		visited[id] = true
+		ordered = append(ordered, p)
+		return nil
+	}
+	for _, id := range ids {
+		if err := visit(id, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// run gproj with the given arguments against a single spec, by running this same
+// executable with --spec and the global flags that were given to workspace
+func runForSpec(ctx context.Context, exe, path string, args *args, command []string) (string, error) {
+	cmdArgs := append([]string{"--spec", path}, childArgs(args, args.Workspace.Parallelism)...)
+	cmdArgs = append(cmdArgs, command...)
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, exe, cmdArgs...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	return out.String(), err
+}
+
+// workspace applies or lists every spec in a directory tree, in dependency order
+func workspace(ctx context.Context, args *args) error {
+	projects, err := loadWorkspace(args.Workspace.Dir)
+	if err != nil {
+		return err
+	}
+
+	// the limits apply to all projects together, so check them before dividing them up
+	if _, err := parseRateLimits(args.RateLimit); err != nil {
+		return err
+	}
+	if args.Workspace.Parallelism < 1 {
+		args.Workspace.Parallelism = 1
+	}
+
+	if args.Workspace.List {
+		for _, p := range projects {
+			line := fmt.Sprintf("%s (%s)", p.spec.ID, p.path)
+			if len(p.spec.DependsOn) > 0 {
+				line += " depends on " + strings.Join(p.spec.DependsOn, ", ")
+			}
+			fmt.Println(line)
+		}
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("error finding the gproj executable: %w", err)
+	}
+
+	// each apply writes a report, from which the step that failed is read
+	reportDir, err := os.MkdirTemp("", "gproj-workspace")
+	if err != nil {
+		return fmt.Errorf("error creating directory for reports: %w", err)
+	}
+	defer os.RemoveAll(reportDir)
+
+	var mu sync.Mutex
+	failures := make(map[string]workspaceFailure)
+
+	// each project is a step in a graph so that independent projects are applied in
+	// parallel and dependents of a failed project are skipped, while the rest carry on
+	var g graph
+	for _, p := range projects {
+		p := p
+		g.add(p.spec.ID, p.spec.DependsOn, func(ctx context.Context) (bool, error) {
+			fmt.Printf("applying %s...\n", p.spec.ID)
+			report := filepath.Join(reportDir, p.spec.ID+".json")
+			out, err := runForSpec(ctx, exe, p.path, args, []string{"apply", "--report", report})
+			if err != nil {
+				fmt.Printf("\n%s failed:\n%s", p.spec.ID, out)
+				f := failureFromReport(report, strings.TrimPrefix(lastLine(out), "error: "))
+				mu.Lock()
+				failures[p.spec.ID] = f
+				mu.Unlock()
+				return false, fmt.Errorf("%s failed: %s", f.phase, f.err)
+			}
+			fmt.Printf("%s: %s\n", p.spec.ID, lastLine(out))
+			return false, nil
+		})
+	}
+	err = g.run(ctx, args.Workspace.Parallelism)
+	if err == nil {
+		fmt.Printf("applied %d projects\n", len(projects))
+		return nil
+	}
+
+	// summarize every project that was not applied, in the order they would have been
+	var failed int
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PROJECT\tPHASE\tERROR\tSUGGESTION")
+	for _, a := range g.actions {
+		var f workspaceFailure
+		switch a.Result {
+		case "failed":
+			f = failures[a.Resource]
+		case "skipped":
+			f = workspaceFailure{
+				phase:      "-",
+				err:        "not applied because a project it depends on failed",
+				suggestion: "fix the projects it depends on",
+			}
+		default:
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", a.Resource, f.phase, f.err, f.suggestion)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%d of %d projects were not applied", failed, len(projects))
+}
+
+// workspaceFailure is the summary of why a project in a workspace was not applied
+type workspaceFailure struct {
+	phase      string // step of the apply that failed, e.g. "billing"
+	err        string
+	suggestion string
+}
+
+// get the first failed step from the report written by an apply, or else describe the
+// failure with the last line of its output, which happens when apply fails before it
+// gets as far as running any steps
+func failureFromReport(path, lastLine string) workspaceFailure {
+	f := workspaceFailure{phase: "apply", err: lastLine}
+	if buf, err := fsys.ReadFile(path); err == nil {
+		var r Report
+		if json.Unmarshal(buf, &r) == nil {
+			for _, a := range r.Actions {
+				if a.Result == "failed" {
+					f.phase, f.err = a.Resource, firstLine(a.Error)
+					break
+				}
+			}
+		}
+	}
+	f.suggestion = suggestFix(f.err)
+	return f
+}
+
+// suggest what to do about an error, based on the most common causes of apply failures
+func suggestFix(msg string) string {
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "billing"):
+		return `check the billing account (see "gproj explain billing")`
+	case strings.Contains(msg, "403") || strings.Contains(lower, "permission"):
+		return "ask for the missing role on the project or its parent"
+	case strings.Contains(msg, "429") || strings.Contains(lower, "quota"):
+		return "lower --parallelism or set --rate-limit"
+	case strings.Contains(msg, "409") || strings.Contains(lower, "already exists"):
+		return "the project ID may be taken; choose another"
+	case strings.Contains(lower, "timed out") || strings.Contains(lower, "interrupted"):
+		return "run gproj resume in the project's directory"
+	}
+	return "see the output above"
+}